@@ -0,0 +1,142 @@
+// Package apierror answers failed API requests, all with the same body: a
+// code, a message and the ID of the request
+package apierror
+
+/*
+
+  Copyright 2024, YggdrasilSoft, LLC.
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/greeneg/update-reporterd/logging"
+	"github.com/greeneg/update-reporterd/model"
+)
+
+// error codes
+const (
+	CodeBadRequest        = "BAD_REQUEST"
+	CodeValidation        = "VALIDATION_FAILED"
+	CodeUnauthorized      = "UNAUTHORIZED"
+	CodeTwoFactorRequired = "TWO_FACTOR_REQUIRED"
+	CodeForbidden         = "FORBIDDEN"
+	CodeNotFound          = "NOT_FOUND"
+	CodeConflict          = "CONFLICT"
+	CodeTooManyRequests   = "TOO_MANY_REQUESTS"
+	CodeInternal          = "INTERNAL_ERROR"
+	CodeNotImplemented    = "NOT_IMPLEMENTED"
+	CodeUnavailable       = "SERVICE_UNAVAILABLE"
+	// the user's role requires two-factor authentication they have not set up
+	CodeTwoFactorEnrollmentRequired = "TWO_FACTOR_ENROLLMENT_REQUIRED"
+	CodePasswordExpired             = "PASSWORD_EXPIRED"
+)
+
+// statusCodes are the codes used for each status, unless a more specific one
+// is given
+var statusCodes = map[int]string{
+	http.StatusBadRequest:          CodeBadRequest,
+	http.StatusUnauthorized:        CodeUnauthorized,
+	http.StatusForbidden:           CodeForbidden,
+	http.StatusNotFound:            CodeNotFound,
+	http.StatusConflict:            CodeConflict,
+	http.StatusTooManyRequests:     CodeTooManyRequests,
+	http.StatusInternalServerError: CodeInternal,
+	http.StatusNotImplemented:      CodeNotImplemented,
+	http.StatusServiceUnavailable:  CodeUnavailable,
+}
+
+// internalMessage is all a client learns of a failure of the service itself,
+// the error is in the log under the request ID
+const internalMessage = "Internal server error, quote the request ID when reporting it"
+
+// New Returns the body of an error response to the request
+func New(c *gin.Context, code string, message string) model.FailureMsg {
+	return model.FailureMsg{
+		Code:      code,
+		Message:   message,
+		RequestId: logging.RequestId(c.Request.Context()),
+		Error:     message,
+	}
+}
+
+// Respond Answers the request with an error of the given status
+func Respond(c *gin.Context, status int, message string) {
+	code, ok := statusCodes[status]
+	if !ok {
+		code = CodeInternal
+	}
+	RespondCode(c, status, code, message)
+}
+
+// RespondCode Answers the request with an error of the given status and code
+func RespondCode(c *gin.Context, status int, code string, message string) {
+	c.IndentedJSON(status, New(c, code, message))
+}
+
+// Status Returns the status and code the API answers an error with
+func Status(err error) (int, string) {
+	var notFound *model.NotFound
+	var conflict *model.Conflict
+	var roleInUse *model.RoleInUse
+	var validation *model.Validation
+	var forbidden *model.Forbidden
+	var invalidStatus *model.InvalidStatusValue
+	var policyViolation *model.PasswordPolicyViolation
+	var hashMismatch *model.PasswordHashMismatch
+	var invalidRecord *model.InvalidUpdateRecord
+	var externallyManaged *model.ExternallyManagedAccount
+	switch {
+	case errors.As(err, &notFound):
+		return http.StatusNotFound, CodeNotFound
+	case errors.As(err, &conflict), errors.As(err, &roleInUse):
+		return http.StatusConflict, CodeConflict
+	case errors.As(err, &validation), errors.As(err, &invalidStatus), errors.As(err, &policyViolation),
+		errors.As(err, &hashMismatch), errors.As(err, &invalidRecord):
+		return http.StatusBadRequest, CodeValidation
+	case errors.As(err, &forbidden), errors.As(err, &externallyManaged):
+		return http.StatusForbidden, CodeForbidden
+	default:
+		return http.StatusInternalServerError, CodeInternal
+	}
+}
+
+// Internal Answers the request with 500, without details, which are for the
+// caller to log
+func Internal(c *gin.Context) {
+	RespondCode(c, http.StatusInternalServerError, CodeInternal, internalMessage)
+}
+
+// NoRoute Answers requests for paths the API does not have, for gin.NoRoute
+func NoRoute(c *gin.Context) {
+	Respond(c, http.StatusNotFound, "No such route "+c.Request.URL.Path)
+}
+
+// Error Answers the request with the status matching err. Failures of the
+// service itself are logged, and their details kept from the client
+func Error(c *gin.Context, err error) {
+	status, code := Status(err)
+	if status == http.StatusInternalServerError {
+		slog.ErrorContext(c.Request.Context(), "Request failed", "error", err)
+		Internal(c)
+		return
+	}
+
+	RespondCode(c, status, code, string(err.Error()))
+}
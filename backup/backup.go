@@ -0,0 +1,420 @@
+package backup
+
+/*
+
+  Copyright 2024, YggdrasilSoft, LLC.
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+
+	"github.com/greeneg/update-reporterd/migrations"
+	"github.com/greeneg/update-reporterd/model"
+)
+
+// FormatVersion is the manifest format written by this build
+const FormatVersion = 1
+
+// ErrNotSqlite is returned for databases other than SQLite, which have their
+// own tools, e.g. pg_dump
+var ErrNotSqlite = errors.New("online backup is only supported for SQLite databases")
+
+// DefaultDirectory Returns where backups of the database at dbPath go when no
+// directory is configured
+func DefaultDirectory(dbPath string) string {
+	return filepath.Join(filepath.Dir(dbPath), "backups")
+}
+
+// Create Copies the database into dir with SQLite's online backup API, which
+// gives a consistent copy while the database is in use, then writes a
+// manifest next to it. Returns the path of the manifest
+func Create(ctx context.Context, db *model.Database, dir string, compress bool) (string, model.BackupManifest, error) {
+	if db.Dialect != model.DialectSqlite {
+		return "", model.BackupManifest{}, ErrNotSqlite
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", model.BackupManifest{}, err
+	}
+
+	now := time.Now().UTC()
+	name := "update-reporterd-" + now.Format("20060102T150405Z")
+	copyPath := filepath.Join(dir, name+".db")
+	manifestPath := filepath.Join(dir, name+".manifest.json")
+	if _, err := os.Stat(manifestPath); err == nil {
+		return "", model.BackupManifest{}, errors.New("backup " + name + " already exists")
+	}
+	partialPath := copyPath + ".partial"
+	defer os.Remove(partialPath)
+
+	if err := copyDatabase(ctx, db, partialPath); err != nil {
+		return "", model.BackupManifest{}, errors.New("cannot copy database: " + err.Error())
+	}
+	schemaVersion, rowCounts, err := inspect(ctx, partialPath)
+	if err != nil {
+		return "", model.BackupManifest{}, errors.New("cannot read the copy: " + err.Error())
+	}
+
+	if compress {
+		copyPath += ".gz"
+		err = compressFile(partialPath, copyPath)
+	} else if err = os.Chmod(partialPath, 0600); err == nil {
+		err = os.Rename(partialPath, copyPath)
+	}
+	if err != nil {
+		os.Remove(copyPath)
+		return "", model.BackupManifest{}, err
+	}
+	checksum, err := sha256File(copyPath)
+	if err != nil {
+		os.Remove(copyPath)
+		return "", model.BackupManifest{}, err
+	}
+
+	manifest := model.BackupManifest{
+		FormatVersion: FormatVersion,
+		File:          filepath.Base(copyPath),
+		Compressed:    compress,
+		Sha256:        checksum,
+		SchemaVersion: schemaVersion,
+		RowCounts:     rowCounts,
+		CreationDate:  model.DbTimestamp(now),
+	}
+	content, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		os.Remove(copyPath)
+		return "", model.BackupManifest{}, err
+	}
+	if err := os.WriteFile(manifestPath, append(content, '\n'), 0600); err != nil {
+		os.Remove(copyPath)
+		return "", model.BackupManifest{}, err
+	}
+
+	slog.Info("Database backed up", "path", copyPath)
+	return manifestPath, manifest, nil
+}
+
+// Restore Replaces the SQLite database at dbPath with the backup described by
+// the manifest, after checking the backup's checksum, integrity, row counts
+// and that its schema is not newer than this build. The replaced database is
+// kept next to it with a .pre-restore suffix. Nothing may have the database
+// open while it is restored
+func Restore(ctx context.Context, manifestPath string, dbPath string) (model.BackupManifest, error) {
+	manifest, err := ReadManifest(manifestPath)
+	if err != nil {
+		return model.BackupManifest{}, err
+	}
+
+	copyPath := filepath.Join(filepath.Dir(manifestPath), manifest.File)
+	checksum, err := sha256File(copyPath)
+	if err != nil {
+		return model.BackupManifest{}, err
+	}
+	if checksum != manifest.Sha256 {
+		return model.BackupManifest{}, errors.New("checksum of " + manifest.File + " does not match the manifest")
+	}
+
+	// stage next to the database so the swap is a rename on one filesystem
+	stagedPath := dbPath + ".restore"
+	defer os.Remove(stagedPath)
+	if manifest.Compressed {
+		err = decompressFile(copyPath, stagedPath)
+	} else {
+		err = copyFile(copyPath, stagedPath)
+	}
+	if err != nil {
+		return model.BackupManifest{}, err
+	}
+
+	if err := validate(ctx, stagedPath, manifest); err != nil {
+		return model.BackupManifest{}, err
+	}
+
+	if err := swap(stagedPath, dbPath); err != nil {
+		return model.BackupManifest{}, err
+	}
+
+	slog.Info("Database restored", "path", copyPath)
+	return manifest, nil
+}
+
+// ReadManifest Reads and checks a backup manifest
+func ReadManifest(manifestPath string) (model.BackupManifest, error) {
+	content, err := os.ReadFile(manifestPath)
+	if err != nil {
+		return model.BackupManifest{}, err
+	}
+
+	manifest := model.BackupManifest{}
+	if err := json.Unmarshal(content, &manifest); err != nil {
+		return model.BackupManifest{}, errors.New("manifest is not valid JSON: " + err.Error())
+	}
+	if manifest.FormatVersion != FormatVersion {
+		return model.BackupManifest{}, errors.New("unsupported manifest format version " + strconv.Itoa(manifest.FormatVersion))
+	}
+	// the file must sit next to the manifest, never elsewhere
+	if manifest.File == "" || manifest.File != filepath.Base(manifest.File) || manifest.File == "." || manifest.File == ".." {
+		return model.BackupManifest{}, errors.New("manifest does not name a backup file")
+	}
+	if manifest.Sha256 == "" {
+		return model.BackupManifest{}, errors.New("manifest has no checksum")
+	}
+
+	return manifest, nil
+}
+
+// validate Checks a staged copy against its manifest and this build
+func validate(ctx context.Context, path string, manifest model.BackupManifest) error {
+	copyDb, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return err
+	}
+	defer copyDb.Close()
+
+	result := ""
+	if err := copyDb.QueryRowContext(ctx, "PRAGMA integrity_check").Scan(&result); err != nil {
+		return errors.New("backup is not a readable SQLite database: " + err.Error())
+	}
+	if result != "ok" {
+		return errors.New("backup failed its integrity check: " + result)
+	}
+
+	schemaVersion, rowCounts, err := inspect(ctx, path)
+	if err != nil {
+		return err
+	}
+	if schemaVersion != manifest.SchemaVersion {
+		return errors.New("backup has schema version " + strconv.Itoa(schemaVersion) +
+			", the manifest says " + strconv.Itoa(manifest.SchemaVersion))
+	}
+	known, err := migrations.Load(model.DialectSqlite)
+	if err != nil {
+		return err
+	}
+	if len(known) > 0 && schemaVersion > known[len(known)-1].Version {
+		return errors.New("backup has schema version " + strconv.Itoa(schemaVersion) +
+			", newer than the " + strconv.Itoa(known[len(known)-1].Version) + " this build supports")
+	}
+	for table, count := range manifest.RowCounts {
+		if rowCounts[table] != count {
+			return errors.New("table " + table + " has " + strconv.FormatInt(rowCounts[table], 10) +
+				" rows, the manifest says " + strconv.FormatInt(count, 10))
+		}
+	}
+
+	return nil
+}
+
+// swap Moves the staged database into place, keeping the current one and
+// its journals aside so a hot journal is never applied to the restored file
+func swap(stagedPath string, dbPath string) error {
+	if _, err := os.Stat(dbPath); err == nil {
+		keptPath := dbPath + ".pre-restore-" + time.Now().UTC().Format("20060102T150405Z")
+		for _, suffix := range []string{"-journal", "-wal", "-shm"} {
+			if _, err := os.Stat(dbPath + suffix); err == nil {
+				if err := os.Rename(dbPath+suffix, keptPath+suffix); err != nil {
+					return err
+				}
+			}
+		}
+		if err := os.Rename(dbPath, keptPath); err != nil {
+			return err
+		}
+		slog.Info("Previous database kept", "path", keptPath)
+	}
+
+	return os.Rename(stagedPath, dbPath)
+}
+
+// copyDatabase Runs the online backup from a connection of the pool into a
+// new database file
+func copyDatabase(ctx context.Context, db *model.Database, path string) error {
+	os.Remove(path)
+	destDb, err := sql.Open("sqlite3", "file:"+path)
+	if err != nil {
+		return err
+	}
+	defer destDb.Close()
+
+	destConn, err := destDb.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer destConn.Close()
+	srcConn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer srcConn.Close()
+
+	return destConn.Raw(func(destDriverConn any) error {
+		return srcConn.Raw(func(srcDriverConn any) error {
+			dest, ok := destDriverConn.(*sqlite3.SQLiteConn)
+			src, ok2 := srcDriverConn.(*sqlite3.SQLiteConn)
+			if !ok || !ok2 {
+				return ErrNotSqlite
+			}
+
+			b, err := dest.Backup("main", src, "main")
+			if err != nil {
+				return err
+			}
+			// copying every page in one step holds the read lock only once,
+			// where smaller steps would start over on each concurrent write
+			if _, err := b.Step(-1); err != nil {
+				b.Close()
+				return err
+			}
+			return b.Finish()
+		})
+	})
+}
+
+// inspect Returns the schema version and the rows in each table of a
+// database file
+func inspect(ctx context.Context, path string) (int, map[string]int64, error) {
+	copyDb, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return 0, nil, err
+	}
+	defer copyDb.Close()
+
+	rows, err := copyDb.QueryContext(ctx, "SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' ORDER BY name")
+	if err != nil {
+		return 0, nil, err
+	}
+	tables := make([]string, 0)
+	for rows.Next() {
+		table := ""
+		if err := rows.Scan(&table); err != nil {
+			rows.Close()
+			return 0, nil, err
+		}
+		tables = append(tables, table)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, nil, err
+	}
+
+	rowCounts := map[string]int64{}
+	schemaVersion := 0
+	for _, table := range tables {
+		count := int64(0)
+		if err := copyDb.QueryRowContext(ctx, `SELECT COUNT(*) FROM "`+table+`"`).Scan(&count); err != nil {
+			return 0, nil, err
+		}
+		rowCounts[table] = count
+
+		if table == "schema_migrations" {
+			if err := copyDb.QueryRowContext(ctx, "SELECT COALESCE(MAX(Version), 0) FROM schema_migrations").Scan(&schemaVersion); err != nil {
+				return 0, nil, err
+			}
+		}
+	}
+
+	return schemaVersion, rowCounts, nil
+}
+
+func sha256File(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func compressFile(src string, dest string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dest, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	zw := gzip.NewWriter(out)
+	if _, err := io.Copy(zw, in); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+
+	return out.Close()
+}
+
+func decompressFile(src string, dest string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	zr, err := gzip.NewReader(in)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	return writeFile(dest, zr)
+}
+
+func copyFile(src string, dest string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	return writeFile(dest, in)
+}
+
+func writeFile(dest string, r io.Reader) error {
+	out, err := os.OpenFile(dest, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	if _, err := io.Copy(out, r); err != nil {
+		return err
+	}
+
+	return out.Close()
+}
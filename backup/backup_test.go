@@ -0,0 +1,229 @@
+package backup_test
+
+/*
+
+  Copyright 2024, YggdrasilSoft, LLC.
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/greeneg/update-reporterd/backup"
+	"github.com/greeneg/update-reporterd/model"
+	"github.com/greeneg/update-reporterd/model/storetest"
+)
+
+// writeManifest Writes manifest next to a backup file in dir
+func writeManifest(t *testing.T, dir string, manifest model.BackupManifest) string {
+	t.Helper()
+	content, err := json.Marshal(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "test.manifest.json")
+	if err := os.WriteFile(path, content, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// countRoles Returns the roles in the database file at path
+func countRoles(t *testing.T, path string) int {
+	t.Helper()
+	db, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	count := 0
+	if err := db.QueryRow("SELECT COUNT(*) FROM Roles").Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+func TestBackupAndRestore(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		name := "plain"
+		if compress {
+			name = "compressed"
+		}
+		t.Run(name, func(t *testing.T) {
+			db := storetest.OpenDatabase(t)
+			if _, err := model.CreateRole(model.Role{RoleName: "backed-up"}); err != nil {
+				t.Fatal(err)
+			}
+			dir := filepath.Join(t.TempDir(), "backups")
+
+			manifestPath, manifest, err := backup.Create(context.Background(), db, dir, compress)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if manifest.Compressed != compress || manifest.SchemaVersion == 0 || manifest.Sha256 == "" {
+				t.Errorf("unexpected manifest %+v", manifest)
+			}
+			if manifest.RowCounts["Roles"] == 0 {
+				t.Errorf("manifest counts no roles: %v", manifest.RowCounts)
+			}
+			read, err := backup.ReadManifest(manifestPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if read.File != manifest.File || read.Sha256 != manifest.Sha256 {
+				t.Errorf("manifest read back as %+v, written as %+v", read, manifest)
+			}
+
+			// a database restored over, which is kept aside
+			dbPath := filepath.Join(t.TempDir(), "restored.db")
+			if err := os.WriteFile(dbPath, []byte("replaced"), 0600); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := backup.Restore(context.Background(), manifestPath, dbPath); err != nil {
+				t.Fatal(err)
+			}
+			if count := countRoles(t, dbPath); int64(count) != manifest.RowCounts["Roles"] {
+				t.Errorf("restored %d roles, the manifest says %d", count, manifest.RowCounts["Roles"])
+			}
+			kept, err := filepath.Glob(dbPath + ".pre-restore-*")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(kept) != 1 {
+				t.Errorf("kept %d copies of the replaced database, expected 1", len(kept))
+			}
+		})
+	}
+}
+
+func TestBackupNeedsSqlite(t *testing.T) {
+	db := &model.Database{Dialect: model.DialectPostgres}
+	if _, _, err := backup.Create(context.Background(), db, t.TempDir(), false); err != backup.ErrNotSqlite {
+		t.Errorf("backing up PostgreSQL returned %v", err)
+	}
+}
+
+func TestManifestNamesAFileNextToIt(t *testing.T) {
+	cases := []struct {
+		name     string
+		manifest model.BackupManifest
+	}{
+		{"parent directory", model.BackupManifest{FormatVersion: backup.FormatVersion, File: "../update-reporterd.db", Sha256: "00"}},
+		{"absolute path", model.BackupManifest{FormatVersion: backup.FormatVersion, File: "/etc/passwd", Sha256: "00"}},
+		{"subdirectory", model.BackupManifest{FormatVersion: backup.FormatVersion, File: "nested/copy.db", Sha256: "00"}},
+		{"dot dot", model.BackupManifest{FormatVersion: backup.FormatVersion, File: "..", Sha256: "00"}},
+		{"dot", model.BackupManifest{FormatVersion: backup.FormatVersion, File: ".", Sha256: "00"}},
+		{"no file", model.BackupManifest{FormatVersion: backup.FormatVersion, Sha256: "00"}},
+		{"no checksum", model.BackupManifest{FormatVersion: backup.FormatVersion, File: "copy.db"}},
+		{"newer format", model.BackupManifest{FormatVersion: backup.FormatVersion + 1, File: "copy.db", Sha256: "00"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := writeManifest(t, t.TempDir(), tc.manifest)
+			if _, err := backup.ReadManifest(path); err == nil {
+				t.Errorf("manifest %+v was accepted", tc.manifest)
+			}
+		})
+	}
+
+	t.Run("not JSON", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "test.manifest.json")
+		if err := os.WriteFile(path, []byte("file = copy.db"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := backup.ReadManifest(path); err == nil {
+			t.Error("a manifest that is not JSON was accepted")
+		}
+	})
+}
+
+func TestRestoreRefusesAMismatchedBackup(t *testing.T) {
+	cases := []struct {
+		name   string
+		tamper func(t *testing.T, dir string, manifest *model.BackupManifest)
+		reason string
+	}{
+		{"changed copy", func(t *testing.T, dir string, manifest *model.BackupManifest) {
+			f, err := os.OpenFile(filepath.Join(dir, manifest.File), os.O_APPEND|os.O_WRONLY, 0600)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			if _, err := f.WriteString("tampered"); err != nil {
+				t.Fatal(err)
+			}
+		}, "checksum"},
+		{"row counts", func(t *testing.T, dir string, manifest *model.BackupManifest) {
+			manifest.RowCounts["Roles"]++
+		}, "rows"},
+		{"schema version", func(t *testing.T, dir string, manifest *model.BackupManifest) {
+			manifest.SchemaVersion--
+		}, "schema version"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			db := storetest.OpenDatabase(t)
+			dir := t.TempDir()
+			_, manifest, err := backup.Create(context.Background(), db, dir, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tc.tamper(t, dir, &manifest)
+			manifestPath := writeManifest(t, dir, manifest)
+
+			dbPath := filepath.Join(t.TempDir(), "current.db")
+			if err := os.WriteFile(dbPath, []byte("current"), 0600); err != nil {
+				t.Fatal(err)
+			}
+			_, err = backup.Restore(context.Background(), manifestPath, dbPath)
+			if err == nil || !strings.Contains(err.Error(), tc.reason) {
+				t.Fatalf("restore returned %v, expected an error about the %s", err, tc.reason)
+			}
+			content, err := os.ReadFile(dbPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(content) != "current" {
+				t.Error("the refused backup replaced the database")
+			}
+		})
+	}
+}
+
+func TestRestoreRefusesANewerSchema(t *testing.T) {
+	db := storetest.OpenDatabase(t)
+	if _, err := db.Exec("INSERT INTO schema_migrations (Version, Name, AppliedDate) VALUES (9999, 'future', '2099-01-01 00:00:00')"); err != nil {
+		t.Fatal(err)
+	}
+	manifestPath, _, err := backup.Create(context.Background(), db, t.TempDir(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dbPath := filepath.Join(t.TempDir(), "current.db")
+	_, err = backup.Restore(context.Background(), manifestPath, dbPath)
+	if err == nil || !strings.Contains(err.Error(), "newer") {
+		t.Fatalf("restore returned %v, expected an error about a newer schema", err)
+	}
+	if _, err := os.Stat(dbPath); !os.IsNotExist(err) {
+		t.Error("a backup from a newer build was restored")
+	}
+}
@@ -0,0 +1,153 @@
+// Package configfile finds, reads and checks the configuration of the daemon
+package configfile
+
+/*
+
+  Copyright 2024, YggdrasilSoft, LLC.
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/greeneg/update-reporterd/globals"
+)
+
+// EnvPathVariable names the config file when no path is given on the command line
+const EnvPathVariable = EnvPrefix + "CONFIG"
+
+// fileNames are looked for in each search directory, in order
+var fileNames = []string{"config.json", "config.yaml", "config.yml"}
+
+// SearchPaths Returns the directories searched for a config file, in order:
+// the config directory next to the binary, the user's XDG config directory,
+// the XDG system config directories and /etc
+func SearchPaths(appDir string) []string {
+	dirs := []string{filepath.Join(appDir, "config")}
+
+	configHome := os.Getenv("XDG_CONFIG_HOME")
+	if configHome == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			configHome = filepath.Join(home, ".config")
+		}
+	}
+	if configHome != "" {
+		dirs = append(dirs, filepath.Join(configHome, "update-reporterd"))
+	}
+
+	configDirs := os.Getenv("XDG_CONFIG_DIRS")
+	if configDirs == "" {
+		configDirs = "/etc/xdg"
+	}
+	for _, dir := range filepath.SplitList(configDirs) {
+		if dir != "" {
+			dirs = append(dirs, filepath.Join(dir, "update-reporterd"))
+		}
+	}
+
+	return append(dirs, "/etc/update-reporterd")
+}
+
+// Find Returns the config file to use: path if given, then the file named by
+// the environment, then the first file found in the search paths. Returns an
+// empty path if there is none, leaving the config to the environment
+func Find(path string, appDir string) (string, error) {
+	if path == "" {
+		path = os.Getenv(EnvPathVariable)
+	}
+	if path != "" {
+		if _, err := os.Stat(path); err != nil {
+			return "", errors.New("config file '" + path + "' cannot be read: " + string(err.Error()))
+		}
+		return path, nil
+	}
+
+	for _, dir := range SearchPaths(appDir) {
+		for _, name := range fileNames {
+			candidate := filepath.Join(dir, name)
+			if _, err := os.Stat(candidate); err == nil {
+				return candidate, nil
+			}
+		}
+	}
+
+	return "", nil
+}
+
+// Load Reads the config file at path, if any, applies the environment
+// overrides on top and validates the result
+func Load(path string) (globals.Config, error) {
+	config := globals.Config{}
+	if path != "" {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return config, err
+		}
+		if err := Decode(content, isYaml(path), &config); err != nil {
+			return config, errors.New("config file '" + path + "': " + string(err.Error()))
+		}
+	}
+
+	if err := ApplyEnv(&config, os.LookupEnv); err != nil {
+		return config, err
+	}
+	if err := Validate(config); err != nil {
+		return config, err
+	}
+
+	return config, nil
+}
+
+func isYaml(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext == ".yaml" || ext == ".yml"
+}
+
+// Decode Decodes a JSON or YAML document into config, refusing keys the
+// config does not have. YAML uses the same keys as JSON
+func Decode(content []byte, isYaml bool, config *globals.Config) error {
+	if isYaml {
+		var document any
+		if err := yaml.Unmarshal(content, &document); err != nil {
+			return err
+		}
+		if document == nil {
+			return errors.New("the document is empty")
+		}
+		var err error
+		content, err = json.Marshal(document)
+		if err != nil {
+			return err
+		}
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(config); err != nil {
+		return err
+	}
+	if decoder.More() {
+		return errors.New("unexpected content after the document")
+	}
+
+	return nil
+}
@@ -0,0 +1,194 @@
+package configfile_test
+
+/*
+
+  Copyright 2024, YggdrasilSoft, LLC.
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/greeneg/update-reporterd/configfile"
+	"github.com/greeneg/update-reporterd/globals"
+)
+
+func TestVariableNames(t *testing.T) {
+	cases := map[string]string{
+		"tcpPort":               "UPDATE_REPORTER_TCP_PORT",
+		"useTls":                "UPDATE_REPORTER_USE_TLS",
+		"tlsPemFile":            "UPDATE_REPORTER_TLS_PEM_FILE",
+		"session.maxAge":        "UPDATE_REPORTER_SESSION_MAX_AGE",
+		"health.minFreeDiskMib": "UPDATE_REPORTER_HEALTH_MIN_FREE_DISK_MIB",
+	}
+	for key, expected := range cases {
+		if name := configfile.Variable(key); name != expected {
+			t.Errorf("%s is overridden by %s, expected %s", key, name, expected)
+		}
+	}
+}
+
+func TestEnvironmentOverrides(t *testing.T) {
+	env := map[string]string{
+		"UPDATE_REPORTER_TCP_PORT":          " 8081 ",
+		"UPDATE_REPORTER_USE_TLS":           "true",
+		"UPDATE_REPORTER_DB_PATH":           "/var/lib/update-reporterd/db",
+		"UPDATE_REPORTER_SESSION_SECRETS":   "first, second,,",
+		"UPDATE_REPORTER_SESSION_HTTP_ONLY": "false",
+		"UPDATE_REPORTER_LDAP_GROUP_ROLES":  `[{"groupDn": "cn=admins", "roleName": "Administrators"}]`,
+	}
+	lookup := func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}
+
+	config := globals.Config{TcpPort: 8080, TLSTcpPort: 8443, Session: globals.SessionConfig{MaxAge: 3600}}
+	if err := configfile.ApplyEnv(&config, lookup); err != nil {
+		t.Fatal(err)
+	}
+
+	if config.TcpPort != 8081 || !config.UseTLS || config.DbPath != "/var/lib/update-reporterd/db" {
+		t.Errorf("top level overrides not applied: %+v", config)
+	}
+	if config.TLSTcpPort != 8443 || config.Session.MaxAge != 3600 {
+		t.Error("values without a variable were changed")
+	}
+	if strings.Join(config.Session.Secrets, "|") != "first|second" {
+		t.Errorf("secrets read as %q", config.Session.Secrets)
+	}
+	if config.Session.HttpOnly == nil || *config.Session.HttpOnly {
+		t.Errorf("httpOnly read as %v", config.Session.HttpOnly)
+	}
+	if len(config.Ldap.GroupRoles) != 1 || config.Ldap.GroupRoles[0].RoleName != "Administrators" {
+		t.Errorf("group roles read as %+v", config.Ldap.GroupRoles)
+	}
+}
+
+func TestEnvironmentOverrideErrors(t *testing.T) {
+	cases := map[string]string{
+		"UPDATE_REPORTER_TCP_PORT":         "http",
+		"UPDATE_REPORTER_USE_TLS":          "sometimes",
+		"UPDATE_REPORTER_LDAP_GROUP_ROLES": "cn=admins",
+	}
+	for name, value := range cases {
+		t.Run(name, func(t *testing.T) {
+			lookup := func(n string) (string, bool) {
+				return value, n == name
+			}
+			err := configfile.ApplyEnv(&globals.Config{}, lookup)
+			if err == nil || !strings.Contains(err.Error(), name) {
+				t.Errorf("'%s' returned %v, expected an error naming the variable", value, err)
+			}
+		})
+	}
+}
+
+func TestDecodeRefusesUnknownKeys(t *testing.T) {
+	cases := []struct {
+		name    string
+		content string
+		isYaml  bool
+	}{
+		{"JSON top level", `{"tcpPort": 8080, "port": 8081}`, false},
+		{"JSON nested", `{"session": {"maxAgeSeconds": 60}}`, false},
+		{"YAML top level", "tcpPort: 8080\nenableTls: true\n", true},
+		{"YAML nested", "session:\n  max_age: 60\n", true},
+		{"JSON trailing content", `{"tcpPort": 8080} {"tcpPort": 8081}`, false},
+		{"YAML empty", "", true},
+		{"wrong type", `{"tcpPort": "8080"}`, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			config := globals.Config{}
+			if err := configfile.Decode([]byte(tc.content), tc.isYaml, &config); err == nil {
+				t.Errorf("%q was accepted", tc.content)
+			}
+		})
+	}
+
+	config := globals.Config{}
+	content := "tcpPort: 8080\nsession:\n  maxAge: 60\n  secrets: [one, two]\n"
+	if err := configfile.Decode([]byte(content), true, &config); err != nil {
+		t.Fatal(err)
+	}
+	if config.TcpPort != 8080 || config.Session.MaxAge != 60 || len(config.Session.Secrets) != 2 {
+		t.Errorf("YAML decoded as %+v", config)
+	}
+}
+
+func TestLoadAppliesTheEnvironmentOverTheFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	content := "tcpPort: 8080\ndbPath: /var/lib/update-reporterd/db\nlogging:\n  level: info\n"
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("UPDATE_REPORTER_TCP_PORT", "9090")
+
+	config, err := configfile.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.TcpPort != 9090 || config.Logging.Level != "info" {
+		t.Errorf("loaded %+v", config)
+	}
+
+	// every problem is reported, each with its variable
+	t.Setenv("UPDATE_REPORTER_TCP_PORT", "70000")
+	t.Setenv("UPDATE_REPORTER_LOGGING_LEVEL", "loud")
+	_, err = configfile.Load(path)
+	var invalid *configfile.ValidationError
+	if !errors.As(err, &invalid) || len(invalid.Problems) != 2 {
+		t.Fatalf("loading an invalid config returned %v", err)
+	}
+	if !strings.Contains(invalid.Problems[0], "UPDATE_REPORTER_TCP_PORT") {
+		t.Errorf("problem %q does not name its variable", invalid.Problems[0])
+	}
+}
+
+func TestFindConfigFile(t *testing.T) {
+	// keep the host's config files out of the search
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("XDG_CONFIG_DIRS", t.TempDir())
+	t.Setenv(configfile.EnvPathVariable, "")
+	appDir := t.TempDir()
+
+	if _, err := configfile.Find(filepath.Join(appDir, "missing.json"), appDir); err == nil {
+		t.Error("a missing config file given by path was accepted")
+	}
+
+	if err := os.Mkdir(filepath.Join(appDir, "config"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	found := filepath.Join(appDir, "config", "config.yml")
+	if err := os.WriteFile(found, []byte("tcpPort: 8080\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if path, err := configfile.Find("", appDir); err != nil || path != found {
+		t.Errorf("found %q, %v, expected %q", path, err, found)
+	}
+
+	named := filepath.Join(t.TempDir(), "named.json")
+	if err := os.WriteFile(named, []byte("{}"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(configfile.EnvPathVariable, named)
+	if path, err := configfile.Find("", appDir); err != nil || path != named {
+		t.Errorf("found %q, %v, expected the file named by the environment", path, err)
+	}
+}
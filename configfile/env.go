@@ -0,0 +1,132 @@
+package configfile
+
+/*
+
+  Copyright 2024, YggdrasilSoft, LLC.
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/greeneg/update-reporterd/globals"
+)
+
+// EnvPrefix starts the name of every environment variable the config reads
+const EnvPrefix = "UPDATE_REPORTER_"
+
+// Variable Returns the environment variable that overrides a config key
+// given as its path of JSON names, e.g. "session.maxAge" is
+// UPDATE_REPORTER_SESSION_MAX_AGE
+func Variable(key string) string {
+	var name strings.Builder
+	name.WriteString(EnvPrefix)
+	for i, part := range strings.Split(key, ".") {
+		if i > 0 {
+			name.WriteRune('_')
+		}
+		for j, r := range part {
+			if j > 0 && unicode.IsUpper(r) && !unicode.IsUpper(rune(part[j-1])) {
+				name.WriteRune('_')
+			}
+			name.WriteRune(unicode.ToUpper(r))
+		}
+	}
+
+	return name.String()
+}
+
+// ApplyEnv Overrides config values with the environment variables set for
+// them. Lists of strings are comma separated, lists of objects are JSON
+func ApplyEnv(config *globals.Config, lookup func(string) (string, bool)) error {
+	return applyEnv(reflect.ValueOf(config).Elem(), "", lookup)
+}
+
+func applyEnv(v reflect.Value, prefix string, lookup func(string) (string, bool)) error {
+	for i := 0; i < v.NumField(); i++ {
+		key, _, _ := strings.Cut(v.Type().Field(i).Tag.Get("json"), ",")
+		if key == "" || key == "-" {
+			continue
+		}
+		key = prefix + key
+		field := v.Field(i)
+
+		if field.Kind() == reflect.Struct {
+			if err := applyEnv(field, key+".", lookup); err != nil {
+				return err
+			}
+			continue
+		}
+
+		name := Variable(key)
+		value, ok := lookup(name)
+		if !ok {
+			continue
+		}
+		if err := setValue(field, value); err != nil {
+			return errors.New("environment variable " + name + ": " + string(err.Error()))
+		}
+	}
+
+	return nil
+}
+
+func setValue(field reflect.Value, value string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Int:
+		n, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			return errors.New("'" + value + "' is not a whole number")
+		}
+		field.SetInt(int64(n))
+	case reflect.Bool:
+		b, err := strconv.ParseBool(strings.TrimSpace(value))
+		if err != nil {
+			return errors.New("'" + value + "' is not true or false")
+		}
+		field.SetBool(b)
+	case reflect.Pointer:
+		elem := reflect.New(field.Type().Elem())
+		if err := setValue(elem.Elem(), value); err != nil {
+			return err
+		}
+		field.Set(elem)
+	case reflect.Slice:
+		if field.Type().Elem().Kind() == reflect.String {
+			items := reflect.MakeSlice(field.Type(), 0, 0)
+			for _, item := range strings.Split(value, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					items = reflect.Append(items, reflect.ValueOf(item))
+				}
+			}
+			field.Set(items)
+			return nil
+		}
+		fallthrough
+	default:
+		if err := json.Unmarshal([]byte(value), field.Addr().Interface()); err != nil {
+			return errors.New("'" + value + "' is not valid JSON: " + string(err.Error()))
+		}
+	}
+
+	return nil
+}
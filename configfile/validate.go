@@ -0,0 +1,245 @@
+package configfile
+
+/*
+
+  Copyright 2024, YggdrasilSoft, LLC.
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+import (
+	"net"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/greeneg/update-reporterd/globals"
+	"github.com/greeneg/update-reporterd/listener"
+	"github.com/greeneg/update-reporterd/sessionstore"
+)
+
+// ValidationError lists every problem found in a config
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid configuration:\n  " + strings.Join(e.Problems, "\n  ")
+}
+
+type validator struct {
+	problems []string
+}
+
+func (v *validator) check(ok bool, key string, problem string) {
+	if !ok {
+		v.problems = append(v.problems, key+" "+problem+" (environment variable "+Variable(key)+")")
+	}
+}
+
+func (v *validator) port(port int, key string, required bool) {
+	if required {
+		v.check(port > 0 && port <= 65535, key, "must be between 1 and 65535, got "+strconv.Itoa(port))
+	} else {
+		v.check(port >= 0 && port <= 65535, key, "must be between 0 and 65535, got "+strconv.Itoa(port))
+	}
+}
+
+func (v *validator) atLeast(value int, min int, key string) {
+	v.check(value >= min, key, "must be "+strconv.Itoa(min)+" or more, got "+strconv.Itoa(value))
+}
+
+func (v *validator) oneOf(value string, key string, allowed ...string) {
+	v.check(value == "" || slices.Contains(allowed, value), key,
+		"must be one of "+strings.Join(allowed, ", ")+", got '"+value+"'")
+}
+
+func (v *validator) file(path string, key string) {
+	if path == "" {
+		v.check(false, key, "is not set")
+		return
+	}
+	_, err := os.Stat(path)
+	v.check(err == nil, key, "cannot be read: "+errorString(err))
+}
+
+func (v *validator) url(value string, key string, schemes ...string) {
+	if value == "" {
+		v.check(false, key, "is not set")
+		return
+	}
+	parsed, err := url.Parse(value)
+	if err != nil {
+		v.check(false, key, "is not a valid URL: "+string(err.Error()))
+		return
+	}
+	v.check(slices.Contains(schemes, parsed.Scheme) && parsed.Host != "", key,
+		"must be a "+strings.Join(schemes, ":// or ")+":// URL, got '"+value+"'")
+}
+
+func (v *validator) address(address string, key string) {
+	if address == "" {
+		return
+	}
+	err := listener.CheckAddress(address)
+	v.check(err == nil, key, errorString(err))
+}
+
+func (v *validator) rateLimit(limit globals.RateLimit, key string) {
+	v.atLeast(limit.PerMinute, -1, key+".perMinute")
+	v.atLeast(limit.Burst, 0, key+".burst")
+}
+
+// checkProxy Returns whether a trusted proxy is an address or a CIDR range
+func checkProxy(proxy string) bool {
+	if strings.Contains(proxy, "/") {
+		_, _, err := net.ParseCIDR(proxy)
+		return err == nil
+	}
+	return net.ParseIP(proxy) != nil
+}
+
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return string(err.Error())
+}
+
+// Validate Checks a config for values the daemon cannot run with, reporting
+// all of them at once as a ValidationError
+func Validate(config globals.Config) error {
+	v := &validator{}
+
+	servesHttp := !config.UseTLS || config.Listen.HttpMode == globals.HttpModeServe ||
+		config.Listen.HttpMode == globals.HttpModeRedirect
+	v.port(config.TcpPort, "tcpPort", servesHttp && config.Listen.HttpAddress == "")
+	v.port(config.TLSTcpPort, "tlsTcpPort", config.UseTLS && config.Listen.HttpsAddress == "")
+	v.address(config.Listen.HttpAddress, "listen.httpAddress")
+	v.address(config.Listen.HttpsAddress, "listen.httpsAddress")
+	v.oneOf(config.Listen.HttpMode, "listen.httpMode", globals.HttpModeOff, globals.HttpModeServe, globals.HttpModeRedirect)
+	v.check(config.UseTLS || config.Listen.HttpMode != globals.HttpModeRedirect, "listen.httpMode",
+		"'redirect' requires useTls")
+	_, err := listener.ParseMode(config.Listen.SocketMode)
+	v.check(err == nil, "listen.socketMode", errorString(err))
+	v.atLeast(config.Listen.ReadHeaderTimeoutSeconds, 0, "listen.readHeaderTimeoutSeconds")
+	v.atLeast(config.Listen.ReadTimeoutSeconds, 0, "listen.readTimeoutSeconds")
+	v.atLeast(config.Listen.WriteTimeoutSeconds, 0, "listen.writeTimeoutSeconds")
+	v.atLeast(config.Listen.IdleTimeoutSeconds, 0, "listen.idleTimeoutSeconds")
+	v.atLeast(config.Listen.ShutdownTimeoutSeconds, 0, "listen.shutdownTimeoutSeconds")
+	for _, proxy := range config.Listen.TrustedProxies {
+		v.check(checkProxy(proxy), "listen.trustedProxies", "entry '"+proxy+"' is not an IP address or CIDR range")
+	}
+	if config.UseTLS {
+		v.file(config.TLSPemFile, "tlsPemFile")
+		v.file(config.TLSKeyFile, "tlsKeyFile")
+		for i, certificate := range config.TLS.Certificates {
+			v.check(certificate.PemFile != "" && certificate.KeyFile != "", "tls.certificates",
+				"entry "+strconv.Itoa(i+1)+" needs a pemFile and a keyFile")
+			if certificate.PemFile != "" && certificate.KeyFile != "" {
+				v.file(certificate.PemFile, "tls.certificates")
+				v.file(certificate.KeyFile, "tls.certificates")
+			}
+		}
+	}
+	v.atLeast(config.TLS.WatchIntervalSeconds, 0, "tls.watchIntervalSeconds")
+	v.atLeast(config.TLS.ExpiryWarningDays, 0, "tls.expiryWarningDays")
+
+	v.oneOf(config.Database.Driver, "database.driver", globals.DatabaseSqlite, globals.DatabasePostgres)
+	if config.Database.Driver == globals.DatabasePostgres {
+		v.check(config.Database.Url != "", "database.url", "is not set")
+	} else {
+		v.check(config.DbPath != "", "dbPath", "is not set")
+	}
+	v.atLeast(config.Database.QueryTimeoutSeconds, 0, "database.queryTimeoutSeconds")
+
+	v.oneOf(config.Session.Store, "session.store", globals.SessionStoreCookie, globals.SessionStoreDatabase)
+	for i, secret := range config.Session.Secrets {
+		v.check(len(secret) >= sessionstore.MinSecretLength, "session.secrets", "entry "+strconv.Itoa(i+1)+
+			" is shorter than "+strconv.Itoa(sessionstore.MinSecretLength)+" bytes")
+	}
+	if config.Session.SecretFile != "" {
+		v.file(config.Session.SecretFile, "session.secretFile")
+	}
+	v.atLeast(config.Session.MaxAge, 0, "session.maxAge")
+	v.oneOf(config.Session.SameSite, "session.sameSite", "default", "lax", "strict", "none")
+	v.check(config.Session.SameSite != "none" || config.Session.Secure, "session.sameSite",
+		"'none' requires session.secure")
+
+	v.atLeast(config.PasswordPolicy.MinLength, 0, "passwordPolicy.minLength")
+	v.atLeast(config.PasswordPolicy.HistoryCount, 0, "passwordPolicy.historyCount")
+	v.atLeast(config.PasswordPolicy.MaxAgeDays, 0, "passwordPolicy.maxAgeDays")
+
+	v.atLeast(config.Lockout.MaxFailedAttempts, 0, "lockout.maxFailedAttempts")
+	v.atLeast(config.Lockout.MaxFailedAttemptsPerIp, 0, "lockout.maxFailedAttemptsPerIp")
+	v.atLeast(config.Lockout.WindowMinutes, 0, "lockout.windowMinutes")
+	v.atLeast(config.Lockout.CooldownMinutes, -1, "lockout.cooldownMinutes")
+
+	v.rateLimit(config.RateLimit.Auth, "rateLimit.auth")
+	v.rateLimit(config.RateLimit.AuthFailures, "rateLimit.authFailures")
+	v.rateLimit(config.RateLimit.Ingestion, "rateLimit.ingestion")
+
+	v.atLeast(config.Retention.ReportDays, -1, "retention.reportDays")
+	v.atLeast(config.Retention.AggregateMonths, -1, "retention.aggregateMonths")
+	v.atLeast(config.Retention.DecommissionedSystemDays, 0, "retention.decommissionedSystemDays")
+	v.atLeast(config.Retention.IntervalMinutes, 0, "retention.intervalMinutes")
+	v.atLeast(config.Retention.BatchSize, 0, "retention.batchSize")
+
+	v.oneOf(strings.ToLower(config.Logging.Level), "logging.level", "debug", "info", "warn", "error")
+	v.oneOf(strings.ToLower(config.Logging.Format), "logging.format", globals.LogFormatText, globals.LogFormatJson)
+
+	v.atLeast(config.Health.DbTimeoutSeconds, 0, "health.dbTimeoutSeconds")
+	v.atLeast(config.Health.MinFreeDiskMiB, 0, "health.minFreeDiskMib")
+	v.check(config.Health.MinFreeDiskPercent >= 0 && config.Health.MinFreeDiskPercent <= 100,
+		"health.minFreeDiskPercent", "must be between 0 and 100, got "+strconv.Itoa(config.Health.MinFreeDiskPercent))
+	v.atLeast(config.Health.MaxIngestionLagMinutes, 0, "health.maxIngestionLagMinutes")
+	v.atLeast(config.Health.StaleAgentHours, 0, "health.staleAgentHours")
+	v.check(config.Health.MaxStaleAgentPercent >= 0 && config.Health.MaxStaleAgentPercent <= 100,
+		"health.maxStaleAgentPercent", "must be between 0 and 100, got "+strconv.Itoa(config.Health.MaxStaleAgentPercent))
+
+	if config.Ldap.Enabled {
+		v.url(config.Ldap.Url, "ldap.url", "ldap", "ldaps")
+		v.check(config.Ldap.BaseDn != "", "ldap.baseDn", "is not set")
+		v.check(!config.Ldap.StartTLS || !strings.HasPrefix(strings.ToLower(config.Ldap.Url), "ldaps://"), "ldap.startTls",
+			"cannot be used with an ldaps:// url")
+		v.check(config.Ldap.UserFilter == "" || strings.Contains(config.Ldap.UserFilter, "%s"), "ldap.userFilter",
+			"must contain %s for the user name")
+		if config.Ldap.CaFile != "" {
+			v.file(config.Ldap.CaFile, "ldap.caFile")
+		}
+		v.atLeast(config.Ldap.TimeoutSeconds, 0, "ldap.timeoutSeconds")
+		for i, groupRole := range config.Ldap.GroupRoles {
+			v.check(groupRole.GroupDn != "" && groupRole.RoleName != "", "ldap.groupRoles",
+				"entry "+strconv.Itoa(i+1)+" needs a groupDn and a roleName")
+		}
+	}
+
+	if config.Oidc.Enabled {
+		v.url(config.Oidc.Issuer, "oidc.issuer", "https", "http")
+		v.check(config.Oidc.ClientId != "", "oidc.clientId", "is not set")
+		v.url(config.Oidc.RedirectUrl, "oidc.redirectUrl", "https", "http")
+		for i, claimRole := range config.Oidc.ClaimRoles {
+			v.check(claimRole.Value != "" && claimRole.RoleName != "", "oidc.claimRoles",
+				"entry "+strconv.Itoa(i+1)+" needs a value and a roleName")
+		}
+	}
+
+	if len(v.problems) > 0 {
+		return &ValidationError{Problems: v.problems}
+	}
+
+	return nil
+}
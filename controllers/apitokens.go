@@ -0,0 +1,173 @@
+package controllers
+
+/*
+
+  Copyright 2024, YggdrasilSoft, LLC.
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/greeneg/update-reporterd/apierror"
+	"github.com/greeneg/update-reporterd/helpers"
+	"github.com/greeneg/update-reporterd/model"
+)
+
+// notWithApiToken Returns whether the request was made without an API token,
+// otherwise writes an error response. Tokens may not manage tokens, so a
+// leaked one cannot be used to mint broader or longer lived ones
+func notWithApiToken(c *gin.Context) bool {
+	if _, isToken := apiTokenScopes(c); isToken {
+		apierror.Respond(c, http.StatusForbidden, "API tokens cannot be managed with an API token")
+		return false
+	}
+	return true
+}
+
+// GetMyApiTokens Retrieve the logged in user's API tokens
+//
+//	@Summary		Retrieve your API tokens
+//	@Description	Retrieve the API tokens of the logged in user. The tokens themselves are never shown again after creation
+//	@Tags			token
+//	@Produce		json
+//	@Security		BasicAuth
+//	@Success		200	{object}	model.ApiTokensList
+//	@Failure		403	{object}	model.FailureMsg
+//	@Router			/me/tokens [get]
+func (u *UpdateReporter) GetMyApiTokens(c *gin.Context) {
+	user, authed := u.GetUserId(c)
+	if authed {
+		if !notWithApiToken(c) {
+			return
+		}
+
+		tokens, err := model.GetApiTokensByUserName(user.UserName)
+		if err != nil {
+			apierror.Error(c, err)
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, gin.H{"data": tokens})
+	} else {
+		accessDenied(c)
+	}
+}
+
+// CreateMyApiToken Create an API token for the logged in user
+//
+//	@Summary		Create an API token
+//	@Description	Create an API token for the logged in user. Scopes are read, write and admin, and may not exceed
+//	@Description	the permissions of the user's role. The token is only shown in this response
+//	@Tags			token
+//	@Accept			json
+//	@Produce		json
+//	@Param			token	body	model.ProposedApiToken	true	"Token data"
+//	@Security		BasicAuth
+//	@Success		200	{object}	model.CreatedApiToken
+//	@Failure		400	{object}	model.FailureMsg
+//	@Failure		403	{object}	model.FailureMsg
+//	@Router			/me/tokens [post]
+func (u *UpdateReporter) CreateMyApiToken(c *gin.Context) {
+	user, authed := u.GetUserId(c)
+	if authed {
+		if !notWithApiToken(c) {
+			return
+		}
+
+		var json model.ProposedApiToken
+		if err := c.ShouldBindJSON(&json); err != nil {
+			apierror.Respond(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		if json.Name == "" {
+			apierror.Respond(c, http.StatusBadRequest, "Token name is required")
+			return
+		}
+		if json.ExpiresInDays < 0 {
+			apierror.Respond(c, http.StatusBadRequest, "expiresInDays cannot be negative")
+			return
+		}
+		scopes, err := helpers.ValidateApiTokenScopes(user, json.Scopes)
+		if err != nil {
+			apierror.Error(c, err)
+			return
+		}
+
+		token, tokenPrefix, tokenHash, err := helpers.GenerateApiToken()
+		if err != nil {
+			apierror.Error(c, err)
+			return
+		}
+		id, err := model.CreateApiToken(user.UserName, json.Name, tokenPrefix, tokenHash, scopes,
+			helpers.ApiTokenExpiry(json.ExpiresInDays))
+		if err != nil {
+			apierror.Error(c, err)
+			return
+		}
+		apiToken, err := model.GetApiTokenById(id)
+		if err != nil {
+			apierror.Error(c, err)
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, model.CreatedApiToken{ApiToken: apiToken, Token: token})
+	} else {
+		accessDenied(c)
+	}
+}
+
+// DeleteMyApiToken Revoke one of the logged in user's API tokens
+//
+//	@Summary		Revoke an API token
+//	@Description	Revoke one of the logged in user's API tokens
+//	@Tags			token
+//	@Produce		json
+//	@Param			tokenId	path	int	true	"Token Id"
+//	@Security		BasicAuth
+//	@Success		200	{object}	model.SuccessMsg
+//	@Failure		400	{object}	model.FailureMsg
+//	@Failure		403	{object}	model.FailureMsg
+//	@Failure		404	{object}	model.FailureMsg
+//	@Router			/me/tokens/{tokenId} [delete]
+func (u *UpdateReporter) DeleteMyApiToken(c *gin.Context) {
+	user, authed := u.GetUserId(c)
+	if authed {
+		if !notWithApiToken(c) {
+			return
+		}
+
+		tokenId, err := strconv.Atoi(c.Param("tokenId"))
+		if err != nil {
+			apierror.Respond(c, http.StatusBadRequest, "Invalid token Id")
+			return
+		}
+		status, err := model.DeleteApiToken(user.UserName, tokenId)
+		if err != nil {
+			apierror.Error(c, err)
+			return
+		}
+		if !status {
+			apierror.Respond(c, http.StatusNotFound, "No such API token Id "+strconv.Itoa(tokenId))
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, gin.H{"message": "API token Id " + strconv.Itoa(tokenId) + " has been revoked"})
+	} else {
+		accessDenied(c)
+	}
+}
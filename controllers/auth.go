@@ -0,0 +1,179 @@
+package controllers
+
+/*
+
+  Copyright 2024, YggdrasilSoft, LLC.
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/greeneg/update-reporterd/apierror"
+	"github.com/greeneg/update-reporterd/globals"
+	"github.com/greeneg/update-reporterd/helpers"
+	"github.com/greeneg/update-reporterd/model"
+	"github.com/greeneg/update-reporterd/ratelimit"
+	"github.com/greeneg/update-reporterd/sessionstore"
+)
+
+// Login Start a session for a user
+//
+//	@Summary		Log in
+//	@Description	Verify a user's credentials and start a session for them. Users enrolled in
+//	@Description	two-factor authentication must also send a TOTP or recovery code
+//	@Tags			auth
+//	@Accept			json
+//	@Produce		json
+//	@Param			credentials	body	model.Credentials	true	"User credentials"
+//	@Success		200	{object}	UserProfile
+//	@Failure		400	{object}	model.FailureMsg
+//	@Failure		401	{object}	TwoFactorRequiredMsg
+//	@Failure		429	{object}	model.FailureMsg
+//	@Header			429	{integer}	Retry-After	"Seconds to wait before trying again"
+//	@Router			/login [post]
+func (u *UpdateReporter) Login(c *gin.Context) {
+	if !ratelimit.CheckClient(c) {
+		return
+	}
+
+	var json model.Credentials
+	if err := c.ShouldBindJSON(&json); err != nil {
+		apierror.Respond(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if helpers.EmptyUserPass(json.UserName, json.Password) {
+		apierror.Respond(c, http.StatusBadRequest, "User name and password are required")
+		return
+	}
+
+	if !ratelimit.CheckUser(c, json.UserName) {
+		return
+	}
+	user, ok := helpers.AuthenticateUser(json.UserName, json.Password, c.ClientIP())
+	if !ok {
+		ratelimit.RecordFailure(json.UserName)
+		slog.ErrorContext(c.Request.Context(), "Login failed", "user", json.UserName)
+		apierror.Respond(c, http.StatusUnauthorized, "not authorized!")
+		return
+	}
+
+	// enrolled users need their second factor as well
+	enrolled, err := helpers.CheckTwoFactorEnrolled(user)
+	if err != nil {
+		apierror.Error(c, err)
+		return
+	}
+	if enrolled {
+		if json.TotpCode == "" {
+			c.IndentedJSON(http.StatusUnauthorized, TwoFactorRequiredMsg{
+				FailureMsg:        apierror.New(c, apierror.CodeTwoFactorRequired, "Two-factor code required"),
+				TwoFactorRequired: true,
+			})
+			return
+		}
+		if !helpers.CheckSecondFactor(user, json.TotpCode, c.ClientIP()) {
+			ratelimit.RecordFailure(json.UserName)
+			slog.ErrorContext(c.Request.Context(), "Login failed, wrong two-factor code", "user", json.UserName)
+			apierror.Respond(c, http.StatusUnauthorized, "not authorized!")
+			return
+		}
+	}
+	helpers.RecordLoginSuccess(user.UserName, c.ClientIP())
+
+	session := sessions.Default(c)
+	session.Clear()
+	if err := sessionstore.Renew(session); err != nil {
+		slog.ErrorContext(c.Request.Context(), "Cannot renew session", "error", err)
+		apierror.Internal(c)
+		return
+	}
+	session.Set(globals.UserKey, user.UserName)
+	if err := session.Save(); err != nil {
+		apierror.Respond(c, http.StatusInternalServerError, "failed to save user session")
+		return
+	}
+	slog.InfoContext(c.Request.Context(), "User logged in", "user", user.UserName)
+
+	profile, err := userProfile(user)
+	if err != nil {
+		apierror.Error(c, err)
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, profile)
+}
+
+// Logout End the current session
+//
+//	@Summary		Log out
+//	@Description	End the current session
+//	@Tags			auth
+//	@Produce		json
+//	@Success		200	{object}	model.SuccessMsg
+//	@Failure		500	{object}	model.FailureMsg
+//	@Router			/logout [post]
+func (u *UpdateReporter) Logout(c *gin.Context) {
+	session := sessions.Default(c)
+	user := session.Get(globals.UserKey)
+
+	options, err := sessionstore.Options(u.Config().Session)
+	if err != nil {
+		apierror.Error(c, err)
+		return
+	}
+	// a negative max age removes the session and expires the cookie
+	options.MaxAge = -1
+	session.Clear()
+	session.Options(options)
+	if err := session.Save(); err != nil {
+		apierror.Respond(c, http.StatusInternalServerError, "failed to clear user session")
+		return
+	}
+
+	if user != nil {
+		slog.InfoContext(c.Request.Context(), "User logged out", "user", user)
+	}
+	c.IndentedJSON(http.StatusOK, gin.H{"message": "Logged out"})
+}
+
+// GetMe Retrieve the current user
+//
+//	@Summary		Retrieve the current user
+//	@Description	Retrieve the current user along with their role and permissions
+//	@Tags			auth
+//	@Produce		json
+//	@Security		BasicAuth
+//	@Success		200	{object}	UserProfile
+//	@Failure		403	{object}	model.FailureMsg
+//	@Router			/me [get]
+func (u *UpdateReporter) GetMe(c *gin.Context) {
+	user, authed := u.GetUserId(c)
+	if authed {
+		profile, err := userProfile(user)
+		if err != nil {
+			apierror.Error(c, err)
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, profile)
+	} else {
+		accessDenied(c)
+	}
+}
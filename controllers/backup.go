@@ -0,0 +1,82 @@
+package controllers
+
+/*
+
+  Copyright 2024, YggdrasilSoft, LLC.
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/greeneg/update-reporterd/apierror"
+	"github.com/greeneg/update-reporterd/backup"
+	"github.com/greeneg/update-reporterd/model"
+)
+
+// CreateBackup Back up the database while the service runs
+//
+//	@Summary		Back up the database
+//	@Description	Write a consistent copy of the SQLite database and its manifest to the backup directory on the server
+//	@Tags			admin
+//	@Produce		json
+//	@Param			compress	query	bool	false	"gzip the copy, defaults to the backup config"
+//	@Security		BasicAuth
+//	@Success		200	{object}	model.CreatedBackup
+//	@Failure		400	{object}	model.FailureMsg
+//	@Failure		403	{object}	model.FailureMsg
+//	@Failure		500	{object}	model.FailureMsg
+//	@Failure		501	{object}	model.FailureMsg
+//	@Router			/admin/backup [post]
+func (u *UpdateReporter) CreateBackup(c *gin.Context) {
+	_, authed := u.GetAdminUserId(c)
+	if authed {
+		config := u.Config()
+		compress := config.Backup.Compress
+		if value := c.Query("compress"); value != "" {
+			var err error
+			compress, err = strconv.ParseBool(value)
+			if err != nil {
+				apierror.Respond(c, http.StatusBadRequest, "compress must be true or false")
+				return
+			}
+		}
+
+		dir := config.Backup.Directory
+		if dir == "" {
+			dir = backup.DefaultDirectory(config.DbPath)
+		}
+
+		manifestPath, manifest, err := backup.Create(c.Request.Context(), model.DB, dir, compress)
+		if err != nil {
+			if err == backup.ErrNotSqlite {
+				apierror.Respond(c, http.StatusNotImplemented, "Online backup is only supported for SQLite databases")
+				return
+			}
+			apierror.Error(c, err)
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, model.CreatedBackup{
+			Message:  "Database backed up",
+			Path:     manifestPath,
+			Manifest: manifest,
+		})
+	} else {
+		accessDenied(c)
+	}
+}
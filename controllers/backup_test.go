@@ -0,0 +1,66 @@
+package controllers
+
+/*
+
+  Copyright 2024, YggdrasilSoft, LLC.
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-contrib/sessions/cookie"
+	"github.com/gin-gonic/gin"
+
+	"github.com/greeneg/update-reporterd/globals"
+	"github.com/greeneg/update-reporterd/model/storetest"
+)
+
+func TestFailedBackupKeepsItsDetails(t *testing.T) {
+	storetest.OpenDatabase(t)
+	createTestUsers(t)
+
+	// a file in the way of the backup directory
+	blocker := filepath.Join(t.TempDir(), "not-a-directory")
+	if err := os.WriteFile(blocker, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(sessions.Sessions("session", cookie.NewStore([]byte("0123456789abcdef0123456789abcdef"))), authAs)
+	u := &UpdateReporter{ConfStruct: globals.Config{
+		Backup: globals.BackupConfig{Directory: filepath.Join(blocker, "backups")},
+	}}
+	r.POST("/admin/backup", u.CreateBackup)
+
+	req := httptest.NewRequest(http.MethodPost, "/admin/backup", nil)
+	req.Header.Set("X-Test-User", "root")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("a backup that cannot be written was answered %d: %s", w.Code, w.Body.String())
+	}
+	if strings.Contains(w.Body.String(), "not-a-directory") {
+		t.Errorf("the response gives away the error: %s", w.Body.String())
+	}
+}
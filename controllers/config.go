@@ -0,0 +1,200 @@
+package controllers
+
+/*
+
+  Copyright 2024, YggdrasilSoft, LLC.
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/greeneg/update-reporterd/apierror"
+	"github.com/greeneg/update-reporterd/configfile"
+	"github.com/greeneg/update-reporterd/globals"
+	"github.com/greeneg/update-reporterd/helpers"
+	"github.com/greeneg/update-reporterd/ldapauth"
+	"github.com/greeneg/update-reporterd/logging"
+	"github.com/greeneg/update-reporterd/model"
+	"github.com/greeneg/update-reporterd/oidcauth"
+	"github.com/greeneg/update-reporterd/ratelimit"
+)
+
+// restartRequired are the config keys whose changes only take effect after a
+// restart, as listeners, the database and the session store are set up once
+var restartRequired = []string{
+	"tcpPort",
+	"tlsTcpPort",
+	"useTls",
+	"listen",
+	"dbPath",
+	"database",
+	"disableAutoMigrate",
+	"session",
+}
+
+// Config Returns the config in effect
+func (u *UpdateReporter) Config() globals.Config {
+	u.configMu.RLock()
+	defer u.configMu.RUnlock()
+
+	return u.ConfStruct
+}
+
+// changedKeys Returns the top level config keys whose values differ, split
+// into those applied now and those that need a restart. The running values of
+// the latter are copied into loaded
+func changedKeys(running globals.Config, loaded *globals.Config) (applied []string, restart []string) {
+	runningValue := reflect.ValueOf(running)
+	loadedValue := reflect.ValueOf(loaded).Elem()
+	for i := 0; i < runningValue.NumField(); i++ {
+		key, _, _ := strings.Cut(runningValue.Type().Field(i).Tag.Get("json"), ",")
+		if reflect.DeepEqual(runningValue.Field(i).Interface(), loadedValue.Field(i).Interface()) {
+			continue
+		}
+		if slices.Contains(restartRequired, key) {
+			restart = append(restart, key)
+			// the running value stays in effect until the restart
+			loadedValue.Field(i).Set(runningValue.Field(i))
+		} else {
+			applied = append(applied, key)
+		}
+	}
+
+	return applied, restart
+}
+
+// ReloadConfig Reads the config again and applies the settings that can
+// change while running. The TLS certificates are read again even if their
+// paths are unchanged, to pick up renewed ones. Nothing is applied if any part of
+// the new config cannot be
+func (u *UpdateReporter) ReloadConfig(ctx context.Context) (model.ConfigReload, error) {
+	u.reloadMu.Lock()
+	defer u.reloadMu.Unlock()
+
+	config, err := configfile.Load(u.ConfigPath)
+	if err != nil {
+		slog.Error("Config reload failed, keeping the running config", "error", err)
+		return model.ConfigReload{}, err
+	}
+
+	running := u.Config()
+	applied, restart := changedKeys(running, &config)
+
+	// set up everything that can fail before anything is applied
+	ldapChanged := slices.Contains(applied, "ldap")
+	var ldapAuthenticator *ldapauth.Authenticator
+	if ldapChanged && config.Ldap.Enabled {
+		ldapAuthenticator, err = ldapauth.New(config.Ldap)
+		if err != nil {
+			slog.Error("Config reload failed, keeping the running config", "error", err)
+			return model.ConfigReload{}, err
+		}
+	}
+	oidcChanged := slices.Contains(applied, "oidc")
+	var oidcProvider *oidcauth.Provider
+	if oidcChanged && config.Oidc.Enabled {
+		oidcProvider, err = oidcauth.New(ctx, config.Oidc)
+		if err != nil {
+			slog.Error("Config reload failed, keeping the running config", "error", err)
+			return model.ConfigReload{}, err
+		}
+	}
+	logHandler, err := logging.NewHandler(os.Stderr, config.Logging)
+	if err != nil {
+		slog.Error("Config reload failed, keeping the running config", "error", err)
+		return model.ConfigReload{}, err
+	}
+	certificateReloaded := false
+	if u.Certificates != nil {
+		if err := u.Certificates.Reload(config); err != nil {
+			slog.Error("Config reload failed, keeping the running config", "error", err)
+			return model.ConfigReload{}, err
+		}
+		certificateReloaded = true
+	}
+
+	slog.SetDefault(slog.New(logHandler))
+	model.SetPasswordPolicy(config.PasswordPolicy)
+	helpers.SetLockoutPolicy(config.Lockout)
+	ratelimit.Configure(config.RateLimit)
+	if ldapChanged {
+		helpers.SetLdapAuthenticator(ldapAuthenticator)
+	}
+	if oidcChanged {
+		helpers.SetOidcProvider(oidcProvider)
+	}
+	if u.Retention != nil {
+		u.Retention.Reconfigure(config.Retention)
+	}
+
+	u.configMu.Lock()
+	u.ConfStruct = config
+	u.configMu.Unlock()
+
+	result := model.ConfigReload{
+		Message:             "Configuration reloaded",
+		ConfigPath:          u.ConfigPath,
+		Applied:             applied,
+		RestartRequired:     restart,
+		CertificateReloaded: certificateReloaded,
+	}
+	if result.Applied == nil {
+		result.Applied = []string{}
+	}
+	if result.RestartRequired == nil {
+		result.RestartRequired = []string{}
+	}
+
+	slog.Info("Configuration reloaded", "applied", result.Applied)
+	if len(restart) > 0 {
+		slog.Warn("Changes take effect after a restart", "keys", restart)
+	}
+
+	return result, nil
+}
+
+// PostConfigReload Reload the configuration
+//
+//	@Summary		Reload the configuration
+//	@Description	Read the configuration again, as on SIGHUP, and apply the settings that can change while running. Settings that need a restart are listed and keep their running values
+//	@Tags			admin
+//	@Produce		json
+//	@Security		BasicAuth
+//	@Success		200	{object}	model.ConfigReload
+//	@Failure		403	{object}	model.FailureMsg
+//	@Failure		500	{object}	model.FailureMsg
+//	@Router			/admin/config/reload [post]
+func (u *UpdateReporter) PostConfigReload(c *gin.Context) {
+	_, authed := u.GetAdminUserId(c)
+	if authed {
+		result, err := u.ReloadConfig(c.Request.Context())
+		if err != nil {
+			apierror.Error(c, err)
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, result)
+	} else {
+		accessDenied(c)
+	}
+}
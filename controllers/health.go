@@ -19,173 +19,219 @@ package controllers
 */
 
 import (
-	"encoding/json"
-	"errors"
-	"log"
+	"context"
 	"net/http"
-	"os"
 	"path/filepath"
+	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/greeneg/update-reporterd/globals"
+	"github.com/greeneg/update-reporterd/model"
+	"github.com/greeneg/update-reporterd/tlscert"
 	"golang.org/x/sys/unix"
 )
 
-// getConfig Returns our configuration
-func getConfig() (globals.Config, error) {
-	config := globals.Config{}
-	appdir, err := filepath.Abs(filepath.Dir(os.Args[0]))
-	if err != nil {
-		return config, err
+// results of a health check, from best to worst
+const (
+	healthOk        = "OK"
+	healthWarning   = "WARNING"
+	healthUnhealthy = "UNHEALTHY"
+)
+
+// healthReport collects the results of the checks, the overall health being
+// the worst of them
+type healthReport struct {
+	model.HealthCheck
+}
+
+func newHealthReport() *healthReport {
+	return &healthReport{HealthCheck: model.HealthCheck{Health: healthOk}}
+}
+
+// set Records the result of a check, with the problem found, if any
+func (h *healthReport) set(check *string, result string, problem string) {
+	*check = result
+	if problem != "" {
+		*check += ": " + problem
 	}
-	configDir := filepath.Join(appdir, "config")
-	jsonContent, err := os.ReadFile(filepath.Join(configDir, "config.json"))
-	if err != nil {
-		return config, err
+	if result == healthUnhealthy || (result == healthWarning && h.Health == healthOk) {
+		h.Health = result
 	}
-	err = json.Unmarshal(jsonContent, &config)
-	if err != nil {
-		return config, err
+}
+
+// respond Sends the report, with 503 once a check is unhealthy so that load
+// balancers stop sending requests
+func (h *healthReport) respond(c *gin.Context) {
+	h.Status = http.StatusOK
+	if h.Health == healthUnhealthy {
+		h.Status = http.StatusServiceUnavailable
 	}
+	c.IndentedJSON(h.Status, h.HealthCheck)
+}
 
-	return config, nil
+func withDefault(value int, fallback int) int {
+	if value <= 0 {
+		return fallback
+	}
+	return value
 }
 
-// checkDbIsPresent Returns whether the DB is present
-func checkDbIsPresent() (bool, error) {
-	// get our config
-	config, err := getConfig()
-	if err != nil {
-		return false, err
+// checkDb Pings the database and runs a trivial query, within the timeout
+func checkDb(ctx context.Context, h *healthReport, config globals.HealthConfig) {
+	timeout := time.Duration(withDefault(config.DbTimeoutSeconds, globals.DefaultHealthDbTimeoutSeconds)) * time.Second
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	if err := model.Probe(ctx); err != nil {
+		h.set(&h.Db, healthUnhealthy, string(err.Error()))
+		return
 	}
+	h.set(&h.Db, healthOk, "")
+}
 
-	dbFile := config.DbPath
-	if _, err := os.Stat(dbFile); err == nil {
-		return true, nil
-	} else if errors.Is(err, os.ErrNotExist) {
-		return false, err
+// checkDisk Checks the filesystem holding the SQLite database for free space
+// and that the database and its directory, where the journal is written, are
+// writable
+func checkDisk(h *healthReport, dbPath string, config globals.HealthConfig) {
+	var stat unix.Statfs_t
+	if err := unix.Statfs(filepath.Dir(dbPath), &stat); err != nil {
+		h.set(&h.DiskSpace, healthUnhealthy, string(err.Error()))
 	} else {
-		// should never get here, this is a schroedinger case
-		return false, err
+		freeMiB := int64(stat.Bavail * uint64(stat.Bsize) / (1024 * 1024))
+		freePercent := 100
+		if stat.Blocks > 0 {
+			freePercent = int(stat.Bavail * 100 / stat.Blocks)
+		}
+		minMiB := int64(withDefault(config.MinFreeDiskMiB, globals.DefaultHealthMinFreeDiskMiB))
+		free := strconv.FormatInt(freeMiB, 10) + " MiB (" + strconv.Itoa(freePercent) + "%) free"
+		if freeMiB < minMiB {
+			h.set(&h.DiskSpace, healthUnhealthy, free+", less than "+strconv.FormatInt(minMiB, 10)+" MiB")
+		} else if freePercent < config.MinFreeDiskPercent {
+			h.set(&h.DiskSpace, healthUnhealthy, free+", less than "+strconv.Itoa(config.MinFreeDiskPercent)+"%")
+		} else {
+			h.set(&h.DiskSpace, healthOk, "")
+		}
+	}
+
+	for _, path := range []string{dbPath, filepath.Dir(dbPath)} {
+		if err := unix.Access(path, unix.W_OK); err != nil {
+			h.set(&h.DiskWritable, healthUnhealthy, path+": "+string(err.Error()))
+			return
+		}
 	}
+	h.set(&h.DiskWritable, healthOk, "")
 }
 
-// checkDiskSpace Returns whether the disk that houses the working directory has adequate space
-func checkDiskSpace() (bool, error) {
-	// get the amount of space from the disk
-	var stat unix.Statfs_t
+// checkIngestion Checks how long ago the last report arrived and how many
+// systems have stopped reporting, if configured. Neither keeps the service
+// from serving, so both only warn
+func checkIngestion(ctx context.Context, h *healthReport, config globals.HealthConfig, now time.Time) {
+	if config.MaxIngestionLagMinutes <= 0 && config.StaleAgentHours <= 0 {
+		return
+	}
+	timeout := time.Duration(withDefault(config.DbTimeoutSeconds, globals.DefaultHealthDbTimeoutSeconds)) * time.Second
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
 
-	// get our working directory
-	wd, err := os.Getwd()
+	staleBefore := now.Add(-time.Duration(config.StaleAgentHours) * time.Hour)
+	status, err := model.GetIngestionStatus(ctx, staleBefore)
 	if err != nil {
-		return false, err
+		if config.MaxIngestionLagMinutes > 0 {
+			h.set(&h.IngestionLag, healthWarning, string(err.Error()))
+		}
+		if config.StaleAgentHours > 0 {
+			h.set(&h.StaleAgents, healthWarning, string(err.Error()))
+		}
+		return
 	}
 
-	// retrieve our statfs data
-	unix.Statfs(wd, &stat)
+	if config.MaxIngestionLagMinutes > 0 {
+		lag := now.Sub(status.LastReport)
+		if !status.LastReport.IsZero() && lag > time.Duration(config.MaxIngestionLagMinutes)*time.Minute {
+			h.set(&h.IngestionLag, healthWarning, "no report for "+strconv.Itoa(int(lag.Minutes()))+" minutes")
+		} else {
+			h.set(&h.IngestionLag, healthOk, "")
+		}
+	}
 
-	freeSpaceInGiB := stat.Bavail * uint64(stat.Bsize) / (1024 * 1024 * 1024)
-	if freeSpaceInGiB > 10 {
-		return true, nil
-	} else {
-		// no error, but we don't have adequate safe space on the disk
-		return false, nil
+	if config.StaleAgentHours > 0 {
+		maxPercent := withDefault(config.MaxStaleAgentPercent, globals.DefaultHealthMaxStaleAgentPercent)
+		stale := strconv.Itoa(status.StaleSystems) + " of " + strconv.Itoa(status.Systems) +
+			" systems have not reported for " + strconv.Itoa(config.StaleAgentHours) + " hours"
+		if status.Systems > 0 && status.StaleSystems*100 >= status.Systems*maxPercent {
+			h.set(&h.StaleAgents, healthWarning, stale)
+		} else {
+			h.set(&h.StaleAgents, healthOk, "")
+		}
 	}
 }
 
-// checkDiskIsWritable Returns whether the disk that houses the DB is writable
-func checkDiskIsWritable() (bool, error) {
-	config, err := getConfig()
-	if err != nil {
-		return false, err
-	}
-	err = unix.Access(config.DbPath, unix.W_OK)
-	if err != nil {
-		return false, err
+// checkCertificates Checks the TLS certificates for their expiry
+func (u *UpdateReporter) checkCertificates(h *healthReport, now time.Time) {
+	if u.Certificates == nil {
+		return
 	}
 
-	return true, nil
+	h.Certificates = u.Certificates.Status(now)
+	switch tlscert.Worst(h.Certificates) {
+	case tlscert.StatusExpired:
+		h.Health = healthUnhealthy
+	case tlscert.StatusExpiring:
+		// still serving, but a certificate is due for renewal
+		if h.Health == healthOk {
+			h.Health = healthWarning
+		}
+	}
 }
 
-// GetHealth Retrieve the health of the service
+// GetLiveness Retrieve whether the service is alive
 //
-//	@Summary		Retrieve overall health of the service
-//	@Description	Retrieve overall health of the service
+//	@Summary		Retrieve whether the service is alive
+//	@Description	Retrieve whether the service is alive. Answers without checking any dependency, so a failing database does not get the service restarted
 //	@Tags			serviceHealth
 //	@Produce		json
 //	@Success		200	{object}	model.HealthCheck
-//	@Failure		500	{object}	model.HealthCheck
-//	@Router			/health [get]
-func (u *UpdateReporter) GetHealth(c *gin.Context) {
-	log.Println("INFO: Retrieving Health status")
-
-	// Determine our own host's health
-	// - is the db present?
-	// - do we have adequate disk space?
-	// - is our disk even writable?
-
-	// db exists?
-	dbStatus, err := checkDbIsPresent()
-	var dbStatusString string
-	if dbStatus {
-		dbStatusString = "OK"
-	} else {
-		dbStatusString = "UNHEALTHY"
-	}
-	if err != nil {
-		c.IndentedJSON(http.StatusInternalServerError, gin.H{
-			"db":            dbStatusString + ": " + string(err.Error()),
-			"diskSpace":     "UNKNOWN",
-			"diskWriteable": "UNKNOWN",
-			"health":        "UNHEALTHY",
-			"status":        500,
-		})
-		return
-	}
+//	@Router			/health/live [get]
+func (u *UpdateReporter) GetLiveness(c *gin.Context) {
+	newHealthReport().respond(c)
+}
 
-	// disk space?
-	diskSpaceStatus, err := checkDiskSpace()
-	var diskSpaceStatusString string
-	if diskSpaceStatus {
-		diskSpaceStatusString = "OK"
-	} else {
-		diskSpaceStatusString = "UNHEALTHY"
+// GetReadiness Retrieve whether the service can serve requests
+//
+//	@Summary		Retrieve whether the service can serve requests
+//	@Description	Retrieve whether the service can serve requests. The database is queried within health.dbTimeoutSeconds and, for SQLite, the free space and writability of its filesystem are checked; any failure gives 503. Ingestion lag, stale systems and TLS certificates due for renewal are reported as WARNING, an expired certificate as UNHEALTHY
+//	@Tags			serviceHealth
+//	@Produce		json
+//	@Success		200	{object}	model.HealthCheck
+//	@Failure		503	{object}	model.HealthCheck
+//	@Router			/health/ready [get]
+func (u *UpdateReporter) GetReadiness(c *gin.Context) {
+	config := u.Config()
+	now := time.Now()
+	h := newHealthReport()
+
+	checkDb(c.Request.Context(), h, config.Health)
+	if dialect, dbPath := model.DatabaseSource(config); dialect == model.DialectSqlite {
+		checkDisk(h, dbPath, config.Health)
 	}
-	if err != nil {
-		c.IndentedJSON(http.StatusInternalServerError, gin.H{
-			"db":           dbStatusString,
-			"diskSpace":    diskSpaceStatusString + ": " + string(err.Error()),
-			"diskWritable": "UNKNOWN",
-			"health":       "UNHEALTHY",
-			"status":       500,
-		})
-		return
+	if h.Db == healthOk {
+		checkIngestion(c.Request.Context(), h, config.Health, now)
 	}
+	u.checkCertificates(h, now)
 
-	// disk is writable?
-	diskIsWritableStatus, err := checkDiskIsWritable()
-	var diskIsWritableStatusString string
-	if diskIsWritableStatus {
-		diskIsWritableStatusString = "OK"
-	} else {
-		diskIsWritableStatusString = "UNHEALTHY"
-	}
-	if err != nil {
-		c.IndentedJSON(http.StatusInternalServerError, gin.H{
-			"db":           dbStatusString,
-			"diskSpace":    diskSpaceStatusString,
-			"diskWritable": diskIsWritableStatusString + ": " + string(err.Error()),
-			"health":       "UNHEALTHY",
-			"status":       500,
-		})
-		return
-	}
+	h.respond(c)
+}
 
-	c.IndentedJSON(http.StatusOK, gin.H{
-		"db":           "OK",
-		"diskSpace":    "OK",
-		"diskWritable": "OK",
-		"health":       "OK",
-		"status":       200,
-	})
+// GetHealth Retrieve the health of the service
+//
+//	@Summary		Retrieve overall health of the service
+//	@Description	Retrieve overall health of the service, the same as /health/ready
+//	@Tags			serviceHealth
+//	@Produce		json
+//	@Success		200	{object}	model.HealthCheck
+//	@Failure		503	{object}	model.HealthCheck
+//	@Router			/health [get]
+func (u *UpdateReporter) GetHealth(c *gin.Context) {
+	u.GetReadiness(c)
 }
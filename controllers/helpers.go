@@ -2,12 +2,15 @@ package controllers
 
 import (
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
-	"strconv"
+	"slices"
 
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
+	"github.com/greeneg/update-reporterd/apierror"
+	"github.com/greeneg/update-reporterd/globals"
+	"github.com/greeneg/update-reporterd/helpers"
 	"github.com/greeneg/update-reporterd/model"
 )
 
@@ -15,6 +18,10 @@ func (u *UpdateReporter) GetUserId(c *gin.Context) (model.User, bool) {
 	// need to get our current user context to get the CreatorId
 	session := sessions.Default(c)
 	user := session.Get("user")
+	// requests authenticated with a bearer token carry no session
+	if tokenUser, exists := c.Get(globals.UserKey); exists {
+		user = tokenUser
+	}
 	// if nil, we have an issue
 	if user == nil {
 		return model.User{}, false
@@ -23,15 +30,89 @@ func (u *UpdateReporter) GetUserId(c *gin.Context) (model.User, bool) {
 	// convert user interface to a string
 	username := fmt.Sprintf("%v", user)
 	// lets output our session user
-	log.Println("INFO: Session user: " + username)
+	slog.DebugContext(c.Request.Context(), "Session user", "user", username)
 	// get our user id
 	userObject, err := model.GetUserByUserName(username)
 	if err != nil {
-		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err})
+		// answered here, so accessDenied leaves the response alone
+		apierror.Error(c, err)
 		return model.User{}, false
 	}
 
 	// what is our user Id
-	log.Println("INFO: Session user's ID: " + strconv.Itoa(userObject.Id))
+	slog.DebugContext(c.Request.Context(), "Session user's ID", "userId", userObject.Id)
+	return userObject, true
+}
+
+// GetAdminUserId Returns the session user only if they hold an administrative role
+func (u *UpdateReporter) GetAdminUserId(c *gin.Context) (model.User, bool) {
+	userObject, authed := u.GetUserId(c)
+	if !authed {
+		return model.User{}, false
+	}
+	if !helpers.CheckIsAdministrator(userObject) {
+		slog.WarnContext(c.Request.Context(), "User is not an administrator", "user", userObject.UserName)
+		return model.User{}, false
+	}
+	if scopes, isToken := apiTokenScopes(c); isToken && !slices.Contains(scopes, globals.PermissionAdmin) {
+		slog.WarnContext(c.Request.Context(), "API token lacks the admin scope", "user", userObject.UserName)
+		return model.User{}, false
+	}
+
 	return userObject, true
 }
+
+// accessDenied Answers a request the user may not make, unless the request
+// has been answered already because looking the user up failed
+func accessDenied(c *gin.Context) {
+	if c.Writer.Written() {
+		return
+	}
+	apierror.Respond(c, http.StatusForbidden, "Insufficient access. Access denied!")
+}
+
+// apiTokenScopes Returns the scopes of the API token the request was
+// authenticated with, or false if it was not authenticated with one
+func apiTokenScopes(c *gin.Context) ([]string, bool) {
+	scopes, exists := c.Get(globals.TokenScopesKey)
+	if !exists {
+		return nil, false
+	}
+	return scopes.([]string), true
+}
+
+// toSafeUser Returns the user without any credential material
+func toSafeUser(user model.User) SafeUser {
+	return SafeUser{
+		Id:                      user.Id,
+		UserName:                user.UserName,
+		FullName:                user.FullName,
+		Status:                  user.Status,
+		OrgUnitId:               user.OrgUnitId,
+		RoleId:                  user.RoleId,
+		CreationDate:            user.CreationDate,
+		LastPasswordChangedDate: user.LastPasswordChangedDate,
+		AuthSource:              user.AuthSource,
+	}
+}
+
+// userProfile Returns the user along with their role and permissions
+func userProfile(user model.User) (UserProfile, error) {
+	role, err := model.GetRoleById(user.RoleId)
+	if err != nil {
+		return UserProfile{}, err
+	}
+
+	twoFactor, err := helpers.TwoFactorStatus(user)
+	if err != nil {
+		return UserProfile{}, err
+	}
+
+	return UserProfile{
+		SafeUser:        toSafeUser(user),
+		Role:            role,
+		Permissions:     helpers.UserPermissions(user),
+		PasswordExpired: model.IsPasswordExpired(user),
+		TwoFactor:       twoFactor,
+	}, nil
+}
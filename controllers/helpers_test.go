@@ -0,0 +1,122 @@
+package controllers
+
+/*
+
+  Copyright 2024, YggdrasilSoft, LLC.
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-contrib/sessions/cookie"
+	"github.com/gin-gonic/gin"
+
+	"github.com/greeneg/update-reporterd/globals"
+	"github.com/greeneg/update-reporterd/model"
+	"github.com/greeneg/update-reporterd/model/storetest"
+)
+
+// createTestUsers Creates an administrator named root and a user named alice
+// in a role of their own
+func createTestUsers(t *testing.T) {
+	if _, err := model.CreateRole(model.Role{RoleName: "users"}); err != nil {
+		t.Fatal(err)
+	}
+	users, err := model.GetRoleByName("users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for username, roleId := range map[string]int{"root": model.AdministratorsRoleId, "alice": users.Id} {
+		_, err := model.CreateUser(model.ProposedUser{UserName: username, RoleId: roleId, Password: "Correct-Horse-42!"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// authAs Stands in for the authentication middleware, taking the user from
+// the X-Test-User header and, for token requests, the scopes from
+// X-Test-Scopes
+func authAs(c *gin.Context) {
+	c.Set(globals.UserKey, c.GetHeader("X-Test-User"))
+	if scopes := c.GetHeader("X-Test-Scopes"); scopes != "" {
+		c.Set(globals.TokenScopesKey, strings.Split(scopes, ","))
+	}
+}
+
+func TestRoleManagementNeedsAnAdministrator(t *testing.T) {
+	storetest.OpenDatabase(t)
+	createTestUsers(t)
+	users, err := model.GetRoleByName("users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	usersPath := "/role/" + strconv.Itoa(users.Id)
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(sessions.Sessions("session", cookie.NewStore([]byte("0123456789abcdef0123456789abcdef"))), authAs)
+	u := &UpdateReporter{}
+	r.POST("/role", u.CreateRole)
+	r.PATCH("/role/:roleId", u.UpdateRole)
+	r.DELETE("/role/:roleId", u.DeleteRole)
+
+	cases := []struct {
+		name   string
+		method string
+		path   string
+		user   string
+		scopes string
+		status int
+	}{
+		{"session of a user creating a role", http.MethodPost, "/role", "alice", "", http.StatusForbidden},
+		{"session of a user renaming a role", http.MethodPatch, usersPath, "alice", "", http.StatusForbidden},
+		{"session of a user deleting a role", http.MethodDelete, usersPath + "?reassignTo=" + strconv.Itoa(model.AdministratorsRoleId), "alice", "", http.StatusForbidden},
+		{"token of a user", http.MethodPost, "/role", "alice", "read,write,admin", http.StatusForbidden},
+		{"token of an administrator without admin scope", http.MethodPost, "/role", "root", "read,write", http.StatusForbidden},
+		{"token of an administrator with admin scope", http.MethodPost, "/role", "root", "read,write,admin", http.StatusOK},
+		{"session of an administrator", http.MethodPost, "/role", "root", "", http.StatusOK},
+	}
+	for i, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			body := `{"roleName": "scoped-` + string(rune('a'+i)) + `"}`
+			req := httptest.NewRequest(tc.method, tc.path, strings.NewReader(body))
+			req.Header.Set("X-Test-User", tc.user)
+			if tc.scopes != "" {
+				req.Header.Set("X-Test-Scopes", tc.scopes)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tc.status {
+				t.Errorf("answered %d, not %d: %s", w.Code, tc.status, w.Body.String())
+			}
+		})
+	}
+
+	kept, err := model.GetRoleById(users.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kept.RoleName != "users" {
+		t.Errorf("the users role was changed to %+v", kept)
+	}
+}
@@ -0,0 +1,163 @@
+package controllers
+
+/*
+
+  Copyright 2024, YggdrasilSoft, LLC.
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/greeneg/update-reporterd/apierror"
+	"github.com/greeneg/update-reporterd/model"
+)
+
+// login events returned when no limit is asked for, and the most that may be
+const (
+	defaultLoginHistoryLimit = 50
+	maxLoginHistoryLimit     = 500
+)
+
+// UpdateMe Change the current user's profile
+//
+//	@Summary		Update your profile
+//	@Description	Change the full name of the logged in user. Users from an external identity
+//	@Description	provider have their profile managed there
+//	@Tags			auth
+//	@Accept			json
+//	@Produce		json
+//	@Param			profile	body	model.ProfileUpdate	true	"Profile fields"
+//	@Security		BasicAuth
+//	@Success		200	{object}	UserProfile
+//	@Failure		400	{object}	model.FailureMsg
+//	@Failure		403	{object}	model.FailureMsg
+//	@Router			/me [patch]
+func (u *UpdateReporter) UpdateMe(c *gin.Context) {
+	user, authed := u.GetUserId(c)
+	if authed {
+		var json model.ProfileUpdate
+		if err := c.ShouldBindJSON(&json); err != nil {
+			apierror.Respond(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		if json.FullName == nil {
+			apierror.RespondCode(c, http.StatusBadRequest, apierror.CodeValidation, "No fields to update")
+			return
+		}
+
+		// only the fields of ProfileUpdate are passed on, so users cannot
+		// change their own role
+		updated, err := model.UpdateUser(user.Id, model.UserUpdate{FullName: json.FullName})
+		if err != nil {
+			apierror.Error(c, err)
+			return
+		}
+		profile, err := userProfile(updated)
+		if err != nil {
+			apierror.Error(c, err)
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, profile)
+	} else {
+		accessDenied(c)
+	}
+}
+
+// ChangeMyPassword Change the current user's password
+//
+//	@Summary		Change your password
+//	@Description	Change the password of the logged in user. Allowed with an expired password
+//	@Tags			auth
+//	@Accept			json
+//	@Produce		json
+//	@Param			changePassword	body	model.PasswordChange	true	"Password data"
+//	@Security		BasicAuth
+//	@Success		200	{object}	model.SuccessMsg
+//	@Failure		400	{object}	model.FailureMsg
+//	@Failure		403	{object}	model.FailureMsg
+//	@Router			/me/password [put]
+func (u *UpdateReporter) ChangeMyPassword(c *gin.Context) {
+	user, authed := u.GetUserId(c)
+	if authed {
+		u.changePassword(c, user.UserName)
+	} else {
+		accessDenied(c)
+	}
+}
+
+// changePassword Changes a user's password from the old one in the request
+func (u *UpdateReporter) changePassword(c *gin.Context, username string) {
+	var json model.PasswordChange
+	if err := c.ShouldBindJSON(&json); err != nil {
+		apierror.Respond(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	status, err := model.ChangeAccountPassword(username, json.OldPassword, json.NewPassword)
+	if err != nil {
+		apierror.Error(c, err)
+		return
+	}
+
+	if status {
+		slog.InfoContext(c.Request.Context(), "User changed their password", "user", username)
+		c.IndentedJSON(http.StatusOK, gin.H{"message": "User '" + username + "' has changed their password"})
+	} else {
+		apierror.Respond(c, http.StatusBadRequest, "User password could not be updated!")
+	}
+}
+
+// GetMyLogins Retrieve the current user's login history
+//
+//	@Summary		Retrieve your login history
+//	@Description	Retrieve the most recent logins, failed logins and lockouts of the logged in user, newest first
+//	@Tags			auth
+//	@Produce		json
+//	@Param			limit	query	int	false	"Number of events, 50 unless given, at most 500"
+//	@Security		BasicAuth
+//	@Success		200	{object}	model.LoginEventsList
+//	@Failure		400	{object}	model.FailureMsg
+//	@Failure		403	{object}	model.FailureMsg
+//	@Router			/me/logins [get]
+func (u *UpdateReporter) GetMyLogins(c *gin.Context) {
+	user, authed := u.GetUserId(c)
+	if authed {
+		limit := defaultLoginHistoryLimit
+		if value := c.Query("limit"); value != "" {
+			var err error
+			limit, err = strconv.Atoi(value)
+			if err != nil || limit < 1 || limit > maxLoginHistoryLimit {
+				apierror.RespondCode(c, http.StatusBadRequest, apierror.CodeValidation,
+					"limit must be a number from 1 to "+strconv.Itoa(maxLoginHistoryLimit))
+				return
+			}
+		}
+
+		events, err := model.GetLoginEvents(user.UserName, limit)
+		if err != nil {
+			apierror.Error(c, err)
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, gin.H{"data": events})
+	} else {
+		accessDenied(c)
+	}
+}
@@ -0,0 +1,98 @@
+package controllers
+
+/*
+
+  Copyright 2024, YggdrasilSoft, LLC.
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/greeneg/update-reporterd/ratelimit"
+)
+
+// labelEscaper escapes label values for the Prometheus text format
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// writeMetric Writes the help and type lines of a metric, followed by its samples
+func writeMetric(b *strings.Builder, name string, metricType string, help string, samples ...string) {
+	if len(samples) == 0 {
+		return
+	}
+	b.WriteString("# HELP " + name + " " + help + "\n")
+	b.WriteString("# TYPE " + name + " " + metricType + "\n")
+	for _, sample := range samples {
+		b.WriteString(name + sample + "\n")
+	}
+}
+
+func writeGauge(b *strings.Builder, name string, help string, samples ...string) {
+	writeMetric(b, name, "gauge", help, samples...)
+}
+
+func writeCounter(b *strings.Builder, name string, help string, samples ...string) {
+	writeMetric(b, name, "counter", help, samples...)
+}
+
+// GetMetrics Retrieve metrics of the service
+//
+//	@Summary		Retrieve metrics of the service
+//	@Description	Retrieve metrics of the service in the Prometheus text format: the requests refused by each rate limit and, when TLS is used, the days until each certificate expires and the warning threshold
+//	@Tags			serviceHealth
+//	@Produce		plain
+//	@Success		200	{string}	string
+//	@Router			/metrics [get]
+func (u *UpdateReporter) GetMetrics(c *gin.Context) {
+	var b strings.Builder
+
+	refused := ratelimit.Refused()
+	limits := make([]string, 0, len(refused))
+	for limit := range refused {
+		limits = append(limits, limit)
+	}
+	slices.Sort(limits)
+	samples := make([]string, 0, len(limits))
+	for _, limit := range limits {
+		samples = append(samples, `{limit="`+limit+`"} `+strconv.FormatUint(refused[limit], 10))
+	}
+	writeCounter(&b, "update_reporterd_rate_limited_requests_total",
+		"Requests refused with 429 because a rate limit was reached.", samples...)
+
+	if u.Certificates != nil {
+		var expiryDays, notAfter []string
+		for _, certificate := range u.Certificates.Status(time.Now()) {
+			labels := `{pem_file="` + labelEscaper.Replace(certificate.PemFile) +
+				`",subject="` + labelEscaper.Replace(certificate.Subject) + `"} `
+			expiryDays = append(expiryDays, labels+strconv.Itoa(certificate.DaysUntilExpiry))
+			expiry, _ := time.Parse(time.RFC3339, certificate.NotAfter)
+			notAfter = append(notAfter, labels+strconv.FormatInt(expiry.Unix(), 10))
+		}
+		writeGauge(&b, "update_reporterd_tls_certificate_expiry_days",
+			"Whole days until the TLS certificate expires, negative once expired.", expiryDays...)
+		writeGauge(&b, "update_reporterd_tls_certificate_not_after_timestamp_seconds",
+			"Time the TLS certificate expires, in seconds since the epoch.", notAfter...)
+		writeGauge(&b, "update_reporterd_tls_certificate_expiry_warning_days",
+			"Days before expiry from which TLS certificates are reported as expiring.",
+			" "+strconv.Itoa(u.Certificates.WarningDays()))
+	}
+
+	c.Data(http.StatusOK, "text/plain; version=0.0.4; charset=utf-8", []byte(b.String()))
+}
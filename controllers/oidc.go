@@ -0,0 +1,131 @@
+package controllers
+
+/*
+
+  Copyright 2024, YggdrasilSoft, LLC.
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+import (
+	"crypto/subtle"
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/greeneg/update-reporterd/apierror"
+	"github.com/greeneg/update-reporterd/globals"
+	"github.com/greeneg/update-reporterd/helpers"
+	"github.com/greeneg/update-reporterd/oidcauth"
+)
+
+// OidcLogin Start a single sign-on login
+//
+//	@Summary		Log in with single sign-on
+//	@Description	Redirect the browser to the OpenID Connect issuer to log in
+//	@Tags			auth
+//	@Success		302
+//	@Failure		501	{object}	model.FailureMsg
+//	@Router			/oidc/login [get]
+func (u *UpdateReporter) OidcLogin(c *gin.Context) {
+	provider := helpers.OidcProvider()
+	if provider == nil {
+		apierror.Respond(c, http.StatusNotImplemented, "Single sign-on is not enabled")
+		return
+	}
+
+	login, err := oidcauth.NewLoginState()
+	if err != nil {
+		apierror.Error(c, err)
+		return
+	}
+
+	// the login is bound to this browser's session until the callback
+	session := sessions.Default(c)
+	session.Clear()
+	session.Set(globals.OidcStateKey, login.State)
+	session.Set(globals.OidcNonceKey, login.Nonce)
+	session.Set(globals.OidcVerifierKey, login.Verifier)
+	if err := session.Save(); err != nil {
+		apierror.Respond(c, http.StatusInternalServerError, "failed to save user session")
+		return
+	}
+
+	c.Redirect(http.StatusFound, provider.AuthCodeURL(login))
+}
+
+// OidcCallback Complete a single sign-on login
+//
+//	@Summary		Single sign-on callback
+//	@Description	Complete a login at the OpenID Connect issuer and start a session for the user
+//	@Tags			auth
+//	@Param			code	query	string	true	"Authorization code"
+//	@Param			state	query	string	true	"Login state"
+//	@Success		302
+//	@Failure		400	{object}	model.FailureMsg
+//	@Failure		401	{object}	model.FailureMsg
+//	@Failure		501	{object}	model.FailureMsg
+//	@Router			/oidc/callback [get]
+func (u *UpdateReporter) OidcCallback(c *gin.Context) {
+	provider := helpers.OidcProvider()
+	if provider == nil {
+		apierror.Respond(c, http.StatusNotImplemented, "Single sign-on is not enabled")
+		return
+	}
+
+	session := sessions.Default(c)
+	state, _ := session.Get(globals.OidcStateKey).(string)
+	nonce, _ := session.Get(globals.OidcNonceKey).(string)
+	verifier, _ := session.Get(globals.OidcVerifierKey).(string)
+	// a login state is only good for one attempt
+	session.Clear()
+
+	if errorCode := c.Query("error"); errorCode != "" {
+		slog.ErrorContext(c.Request.Context(), "Single sign-on failed at the issuer", "errorCode", errorCode, "description", c.Query("error_description"))
+		session.Save()
+		apierror.Respond(c, http.StatusUnauthorized, "Single sign-on failed: "+errorCode)
+		return
+	}
+	if state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(c.Query("state"))) != 1 {
+		session.Save()
+		apierror.Respond(c, http.StatusBadRequest, "No matching single sign-on login in progress")
+		return
+	}
+
+	identity, err := provider.Exchange(c.Request.Context(), c.Query("code"), verifier, nonce)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Single sign-on code exchange failed", "error", err)
+		session.Save()
+		apierror.Respond(c, http.StatusUnauthorized, "not authorized!")
+		return
+	}
+
+	user, ok := helpers.AuthenticateOidcIdentity(identity, c.ClientIP())
+	if !ok {
+		session.Save()
+		apierror.Respond(c, http.StatusUnauthorized, "not authorized!")
+		return
+	}
+	helpers.RecordLoginSuccess(user.UserName, c.ClientIP())
+
+	session.Set(globals.UserKey, user.UserName)
+	if err := session.Save(); err != nil {
+		apierror.Respond(c, http.StatusInternalServerError, "failed to save user session")
+		return
+	}
+
+	slog.InfoContext(c.Request.Context(), "User logged in with single sign-on", "user", user.UserName)
+	c.Redirect(http.StatusFound, provider.PostLoginRedirect())
+}
@@ -0,0 +1,205 @@
+package controllers
+
+/*
+
+  Copyright 2024, YggdrasilSoft, LLC.
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-contrib/sessions/cookie"
+	"github.com/gin-gonic/gin"
+
+	"github.com/greeneg/update-reporterd/globals"
+	"github.com/greeneg/update-reporterd/helpers"
+	"github.com/greeneg/update-reporterd/model"
+	"github.com/greeneg/update-reporterd/model/storetest"
+	"github.com/greeneg/update-reporterd/oidcauth"
+	"github.com/greeneg/update-reporterd/oidcauth/oidctest"
+)
+
+const oidcClientId = "update-reporter"
+
+// enableTestIssuer Turns on single sign-on through a fake issuer whose
+// reporter-admins group maps to the administrators role
+func enableTestIssuer(t *testing.T) *oidctest.Issuer {
+	issuer, err := oidctest.NewIssuer(oidcClientId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(issuer.Close)
+
+	p, err := oidcauth.New(context.Background(), globals.OidcConfig{
+		Enabled:     true,
+		Issuer:      issuer.URL(),
+		ClientId:    oidcClientId,
+		RedirectUrl: "https://reporter.example.com/api/v1/oidc/callback",
+		ClaimRoles:  []globals.OidcClaimRole{{Value: "reporter-admins", RoleName: "administrators"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	helpers.SetOidcProvider(p)
+	t.Cleanup(func() { helpers.SetOidcProvider(nil) })
+
+	return issuer
+}
+
+func oidcRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(sessions.Sessions("session", cookie.NewStore([]byte("0123456789abcdef0123456789abcdef"))))
+	u := &UpdateReporter{}
+	r.GET("/oidc/login", u.OidcLogin)
+	r.GET("/oidc/callback", u.OidcCallback)
+	r.GET("/whoami", func(c *gin.Context) {
+		user, _ := sessions.Default(c).Get(globals.UserKey).(string)
+		c.String(http.StatusOK, user)
+	})
+
+	return r
+}
+
+// get Sends a GET with the session cookie, returning the response and the
+// cookie to send next
+func get(r *gin.Engine, target string, session *http.Cookie) (*httptest.ResponseRecorder, *http.Cookie) {
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	if session != nil {
+		req.AddCookie(session)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	for _, c := range w.Result().Cookies() {
+		if c.Name == "session" {
+			return w, c
+		}
+	}
+	return w, session
+}
+
+// startOidcLogin Starts a login, returning the session cookie and the state
+// and nonce sent to the issuer
+func startOidcLogin(t *testing.T, r *gin.Engine) (*http.Cookie, string, string) {
+	w, session := get(r, "/oidc/login", nil)
+	if w.Code != http.StatusFound {
+		t.Fatalf("login answered %d: %s", w.Code, w.Body.String())
+	}
+	location, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return session, location.Query().Get("state"), location.Query().Get("nonce")
+}
+
+func TestOidcCallback(t *testing.T) {
+	storetest.OpenDatabase(t)
+	issuer := enableTestIssuer(t)
+	r := oidcRouter()
+	hourAgo := time.Now().Add(-time.Hour).Unix()
+
+	cases := []struct {
+		name       string
+		claims     map[string]any
+		wrongState bool
+		status     int
+		user       string
+	}{
+		{"valid token", nil, false, http.StatusFound, "erin"},
+		{"state mismatch", nil, true, http.StatusBadRequest, ""},
+		{"wrong audience", map[string]any{"aud": "another-client"}, false, http.StatusUnauthorized, ""},
+		{"expired token", map[string]any{"iat": hourAgo - 60, "exp": hourAgo}, false, http.StatusUnauthorized, ""},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			session, state, nonce := startOidcLogin(t, r)
+			claims := map[string]any{
+				"sub":                "f47ac10b",
+				"preferred_username": "erin",
+				"groups":             []string{"reporter-admins"},
+				"nonce":              nonce,
+			}
+			for name, value := range tc.claims {
+				claims[name] = value
+			}
+			issuer.SetIdTokenClaims(claims)
+
+			callbackState := state
+			if tc.wrongState {
+				callbackState = "forged-" + state
+			}
+			w, session := get(r, "/oidc/callback?code=abc&state="+url.QueryEscape(callbackState), session)
+			if w.Code != tc.status {
+				t.Fatalf("callback answered %d, not %d: %s", w.Code, tc.status, w.Body.String())
+			}
+			if w, _ := get(r, "/whoami", session); w.Body.String() != tc.user {
+				t.Errorf("session is logged in as '%s', not '%s'", w.Body.String(), tc.user)
+			}
+
+			// the login state is gone after any callback
+			if w, _ := get(r, "/oidc/callback?code=abc&state="+url.QueryEscape(state), session); w.Code != http.StatusBadRequest {
+				t.Errorf("a second callback answered %d", w.Code)
+			}
+		})
+	}
+
+	erin, err := model.GetUserByUserName("erin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if erin.AuthSource != model.AuthSourceOidc || erin.RoleId != model.AdministratorsRoleId {
+		t.Errorf("erin was recorded as %+v", erin)
+	}
+}
+
+func TestOidcUserNameClaimIsNotIdentity(t *testing.T) {
+	storetest.OpenDatabase(t)
+	issuer := enableTestIssuer(t)
+	r := oidcRouter()
+
+	// login Logs in as the subject claiming the user name, returning the
+	// status and the user the session is logged in as
+	login := func(subject string, username string) (int, string) {
+		session, state, nonce := startOidcLogin(t, r)
+		issuer.SetIdTokenClaims(map[string]any{
+			"sub":                subject,
+			"preferred_username": username,
+			"groups":             []string{"reporter-admins"},
+			"nonce":              nonce,
+		})
+		w, session := get(r, "/oidc/callback?code=abc&state="+url.QueryEscape(state), session)
+		whoami, _ := get(r, "/whoami", session)
+		return w.Code, whoami.Body.String()
+	}
+
+	if status, user := login("subject-a", "erin"); status != http.StatusFound || user != "erin" {
+		t.Fatalf("first login answered %d as '%s'", status, user)
+	}
+	if status, user := login("subject-b", "erin"); status != http.StatusUnauthorized || user != "" {
+		t.Errorf("another subject claiming the name answered %d as '%s'", status, user)
+	}
+	if status, user := login("subject-a", "erin-renamed"); status != http.StatusFound || user != "erin" {
+		t.Errorf("a renamed subject answered %d as '%s', not as erin", status, user)
+	}
+}
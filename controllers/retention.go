@@ -0,0 +1,49 @@
+package controllers
+
+/*
+
+  Copyright 2024, YggdrasilSoft, LLC.
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/greeneg/update-reporterd/retention"
+)
+
+// GetRetentionStatus Retrieve the retention settings and the last pruning run
+//
+//	@Summary		Retrieve the retention status
+//	@Description	Retrieve the retention settings in effect and what the last run of the pruning job deleted
+//	@Tags			admin
+//	@Produce		json
+//	@Security		BasicAuth
+//	@Success		200	{object}	model.RetentionStatus
+//	@Failure		403	{object}	model.FailureMsg
+//	@Router			/admin/retention [get]
+func (u *UpdateReporter) GetRetentionStatus(c *gin.Context) {
+	_, authed := u.GetAdminUserId(c)
+	if authed {
+		pruner := u.Retention
+		if pruner == nil {
+			pruner = retention.New(u.Config().Retention)
+		}
+		c.IndentedJSON(http.StatusOK, pruner.Status())
+	} else {
+		accessDenied(c)
+	}
+}
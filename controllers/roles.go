@@ -19,11 +19,13 @@ package controllers
 */
 
 import (
-	"log"
+	"errors"
+	"log/slog"
 	"net/http"
 	"strconv"
 
 	"github.com/gin-gonic/gin"
+	"github.com/greeneg/update-reporterd/apierror"
 	"github.com/greeneg/update-reporterd/model"
 )
 
@@ -38,13 +40,14 @@ import (
 //	@Security		BasicAuth
 //	@Success		200	{object}	model.SuccessMsg
 //	@Failure		400	{object}	model.FailureMsg
+//	@Failure		409	{object}	model.FailureMsg
 //	@Router			/role [post]
 func (u *UpdateReporter) CreateRole(c *gin.Context) {
-	_, authed := u.GetUserId(c)
+	_, authed := u.GetAdminUserId(c)
 	if authed {
 		var json model.Role
 		if err := c.ShouldBindJSON(&json); err != nil {
-			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			apierror.Respond(c, http.StatusBadRequest, err.Error())
 			return
 		}
 
@@ -52,61 +55,116 @@ func (u *UpdateReporter) CreateRole(c *gin.Context) {
 		if s {
 			c.IndentedJSON(http.StatusOK, gin.H{"message": "Role '" + json.RoleName + "' has been added to system"})
 		} else {
-			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			apierror.Error(c, err)
 		}
 	} else {
-		c.IndentedJSON(http.StatusForbidden, gin.H{"error": "Insufficient access. Access denied!"})
+		accessDenied(c)
 	}
 }
 
 // DeleteRole Remove a role
 //
 //	@Summary		Delete role
-//	@Description	Delete a role
+//	@Description	Delete a role. Its users are moved to the role reassignTo in the same transaction,
+//	@Description	without it a role that still has users is not deleted. Built-in roles cannot be deleted
 //	@Tags			role
 //	@Accept			json
 //	@Produce		json
-//	@Param			roleId	path	int	true	"Role Id"
+//	@Param			roleId		path	int	true	"Role Id"
+//	@Param			reassignTo	query	int	false	"Id of the role to move the users to"
 //	@Security		BasicAuth
 //	@Success		200	{object}	model.SuccessMsg
 //	@Failure		400	{object}	model.FailureMsg
+//	@Failure		403	{object}	model.FailureMsg
+//	@Failure		404	{object}	model.FailureMsg
+//	@Failure		409	{object}	RoleInUseMsg
 //	@Router			/role/{roleId} [delete]
 func (u *UpdateReporter) DeleteRole(c *gin.Context) {
-	_, authed := u.GetUserId(c)
+	_, authed := u.GetAdminUserId(c)
 	if authed {
-		roleId, _ := strconv.Atoi(c.Param("roleId"))
-		systemRole, err := model.GetRoleByName("SYSTEM")
+		roleId, err := strconv.Atoi(c.Param("roleId"))
 		if err != nil {
-			log.Println("ERROR: Could not retrieve role by Id" + string(err.Error()))
-			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "Unable to retrieve built-in SYSTEM role! " + string(err.Error())})
+			apierror.Respond(c, http.StatusBadRequest, "Invalid role Id")
 			return
 		}
-		adminsRole, err := model.GetRoleByName("administrators")
-		if err != nil {
-			log.Println("ERROR: Could not retrieve role by Id" + string(err.Error()))
-			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "Unable to retrieve protected administrators role! " + string(err.Error())})
+		reassignTo := 0
+		if value := c.Query("reassignTo"); value != "" {
+			reassignTo, err = strconv.Atoi(value)
+			if err != nil {
+				apierror.Respond(c, http.StatusBadRequest, "Invalid role Id '"+value+"' to reassign users to")
+				return
+			}
+		}
+
+		_, err = model.DeleteRole(roleId, reassignTo)
+		var inUse *model.RoleInUse
+		if errors.As(err, &inUse) {
+			c.IndentedJSON(http.StatusConflict, RoleInUseMsg{
+				FailureMsg: apierror.New(c, apierror.CodeConflict, inUse.Error()+", name a role to reassign them to"),
+				UserNames:  inUse.UserNames,
+			})
 			return
 		}
-		if systemRole.RoleName == "SYSTEM" || adminsRole.RoleName == "administrators" {
-			log.Println("WARNING: Someone tried to remove a protected role!")
-			c.IndentedJSON(http.StatusForbidden, gin.H{"error": "Protected roles cannot be removed!"})
+		if err != nil {
+			var forbidden *model.Forbidden
+			if errors.As(err, &forbidden) {
+				slog.WarnContext(c.Request.Context(), "Someone tried to remove a protected role", "roleId", roleId)
+			}
+			apierror.Error(c, err)
 			return
 		}
-		status, err := model.DeleteRole(roleId)
+
+		roleIdStr := strconv.Itoa(roleId)
+		c.IndentedJSON(http.StatusOK, gin.H{"message": "Role Id " + roleIdStr + " has been removed from system"})
+	} else {
+		accessDenied(c)
+	}
+}
+
+// UpdateRole Change a role
+//
+//	@Summary		Update role
+//	@Description	Change the name, description or two-factor requirement of a role. Fields left out
+//	@Description	keep their value. Built-in roles cannot be renamed
+//	@Tags			role
+//	@Accept			json
+//	@Produce		json
+//	@Param			roleId	path	int					true	"Role Id"
+//	@Param			role	body	model.RoleUpdate	true	"Role fields"
+//	@Security		BasicAuth
+//	@Success		200	{object}	model.Role
+//	@Failure		400	{object}	model.FailureMsg
+//	@Failure		403	{object}	model.FailureMsg
+//	@Failure		404	{object}	model.FailureMsg
+//	@Failure		409	{object}	model.FailureMsg
+//	@Router			/role/{roleId} [patch]
+func (u *UpdateReporter) UpdateRole(c *gin.Context) {
+	_, authed := u.GetAdminUserId(c)
+	if authed {
+		roleId, err := strconv.Atoi(c.Param("roleId"))
 		if err != nil {
-			log.Println("ERROR: Cannot delete role: " + string(err.Error()))
-			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "Unable to remove role! " + string(err.Error())})
+			apierror.Respond(c, http.StatusBadRequest, "Invalid role Id")
+			return
+		}
+		var json model.RoleUpdate
+		if err := c.ShouldBindJSON(&json); err != nil {
+			apierror.Respond(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		if json.RoleName == nil && json.Description == nil && json.TwoFactorRequired == nil {
+			apierror.RespondCode(c, http.StatusBadRequest, apierror.CodeValidation, "No fields to update")
 			return
 		}
 
-		if status {
-			roleIdStr := strconv.Itoa(roleId)
-			c.IndentedJSON(http.StatusOK, gin.H{"message": "Role Id " + roleIdStr + " has been removed from system"})
-		} else {
-			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "Unable to remove role!"})
+		role, err := model.UpdateRole(roleId, json)
+		if err != nil {
+			apierror.Error(c, err)
+			return
 		}
+
+		c.IndentedJSON(http.StatusOK, role)
 	} else {
-		c.IndentedJSON(http.StatusForbidden, gin.H{"error": "Insufficient access. Access denied!"})
+		accessDenied(c)
 	}
 }
 
@@ -118,24 +176,24 @@ func (u *UpdateReporter) DeleteRole(c *gin.Context) {
 //	@Produce		json
 //	@Security		BasicAuth
 //	@Success		200	{object}	model.RolesList
-//	@Failure		400	{object}	model.FailureMsg
+//	@Failure		500	{object}	model.FailureMsg
 //	@Router			/roles [get]
 func (u *UpdateReporter) GetRoles(c *gin.Context) {
 	_, authed := u.GetUserId(c)
 	if authed {
 		roles, err := model.GetRoles()
 		if err != nil {
-			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": string(err.Error())})
+			apierror.Error(c, err)
 			return
 		}
 
 		if roles == nil {
-			c.IndentedJSON(http.StatusNotFound, gin.H{"error": "No records found!"})
+			apierror.Respond(c, http.StatusNotFound, "No records found!")
 		} else {
 			c.IndentedJSON(http.StatusOK, gin.H{"data": roles})
 		}
 	} else {
-		c.IndentedJSON(http.StatusForbidden, gin.H{"error": "Insufficient access. Access denied!"})
+		accessDenied(c)
 	}
 }
 
@@ -148,7 +206,7 @@ func (u *UpdateReporter) GetRoles(c *gin.Context) {
 //	@Param			roleId	path int true "Role ID"
 //	@Security		BasicAuth
 //	@Success		200	{object}	model.Role
-//	@Failure		400	{object}	model.FailureMsg
+//	@Failure		404	{object}	model.FailureMsg
 //	@Router			/role/id/{roleId} [get]
 func (u *UpdateReporter) GetRoleById(c *gin.Context) {
 	_, authed := u.GetUserId(c)
@@ -156,18 +214,18 @@ func (u *UpdateReporter) GetRoleById(c *gin.Context) {
 		id, _ := strconv.Atoi(c.Param("roleId"))
 		role, err := model.GetRoleById(id)
 		if err != nil {
-			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": string(err.Error())})
+			apierror.Error(c, err)
 			return
 		}
 
 		if role.RoleName == "" {
 			strId := strconv.Itoa(id)
-			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "No records found with role id " + strId})
+			apierror.Respond(c, http.StatusNotFound, "No records found with role id "+strId)
 		} else {
 			c.IndentedJSON(http.StatusOK, role)
 		}
 	} else {
-		c.IndentedJSON(http.StatusForbidden, gin.H{"error": "Insufficient access. Access denied!"})
+		accessDenied(c)
 	}
 }
 
@@ -180,7 +238,7 @@ func (u *UpdateReporter) GetRoleById(c *gin.Context) {
 //	@Param			roleName	path string true "Role Name"
 //	@Security		BasicAuth
 //	@Success		200	{object}	model.Role
-//	@Failure		400	{object}	model.FailureMsg
+//	@Failure		404	{object}	model.FailureMsg
 //	@Router			/role/name/{roleName} [get]
 func (u *UpdateReporter) GetRoleByName(c *gin.Context) {
 	_, authed := u.GetUserId(c)
@@ -188,16 +246,16 @@ func (u *UpdateReporter) GetRoleByName(c *gin.Context) {
 		roleName := c.Param("roleName")
 		role, err := model.GetRoleByName(roleName)
 		if err != nil {
-			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": string(err.Error())})
+			apierror.Error(c, err)
 			return
 		}
 
 		if role.RoleName == "" {
-			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "No records found with role name " + roleName})
+			apierror.Respond(c, http.StatusNotFound, "No records found with role name "+roleName)
 		} else {
 			c.IndentedJSON(http.StatusOK, role)
 		}
 	} else {
-		c.IndentedJSON(http.StatusForbidden, gin.H{"error": "Insufficient access. Access denied!"})
+		accessDenied(c)
 	}
 }
@@ -0,0 +1,224 @@
+package controllers
+
+/*
+
+  Copyright 2024, YggdrasilSoft, LLC.
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/greeneg/update-reporterd/apierror"
+	"github.com/greeneg/update-reporterd/globals"
+	"github.com/greeneg/update-reporterd/model"
+)
+
+// serverSideSessions Returns whether sessions are kept in the database, and
+// so can be listed and revoked. Otherwise writes an error response
+func (u *UpdateReporter) serverSideSessions(c *gin.Context) bool {
+	if u.Config().Session.Store != globals.SessionStoreDatabase {
+		apierror.Respond(c, http.StatusNotImplemented, "Server-side sessions are not enabled")
+		return false
+	}
+	return true
+}
+
+// GetUserSessions Retrieve the active sessions of a user
+//
+//	@Summary		Retrieve a user's active sessions
+//	@Description	Retrieve a user's active sessions. Requires server-side sessions
+//	@Tags			session
+//	@Produce		json
+//	@Param			name	path	string	true	"User name"
+//	@Security		BasicAuth
+//	@Success		200	{object}	model.SessionsList
+//	@Failure		403	{object}	model.FailureMsg
+//	@Failure		501	{object}	model.FailureMsg
+//	@Router			/user/name/{name}/sessions [get]
+func (u *UpdateReporter) GetUserSessions(c *gin.Context) {
+	_, authed := u.GetAdminUserId(c)
+	if authed {
+		if !u.serverSideSessions(c) {
+			return
+		}
+
+		username := c.Param("name")
+		sessions, err := model.GetSessionsByUserName(username)
+		if err != nil {
+			apierror.Error(c, err)
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, gin.H{"data": sessions})
+	} else {
+		accessDenied(c)
+	}
+}
+
+// DeleteUserSessions Revoke all sessions of a user
+//
+//	@Summary		Revoke all of a user's sessions
+//	@Description	Revoke all of a user's sessions. Requires server-side sessions
+//	@Tags			session
+//	@Produce		json
+//	@Param			name	path	string	true	"User name"
+//	@Security		BasicAuth
+//	@Success		200	{object}	model.SuccessMsg
+//	@Failure		403	{object}	model.FailureMsg
+//	@Failure		501	{object}	model.FailureMsg
+//	@Router			/user/name/{name}/sessions [delete]
+func (u *UpdateReporter) DeleteUserSessions(c *gin.Context) {
+	_, authed := u.GetAdminUserId(c)
+	if authed {
+		if !u.serverSideSessions(c) {
+			return
+		}
+
+		username := c.Param("name")
+		count, err := model.DeleteSessionsByUserName(username)
+		if err != nil {
+			apierror.Error(c, err)
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, gin.H{"message": strconv.Itoa(int(count)) + " sessions of user '" + username + "' have been revoked"})
+	} else {
+		accessDenied(c)
+	}
+}
+
+// DeleteUserSession Revoke a single session of a user
+//
+//	@Summary		Revoke one of a user's sessions
+//	@Description	Revoke one of a user's sessions. Requires server-side sessions
+//	@Tags			session
+//	@Produce		json
+//	@Param			name		path	string	true	"User name"
+//	@Param			sessionId	path	string	true	"Session Id"
+//	@Security		BasicAuth
+//	@Success		200	{object}	model.SuccessMsg
+//	@Failure		403	{object}	model.FailureMsg
+//	@Failure		404	{object}	model.FailureMsg
+//	@Failure		501	{object}	model.FailureMsg
+//	@Router			/user/name/{name}/sessions/{sessionId} [delete]
+func (u *UpdateReporter) DeleteUserSession(c *gin.Context) {
+	_, authed := u.GetAdminUserId(c)
+	if authed {
+		if !u.serverSideSessions(c) {
+			return
+		}
+
+		username := c.Param("name")
+		sessionId := c.Param("sessionId")
+		session, err := model.GetSessionById(sessionId)
+		if err != nil {
+			apierror.Error(c, err)
+			return
+		}
+		if session.Id == "" || session.UserName != username {
+			apierror.Respond(c, http.StatusNotFound, "No such session for user '"+username+"'")
+			return
+		}
+
+		_, err = model.DeleteSessionById(sessionId)
+		if err != nil {
+			apierror.Error(c, err)
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, gin.H{"message": "Session of user '" + username + "' has been revoked"})
+	} else {
+		accessDenied(c)
+	}
+}
+
+// GetMySessions Retrieve the current user's active sessions
+//
+//	@Summary		Retrieve your active sessions
+//	@Description	Retrieve the active sessions of the logged in user. Requires server-side sessions
+//	@Tags			session
+//	@Produce		json
+//	@Security		BasicAuth
+//	@Success		200	{object}	model.SessionsList
+//	@Failure		403	{object}	model.FailureMsg
+//	@Failure		501	{object}	model.FailureMsg
+//	@Router			/me/sessions [get]
+func (u *UpdateReporter) GetMySessions(c *gin.Context) {
+	user, authed := u.GetUserId(c)
+	if authed {
+		if !u.serverSideSessions(c) {
+			return
+		}
+
+		sessions, err := model.GetSessionsByUserName(user.UserName)
+		if err != nil {
+			apierror.Error(c, err)
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, gin.H{"data": sessions})
+	} else {
+		accessDenied(c)
+	}
+}
+
+// DeleteMySession Revoke one of the current user's sessions
+//
+//	@Summary		Revoke one of your sessions
+//	@Description	Revoke one of the logged in user's sessions, such as one left open on another machine.
+//	@Description	Requires server-side sessions
+//	@Tags			session
+//	@Produce		json
+//	@Param			sessionId	path	string	true	"Session Id"
+//	@Security		BasicAuth
+//	@Success		200	{object}	model.SuccessMsg
+//	@Failure		403	{object}	model.FailureMsg
+//	@Failure		404	{object}	model.FailureMsg
+//	@Failure		501	{object}	model.FailureMsg
+//	@Router			/me/sessions/{sessionId} [delete]
+func (u *UpdateReporter) DeleteMySession(c *gin.Context) {
+	user, authed := u.GetUserId(c)
+	if authed {
+		if !u.serverSideSessions(c) {
+			return
+		}
+
+		sessionId := c.Param("sessionId")
+		session, err := model.GetSessionById(sessionId)
+		if err != nil {
+			apierror.Error(c, err)
+			return
+		}
+		// sessions of other users are not found, rather than forbidden, so
+		// their Ids cannot be probed
+		if session.Id == "" || session.UserName != user.UserName {
+			apierror.Respond(c, http.StatusNotFound, "No such session")
+			return
+		}
+
+		_, err = model.DeleteSessionById(sessionId)
+		if err != nil {
+			apierror.Error(c, err)
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, gin.H{"message": "Session has been revoked"})
+	} else {
+		accessDenied(c)
+	}
+}
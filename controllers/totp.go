@@ -0,0 +1,323 @@
+package controllers
+
+/*
+
+  Copyright 2024, YggdrasilSoft, LLC.
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/greeneg/update-reporterd/apierror"
+	"github.com/greeneg/update-reporterd/helpers"
+	"github.com/greeneg/update-reporterd/model"
+)
+
+// GetMyTotp Retrieve the two-factor status of the logged in user
+//
+//	@Summary		Retrieve two-factor status
+//	@Description	Retrieve whether the logged in user has two-factor authentication enabled
+//	@Tags			totp
+//	@Produce		json
+//	@Security		BasicAuth
+//	@Success		200	{object}	model.TotpStatus
+//	@Failure		403	{object}	model.FailureMsg
+//	@Router			/me/totp [get]
+func (u *UpdateReporter) GetMyTotp(c *gin.Context) {
+	user, authed := u.GetUserId(c)
+	if authed {
+		status, err := helpers.TwoFactorStatus(user)
+		if err != nil {
+			apierror.Error(c, err)
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, status)
+	} else {
+		accessDenied(c)
+	}
+}
+
+// EnrollMyTotp Start a TOTP enrolment for the logged in user
+//
+//	@Summary		Start two-factor enrolment
+//	@Description	Generate a new TOTP secret for the logged in user. The enrolment must be confirmed with a code before it takes effect
+//	@Tags			totp
+//	@Produce		json
+//	@Security		BasicAuth
+//	@Success		200	{object}	model.TotpEnrollment
+//	@Failure		403	{object}	model.FailureMsg
+//	@Failure		409	{object}	model.FailureMsg
+//	@Router			/me/totp [post]
+func (u *UpdateReporter) EnrollMyTotp(c *gin.Context) {
+	user, authed := u.GetUserId(c)
+	if authed {
+		enrolled, err := helpers.CheckTwoFactorEnrolled(user)
+		if err != nil {
+			apierror.Error(c, err)
+			return
+		}
+		if enrolled {
+			apierror.Respond(c, http.StatusConflict, "Two-factor authentication is already enabled")
+			return
+		}
+
+		enrollment, err := helpers.GenerateTotpEnrollment(user.UserName)
+		if err != nil {
+			apierror.Error(c, err)
+			return
+		}
+		_, err = model.SaveUserTotpSecret(user.UserName, enrollment.Secret)
+		if err != nil {
+			apierror.Error(c, err)
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, enrollment)
+	} else {
+		accessDenied(c)
+	}
+}
+
+// ConfirmMyTotp Confirm the logged in user's TOTP enrolment
+//
+//	@Summary		Confirm two-factor enrolment
+//	@Description	Confirm a TOTP enrolment with a current code. Returns the recovery codes, which are only shown once
+//	@Tags			totp
+//	@Accept			json
+//	@Produce		json
+//	@Param			code	body	model.TotpCode	true	"Current TOTP code"
+//	@Security		BasicAuth
+//	@Success		200	{object}	model.TotpRecoveryCodes
+//	@Failure		400	{object}	model.FailureMsg
+//	@Failure		403	{object}	model.FailureMsg
+//	@Failure		409	{object}	model.FailureMsg
+//	@Router			/me/totp/confirm [post]
+func (u *UpdateReporter) ConfirmMyTotp(c *gin.Context) {
+	user, authed := u.GetUserId(c)
+	if authed {
+		var json model.TotpCode
+		if err := c.ShouldBindJSON(&json); err != nil {
+			apierror.Respond(c, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		userTotp, err := model.GetUserTotp(user.UserName)
+		if err != nil {
+			apierror.Error(c, err)
+			return
+		}
+		if userTotp.Secret == "" {
+			apierror.Respond(c, http.StatusBadRequest, "No two-factor enrolment has been started")
+			return
+		}
+		if userTotp.Confirmed {
+			apierror.Respond(c, http.StatusConflict, "Two-factor authentication is already enabled")
+			return
+		}
+
+		counter, ok := helpers.MatchTotpCode(userTotp.Secret, json.Code)
+		if !ok {
+			apierror.Respond(c, http.StatusBadRequest, "Invalid two-factor code")
+			return
+		}
+
+		codes, hashes, err := helpers.GenerateRecoveryCodes()
+		if err != nil {
+			apierror.Error(c, err)
+			return
+		}
+		_, err = model.ConfirmUserTotp(user.UserName, counter, hashes)
+		if err != nil {
+			apierror.Error(c, err)
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, model.TotpRecoveryCodes{RecoveryCodes: codes})
+	} else {
+		accessDenied(c)
+	}
+}
+
+// RegenerateMyRecoveryCodes Replace the logged in user's recovery codes
+//
+//	@Summary		Regenerate recovery codes
+//	@Description	Replace all recovery codes of the logged in user. Requires a current TOTP code
+//	@Tags			totp
+//	@Accept			json
+//	@Produce		json
+//	@Param			code	body	model.TotpCode	true	"Current TOTP code"
+//	@Security		BasicAuth
+//	@Success		200	{object}	model.TotpRecoveryCodes
+//	@Failure		400	{object}	model.FailureMsg
+//	@Failure		403	{object}	model.FailureMsg
+//	@Router			/me/totp/recoveryCodes [post]
+func (u *UpdateReporter) RegenerateMyRecoveryCodes(c *gin.Context) {
+	user, authed := u.GetUserId(c)
+	if authed {
+		var json model.TotpCode
+		if err := c.ShouldBindJSON(&json); err != nil {
+			apierror.Respond(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		if !helpers.VerifySecondFactor(user, json.Code) {
+			apierror.Respond(c, http.StatusBadRequest, "Invalid two-factor code")
+			return
+		}
+
+		codes, hashes, err := helpers.GenerateRecoveryCodes()
+		if err != nil {
+			apierror.Error(c, err)
+			return
+		}
+		_, err = model.ReplaceRecoveryCodes(user.UserName, hashes)
+		if err != nil {
+			apierror.Error(c, err)
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, model.TotpRecoveryCodes{RecoveryCodes: codes})
+	} else {
+		accessDenied(c)
+	}
+}
+
+// DeleteMyTotp Turn off two-factor authentication for the logged in user
+//
+//	@Summary		Disable two-factor authentication
+//	@Description	Remove the logged in user's TOTP enrolment and recovery codes. Not allowed when their role requires two-factor authentication
+//	@Tags			totp
+//	@Accept			json
+//	@Produce		json
+//	@Param			code	body	model.TotpCode	true	"Current TOTP or recovery code"
+//	@Security		BasicAuth
+//	@Success		200	{object}	model.SuccessMsg
+//	@Failure		400	{object}	model.FailureMsg
+//	@Failure		403	{object}	model.FailureMsg
+//	@Router			/me/totp [delete]
+func (u *UpdateReporter) DeleteMyTotp(c *gin.Context) {
+	user, authed := u.GetUserId(c)
+	if authed {
+		var json model.TotpCode
+		if err := c.ShouldBindJSON(&json); err != nil {
+			apierror.Respond(c, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		required, err := helpers.CheckTwoFactorRequired(user)
+		if err != nil {
+			apierror.Error(c, err)
+			return
+		}
+		if required {
+			apierror.Respond(c, http.StatusForbidden, "Two-factor authentication is required for your role")
+			return
+		}
+		if !helpers.VerifySecondFactor(user, json.Code) {
+			apierror.Respond(c, http.StatusBadRequest, "Invalid two-factor code")
+			return
+		}
+
+		_, err = model.DeleteUserTotp(user.UserName)
+		if err != nil {
+			apierror.Error(c, err)
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, gin.H{"message": "Two-factor authentication has been disabled"})
+	} else {
+		accessDenied(c)
+	}
+}
+
+// DeleteUserTotp Reset a user's two-factor enrolment
+//
+//	@Summary		Reset a user's two-factor authentication
+//	@Description	Remove a user's TOTP enrolment and recovery codes, e.g. after they lose their device
+//	@Tags			totp
+//	@Produce		json
+//	@Param			name	path	string	true	"User name"
+//	@Security		BasicAuth
+//	@Success		200	{object}	model.SuccessMsg
+//	@Failure		403	{object}	model.FailureMsg
+//	@Failure		404	{object}	model.FailureMsg
+//	@Router			/user/name/{name}/totp [delete]
+func (u *UpdateReporter) DeleteUserTotp(c *gin.Context) {
+	_, authed := u.GetAdminUserId(c)
+	if authed {
+		username := c.Param("name")
+		status, err := model.DeleteUserTotp(username)
+		if err != nil {
+			apierror.Error(c, err)
+			return
+		}
+		if !status {
+			apierror.Respond(c, http.StatusNotFound, "User '"+username+"' has no two-factor enrolment")
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, gin.H{"message": "Two-factor authentication of user '" + username + "' has been reset"})
+	} else {
+		accessDenied(c)
+	}
+}
+
+// SetRoleTwoFactorRequired Set whether a role requires two-factor authentication
+//
+//	@Summary		Set two-factor requirement of a role
+//	@Description	Set whether members of a role must use two-factor authentication
+//	@Tags			role
+//	@Accept			json
+//	@Produce		json
+//	@Param			roleId	path	int							true	"Role Id"
+//	@Param			setting	body	model.RoleTwoFactorRequired	true	"Two-factor requirement"
+//	@Security		BasicAuth
+//	@Success		200	{object}	model.SuccessMsg
+//	@Failure		400	{object}	model.FailureMsg
+//	@Failure		403	{object}	model.FailureMsg
+//	@Failure		404	{object}	model.FailureMsg
+//	@Router			/role/{roleId}/twoFactorRequired [patch]
+func (u *UpdateReporter) SetRoleTwoFactorRequired(c *gin.Context) {
+	_, authed := u.GetAdminUserId(c)
+	if authed {
+		roleId, err := strconv.Atoi(c.Param("roleId"))
+		if err != nil {
+			apierror.Respond(c, http.StatusBadRequest, "Invalid role Id")
+			return
+		}
+		var json model.RoleTwoFactorRequired
+		if err := c.ShouldBindJSON(&json); err != nil {
+			apierror.Respond(c, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		status, err := model.SetRoleTwoFactorRequired(roleId, json)
+		if err != nil {
+			apierror.Error(c, err)
+			return
+		}
+		if !status {
+			apierror.Respond(c, http.StatusNotFound, "No such role Id "+strconv.Itoa(roleId))
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, gin.H{"message": "Two-factor requirement of role Id " + strconv.Itoa(roleId) + " has been updated"})
+	} else {
+		accessDenied(c)
+	}
+}
@@ -18,18 +18,59 @@ package controllers
 
 */
 
-import "github.com/greeneg/update-reporterd/globals"
+import (
+	"sync"
+
+	"github.com/greeneg/update-reporterd/globals"
+	"github.com/greeneg/update-reporterd/model"
+	"github.com/greeneg/update-reporterd/retention"
+	"github.com/greeneg/update-reporterd/tlscert"
+)
 
 type UpdateReporter struct {
-	AppPath    string
+	AppPath string
+	// the config file loaded, empty when configured by the environment alone
 	ConfigPath string
+	// the config at startup. Handlers read the config in effect with Config,
+	// as it may be reloaded
 	ConfStruct globals.Config
+	// prunes update history, nil when no pruner was set up
+	Retention *retention.Pruner
+	// the certificate served over TLS, nil when TLS is not used
+	Certificates *tlscert.Store
+
+	configMu sync.RWMutex
+	reloadMu sync.Mutex
 }
 
 type SafeUser struct {
-	Id           int
-	UserName     string
-	FullName     string
-	RoleId       int
-	CreationDate string
+	Id                      int
+	UserName                string
+	FullName                string
+	Status                  string
+	OrgUnitId               int
+	RoleId                  int
+	CreationDate            string
+	LastPasswordChangedDate string
+	AuthSource              string
+}
+
+type UserProfile struct {
+	SafeUser
+	Role            model.Role       `json:"role"`
+	Permissions     []string         `json:"permissions"`
+	PasswordExpired bool             `json:"passwordExpired"`
+	TwoFactor       model.TotpStatus `json:"twoFactor"`
+}
+
+// RoleInUseMsg answers the deletion of a role that still has users
+type RoleInUseMsg struct {
+	model.FailureMsg
+	UserNames []string `json:"userNames"`
+}
+
+// TwoFactorRequiredMsg answers a login that needs a second factor
+type TwoFactorRequiredMsg struct {
+	model.FailureMsg
+	TwoFactorRequired bool `json:"twoFactorRequired"`
 }
@@ -19,77 +19,164 @@ package controllers
 */
 
 import (
-	"log"
+	"log/slog"
 	"net/http"
 	"strconv"
 
 	"github.com/gin-gonic/gin"
-	"github.com/greeneg/update-reporterd/helpers"
+	"github.com/greeneg/update-reporterd/apierror"
 	"github.com/greeneg/update-reporterd/model"
 )
 
 // CreateUser Register a user for authentication and authorization
 //
 //	@Summary		Register user
-//	@Description	Add a new user
+//	@Description	Add a new user with their full name, status, role and organizational unit
 //	@Tags			user
 //	@Accept			json
 //	@Produce		json
 //	@Param			user	body	model.ProposedUser	true	"User Data"
 //	@Security		BasicAuth
-//	@Success		200	{object}	model.SuccessMsg
+//	@Success		201	{object}	SafeUser
 //	@Failure		400	{object}	model.FailureMsg
+//	@Failure		409	{object}	model.FailureMsg
 //	@Router			/user [post]
 func (u *UpdateReporter) CreateUser(c *gin.Context) {
-	_, authed := u.GetUserId(c)
+	_, authed := u.GetAdminUserId(c)
 	if authed {
 		var json model.ProposedUser
 		if err := c.ShouldBindJSON(&json); err != nil {
-			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			apierror.Respond(c, http.StatusBadRequest, err.Error())
 			return
 		}
 
-		s, err := model.CreateUser(json)
-		if s {
-			c.IndentedJSON(http.StatusOK, gin.H{"message": "User has been added to system"})
-		} else {
-			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		user, err := model.CreateUser(json)
+		if err != nil {
+			apierror.Error(c, err)
+			return
 		}
+
+		c.IndentedJSON(http.StatusCreated, toSafeUser(user))
 	} else {
-		c.IndentedJSON(http.StatusForbidden, gin.H{"error": "Insufficient access. Access denied!"})
+		accessDenied(c)
 	}
 }
 
-// ChangeAccountPassowrd Change an account's password
+// ReplaceUser Set all profile fields of a user
 //
-//	@Summary		Change password
-//	@Description	Change password
+//	@Summary		Replace user profile
+//	@Description	Set the full name, role and organizational unit of a user. All three are required,
+//	@Description	an orgUnitId of 0 removes the user from their unit
 //	@Tags			user
 //	@Accept			json
 //	@Produce		json
-//	@Param			name	path	string	true	"User name"
-//	@Param			changePassword	body	model.PasswordChange	true	"Password data"
-//	@Success		200	{object}	model.SuccessMsg
+//	@Param			id		path	int					true	"User ID"
+//	@Param			user	body	model.UserUpdate	true	"Profile"
+//	@Security		BasicAuth
+//	@Success		200	{object}	SafeUser
 //	@Failure		400	{object}	model.FailureMsg
-//	@Router			/user/name/{name} [patch]
-func (u *UpdateReporter) ChangeAccountPassword(c *gin.Context) {
-	username := c.Param("name")
-	var json model.PasswordChange
+//	@Failure		403	{object}	model.FailureMsg
+//	@Failure		404	{object}	model.FailureMsg
+//	@Router			/user/id/{id} [put]
+func (u *UpdateReporter) ReplaceUser(c *gin.Context) {
+	u.updateUser(c, true)
+}
+
+// UpdateUser Change profile fields of a user
+//
+//	@Summary		Update user profile
+//	@Description	Change the full name, role or organizational unit of a user. Fields left out
+//	@Description	keep their value, an orgUnitId of 0 removes the user from their unit
+//	@Tags			user
+//	@Accept			json
+//	@Produce		json
+//	@Param			id		path	int					true	"User ID"
+//	@Param			user	body	model.UserUpdate	true	"Profile fields"
+//	@Security		BasicAuth
+//	@Success		200	{object}	SafeUser
+//	@Failure		400	{object}	model.FailureMsg
+//	@Failure		403	{object}	model.FailureMsg
+//	@Failure		404	{object}	model.FailureMsg
+//	@Router			/user/id/{id} [patch]
+func (u *UpdateReporter) UpdateUser(c *gin.Context) {
+	u.updateUser(c, false)
+}
+
+// updateUser Changes the profile of a user, requiring every field when the
+// whole profile is replaced and at least one otherwise
+func (u *UpdateReporter) updateUser(c *gin.Context, replace bool) {
+	_, authed := u.GetAdminUserId(c)
+	if !authed {
+		accessDenied(c)
+		return
+	}
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		apierror.Respond(c, http.StatusBadRequest, "Invalid user Id '"+c.Param("id")+"'")
+		return
+	}
+	var json model.UserUpdate
 	if err := c.ShouldBindJSON(&json); err != nil {
-		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		apierror.Respond(c, http.StatusBadRequest, err.Error())
 		return
 	}
+	if replace && (json.FullName == nil || json.RoleId == nil || json.OrgUnitId == nil) {
+		apierror.RespondCode(c, http.StatusBadRequest, apierror.CodeValidation, "fullName, roleId and orgUnitId are required")
+		return
+	}
+	if !replace && json.FullName == nil && json.RoleId == nil && json.OrgUnitId == nil {
+		apierror.RespondCode(c, http.StatusBadRequest, apierror.CodeValidation, "No fields to update")
+		return
+	}
+
+	if json.RoleId != nil {
+		current, err := model.GetUserById(id)
+		if err != nil {
+			apierror.Error(c, err)
+			return
+		}
+		if (current.UserName == "SYSTEM" || current.UserName == "admin") && *json.RoleId != current.RoleId {
+			slog.WarnContext(c.Request.Context(), "Someone tried to change the role of a protected user")
+			apierror.Respond(c, http.StatusForbidden, "The role of protected users cannot be changed!")
+			return
+		}
+	}
 
-	status, err := model.ChangeAccountPassword(username, json.OldPassword, json.NewPassword)
+	user, err := model.UpdateUser(id, json)
 	if err != nil {
-		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+		apierror.Error(c, err)
 		return
 	}
 
-	if status {
-		c.IndentedJSON(http.StatusOK, gin.H{"message": "User '" + username + "' has changed their password"})
+	c.IndentedJSON(http.StatusOK, toSafeUser(user))
+}
+
+// ChangeAccountPassowrd Change an account's password
+//
+//	@Summary		Change password
+//	@Description	Change password. Users may only change their own, see also /me/password
+//	@Tags			user
+//	@Accept			json
+//	@Produce		json
+//	@Param			name	path	string	true	"User name"
+//	@Param			changePassword	body	model.PasswordChange	true	"Password data"
+//	@Success		200	{object}	model.SuccessMsg
+//	@Failure		400	{object}	model.FailureMsg
+//	@Failure		403	{object}	model.FailureMsg
+//	@Failure		404	{object}	model.FailureMsg
+//	@Router			/user/name/{name} [patch]
+func (u *UpdateReporter) ChangeAccountPassword(c *gin.Context) {
+	user, authed := u.GetUserId(c)
+	username := c.Param("name")
+	if authed && user.UserName == username {
+		u.changePassword(c, username)
 	} else {
-		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "User password could not be updated!"})
+		if authed {
+			slog.WarnContext(c.Request.Context(), "User tried to change the password of another account",
+				"user", user.UserName, "account", username)
+		}
+		accessDenied(c)
 	}
 }
 
@@ -104,37 +191,38 @@ func (u *UpdateReporter) ChangeAccountPassword(c *gin.Context) {
 //	@Security		BasicAuth
 //	@Success		200	{object}	model.SuccessMsg
 //	@Failure		400	{object}	model.FailureMsg
+//	@Failure		404	{object}	model.FailureMsg
 //	@Router			/user/name/{name} [delete]
 func (u *UpdateReporter) DeleteUser(c *gin.Context) {
-	_, authed := u.GetUserId(c)
+	_, authed := u.GetAdminUserId(c)
 	if authed {
 		username := c.Param("name")
 		if username == "SYSTEM" || username == "admin" {
-			log.Println("WARNING: Someone tried to remove a protected user!")
-			c.IndentedJSON(http.StatusForbidden, gin.H{"error": "Protected users cannot be removed!"})
+			slog.WarnContext(c.Request.Context(), "Someone tried to remove a protected user")
+			apierror.Respond(c, http.StatusForbidden, "Protected users cannot be removed!")
 			return
 		}
 		status, err := model.DeleteUser(username)
 		if err != nil {
-			log.Println("ERROR: Cannot delete user: " + string(err.Error()))
-			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "Unable to remove user! " + string(err.Error())})
+			slog.ErrorContext(c.Request.Context(), "Cannot delete user", "error", err)
+			apierror.Error(c, err)
 			return
 		}
 
 		if status {
 			c.IndentedJSON(http.StatusOK, gin.H{"message": "User " + username + " has been removed from system"})
 		} else {
-			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "Unable to remove user!"})
+			apierror.Respond(c, http.StatusInternalServerError, "Unable to remove user!")
 		}
 	} else {
-		c.IndentedJSON(http.StatusForbidden, gin.H{"error": "Insufficient access. Access denied!"})
+		accessDenied(c)
 	}
 }
 
 // GetUserStatus Retrieve the active status of a user. Can be either 'enabled' or 'locked'
 //
 //	@Summary		Retrieve a user's active status. Can be either 'enabled' or 'locked'
-//	@Description	Retrieve a user's active status
+//	@Description	Retrieve a user's active status, and why and until when a locked user is locked
 //	@Tags			user
 //	@Accept			json
 //	@Produce		json
@@ -142,6 +230,7 @@ func (u *UpdateReporter) DeleteUser(c *gin.Context) {
 //	@Security		BasicAuth
 //	@Success		200	{object}	model.UserStatusMsg
 //	@Failure		400	{object}	model.FailureMsg
+//	@Failure		404	{object}	model.FailureMsg
 //	@Router			/user/name/{name}/status [get]
 func (u *UpdateReporter) GetUserStatus(c *gin.Context) {
 	_, authed := u.GetUserId(c)
@@ -149,20 +238,31 @@ func (u *UpdateReporter) GetUserStatus(c *gin.Context) {
 		username := c.Param("name")
 		status, err := model.GetUserStatus(username)
 		if err != nil {
-			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "Unable to get the user " + username + " status: " + string(err.Error())})
+			apierror.Error(c, err)
 			return
 		}
 
 		if status != "" {
-			c.IndentedJSON(http.StatusOK, gin.H{
-				"message":    "User '" + username + "' has status " + status,
-				"userStatus": status,
-			})
+			msg := model.UserStatusMsg{
+				Message:    "User '" + username + "' has status " + status,
+				UserStatus: status,
+			}
+			if status == "locked" {
+				lockout, err := model.GetLockout(username)
+				if err != nil {
+					apierror.Error(c, err)
+					return
+				}
+				if lockout.UserName != "" {
+					msg.Lockout = &lockout
+				}
+			}
+			c.IndentedJSON(http.StatusOK, msg)
 		} else {
-			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "Unable to retrieve user status"})
+			apierror.Respond(c, http.StatusBadRequest, "Unable to retrieve user status")
 		}
 	} else {
-		c.IndentedJSON(http.StatusForbidden, gin.H{"error": "Insufficient access. Access denied!"})
+		accessDenied(c)
 	}
 }
 
@@ -178,33 +278,30 @@ func (u *UpdateReporter) GetUserStatus(c *gin.Context) {
 //	@Security		BasicAuth
 //	@Success		200	{object}	model.UserStatusMsg
 //	@Failure		400	{object}	model.FailureMsg
+//	@Failure		404	{object}	model.FailureMsg
 //	@Router			/user/name/{name}/status [patch]
 func (u *UpdateReporter) SetUserStatus(c *gin.Context) {
-	_, authed := u.GetUserId(c)
+	_, authed := u.GetAdminUserId(c)
 	if authed {
 		username := c.Param("name")
 		var json model.UserStatus
 		if err := c.ShouldBindJSON(&json); err != nil {
-			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			apierror.Respond(c, http.StatusBadRequest, err.Error())
 			return
 		}
 
-		status, err := model.SetUserStatus(username, json)
+		_, err := model.SetUserStatus(username, json)
 		if err != nil {
-			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+			apierror.Error(c, err)
 			return
 		}
 
-		if status {
-			c.IndentedJSON(http.StatusOK, gin.H{
-				"message":    "User '" + username + "' has been " + json.Status,
-				"userStatus": json.Status,
-			})
-		} else {
-			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err})
-		}
+		c.IndentedJSON(http.StatusOK, gin.H{
+			"message":    "User '" + username + "' has been " + json.Status,
+			"userStatus": json.Status,
+		})
 	} else {
-		c.IndentedJSON(http.StatusForbidden, gin.H{"error": "Insufficient access. Access denied!"})
+		accessDenied(c)
 	}
 }
 
@@ -220,32 +317,31 @@ func (u *UpdateReporter) SetUserStatus(c *gin.Context) {
 //	@Security		BasicAuth
 //	@Success		200 {object}	model.UserRoleIdMsg
 //	@Failure		400 {object}	model.FailureMsg
+//	@Failure		404	{object}	model.FailureMsg
 //	@Router			/user/name/{name}/roleId [patch]
 func (u *UpdateReporter) SetUserRoleId(c *gin.Context) {
-	_, authed := u.GetUserId(c)
+	_, authed := u.GetAdminUserId(c)
 	if authed {
 		username := c.Param("name")
 		var json model.UserRoleId
 		if err := c.ShouldBindJSON(&json); err != nil {
-			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			apierror.Respond(c, http.StatusBadRequest, err.Error())
+			return
 		}
 
-		status, err := model.SetUserRoleId(username, json)
+		_, err := model.SetUserRoleId(username, json)
 		if err != nil {
-			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": string(err.Error())})
+			apierror.Error(c, err)
+			return
 		}
 
-		if status {
-			roleId := strconv.Itoa(json.RoleId)
-			c.Copy().IndentedJSON(http.StatusOK, gin.H{
-				"message": "User '" + username + "' has been set to role Id '" + roleId + "'",
-				"roleId":  json.RoleId,
-			})
-		} else {
-			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err})
-		}
+		roleId := strconv.Itoa(json.RoleId)
+		c.IndentedJSON(http.StatusOK, gin.H{
+			"message": "User '" + username + "' has been set to role Id '" + roleId + "'",
+			"roleId":  json.RoleId,
+		})
 	} else {
-		c.IndentedJSON(http.StatusForbidden, gin.H{"error": "Insufficient access. Access denied!"})
+		accessDenied(c)
 	}
 }
 
@@ -257,33 +353,29 @@ func (u *UpdateReporter) SetUserRoleId(c *gin.Context) {
 //	@Produce		json
 //	@Security		BasicAuth
 //	@Success		200	{object}	model.UsersList
-//	@Failure		400	{object}	model.FailureMsg
+//	@Failure		500	{object}	model.FailureMsg
 //	@Router			/users [get]
 func (u *UpdateReporter) GetUsers(c *gin.Context) {
 	_, authed := u.GetUserId(c)
 	if authed {
 		users, err := model.GetUsers()
-		helpers.FatalCheckError(err)
+		if err != nil {
+			apierror.Error(c, err)
+			return
+		}
 
 		safeUsers := make([]SafeUser, 0)
 		for _, user := range users {
-			safeUser := SafeUser{}
-			safeUser.Id = user.Id
-			safeUser.UserName = user.UserName
-			safeUser.FullName = user.FullName
-			safeUser.RoleId = user.RoleId
-			safeUser.CreationDate = user.CreationDate
-
-			safeUsers = append(safeUsers, safeUser)
+			safeUsers = append(safeUsers, toSafeUser(user))
 		}
 
 		if users == nil {
-			c.IndentedJSON(http.StatusNotFound, gin.H{"error": "No records found!"})
+			apierror.Respond(c, http.StatusNotFound, "No records found!")
 		} else {
 			c.IndentedJSON(http.StatusOK, gin.H{"data": safeUsers})
 		}
 	} else {
-		c.IndentedJSON(http.StatusForbidden, gin.H{"error": "Insufficient access. Access denied!"})
+		accessDenied(c)
 	}
 }
 
@@ -303,28 +395,24 @@ func (u *UpdateReporter) GetUsersByRoleId(c *gin.Context) {
 	if authed {
 		roleId, _ := strconv.Atoi(c.Param("roleId"))
 		users, err := model.GetUsersByRoleId(roleId)
-		helpers.FatalCheckError(err)
+		if err != nil {
+			apierror.Error(c, err)
+			return
+		}
 
 		safeUsers := make([]SafeUser, 0)
 		for _, user := range users {
-			safeUser := SafeUser{}
-			safeUser.Id = user.Id
-			safeUser.UserName = user.UserName
-			safeUser.FullName = user.FullName
-			safeUser.RoleId = user.RoleId
-			safeUser.CreationDate = user.CreationDate
-
-			safeUsers = append(safeUsers, safeUser)
+			safeUsers = append(safeUsers, toSafeUser(user))
 		}
 
 		if users == nil {
 			strId := strconv.Itoa(roleId)
-			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "No records found for users with role Id " + strId})
+			apierror.Respond(c, http.StatusBadRequest, "No records found for users with role Id "+strId)
 		} else {
 			c.IndentedJSON(http.StatusOK, gin.H{"data": safeUsers})
 		}
 	} else {
-		c.IndentedJSON(http.StatusForbidden, gin.H{"error": "Insufficient access. Access denied!"})
+		accessDenied(c)
 	}
 }
 
@@ -336,26 +424,21 @@ func (u *UpdateReporter) GetUsersByRoleId(c *gin.Context) {
 //	@Produce		json
 //	@Param			id	path int true "User ID"
 //	@Success		200	{object}	SafeUser
-//	@Failure		400	{object}	model.FailureMsg
+//	@Failure		404	{object}	model.FailureMsg
 //	@Router			/user/id/{id} [get]
 func (u *UpdateReporter) GetUserById(c *gin.Context) {
 	id, _ := strconv.Atoi(c.Param("id"))
 	ent, err := model.GetUserById(id)
-	helpers.FatalCheckError(err)
-
-	// don't return the password hash
-	safeUser := new(SafeUser)
-	safeUser.Id = ent.Id
-	safeUser.UserName = ent.UserName
-	safeUser.FullName = ent.FullName
-	safeUser.RoleId = ent.RoleId
-	safeUser.CreationDate = ent.CreationDate
+	if err != nil {
+		apierror.Error(c, err)
+		return
+	}
 
 	if ent.UserName == "" {
 		strId := strconv.Itoa(id)
-		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "No records found with user id " + strId})
+		apierror.Respond(c, http.StatusNotFound, "No records found with user id "+strId)
 	} else {
-		c.IndentedJSON(http.StatusOK, safeUser)
+		c.IndentedJSON(http.StatusOK, toSafeUser(ent))
 	}
 }
 
@@ -367,24 +450,19 @@ func (u *UpdateReporter) GetUserById(c *gin.Context) {
 //	@Produce		json
 //	@Param			name	path	string	true	"User name"
 //	@Success		200	{object}	SafeUser
-//	@Failure		400	{object}	model.FailureMsg
+//	@Failure		404	{object}	model.FailureMsg
 //	@Router			/user/name/{name} [get]
 func (u *UpdateReporter) GetUserByUserName(c *gin.Context) {
 	username := c.Param("name")
 	ent, err := model.GetUserByUserName(username)
-	helpers.FatalCheckError(err)
-
-	// don't return the password hash
-	safeUser := new(SafeUser)
-	safeUser.Id = ent.Id
-	safeUser.UserName = ent.UserName
-	safeUser.FullName = ent.FullName
-	safeUser.RoleId = ent.RoleId
-	safeUser.CreationDate = ent.CreationDate
+	if err != nil {
+		apierror.Error(c, err)
+		return
+	}
 
 	if ent.UserName == "" {
-		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "No records found with user name " + username})
+		apierror.Respond(c, http.StatusNotFound, "No records found with user name "+username)
 	} else {
-		c.IndentedJSON(http.StatusOK, safeUser)
+		c.IndentedJSON(http.StatusOK, toSafeUser(ent))
 	}
 }
@@ -0,0 +1,106 @@
+package controllers
+
+/*
+
+  Copyright 2024, YggdrasilSoft, LLC.
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-contrib/sessions/cookie"
+	"github.com/gin-gonic/gin"
+
+	"github.com/greeneg/update-reporterd/model"
+	"github.com/greeneg/update-reporterd/model/storetest"
+)
+
+func TestUserManagementNeedsAnAdministrator(t *testing.T) {
+	storetest.OpenDatabase(t)
+	createTestUsers(t)
+	alice, err := model.GetUserByUserName("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	aliceId := strconv.Itoa(alice.Id)
+	promote := `{"roleId": ` + strconv.Itoa(model.AdministratorsRoleId) + `}`
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(sessions.Sessions("session", cookie.NewStore([]byte("0123456789abcdef0123456789abcdef"))), authAs)
+	u := &UpdateReporter{}
+	r.POST("/user", u.CreateUser)
+	r.PUT("/user/id/:id", u.ReplaceUser)
+	r.PATCH("/user/id/:id", u.UpdateUser)
+	r.PATCH("/user/name/:name/status", u.SetUserStatus)
+	r.PATCH("/user/name/:name/roleId", u.SetUserRoleId)
+	r.DELETE("/user/name/:name", u.DeleteUser)
+
+	cases := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+	}{
+		{"create a user", http.MethodPost, "/user", `{"userName": "mallory", "roleId": 2, "password": "Correct-Horse-42!"}`},
+		{"replace own profile", http.MethodPut, "/user/id/" + aliceId, `{"fullName": "Alice", "roleId": 2, "orgUnitId": 0}`},
+		{"promote self", http.MethodPatch, "/user/id/" + aliceId, promote},
+		{"set own role", http.MethodPatch, "/user/name/alice/roleId", promote},
+		{"lock an administrator", http.MethodPatch, "/user/name/root/status", `{"status": "locked"}`},
+		{"delete an administrator", http.MethodDelete, "/user/name/root", ""},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+			req.Header.Set("X-Test-User", "alice")
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != http.StatusForbidden {
+				t.Errorf("a user's session was answered %d, not 403: %s", w.Code, w.Body.String())
+			}
+		})
+	}
+
+	alice, err = model.GetUserByUserName("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if alice.RoleId == model.AdministratorsRoleId {
+		t.Error("alice was made an administrator")
+	}
+	root, err := model.GetUserByUserName("root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root.UserName == "" || root.Status != "enabled" {
+		t.Errorf("root was changed by alice: %+v", root)
+	}
+
+	// an administrator's session still may
+	req := httptest.NewRequest(http.MethodPatch, "/user/id/"+aliceId, strings.NewReader(`{"fullName": "Alice Example"}`))
+	req.Header.Set("X-Test-User", "root")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("an administrator's session was answered %d: %s", w.Code, w.Body.String())
+	}
+}
@@ -18,6 +18,126 @@ package globals
 
 */
 
-var Secret = []byte("secret")
-
 const UserKey = "user"
+
+const SessionName = "session"
+
+// session store backends
+const (
+	SessionStoreCookie   = "cookie"
+	SessionStoreDatabase = "database"
+)
+
+// database drivers
+const (
+	DatabaseSqlite   = "sqlite"
+	DatabasePostgres = "postgres"
+)
+
+// TLS certificate defaults, used when the tls config leaves a value unset
+const (
+	DefaultTLSWatchIntervalSeconds = 60
+	DefaultTLSExpiryWarningDays    = 14
+)
+
+// modes of the HTTP listener when TLS is used
+const (
+	HttpModeOff      = "off"
+	HttpModeServe    = "serve"
+	HttpModeRedirect = "redirect"
+)
+
+// listener defaults, used when the listen config leaves a value unset
+const (
+	DefaultReadHeaderTimeoutSeconds = 10
+	DefaultReadTimeoutSeconds       = 60
+	DefaultWriteTimeoutSeconds      = 120
+	DefaultIdleTimeoutSeconds       = 120
+	DefaultShutdownTimeoutSeconds   = 30
+)
+
+// DefaultDatabaseQueryTimeoutSeconds applies when the database config does not
+// set a query timeout
+const DefaultDatabaseQueryTimeoutSeconds = 10
+
+// retention defaults, used when the retention config leaves a value unset
+const (
+	DefaultRetentionReportDays      = 30
+	DefaultRetentionAggregateMonths = 13
+	DefaultRetentionIntervalMinutes = 60
+	DefaultRetentionBatchSize       = 1000
+)
+
+// log formats
+const (
+	LogFormatText = "text"
+	LogFormatJson = "json"
+)
+
+// health check defaults, used when the health config leaves a value unset
+const (
+	DefaultHealthDbTimeoutSeconds     = 2
+	DefaultHealthMinFreeDiskMiB       = 1024
+	DefaultHealthMaxStaleAgentPercent = 50
+)
+
+// DefaultSessionMaxAge is one day, in seconds
+const DefaultSessionMaxAge = 86400
+
+// DefaultPasswordMinLength applies when the policy does not set a minimum length
+const DefaultPasswordMinLength = 12
+
+// lockout defaults, used when the lockout config leaves a value unset
+const (
+	DefaultLockoutMaxFailedAttempts      = 5
+	DefaultLockoutMaxFailedAttemptsPerIp = 20
+	DefaultLockoutWindowMinutes          = 15
+	DefaultLockoutCooldownMinutes        = 15
+)
+
+// rate limit defaults, used when the rate limit config leaves a value unset
+const (
+	DefaultRateLimitAuthPerMinute         = 30
+	DefaultRateLimitAuthBurst             = 10
+	DefaultRateLimitAuthFailuresPerMinute = 6
+	DefaultRateLimitAuthFailuresBurst     = 5
+	DefaultRateLimitIngestionPerMinute    = 12
+	DefaultRateLimitIngestionBurst        = 20
+)
+
+// TokenScopesKey holds the scopes of the API token a request was
+// authenticated with, if any
+const TokenScopesKey = "tokenScopes"
+
+// permissions granted to users through their role
+const (
+	PermissionRead  = "read"
+	PermissionWrite = "write"
+	PermissionAdmin = "admin"
+)
+
+// LDAP defaults, suitable for OpenLDAP. Active Directory usually wants
+// "(&(objectClass=user)(sAMAccountName=%s))" and sAMAccountName
+const (
+	DefaultLdapUserFilter        = "(&(objectClass=person)(uid=%s))"
+	DefaultLdapUserNameAttribute = "uid"
+	DefaultLdapFullNameAttribute = "cn"
+	DefaultLdapGroupAttribute    = "memberOf"
+	DefaultLdapGroupFilter       = "(member=%s)"
+	DefaultLdapTimeoutSeconds    = 10
+)
+
+// OIDC defaults
+const (
+	DefaultOidcUserNameClaim     = "preferred_username"
+	DefaultOidcFullNameClaim     = "name"
+	DefaultOidcRolesClaim        = "groups"
+	DefaultOidcPostLoginRedirect = "/"
+)
+
+// session keys holding an OIDC login in progress
+const (
+	OidcStateKey    = "oidcState"
+	OidcNonceKey    = "oidcNonce"
+	OidcVerifierKey = "oidcVerifier"
+)
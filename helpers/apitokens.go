@@ -0,0 +1,143 @@
+package helpers
+
+/*
+
+  Copyright 2024, YggdrasilSoft, LLC.
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/greeneg/update-reporterd/globals"
+	"github.com/greeneg/update-reporterd/model"
+)
+
+// ApiTokenPrefix starts every personal API token, telling them apart from
+// single sign-on access tokens and making leaked tokens easy to search for
+const ApiTokenPrefix = "urt_"
+
+// length of the token start kept in clear so users can tell their tokens apart
+const apiTokenDisplayLength = len(ApiTokenPrefix) + 8
+
+// GenerateApiToken Returns a new random token along with its display prefix
+// and the hash to store for it
+func GenerateApiToken() (string, string, string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", "", "", err
+	}
+	token := ApiTokenPrefix + base64.RawURLEncoding.EncodeToString(raw)
+
+	return token, token[:apiTokenDisplayLength], HashApiToken(token), nil
+}
+
+// HashApiToken Returns the stored form of a token. Tokens are long random
+// values, so a fast hash is enough
+func HashApiToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// ValidateApiTokenScopes Returns the requested scopes without duplicates, or
+// an error if a scope is unknown or exceeds what the user's role grants
+func ValidateApiTokenScopes(u model.User, scopes []string) ([]string, error) {
+	if len(scopes) == 0 {
+		return nil, &model.Validation{Message: "at least one scope is required"}
+	}
+
+	permissions := UserPermissions(u)
+	validated := make([]string, 0, len(scopes))
+	for _, scope := range scopes {
+		switch scope {
+		case globals.PermissionRead, globals.PermissionWrite, globals.PermissionAdmin:
+		default:
+			return nil, &model.Validation{Message: "unknown scope '" + scope + "'"}
+		}
+		if !slices.Contains(permissions, scope) {
+			return nil, &model.Validation{Message: "scope '" + scope + "' exceeds the permissions of your role"}
+		}
+		if !slices.Contains(validated, scope) {
+			validated = append(validated, scope)
+		}
+	}
+
+	return validated, nil
+}
+
+// AuthenticateApiToken Returns the owner of a token and the scopes it grants
+// them now, which never exceed what their role currently allows
+func AuthenticateApiToken(token string, remoteAddr string) (model.User, []string, bool) {
+	if !checkAddrIsNotBlocked(remoteAddr) {
+		return model.User{}, nil, false
+	}
+
+	apiToken, err := model.GetApiTokenByHash(HashApiToken(token))
+	if err != nil {
+		return model.User{}, nil, false
+	}
+	if apiToken.Id == 0 {
+		model.RecordLoginEvent("", remoteAddr, model.LoginEventFailure, "Invalid or expired API token")
+		return model.User{}, nil, false
+	}
+
+	user, err := model.GetUserById(apiToken.UserId)
+	if err != nil || user.UserName == "" {
+		return model.User{}, nil, false
+	}
+	if !CheckIsNotLocked(user) && !unlockIfCooledDown(user, remoteAddr) {
+		model.RecordLoginEvent(user.UserName, remoteAddr, model.LoginEventFailure, "Account is locked")
+		return model.User{}, nil, false
+	}
+
+	permissions := UserPermissions(user)
+	scopes := make([]string, 0, len(apiToken.Scopes))
+	for _, scope := range apiToken.Scopes {
+		if slices.Contains(permissions, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	model.TouchApiToken(apiToken.Id)
+	slog.Info("API token used", "token", apiToken.Name, "user", user.UserName)
+	return user, scopes, true
+}
+
+// ApiTokenScopesAllow Returns whether token scopes permit a request method.
+// Read-only tokens may only make safe requests
+func ApiTokenScopesAllow(scopes []string, method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return slices.Contains(scopes, globals.PermissionRead) || slices.Contains(scopes, globals.PermissionWrite)
+	default:
+		return slices.Contains(scopes, globals.PermissionWrite)
+	}
+}
+
+// ApiTokenExpiry Returns when a token created now expires, or the zero time
+// if it does not
+func ApiTokenExpiry(expiresInDays int) time.Time {
+	if expiresInDays <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(time.Duration(expiresInDays) * 24 * time.Hour)
+}
@@ -19,11 +19,12 @@ package helpers
 */
 
 import (
-	"crypto/sha512"
-	"encoding/hex"
-	"log"
+	"log/slog"
+	"os"
 	"strings"
+	"sync"
 
+	"github.com/greeneg/update-reporterd/globals"
 	"github.com/greeneg/update-reporterd/model"
 )
 
@@ -31,25 +32,171 @@ func CheckIsNotLocked(u model.User) bool {
 	return u.Status != "locked"
 }
 
-func CheckUserPass(username, password string) bool {
+func CheckIsAdministrator(u model.User) bool {
+	return u.RoleId == model.SystemRoleId || u.RoleId == model.AdministratorsRoleId
+}
+
+// UserPermissions Returns the permissions a user holds through their role
+func UserPermissions(u model.User) []string {
+	if CheckIsAdministrator(u) {
+		return []string{globals.PermissionRead, globals.PermissionWrite, globals.PermissionAdmin}
+	}
+	return []string{globals.PermissionRead, globals.PermissionWrite}
+}
+
+var (
+	dummyHashOnce sync.Once
+	dummyHash     string
+)
+
+// dummyPasswordHash Returns a hash to verify passwords of unknown users
+// against, so they take as long to refuse as a wrong password would
+func dummyPasswordHash() string {
+	dummyHashOnce.Do(func() {
+		hash, err := model.HashPassword("update-reporterd-unknown-user")
+		if err != nil {
+			slog.Error("Cannot hash the password used for unknown users", "error", err)
+			return
+		}
+		dummyHash = hash
+	})
+
+	return dummyHash
+}
+
+// CheckUserPass Returns whether the credentials are valid, tracking failed
+// attempts from the user and from the remote address for lockout
+func CheckUserPass(username, password, remoteAddr string) bool {
+	_, ok := AuthenticateUser(username, password, remoteAddr)
+	if ok {
+		RecordLoginSuccess(username, remoteAddr)
+	}
+	return ok
+}
+
+// AuthenticateUser Verifies a user's password, tracking failed attempts for
+// lockout. Successes are left for the caller to record with RecordLoginSuccess
+// once any second factor has been checked
+func AuthenticateUser(username, password, remoteAddr string) (model.User, bool) {
+	if !checkAddrIsNotBlocked(remoteAddr) {
+		return model.User{}, false
+	}
+
 	user, err := model.GetUserByUserName(username)
 	if err != nil {
-		return false
+		return model.User{}, false
+	}
+	if user.UserName == "" && currentLdapAuthenticator() == nil {
+		// the hash is still checked, or the quick answer would tell which
+		// user names exist
+		model.VerifyPassword(password, dummyPasswordHash())
+		// still count it against the address
+		model.RecordLoginEvent(username, remoteAddr, model.LoginEventFailure, "No such user")
+		return model.User{}, false
 	}
 
-	status := CheckIsNotLocked(user)
-	if !status {
-		return false
+	if user.UserName != "" {
+		status := CheckIsNotLocked(user)
+		if !status && !unlockIfCooledDown(user, remoteAddr) {
+			model.RecordLoginEvent(username, remoteAddr, model.LoginEventFailure, "Account is locked")
+			return model.User{}, false
+		}
+	}
+
+	// unknown users may still be in the directory, local accounts never are
+	if user.UserName == "" || user.AuthSource == model.AuthSourceLdap {
+		return authenticateLdapUser(user, username, password, remoteAddr)
+	}
+	if user.AuthSource == model.AuthSourceOidc {
+		// not counted towards lockout, or anyone could lock single sign-on users out
+		model.RecordLoginEvent(username, remoteAddr, model.LoginEventFailure, "Single sign-on account")
+		return model.User{}, false
 	}
 
 	// get the password hash from the user so we can compare it
-	pwHash := user.PasswordHash
+	match, needsRehash, err := model.VerifyPassword(password, user.PasswordHash)
+	if err != nil {
+		slog.Error("Cannot verify password", "user", username, "error", err)
+		recordLoginFailure(username, remoteAddr, "Password cannot be verified")
+		return model.User{}, false
+	}
+	if !match {
+		recordLoginFailure(username, remoteAddr, "Wrong password")
+		return model.User{}, false
+	}
+
+	// upgrade legacy or outdated hashes now that we know the password
+	if needsRehash {
+		upgradePasswordHash(username, password)
+	}
+
+	return user, true
+}
+
+// syncExternalUser Creates or refreshes the local record of a user vouched for
+// by an external identity provider, returning it if they may log in
+func syncExternalUser(username, fullName, roleName, authSource, remoteAddr string) (model.User, bool) {
+	roleId, ok := mappedRoleId(roleName, authSource)
+	if !ok {
+		return model.User{}, false
+	}
+
+	saved, err := model.SaveExternalUser(username, fullName, roleId, authSource)
+	if err != nil {
+		return model.User{}, false
+	}
+	if !saved {
+		slog.Error("External user clashes with an account from another source", "authSource", authSource, "user", username)
+		model.RecordLoginEvent(username, remoteAddr, model.LoginEventFailure, "Clashes with an account from another source")
+		return model.User{}, false
+	}
+
+	user, err := model.GetUserByUserName(username)
+	if err != nil || user.UserName == "" {
+		return model.User{}, false
+	}
+
+	return admitExternalUser(user, remoteAddr)
+}
 
-	// now calculate the sha512 of the password and see if it matches
-	sha := sha512.Sum512([]byte(password))
-	newPwHash := hex.EncodeToString(sha[:])
+// mappedRoleId Returns the Id of the role an identity provider's mapping names
+func mappedRoleId(roleName, authSource string) (int, bool) {
+	role, err := model.GetRoleByName(roleName)
+	if err != nil || role.Id == 0 {
+		slog.Error("Mapped role does not exist", "role", roleName, "authSource", authSource)
+		return 0, false
+	}
+	return role.Id, true
+}
+
+// admitExternalUser Returns the stored record of an external user if they
+// may log in
+func admitExternalUser(user model.User, remoteAddr string) (model.User, bool) {
+	// a user found under a different spelling may be locked
+	if !CheckIsNotLocked(user) && !unlockIfCooledDown(user, remoteAddr) {
+		model.RecordLoginEvent(user.UserName, remoteAddr, model.LoginEventFailure, "Account is locked")
+		return model.User{}, false
+	}
+
+	return user, true
+}
 
-	return pwHash == newPwHash // returns boolean based on equality
+func RecordLoginSuccess(username, remoteAddr string) {
+	model.RecordLoginEvent(username, remoteAddr, model.LoginEventSuccess, "")
+}
+
+func upgradePasswordHash(username, password string) {
+	newPwHash, err := model.HashPassword(password)
+	if err != nil {
+		slog.Error("Cannot rehash password", "user", username, "error", err)
+		return
+	}
+	_, err = model.UpdatePasswordHash(username, newPwHash)
+	if err != nil {
+		slog.Error("Cannot store rehashed password", "user", username, "error", err)
+		return
+	}
+	slog.Info("Upgraded password hash", "user", username)
 }
 
 func EmptyUserPass(username, password string) bool {
@@ -58,6 +205,7 @@ func EmptyUserPass(username, password string) bool {
 
 func FatalCheckError(err error) {
 	if err != nil {
-		log.Fatal(err)
+		slog.Error("Cannot continue", "error", err)
+		os.Exit(1)
 	}
 }
@@ -0,0 +1,59 @@
+package helpers
+
+/*
+
+  Copyright 2024, YggdrasilSoft, LLC.
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+import (
+	"testing"
+	"time"
+
+	"github.com/greeneg/update-reporterd/globals"
+	"github.com/greeneg/update-reporterd/model/storetest"
+)
+
+// fastestRefusal Returns the shortest of a few refused authentications
+func fastestRefusal(t *testing.T, username string) time.Duration {
+	fastest := time.Duration(0)
+	for i := 0; i < 3; i++ {
+		start := time.Now()
+		if _, ok := AuthenticateUser(username, "wrong password", testAddr); ok {
+			t.Fatal("a wrong password was accepted")
+		}
+		if elapsed := time.Since(start); fastest == 0 || elapsed < fastest {
+			fastest = elapsed
+		}
+	}
+	return fastest
+}
+
+func TestUnknownUserTakesAsLongAsAWrongPassword(t *testing.T) {
+	storetest.OpenDatabase(t)
+	SetLockoutPolicy(globals.LockoutConfig{MaxFailedAttempts: 100, MaxFailedAttemptsPerIp: 100})
+	t.Cleanup(func() { SetLockoutPolicy(globals.LockoutConfig{}) })
+	createTestUser(t, testUserName)
+	// the first call hashes the password for unknown users
+	dummyPasswordHash()
+
+	known := fastestRefusal(t, testUserName)
+	unknown := fastestRefusal(t, "no-such-user")
+	// the password hash dwarfs the queries, an unknown user refused without
+	// it is many times quicker
+	if unknown < known/3 {
+		t.Errorf("an unknown user was refused in %v, a wrong password in %v", unknown, known)
+	}
+}
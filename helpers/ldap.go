@@ -0,0 +1,86 @@
+package helpers
+
+/*
+
+  Copyright 2024, YggdrasilSoft, LLC.
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+import (
+	"errors"
+	"log/slog"
+	"sync"
+
+	"github.com/greeneg/update-reporterd/ldapauth"
+	"github.com/greeneg/update-reporterd/model"
+)
+
+var (
+	ldapMu            sync.RWMutex
+	ldapAuthenticator *ldapauth.Authenticator
+)
+
+// SetLdapAuthenticator Enables checking passwords of directory users against
+// an LDAP server. nil disables it, leaving only local accounts
+func SetLdapAuthenticator(a *ldapauth.Authenticator) {
+	ldapMu.Lock()
+	defer ldapMu.Unlock()
+	ldapAuthenticator = a
+}
+
+func currentLdapAuthenticator() *ldapauth.Authenticator {
+	ldapMu.RLock()
+	defer ldapMu.RUnlock()
+	return ldapAuthenticator
+}
+
+// authenticateLdapUser Verifies a password against the directory and creates
+// or refreshes the user's local record, including their role, from it
+func authenticateLdapUser(user model.User, username, password, remoteAddr string) (model.User, bool) {
+	authenticator := currentLdapAuthenticator()
+	if authenticator == nil {
+		slog.Error("User is a directory user, but LDAP authentication is not enabled", "user", username)
+		model.RecordLoginEvent(username, remoteAddr, model.LoginEventFailure, "Directory authentication is not enabled")
+		return model.User{}, false
+	}
+
+	entry, err := authenticator.Authenticate(username, password)
+	if err != nil {
+		switch {
+		case errors.Is(err, ldapauth.ErrInvalidCredentials):
+			if user.UserName != "" {
+				recordLoginFailure(username, remoteAddr, "Wrong password")
+			} else {
+				model.RecordLoginEvent(username, remoteAddr, model.LoginEventFailure, "Wrong password")
+			}
+		case errors.Is(err, ldapauth.ErrNoSuchUser):
+			model.RecordLoginEvent(username, remoteAddr, model.LoginEventFailure, "No such user")
+		default:
+			// the directory being unreachable is not the user's fault, so
+			// this does not count towards lockout
+			slog.Error("LDAP authentication failed", "user", username, "error", err)
+		}
+		return model.User{}, false
+	}
+
+	roleName, ok := authenticator.RoleName(entry)
+	if !ok {
+		slog.Warn("Directory user is not in any group mapped to a role", "user", entry.UserName)
+		model.RecordLoginEvent(username, remoteAddr, model.LoginEventFailure, "Not in any mapped directory group")
+		return model.User{}, false
+	}
+
+	return syncExternalUser(entry.UserName, entry.FullName, roleName, model.AuthSourceLdap, remoteAddr)
+}
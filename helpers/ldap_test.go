@@ -0,0 +1,119 @@
+package helpers
+
+/*
+
+  Copyright 2024, YggdrasilSoft, LLC.
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/greeneg/update-reporterd/globals"
+	"github.com/greeneg/update-reporterd/ldapauth"
+	"github.com/greeneg/update-reporterd/ldapauth/ldaptest"
+	"github.com/greeneg/update-reporterd/model"
+	"github.com/greeneg/update-reporterd/model/storetest"
+)
+
+const (
+	directoryUserDn   = "uid=dana,ou=people,dc=example,dc=com"
+	directoryPassword = "Directory-Secret-7"
+	operatorsGroupDn  = "cn=operators,ou=groups,dc=example,dc=com"
+)
+
+// enableTestDirectory Turns on LDAP authentication against a directory holding
+// dana, a member of the operators group, and an entry shadowing the local
+// user of the lockout tests
+func enableTestDirectory(t *testing.T) *ldaptest.Directory {
+	directory := &ldaptest.Directory{Entries: []ldaptest.Entry{
+		{Dn: directoryUserDn, Password: directoryPassword, Attributes: map[string][]string{
+			"objectClass": {"person"},
+			"uid":         {"dana"},
+			"cn":          {"Dana Directory"},
+			"memberOf":    {operatorsGroupDn},
+		}},
+		{Dn: "uid=" + testUserName + ",ou=people,dc=example,dc=com", Password: directoryPassword, Attributes: map[string][]string{
+			"objectClass": {"person"},
+			"uid":         {testUserName},
+			"memberOf":    {operatorsGroupDn},
+		}},
+	}}
+	a, err := ldapauth.NewWithDialer(globals.LdapConfig{
+		Enabled:    true,
+		Url:        "ldap://directory.example.com",
+		BaseDn:     "ou=people,dc=example,dc=com",
+		GroupRoles: []globals.LdapGroupRole{{GroupDn: operatorsGroupDn, RoleName: "operators"}},
+	}, directory.Dial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetLdapAuthenticator(a)
+	t.Cleanup(func() { SetLdapAuthenticator(nil) })
+
+	return directory
+}
+
+func TestLdapLoginCreatesMappedUser(t *testing.T) {
+	storetest.OpenDatabase(t)
+	if _, err := model.CreateRole(model.Role{RoleName: "operators"}); err != nil {
+		t.Fatal(err)
+	}
+	enableTestDirectory(t)
+
+	if _, ok := AuthenticateUser("dana", "wrong password", testAddr); ok {
+		t.Fatal("a wrong directory password was accepted")
+	}
+	if user, _ := model.GetUserByUserName("dana"); user.UserName != "" {
+		t.Error("a failed directory login created a local record")
+	}
+
+	user, ok := AuthenticateUser("dana", directoryPassword, testAddr)
+	if !ok {
+		t.Fatal("the directory password was refused")
+	}
+	operators, err := model.GetRoleByName("operators")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.AuthSource != model.AuthSourceLdap || user.FullName != "Dana Directory" || user.RoleId != operators.Id {
+		t.Errorf("dana was recorded as %+v", user)
+	}
+}
+
+func TestLdapLeavesLocalAccountsLocal(t *testing.T) {
+	storetest.OpenDatabase(t)
+	createTestUser(t, testUserName)
+	directory := enableTestDirectory(t)
+
+	if _, ok := AuthenticateUser(testUserName, directoryPassword, testAddr); ok {
+		t.Error("a local account was let in with the directory's password")
+	}
+	if _, ok := AuthenticateUser(testUserName, testPassword, testAddr); !ok {
+		t.Error("a local account was refused its own password")
+	}
+	if directory.Binds != 0 {
+		t.Errorf("local logins made %d binds to the directory", directory.Binds)
+	}
+
+	directory.Unreachable = errors.New("connection refused")
+	if _, ok := AuthenticateUser(testUserName, testPassword, testAddr); !ok {
+		t.Error("a local account was refused while the directory is down")
+	}
+	if _, ok := AuthenticateUser("dana", directoryPassword, testAddr); ok {
+		t.Error("a directory user was let in while the directory is down")
+	}
+}
@@ -0,0 +1,141 @@
+package helpers
+
+/*
+
+  Copyright 2024, YggdrasilSoft, LLC.
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+import (
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/greeneg/update-reporterd/globals"
+	"github.com/greeneg/update-reporterd/model"
+)
+
+var (
+	lockoutMu     sync.RWMutex
+	lockoutPolicy = withLockoutDefaults(globals.LockoutConfig{})
+)
+
+// SetLockoutPolicy Sets the thresholds used to lock users and refuse addresses
+// after repeated failed logins
+func SetLockoutPolicy(l globals.LockoutConfig) {
+	lockoutMu.Lock()
+	defer lockoutMu.Unlock()
+	lockoutPolicy = withLockoutDefaults(l)
+}
+
+func currentLockoutPolicy() globals.LockoutConfig {
+	lockoutMu.RLock()
+	defer lockoutMu.RUnlock()
+	return lockoutPolicy
+}
+
+func withLockoutDefaults(l globals.LockoutConfig) globals.LockoutConfig {
+	if l.MaxFailedAttempts <= 0 {
+		l.MaxFailedAttempts = globals.DefaultLockoutMaxFailedAttempts
+	}
+	if l.MaxFailedAttemptsPerIp <= 0 {
+		l.MaxFailedAttemptsPerIp = globals.DefaultLockoutMaxFailedAttemptsPerIp
+	}
+	if l.WindowMinutes <= 0 {
+		l.WindowMinutes = globals.DefaultLockoutWindowMinutes
+	}
+	if l.CooldownMinutes == 0 {
+		l.CooldownMinutes = globals.DefaultLockoutCooldownMinutes
+	}
+	return l
+}
+
+func lockoutWindowStart(policy globals.LockoutConfig) time.Time {
+	return time.Now().Add(-time.Duration(policy.WindowMinutes) * time.Minute)
+}
+
+// checkAddrIsNotBlocked Returns whether an address may still attempt logins
+func checkAddrIsNotBlocked(remoteAddr string) bool {
+	policy := currentLockoutPolicy()
+	if policy.Disabled || remoteAddr == "" {
+		return true
+	}
+
+	failures, err := model.CountLoginFailuresFromAddr(remoteAddr, lockoutWindowStart(policy))
+	if err != nil {
+		// don't lock everyone out because the count failed
+		return true
+	}
+	if failures >= policy.MaxFailedAttemptsPerIp {
+		slog.Warn("Refusing login after repeated failures", "clientIp", remoteAddr, "failures", failures)
+		return false
+	}
+
+	return true
+}
+
+// unlockIfCooledDown Lifts an automatic lock whose cooldown has passed,
+// returning whether the user is now unlocked
+func unlockIfCooledDown(user model.User, remoteAddr string) bool {
+	lockout, err := model.GetLockout(user.UserName)
+	if err != nil || lockout.UnlockDate == "" {
+		return false
+	}
+
+	unlockDate, err := time.Parse("2006-01-02 15:04:05", lockout.UnlockDate)
+	if err != nil || time.Now().UTC().Before(unlockDate) {
+		return false
+	}
+
+	_, err = model.SetUserStatus(user.UserName, model.UserStatus{
+		Status: "enabled",
+		Reason: "Cooldown after automatic lock has passed",
+	})
+	if err != nil {
+		return false
+	}
+	slog.Info("Automatic lock has been lifted", "user", user.UserName, "clientIp", remoteAddr)
+
+	return true
+}
+
+// recordLoginFailure Records a failed login, locking the user once they reach the threshold
+func recordLoginFailure(username string, remoteAddr string, reason string) {
+	model.RecordLoginEvent(username, remoteAddr, model.LoginEventFailure, reason)
+	policy := currentLockoutPolicy()
+	if policy.Disabled {
+		return
+	}
+
+	failures, err := model.CountLoginFailures(username, lockoutWindowStart(policy))
+	if err != nil || failures < policy.MaxFailedAttempts {
+		return
+	}
+
+	lockReason := strconv.Itoa(failures) + " failed login attempts within " +
+		strconv.Itoa(policy.WindowMinutes) + " minutes"
+	unlockDate := time.Time{}
+	if policy.CooldownMinutes > 0 {
+		unlockDate = time.Now().Add(time.Duration(policy.CooldownMinutes) * time.Minute)
+	}
+
+	_, err = model.LockUser(username, lockReason, unlockDate)
+	if err != nil {
+		return
+	}
+	model.RecordLoginEvent(username, remoteAddr, model.LoginEventLocked, lockReason)
+	slog.Warn("User has been locked", "user", username, "reason", lockReason)
+}
@@ -0,0 +1,88 @@
+package helpers
+
+/*
+
+  Copyright 2024, YggdrasilSoft, LLC.
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+import (
+	"testing"
+
+	"github.com/greeneg/update-reporterd/globals"
+	"github.com/greeneg/update-reporterd/model"
+	"github.com/greeneg/update-reporterd/model/storetest"
+)
+
+const (
+	testUserName = "lockout-test"
+	testPassword = "Correct-Horse-42!"
+	testAddr     = "192.0.2.10"
+)
+
+// createTestUser Creates a local user in a role of their own
+func createTestUser(t *testing.T, username string) model.User {
+	if _, err := model.CreateRole(model.Role{RoleName: username + "-role"}); err != nil {
+		t.Fatal(err)
+	}
+	role, err := model.GetRoleByName(username + "-role")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := model.CreateUser(model.ProposedUser{UserName: username, RoleId: role.Id, Password: testPassword})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return user
+}
+
+func userStatus(t *testing.T, username string) string {
+	status, err := model.GetUserStatus(username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return status
+}
+
+func TestAdminUnlockEndsEarlierFailures(t *testing.T) {
+	storetest.OpenDatabase(t)
+	SetLockoutPolicy(globals.LockoutConfig{MaxFailedAttempts: 3, MaxFailedAttemptsPerIp: 100, CooldownMinutes: -1})
+	t.Cleanup(func() { SetLockoutPolicy(globals.LockoutConfig{}) })
+	createTestUser(t, testUserName)
+
+	for i := 0; i < 3; i++ {
+		if _, ok := AuthenticateUser(testUserName, "wrong password", testAddr); ok {
+			t.Fatal("a wrong password was accepted")
+		}
+	}
+	if status := userStatus(t, testUserName); status != "locked" {
+		t.Fatalf("user is %s after 3 failed logins, not locked", status)
+	}
+
+	if _, err := model.SetUserStatus(testUserName, model.UserStatus{Status: "enabled"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := AuthenticateUser(testUserName, "wrong password", testAddr); ok {
+		t.Fatal("a wrong password was accepted")
+	}
+	if status := userStatus(t, testUserName); status != "enabled" {
+		t.Fatalf("user is %s after one failed login following an unlock, not enabled", status)
+	}
+
+	if _, ok := AuthenticateUser(testUserName, testPassword, testAddr); !ok {
+		t.Fatal("the right password was refused after the unlock")
+	}
+}
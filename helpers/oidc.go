@@ -0,0 +1,114 @@
+package helpers
+
+/*
+
+  Copyright 2024, YggdrasilSoft, LLC.
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+
+	"github.com/greeneg/update-reporterd/model"
+	"github.com/greeneg/update-reporterd/oidcauth"
+)
+
+var (
+	oidcMu       sync.RWMutex
+	oidcProvider *oidcauth.Provider
+)
+
+// SetOidcProvider Enables single sign-on through an OpenID Connect issuer.
+// nil disables it
+func SetOidcProvider(p *oidcauth.Provider) {
+	oidcMu.Lock()
+	defer oidcMu.Unlock()
+	oidcProvider = p
+}
+
+// OidcProvider Returns the configured OpenID Connect issuer, or nil if single
+// sign-on is not enabled
+func OidcProvider() *oidcauth.Provider {
+	oidcMu.RLock()
+	defer oidcMu.RUnlock()
+	return oidcProvider
+}
+
+// AuthenticateOidcIdentity Creates or refreshes the local record of a user
+// signed in by the issuer, including their role, and returns it if they may
+// log in
+func AuthenticateOidcIdentity(identity oidcauth.Identity, remoteAddr string) (model.User, bool) {
+	if !checkAddrIsNotBlocked(remoteAddr) {
+		return model.User{}, false
+	}
+
+	provider := OidcProvider()
+	if provider == nil {
+		return model.User{}, false
+	}
+	roleName, ok := provider.RoleName(identity)
+	if !ok {
+		slog.Warn("Single sign-on user has no claim mapped to a role", "user", identity.UserName)
+		model.RecordLoginEvent(identity.UserName, remoteAddr, model.LoginEventFailure, "No mapped role claim")
+		return model.User{}, false
+	}
+
+	roleId, ok := mappedRoleId(roleName, model.AuthSourceOidc)
+	if !ok {
+		return model.User{}, false
+	}
+
+	user, err := model.SaveOidcUser(identity.Issuer, identity.Subject, identity.UserName, identity.FullName, roleId)
+	if err != nil {
+		return model.User{}, false
+	}
+	if user.UserName == "" {
+		slog.Error("Single sign-on user's name is taken by another account", "user", identity.UserName,
+			"subject", identity.Subject)
+		if taken, err := model.GetUserByUserName(identity.UserName); err == nil && taken.AuthSource == model.AuthSourceOidc {
+			// recorded before accounts were matched on issuer and subject, so
+			// it cannot be told whether this is the same person
+			slog.Warn("Remove the single sign-on account so it is created again at its owner's next login",
+				"user", identity.UserName)
+		}
+		model.RecordLoginEvent(identity.UserName, remoteAddr, model.LoginEventFailure, "User name taken by another account")
+		return model.User{}, false
+	}
+
+	return admitExternalUser(user, remoteAddr)
+}
+
+// AuthenticateOidcAccessToken Returns the user a bearer access token from the
+// issuer was issued to
+func AuthenticateOidcAccessToken(ctx context.Context, rawToken string, remoteAddr string) (model.User, bool) {
+	provider := OidcProvider()
+	if provider == nil {
+		return model.User{}, false
+	}
+	if !checkAddrIsNotBlocked(remoteAddr) {
+		return model.User{}, false
+	}
+
+	identity, err := provider.VerifyAccessToken(ctx, rawToken)
+	if err != nil {
+		slog.Error("Bearer token rejected", "error", err)
+		model.RecordLoginEvent("", remoteAddr, model.LoginEventFailure, "Invalid bearer token")
+		return model.User{}, false
+	}
+
+	return AuthenticateOidcIdentity(identity, remoteAddr)
+}
@@ -0,0 +1,211 @@
+package helpers
+
+/*
+
+  Copyright 2024, YggdrasilSoft, LLC.
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
+	"image/png"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+
+	"github.com/greeneg/update-reporterd/model"
+)
+
+const totpIssuer = "Update Reporter"
+
+// TOTP parameters understood by all common authenticator apps
+const (
+	totpPeriod = 30
+	totpDigits = otp.DigitsSix
+	// accept codes one step either side of now to allow for clock drift
+	totpSkew = 1
+)
+
+const recoveryCodeCount = 10
+
+// GenerateTotpEnrollment Returns a new TOTP secret for a user along with its
+// otpauth URI and a QR code of that URI
+func GenerateTotpEnrollment(username string) (model.TotpEnrollment, error) {
+	key, err := totp.Generate(totp.GenerateOpts{
+		Issuer:      totpIssuer,
+		AccountName: username,
+		Period:      totpPeriod,
+		Digits:      totpDigits,
+		Algorithm:   otp.AlgorithmSHA1,
+	})
+	if err != nil {
+		return model.TotpEnrollment{}, err
+	}
+
+	img, err := key.Image(256, 256)
+	if err != nil {
+		return model.TotpEnrollment{}, err
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return model.TotpEnrollment{}, err
+	}
+
+	return model.TotpEnrollment{
+		Secret:     key.Secret(),
+		OtpauthUri: key.URL(),
+		QrCode:     base64.StdEncoding.EncodeToString(buf.Bytes()),
+	}, nil
+}
+
+// MatchTotpCode Returns the time step a code is valid for, if any
+func MatchTotpCode(secret string, code string) (int64, bool) {
+	code = strings.TrimSpace(code)
+	if len(code) != totpDigits.Length() {
+		return 0, false
+	}
+
+	now := time.Now()
+	for skew := -totpSkew; skew <= totpSkew; skew++ {
+		t := now.Add(time.Duration(skew*totpPeriod) * time.Second)
+		expected, err := totp.GenerateCodeCustom(secret, t, totp.ValidateOpts{
+			Period:    totpPeriod,
+			Digits:    totpDigits,
+			Algorithm: otp.AlgorithmSHA1,
+		})
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return t.Unix() / totpPeriod, true
+		}
+	}
+
+	return 0, false
+}
+
+// GenerateRecoveryCodes Returns new single-use recovery codes and the hashes to store for them
+func GenerateRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		raw := make([]byte, 10)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, nil, err
+		}
+		encoded := strings.ToLower(base32.StdEncoding.EncodeToString(raw))
+		code := encoded[:8] + "-" + encoded[8:16]
+
+		codes = append(codes, code)
+		hashes = append(hashes, HashRecoveryCode(code))
+	}
+
+	return codes, hashes, nil
+}
+
+// HashRecoveryCode Returns the stored form of a recovery code. These are long
+// random values, so a fast hash is enough
+func HashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
+
+// CheckTwoFactorEnrolled Returns whether a user has a confirmed TOTP enrolment
+func CheckTwoFactorEnrolled(u model.User) (bool, error) {
+	userTotp, err := model.GetUserTotp(u.UserName)
+	if err != nil {
+		return false, err
+	}
+	return userTotp.Confirmed, nil
+}
+
+// CheckTwoFactorRequired Returns whether the user's role requires two-factor authentication
+func CheckTwoFactorRequired(u model.User) (bool, error) {
+	// single sign-on users get their second factor from the issuer
+	if u.AuthSource == model.AuthSourceOidc {
+		return false, nil
+	}
+	role, err := model.GetRoleById(u.RoleId)
+	if err != nil {
+		return false, err
+	}
+	return role.TwoFactorRequired, nil
+}
+
+// TwoFactorStatus Returns a summary of the user's two-factor enrolment
+func TwoFactorStatus(u model.User) (model.TotpStatus, error) {
+	required, err := CheckTwoFactorRequired(u)
+	if err != nil {
+		return model.TotpStatus{}, err
+	}
+	userTotp, err := model.GetUserTotp(u.UserName)
+	if err != nil {
+		return model.TotpStatus{}, err
+	}
+
+	status := model.TotpStatus{Enabled: userTotp.Confirmed, Required: required}
+	if userTotp.Confirmed {
+		status.ConfirmedDate = userTotp.ConfirmedDate
+		status.RecoveryCodesRemaining, err = model.CountUnusedRecoveryCodes(u.UserName)
+		if err != nil {
+			return model.TotpStatus{}, err
+		}
+	}
+
+	return status, nil
+}
+
+// VerifySecondFactor Returns whether the code is a current TOTP code or an
+// unused recovery code of the user, without recording failures
+func VerifySecondFactor(u model.User, code string) bool {
+	userTotp, err := model.GetUserTotp(u.UserName)
+	if err != nil || !userTotp.Confirmed {
+		return false
+	}
+
+	if counter, ok := MatchTotpCode(userTotp.Secret, code); ok {
+		used, err := model.UseTotpCounter(u.UserName, counter)
+		return err == nil && used
+	}
+
+	used, err := model.UseRecoveryCode(u.UserName, HashRecoveryCode(code))
+	if err == nil && used {
+		slog.Warn("Recovery code used", "user", u.UserName)
+		return true
+	}
+
+	return false
+}
+
+// CheckSecondFactor Verifies a login's second factor, counting a wrong code
+// as a failed login for lockout
+func CheckSecondFactor(u model.User, code string, remoteAddr string) bool {
+	if VerifySecondFactor(u, code) {
+		return true
+	}
+
+	recordLoginFailure(u.UserName, remoteAddr, "Wrong two-factor code")
+	return false
+}
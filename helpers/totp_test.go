@@ -0,0 +1,85 @@
+package helpers
+
+/*
+
+  Copyright 2024, YggdrasilSoft, LLC.
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+
+	"github.com/greeneg/update-reporterd/model"
+	"github.com/greeneg/update-reporterd/model/storetest"
+)
+
+func TestSecondFactorCodes(t *testing.T) {
+	storetest.OpenDatabase(t)
+	user := createTestUser(t, "totp-test")
+
+	enrollment, err := GenerateTotpEnrollment(user.UserName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := model.SaveUserTotpSecret(user.UserName, enrollment.Secret); err != nil {
+		t.Fatal(err)
+	}
+	codes, hashes, err := GenerateRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := model.ConfirmUserTotp(user.UserName, 0, hashes); err != nil {
+		t.Fatal(err)
+	}
+
+	current, err := totp.GenerateCodeCustom(enrollment.Secret, time.Now(), totp.ValidateOpts{
+		Period:    totpPeriod,
+		Digits:    totpDigits,
+		Algorithm: otp.AlgorithmSHA1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := MatchTotpCode(enrollment.Secret, current); !ok {
+		t.Error("the current code did not match")
+	}
+	wrong := []byte(current)
+	wrong[len(wrong)-1] = '0' + (wrong[len(wrong)-1]-'0'+1)%10
+	if _, ok := MatchTotpCode(enrollment.Secret, string(wrong)); ok {
+		t.Error("a code differing in its last digit matched")
+	}
+
+	if VerifySecondFactor(user, "aaaaaaaa-aaaaaaaa") {
+		t.Error("a made up recovery code was accepted")
+	}
+	if !VerifySecondFactor(user, strings.ToUpper(codes[3])) {
+		t.Error("a recovery code was refused")
+	}
+	if VerifySecondFactor(user, codes[3]) {
+		t.Error("a recovery code was accepted a second time")
+	}
+	left, err := model.CountUnusedRecoveryCodes(user.UserName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if left != len(codes)-1 {
+		t.Errorf("%d recovery codes are left, not %d", left, len(codes)-1)
+	}
+}
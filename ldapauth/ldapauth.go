@@ -0,0 +1,305 @@
+package ldapauth
+
+/*
+
+  Copyright 2024, YggdrasilSoft, LLC.
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+
+	"github.com/greeneg/update-reporterd/globals"
+)
+
+// ErrInvalidCredentials is returned when the directory refuses the user's password
+var ErrInvalidCredentials = errors.New("invalid directory credentials")
+
+// ErrNoSuchUser is returned when the user search finds no entry
+var ErrNoSuchUser = errors.New("no such directory user")
+
+// Entry is a directory user that has proven their password
+type Entry struct {
+	Dn       string
+	UserName string
+	FullName string
+	Groups   []string
+}
+
+// Authenticator checks user credentials against an LDAP or Active Directory server
+type Authenticator struct {
+	config    globals.LdapConfig
+	tlsConfig *tls.Config
+	timeout   time.Duration
+	dial      func() (ldap.Client, error)
+}
+
+// New Returns an Authenticator for the config, filling in defaults
+func New(config globals.LdapConfig) (*Authenticator, error) {
+	return NewWithDialer(config, nil)
+}
+
+// NewWithDialer Returns an Authenticator that gets its connections from dial
+// rather than connecting to the configured url, as tests do with a fake
+// directory. A nil dial connects to the url
+func NewWithDialer(config globals.LdapConfig, dial func() (ldap.Client, error)) (*Authenticator, error) {
+	if config.Url == "" {
+		return nil, errors.New("ldap url is not set")
+	}
+	if config.BaseDn == "" {
+		return nil, errors.New("ldap baseDn is not set")
+	}
+	if strings.HasPrefix(strings.ToLower(config.Url), "ldaps://") && config.StartTLS {
+		return nil, errors.New("ldap startTls cannot be used with an ldaps:// url")
+	}
+
+	if config.UserFilter == "" {
+		config.UserFilter = globals.DefaultLdapUserFilter
+	}
+	if !strings.Contains(config.UserFilter, "%s") {
+		return nil, errors.New("ldap userFilter must contain %s for the user name")
+	}
+	if config.UserNameAttribute == "" {
+		config.UserNameAttribute = globals.DefaultLdapUserNameAttribute
+	}
+	if config.FullNameAttribute == "" {
+		config.FullNameAttribute = globals.DefaultLdapFullNameAttribute
+	}
+	if config.GroupAttribute == "" {
+		config.GroupAttribute = globals.DefaultLdapGroupAttribute
+	}
+	if config.GroupBaseDn != "" && config.GroupFilter == "" {
+		config.GroupFilter = globals.DefaultLdapGroupFilter
+	}
+	if config.TimeoutSeconds <= 0 {
+		config.TimeoutSeconds = globals.DefaultLdapTimeoutSeconds
+	}
+
+	tlsConfig, err := newTLSConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &Authenticator{
+		config:    config,
+		tlsConfig: tlsConfig,
+		timeout:   time.Duration(config.TimeoutSeconds) * time.Second,
+		dial:      dial,
+	}
+	if a.dial == nil {
+		a.dial = a.connect
+	}
+
+	return a, nil
+}
+
+func newTLSConfig(config globals.LdapConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: config.InsecureSkipVerify,
+	}
+	if config.CaFile != "" {
+		pem, err := os.ReadFile(config.CaFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("ldap caFile '" + config.CaFile + "' contains no certificates")
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	return tlsConfig, nil
+}
+
+func (a *Authenticator) connect() (ldap.Client, error) {
+	conn, err := ldap.DialURL(a.config.Url,
+		ldap.DialWithDialer(&net.Dialer{Timeout: a.timeout}),
+		ldap.DialWithTLSConfig(a.tlsConfig))
+	if err != nil {
+		return nil, err
+	}
+	conn.SetTimeout(a.timeout)
+
+	if a.config.StartTLS {
+		if err := conn.StartTLS(a.tlsConfig); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	return conn, nil
+}
+
+// Authenticate Looks the user up with the service account, then binds as
+// them to check their password
+func (a *Authenticator) Authenticate(username string, password string) (Entry, error) {
+	// an empty password would be an unauthenticated bind, which many
+	// servers accept for any DN
+	if password == "" {
+		return Entry{}, ErrInvalidCredentials
+	}
+
+	conn, err := a.dial()
+	if err != nil {
+		return Entry{}, err
+	}
+	defer conn.Close()
+
+	if err := a.bindServiceAccount(conn); err != nil {
+		return Entry{}, err
+	}
+
+	entry, err := a.findUser(conn, username)
+	if err != nil {
+		return Entry{}, err
+	}
+
+	if err := conn.Bind(entry.Dn, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return Entry{}, ErrInvalidCredentials
+		}
+		return Entry{}, err
+	}
+
+	if a.config.GroupBaseDn != "" {
+		// search as the service account, users may not be allowed to read groups
+		if err := a.bindServiceAccount(conn); err != nil {
+			return Entry{}, err
+		}
+		groups, err := a.findGroups(conn, entry.Dn)
+		if err != nil {
+			return Entry{}, err
+		}
+		entry.Groups = append(entry.Groups, groups...)
+	}
+
+	return entry, nil
+}
+
+func (a *Authenticator) bindServiceAccount(conn ldap.Client) error {
+	if a.config.BindDn == "" {
+		return conn.UnauthenticatedBind("")
+	}
+	return conn.Bind(a.config.BindDn, a.config.BindPassword)
+}
+
+func (a *Authenticator) findUser(conn ldap.Client, username string) (Entry, error) {
+	request := ldap.NewSearchRequest(
+		a.config.BaseDn,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, a.config.TimeoutSeconds, false,
+		fmt.Sprintf(a.config.UserFilter, ldap.EscapeFilter(username)),
+		[]string{a.config.UserNameAttribute, a.config.FullNameAttribute, a.config.GroupAttribute},
+		nil,
+	)
+	result, err := conn.Search(request)
+	if err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+			return Entry{}, ErrNoSuchUser
+		}
+		return Entry{}, err
+	}
+	if len(result.Entries) == 0 {
+		return Entry{}, ErrNoSuchUser
+	}
+	if len(result.Entries) > 1 {
+		return Entry{}, errors.New("ldap user filter matched more than one entry for '" + username + "'")
+	}
+
+	found := result.Entries[0]
+	entry := Entry{
+		Dn:       found.DN,
+		UserName: found.GetAttributeValue(a.config.UserNameAttribute),
+		FullName: found.GetAttributeValue(a.config.FullNameAttribute),
+		Groups:   found.GetAttributeValues(a.config.GroupAttribute),
+	}
+	// the directory's spelling of the name is used for the local record
+	if entry.UserName == "" {
+		entry.UserName = username
+	}
+	if entry.FullName == "" {
+		entry.FullName = entry.UserName
+	}
+
+	return entry, nil
+}
+
+func (a *Authenticator) findGroups(conn ldap.Client, userDn string) ([]string, error) {
+	request := ldap.NewSearchRequest(
+		a.config.GroupBaseDn,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, a.config.TimeoutSeconds, false,
+		fmt.Sprintf(a.config.GroupFilter, ldap.EscapeFilter(userDn)),
+		[]string{"dn"},
+		nil,
+	)
+	result, err := conn.Search(request)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := make([]string, 0, len(result.Entries))
+	for _, group := range result.Entries {
+		groups = append(groups, group.DN)
+	}
+
+	return groups, nil
+}
+
+// RoleName Returns the name of the role the entry's groups map to, or false if
+// none do and there is no default role
+func (a *Authenticator) RoleName(entry Entry) (string, bool) {
+	for _, mapping := range a.config.GroupRoles {
+		for _, group := range entry.Groups {
+			if strings.EqualFold(normalizeDn(group), normalizeDn(mapping.GroupDn)) {
+				return mapping.RoleName, true
+			}
+		}
+	}
+
+	if a.config.DefaultRole != "" {
+		return a.config.DefaultRole, true
+	}
+	return "", false
+}
+
+// normalizeDn Returns a DN in a form that can be compared, ignoring spacing
+// differences between directories
+func normalizeDn(dn string) string {
+	parsed, err := ldap.ParseDN(dn)
+	if err != nil {
+		return strings.TrimSpace(dn)
+	}
+
+	rdns := make([]string, 0, len(parsed.RDNs))
+	for _, rdn := range parsed.RDNs {
+		attributes := make([]string, 0, len(rdn.Attributes))
+		for _, attribute := range rdn.Attributes {
+			attributes = append(attributes, attribute.Type+"="+attribute.Value)
+		}
+		rdns = append(rdns, strings.Join(attributes, "+"))
+	}
+
+	return strings.Join(rdns, ",")
+}
@@ -0,0 +1,179 @@
+package ldapauth_test
+
+/*
+
+  Copyright 2024, YggdrasilSoft, LLC.
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/greeneg/update-reporterd/globals"
+	"github.com/greeneg/update-reporterd/ldapauth"
+	"github.com/greeneg/update-reporterd/ldapauth/ldaptest"
+)
+
+const (
+	serviceDn       = "cn=reader,dc=example,dc=com"
+	servicePassword = "reader-secret"
+	aliceDn         = "uid=alice,ou=people,dc=example,dc=com"
+	alicePassword   = "alice-secret"
+	operatorsDn     = "cn=operators,ou=groups,dc=example,dc=com"
+	auditorsDn      = "cn=auditors,ou=groups,dc=example,dc=com"
+)
+
+// newDirectory Returns a directory holding a service account, alice, who is
+// a member of the operators and auditors groups, and those groups
+func newDirectory() *ldaptest.Directory {
+	return &ldaptest.Directory{Entries: []ldaptest.Entry{
+		{Dn: serviceDn, Password: servicePassword},
+		{Dn: aliceDn, Password: alicePassword, Attributes: map[string][]string{
+			"objectClass": {"person"},
+			"uid":         {"Alice"},
+			"cn":          {"Alice Example"},
+			"memberOf":    {operatorsDn, auditorsDn},
+		}},
+		{Dn: operatorsDn, Attributes: map[string][]string{"member": {aliceDn}}},
+		{Dn: auditorsDn, Attributes: map[string][]string{"member": {aliceDn}}},
+	}}
+}
+
+func newConfig() globals.LdapConfig {
+	return globals.LdapConfig{
+		Enabled:      true,
+		Url:          "ldap://directory.example.com",
+		BindDn:       serviceDn,
+		BindPassword: servicePassword,
+		BaseDn:       "ou=people,dc=example,dc=com",
+	}
+}
+
+func newAuthenticator(t *testing.T, config globals.LdapConfig, directory *ldaptest.Directory) *ldapauth.Authenticator {
+	a, err := ldapauth.NewWithDialer(config, directory.Dial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func TestAuthenticate(t *testing.T) {
+	a := newAuthenticator(t, newConfig(), newDirectory())
+
+	entry, err := a.Authenticate("alice", alicePassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Dn != aliceDn || entry.UserName != "Alice" || entry.FullName != "Alice Example" {
+		t.Errorf("alice read back as %+v", entry)
+	}
+	if len(entry.Groups) != 2 {
+		t.Errorf("alice is in the groups %v, not operators and auditors", entry.Groups)
+	}
+}
+
+func TestAuthenticateFailures(t *testing.T) {
+	unreachable := errors.New("connection refused")
+	cases := []struct {
+		name     string
+		config   func(c *globals.LdapConfig)
+		down     error
+		username string
+		password string
+		want     error
+	}{
+		{"wrong password", nil, nil, "alice", "wrong", ldapauth.ErrInvalidCredentials},
+		{"empty password", nil, nil, "alice", "", ldapauth.ErrInvalidCredentials},
+		{"unknown user", nil, nil, "bob", alicePassword, ldapauth.ErrNoSuchUser},
+		{"filter characters in the user name", nil, nil, "*", alicePassword, ldapauth.ErrNoSuchUser},
+		{"service account refused", func(c *globals.LdapConfig) { c.BindPassword = "stale" }, nil, "alice", alicePassword, nil},
+		{"server down", nil, unreachable, "alice", alicePassword, unreachable},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			config := newConfig()
+			if tc.config != nil {
+				tc.config(&config)
+			}
+			directory := newDirectory()
+			directory.Unreachable = tc.down
+
+			_, err := newAuthenticator(t, config, directory).Authenticate(tc.username, tc.password)
+			if err == nil {
+				t.Fatal("authentication succeeded")
+			}
+			if tc.want != nil && !errors.Is(err, tc.want) {
+				t.Errorf("failed with '%v', not '%v'", err, tc.want)
+			}
+			// the service account's password is not the user's fault
+			if tc.want == nil && errors.Is(err, ldapauth.ErrInvalidCredentials) {
+				t.Errorf("failed with '%v', blaming the user", err)
+			}
+			if tc.password == "" && directory.Binds != 0 {
+				t.Errorf("an empty password made %d binds", directory.Binds)
+			}
+		})
+	}
+}
+
+func TestGroupSearch(t *testing.T) {
+	directory := newDirectory()
+	// a directory without memberOf
+	delete(directory.Entries[1].Attributes, "memberOf")
+	config := newConfig()
+	config.GroupBaseDn = "ou=groups,dc=example,dc=com"
+	config.GroupRoles = []globals.LdapGroupRole{{GroupDn: auditorsDn, RoleName: "auditors"}}
+
+	a := newAuthenticator(t, config, directory)
+	entry, err := a.Authenticate("alice", alicePassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entry.Groups) != 2 {
+		t.Errorf("the group search found %v, not operators and auditors", entry.Groups)
+	}
+	if role, ok := a.RoleName(entry); !ok || role != "auditors" {
+		t.Errorf("alice maps to the role '%s', not auditors", role)
+	}
+}
+
+func TestRoleName(t *testing.T) {
+	entry := ldapauth.Entry{UserName: "alice", Groups: []string{auditorsDn, "CN=Operators, OU=Groups, DC=example, DC=com"}}
+	cases := []struct {
+		name        string
+		groupRoles  []globals.LdapGroupRole
+		defaultRole string
+		want        string
+		ok          bool
+	}{
+		{"first mapping wins", []globals.LdapGroupRole{{GroupDn: operatorsDn, RoleName: "operators"}, {GroupDn: auditorsDn, RoleName: "auditors"}}, "", "operators", true},
+		{"differently spaced DN", []globals.LdapGroupRole{{GroupDn: "cn=operators, ou=groups, dc=example, dc=com", RoleName: "operators"}}, "", "operators", true},
+		{"default role", []globals.LdapGroupRole{{GroupDn: "cn=other,ou=groups,dc=example,dc=com", RoleName: "other"}}, "readers", "readers", true},
+		{"no mapped group", []globals.LdapGroupRole{{GroupDn: "cn=other,ou=groups,dc=example,dc=com", RoleName: "other"}}, "", "", false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			config := newConfig()
+			config.GroupRoles = tc.groupRoles
+			config.DefaultRole = tc.defaultRole
+
+			role, ok := newAuthenticator(t, config, newDirectory()).RoleName(entry)
+			if role != tc.want || ok != tc.ok {
+				t.Errorf("mapped to ('%s', %t), not ('%s', %t)", role, ok, tc.want, tc.ok)
+			}
+		})
+	}
+}
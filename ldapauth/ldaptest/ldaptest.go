@@ -0,0 +1,121 @@
+// Package ldaptest is an in-memory directory standing in for an LDAP server
+// in tests of ldapauth and its callers
+package ldaptest
+
+/*
+
+  Copyright 2024, YggdrasilSoft, LLC.
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// Entry is a directory object. Entries with a password can bind
+type Entry struct {
+	Dn         string
+	Password   string
+	Attributes map[string][]string
+}
+
+// Directory is a set of entries, searched and bound against by the clients it hands out
+type Directory struct {
+	Entries []Entry
+	// returned by Dial instead of a client, as for a server that is down
+	Unreachable error
+	// number of binds the clients have attempted, service account included
+	Binds int
+}
+
+// equalityTerm matches the (attribute=value) parts of a search filter
+var equalityTerm = regexp.MustCompile(`\(([^()=&|!]+)=([^()]*)\)`)
+
+// Dial Returns a client of the directory, the dialer for ldapauth.NewWithDialer
+func (d *Directory) Dial() (ldap.Client, error) {
+	if d.Unreachable != nil {
+		return nil, d.Unreachable
+	}
+	return &client{directory: d}, nil
+}
+
+// client implements the parts of ldap.Client ldapauth uses. Calling any other
+// method panics on the nil embedded interface
+type client struct {
+	ldap.Client
+	directory *Directory
+}
+
+func (c *client) Close() error {
+	return nil
+}
+
+func (c *client) UnauthenticatedBind(username string) error {
+	c.directory.Binds++
+	return nil
+}
+
+func (c *client) Bind(username, password string) error {
+	c.directory.Binds++
+	for _, entry := range c.directory.Entries {
+		if strings.EqualFold(entry.Dn, username) && entry.Password != "" && entry.Password == password {
+			return nil
+		}
+	}
+	return ldap.NewError(ldap.LDAPResultInvalidCredentials, errors.New("invalid credentials"))
+}
+
+// Search Returns the entries under the base DN having every attribute value
+// the filter tests for equality. Other filter operators are ignored
+func (c *client) Search(request *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	terms := equalityTerm.FindAllStringSubmatch(request.Filter, -1)
+	result := &ldap.SearchResult{}
+	for _, entry := range c.directory.Entries {
+		if !strings.HasSuffix(strings.ToLower(entry.Dn), strings.ToLower(request.BaseDN)) {
+			continue
+		}
+		if matchesAll(entry, terms) {
+			result.Entries = append(result.Entries, ldap.NewEntry(entry.Dn, entry.Attributes))
+		}
+	}
+	if request.SizeLimit > 0 && len(result.Entries) > request.SizeLimit {
+		return nil, ldap.NewError(ldap.LDAPResultSizeLimitExceeded, errors.New("size limit exceeded"))
+	}
+
+	return result, nil
+}
+
+func matchesAll(entry Entry, terms [][]string) bool {
+	for _, term := range terms {
+		found := false
+		for name, values := range entry.Attributes {
+			if !strings.EqualFold(name, term[1]) {
+				continue
+			}
+			for _, value := range values {
+				found = found || strings.EqualFold(ldap.EscapeFilter(value), term[2])
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
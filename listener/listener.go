@@ -0,0 +1,189 @@
+// Package listener opens the sockets the daemon serves on: TCP addresses,
+// Unix sockets and sockets passed in by systemd
+package listener
+
+/*
+
+  Copyright 2024, YggdrasilSoft, LLC.
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+import (
+	"errors"
+	"io/fs"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	unixPrefix    = "unix:"
+	systemdPrefix = "systemd:"
+	// systemd passes sockets starting at this descriptor
+	systemdFirstFd = 3
+)
+
+// Listen Opens a listener for an address, one of:
+//
+//	host:port or :port    a TCP address
+//	unix:/path/to/socket  a Unix socket, created with the given file mode
+//	systemd:name          a socket passed in by systemd socket activation,
+//	                      by its FileDescriptorName= or its position from 0
+func Listen(address string, socketMode fs.FileMode) (net.Listener, error) {
+	switch {
+	case strings.HasPrefix(address, unixPrefix):
+		return listenUnix(strings.TrimPrefix(address, unixPrefix), socketMode)
+	case strings.HasPrefix(address, systemdPrefix):
+		return listenSystemd(strings.TrimPrefix(address, systemdPrefix))
+	default:
+		return net.Listen("tcp", address)
+	}
+}
+
+// Port Returns the TCP port of an address, or 0 if it is not a TCP address
+// or has no port
+func Port(address string) int {
+	if strings.HasPrefix(address, unixPrefix) || strings.HasPrefix(address, systemdPrefix) {
+		return 0
+	}
+	_, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return 0
+	}
+	n, _ := strconv.Atoi(port)
+
+	return n
+}
+
+func listenUnix(path string, socketMode fs.FileMode) (net.Listener, error) {
+	if path == "" {
+		return nil, errors.New("unix socket address has no path")
+	}
+	// a socket left behind by an unclean exit would make the bind fail
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode().Type() != fs.ModeSocket {
+			return nil, errors.New("'" + path + "' exists and is not a socket")
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if socketMode != 0 {
+		if err := os.Chmod(path, socketMode); err != nil {
+			l.Close()
+			return nil, err
+		}
+	}
+
+	return l, nil
+}
+
+var (
+	systemdOnce sync.Once
+	systemdFds  []*os.File
+	systemdErr  error
+)
+
+// systemdFiles Returns the sockets systemd passed in, read from the
+// environment once as they can only be claimed once
+func systemdFiles() ([]*os.File, error) {
+	systemdOnce.Do(func() {
+		pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+		if err != nil || pid != os.Getpid() {
+			systemdErr = errors.New("no sockets were passed in by systemd")
+			return
+		}
+		count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+		if err != nil || count <= 0 {
+			systemdErr = errors.New("no sockets were passed in by systemd")
+			return
+		}
+		names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+		for i := 0; i < count; i++ {
+			name := strconv.Itoa(i)
+			if i < len(names) && names[i] != "" {
+				name = names[i]
+			}
+			systemdFds = append(systemdFds, os.NewFile(uintptr(systemdFirstFd+i), name))
+		}
+		// keep the sockets from being passed on to child processes
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	})
+
+	return systemdFds, systemdErr
+}
+
+func listenSystemd(name string) (net.Listener, error) {
+	files, err := systemdFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	for i, file := range files {
+		if file.Name() == name || strconv.Itoa(i) == name {
+			l, err := net.FileListener(file)
+			if err != nil {
+				return nil, errors.New("systemd socket '" + name + "' cannot be listened on: " + string(err.Error()))
+			}
+			return l, nil
+		}
+	}
+
+	return nil, errors.New("systemd passed in no socket named '" + name + "'")
+}
+
+// ParseMode Parses an octal file mode such as 0660, returning 0 for an empty
+// string
+func ParseMode(mode string) (fs.FileMode, error) {
+	if mode == "" {
+		return 0, nil
+	}
+	n, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil || n > 0777 {
+		return 0, errors.New("'" + mode + "' is not an octal file mode")
+	}
+
+	return fs.FileMode(n), nil
+}
+
+// CheckAddress Returns an error if an address is not in one of the forms
+// Listen accepts
+func CheckAddress(address string) error {
+	switch {
+	case strings.HasPrefix(address, unixPrefix):
+		if strings.TrimPrefix(address, unixPrefix) == "" {
+			return errors.New("unix socket address has no path")
+		}
+	case strings.HasPrefix(address, systemdPrefix):
+		if strings.TrimPrefix(address, systemdPrefix) == "" {
+			return errors.New("systemd socket address has no name")
+		}
+	default:
+		if _, _, err := net.SplitHostPort(address); err != nil {
+			return errors.New("'" + address + "' is not host:port, unix:/path or systemd:name")
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,144 @@
+package listener_test
+
+/*
+
+  Copyright 2024, YggdrasilSoft, LLC.
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+import (
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/greeneg/update-reporterd/listener"
+)
+
+func TestListenTcp(t *testing.T) {
+	l, err := listener.Listen("127.0.0.1:0", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if port := listener.Port(l.Addr().String()); port == 0 {
+		t.Errorf("no port in %s", l.Addr())
+	}
+}
+
+func TestListenUnix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "daemon.sock")
+
+	l, err := listener.Listen("unix:"+path, 0660)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0660 {
+		t.Errorf("socket has mode %v, expected 0660", info.Mode().Perm())
+	}
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	// a socket left behind by an unclean exit is replaced
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	l.Close()
+	l, err = listener.Listen("unix:"+path, 0)
+	if err != nil {
+		t.Fatalf("a stale socket was not replaced: %v", err)
+	}
+	l.Close()
+}
+
+func TestListenUnixKeepsOtherFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "not-a-socket")
+	if err := os.WriteFile(path, []byte("data"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if l, err := listener.Listen("unix:"+path, 0); err == nil {
+		l.Close()
+		t.Fatal("listened on a path holding a regular file")
+	}
+	content, err := os.ReadFile(path)
+	if err != nil || string(content) != "data" {
+		t.Errorf("the file was changed: %q, %v", content, err)
+	}
+}
+
+func TestListenSystemdWithoutSockets(t *testing.T) {
+	if l, err := listener.Listen("systemd:http", 0); err == nil {
+		l.Close()
+		t.Error("listened on a systemd socket that was not passed in")
+	}
+}
+
+func TestPort(t *testing.T) {
+	cases := map[string]int{
+		":8080":             8080,
+		"127.0.0.1:8443":    8443,
+		"[::1]:80":          80,
+		"localhost":         0,
+		"unix:/run/a.sock":  0,
+		"systemd:http":      0,
+		"example.com:https": 0,
+	}
+	for address, expected := range cases {
+		if port := listener.Port(address); port != expected {
+			t.Errorf("port of %s is %d, expected %d", address, port, expected)
+		}
+	}
+}
+
+func TestParseMode(t *testing.T) {
+	valid := map[string]fs.FileMode{"": 0, "0660": 0660, "600": 0600, "0777": 0777}
+	for mode, expected := range valid {
+		parsed, err := listener.ParseMode(mode)
+		if err != nil || parsed != expected {
+			t.Errorf("mode %q parsed as %v, %v", mode, parsed, err)
+		}
+	}
+	for _, mode := range []string{"0800", "rw-rw----", "01000", "-1"} {
+		if _, err := listener.ParseMode(mode); err == nil {
+			t.Errorf("mode %q was accepted", mode)
+		}
+	}
+}
+
+func TestCheckAddress(t *testing.T) {
+	for _, address := range []string{":8080", "0.0.0.0:80", "[::]:443", "unix:/run/update-reporterd.sock", "systemd:https"} {
+		if err := listener.CheckAddress(address); err != nil {
+			t.Errorf("%s was refused: %v", address, err)
+		}
+	}
+	for _, address := range []string{"8080", "unix:", "systemd:", "localhost"} {
+		if err := listener.CheckAddress(address); err == nil {
+			t.Errorf("%s was accepted", address)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+// Package logging sets up the structured log of the daemon and its tools, and
+// carries the ID of the request being served into it
+package logging
+
+/*
+
+  Copyright 2024, YggdrasilSoft, LLC.
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/greeneg/update-reporterd/globals"
+)
+
+// environment variables setting the log level and format of the tools, the
+// same ones that override the logging config of the daemon
+const (
+	LevelVariable  = "UPDATE_REPORTER_LOGGING_LEVEL"
+	FormatVariable = "UPDATE_REPORTER_LOGGING_FORMAT"
+)
+
+// RequestIdHeader carries the ID of a request to and from the daemon
+const RequestIdHeader = "X-Request-ID"
+
+type requestIdKey struct{}
+
+// WithRequestId Returns a context carrying a request ID, which is added to
+// everything logged with it
+func WithRequestId(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIdKey{}, id)
+}
+
+// RequestId Returns the request ID ctx carries, if any
+func RequestId(ctx context.Context) string {
+	id, _ := ctx.Value(requestIdKey{}).(string)
+	return id
+}
+
+// contextHandler adds the request ID of the context to each record
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := RequestId(ctx); id != "" {
+		r.AddAttrs(slog.String("requestId", id))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// ParseLevel Returns the level named debug, info, warn or error, info if
+// the name is empty
+func ParseLevel(name string) (slog.Level, error) {
+	var level slog.Level
+	if name == "" {
+		return slog.LevelInfo, nil
+	}
+	if err := level.UnmarshalText([]byte(name)); err != nil {
+		return level, errors.New("unknown log level '" + name + "'")
+	}
+
+	return level, nil
+}
+
+// NewHandler Returns a handler writing records of the configured level and
+// above to w, as text or as JSON
+func NewHandler(w io.Writer, config globals.LoggingConfig) (slog.Handler, error) {
+	level, err := ParseLevel(config.Level)
+	if err != nil {
+		return nil, err
+	}
+	options := &slog.HandlerOptions{Level: level}
+
+	switch strings.ToLower(config.Format) {
+	case "", globals.LogFormatText:
+		return contextHandler{slog.NewTextHandler(w, options)}, nil
+	case globals.LogFormatJson:
+		return contextHandler{slog.NewJSONHandler(w, options)}, nil
+	default:
+		return nil, errors.New("unknown log format '" + config.Format + "'")
+	}
+}
+
+// Configure Makes the configured handler, writing to stderr, the default
+// logger. Whatever is still written with the log package goes through it too,
+// at info level
+func Configure(config globals.LoggingConfig) error {
+	handler, err := NewHandler(os.Stderr, config)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(slog.New(handler))
+
+	return nil
+}
+
+// ConfigFromEnv Returns the logging config set in the environment, for tools
+// that have no config file
+func ConfigFromEnv() globals.LoggingConfig {
+	return globals.LoggingConfig{
+		Level:  os.Getenv(LevelVariable),
+		Format: os.Getenv(FormatVariable),
+	}
+}
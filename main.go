@@ -24,28 +24,42 @@ package main
 */
 
 import (
-	"database/sql"
-	"encoding/json"
-	"errors"
-	"log"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
 	"os"
+	"os/signal"
 	"path/filepath"
-	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/gin-contrib/sessions"
-	"github.com/gin-contrib/sessions/cookie"
 	"github.com/gin-gonic/gin"
+	"github.com/pborman/getopt/v2"
 
 	swaggerfiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
 
+	"github.com/greeneg/update-reporterd/apierror"
+	"github.com/greeneg/update-reporterd/configfile"
 	"github.com/greeneg/update-reporterd/controllers"
 	_ "github.com/greeneg/update-reporterd/docs"
 	"github.com/greeneg/update-reporterd/globals"
 	"github.com/greeneg/update-reporterd/helpers"
+	"github.com/greeneg/update-reporterd/ldapauth"
+	"github.com/greeneg/update-reporterd/logging"
 	"github.com/greeneg/update-reporterd/middleware"
+	"github.com/greeneg/update-reporterd/migrations"
 	"github.com/greeneg/update-reporterd/model"
+	"github.com/greeneg/update-reporterd/oidcauth"
+	"github.com/greeneg/update-reporterd/ratelimit"
+	"github.com/greeneg/update-reporterd/retention"
 	"github.com/greeneg/update-reporterd/routes"
+	"github.com/greeneg/update-reporterd/sessionstore"
+	"github.com/greeneg/update-reporterd/tlscert"
 )
 
 //	@title		Update Reporter Daemon
@@ -65,132 +79,183 @@ import (
 
 //	@schemas	http https
 
-func createDB(dbName string) (bool, error) {
-	log.Println("INFO: DB doesn't exist. Attempt to create it")
-	const schema string = `CREATE TABLE IF NOT EXISTS Architectures (
-		Id                      INTEGER		PRIMARY KEY AUTOINCREMENT	UNIQUE	NOT NULL,
-		ArchName                STRING		UNIQUE				NOT NULL,
-		CreationDate            DATETIME	NOT NULL			DEFAULT (CURRENT_TIMESTAMP)
-	);
-
-	INSERT INTO Architectures (Id, ArchName) VALUES (1, 'noarch');
-	INSERT INTO Architectures (Id, ArchName) VALUES (2, 'aarch64');
-	INSERT INTO Architectures (Id, ArchName) VALUES (3, 'x86');
-	INSERT INTO Architectures (Id, ArchName) VALUES (4, 'x86_64');
-
-	CREATE TABLE IF NOT EXISTS OperatingSystems (
-		Id                      INTEGER		PRIMARY KEY AUTOINCREMENT	UNIQUE	NOT NULL,
-		OsIdName                STRING		NOT NULL,
-		OsVersion               STRING		NOT NULL,
-		CreationDate            DATETIME	NOT NULL			DEFAULT (CURRENT_TIMESTAMP)
-	);
-
-	CREATE TABLE IF NOT EXISTS OsFamilies (
-		Id                      INTEGER		PRIMARY KEY AUTOINCREMENT	UNIQUE	NOT NULL,
-		FamilyName              STRING		UNIQUE				NOT NULL,
-		CreationDate            DATETIME	NOT NULL			DEFAULT (CURRENT_TIMESTAMP)
-	);
-
-	INSERT INTO OsFamilies (Id, FamilyName) VALUES (1, 'linux');
-	INSERT INTO OsFamilies (Id, FamilyName) VALUES (2, 'darwin');
-	INSERT INTO OsFamilies (Id, FamilyName) VALUES (3, 'windows');
-
-	CREATE TABLE IF NOT EXISTS Roles (
-		Id                      INTEGER		PRIMARY KEY AUTOINCREMENT	UNIQUE	NOT NULL,
-		RoleName                STRING		UNIQUE				NOT NULL,
-		Description             STRING		NOT NULL,
-		CreationDate            DATETIME	NOT NULL		 	DEFAULT (CURRENT_TIMESTAMP)
-	);
-
-	INSERT INTO Roles (Id, RoleName, Description)
-		VALUES (1, 'SYSTEM', 'Built-in system role');
-	INSERT INTO Roles (Id, RoleName, Description)
-		VALUES (2, 'administrators', 'Accounts that have full administrative rights to the system');
-
-	CREATE TABLE IF NOT EXISTS Systems (
-		Id                      INTEGER		PRIMARY KEY AUTOINCREMENT		UNIQUE	NOT NULL,
-		FQDN                    STRING		UNIQUE					NOT NULL,
-		OsFamilyId              INTEGER		REFERENCES OsFamilies (Id)		NOT NULL,
-		OsId                    INTEGER		REFERENCES OperatingSystems (Id)	NOT NULL,
-		ArchId                  INTEGER		REFERENCES Architectures (Id)		NOT NULL,
-		CreationDate            DATETIME	NOT NULL				DEFAULT (CURRENT_TIMESTAMP)
-	);
-
-	CREATE TABLE IF NOT EXISTS UpdateRecords (
-		Id                      INTEGER		PRIMARY KEY AUTOINCREMENT		UNIQUE	NOT NULL,
-		SystemId                INTEGER		REFERENCES Systems (Id)			UNIQUE	NOT NULL,
-		UpdateCount		INTEGER		NOT NULL,
-		UpdateRecord            JSON		NOT NULL,
-		CreationDate            INTEGER		NOT NULL				DEFAULT (CURRENT_TIMESTAMP)
-		LastUpdateDate          DATETIME	NOT NULL				DEFAULT (CURRENT_TIMESTAMP)
-	);
-
-	CREATE TABLE IF NOT EXISTS Users (
-		Id                      INTEGER 	PRIMARY KEY AUTOINCREMENT	UNIQUE	NOT NULL,
-		UserName                STRING		NOT NULL			UNIQUE,
-		FullName                STRING		NOT NULL,
-		Status                  STRING		NOT NULL			DEFAULT enabled,
-		RoleId                  INTEGER		REFERENCES Roles (Id)		NOT NULL,
-		PasswordHash            STRING		NOT NULL,
-		CreationDate            DATETIME	NOT NULL			DEFAULT (CURRENT_TIMESTAMP),
-		LastPasswordChangedDate DATETIME	NOT NULL			DEFAULT (CURRENT_TIMESTAMP)
-	);
-
-	INSERT INTO Users (Id, UserName, FullName, Status, RoleId, PasswordHash)
-		VALUES (1, 'SYSTEM', 'Built-in System User', 'enabled', 1, '!');
-	`
-
-	db, err := sql.Open("sqlite3", dbName)
-	if err != nil {
+var (
+	optConfig      = getopt.StringLong("config", 'c', "", "The config file to use")
+	optCheckConfig = getopt.BoolLong("check-config", 0, "Check the config and exit")
+	optHelp        = getopt.BoolLong("help", 'h', "This help message")
+)
+
+// migrateDatabase Brings the database schema up to date, or refuses to start
+// if it is out of date and automatic migration is disabled
+func migrateDatabase(config globals.Config) {
+	if config.DisableAutoMigrate {
+		pending, err := migrations.Pending(context.Background(), model.DB)
 		helpers.FatalCheckError(err)
+		if len(pending) > 0 {
+			target := "-d " + config.DbPath
+			if model.DB.Dialect == model.DialectPostgres {
+				target = "-u <database url>"
+			}
+			slog.Error("Database schema has pending migrations. Run 'setuptool "+target+" migrate' first",
+				"pending", len(pending))
+			os.Exit(1)
+		}
+		return
 	}
-	if _, err := db.Exec(schema); err != nil {
-		helpers.FatalCheckError(err)
+
+	applied, err := migrations.Up(context.Background(), model.DB, false)
+	helpers.FatalCheckError(err)
+	if len(applied) > 0 {
+		slog.Info("Applied database migrations", "count", len(applied))
+	}
+}
+
+// checkConfig Reports whether the config is valid and exits, for --check-config
+func checkConfig(configPath string, err error) {
+	name := "Configuration"
+	if configPath != "" {
+		name += " " + configPath
+	} else if err == nil {
+		name += " from the environment"
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, name+" is not valid: "+string(err.Error()))
+		os.Exit(1)
 	}
-	return true, err
+	fmt.Println(name + " is valid")
+	os.Exit(0)
+}
+
+// reloadOnHangup Reloads the config whenever the process receives SIGHUP
+func reloadOnHangup(app *controllers.UpdateReporter) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	go func() {
+		for range hangup {
+			slog.Info("Received SIGHUP, reloading the configuration")
+			app.ReloadConfig(context.Background())
+		}
+	}()
+}
+
+func showHelp() {
+	println("update-reporterd - Update Reporter Daemon")
+	println("USAGE: update-reporterd [OPTIONS]\n")
+	println("OPTIONS:")
+	println("   -c|--config FILENAME_PATH              OPTIONAL: The JSON or YAML config")
+	println("                                          file. Without it, " + configfile.EnvPathVariable)
+	println("                                          is used, then the first config.json,")
+	println("                                          config.yaml or config.yml found in:")
+	for _, dir := range configfile.SearchPaths("<binary directory>") {
+		println("                                            " + dir)
+	}
+	println("   --check-config                         OPTIONAL: Check the config and exit")
+	println("   -h|--help                              This help message")
+	println("")
+	println("Every config value can be overridden with an environment variable named")
+	println("after its key, e.g. session.maxAge with " + configfile.Variable("session.maxAge"))
 }
 
 func main() {
-	r := gin.Default()
-	r.SetTrustedProxies(nil)
+	getopt.Parse()
+	if *optHelp {
+		showHelp()
+		os.Exit(0)
+	}
 
 	// lets get our working directory
 	appdir, err := filepath.Abs(filepath.Dir(os.Args[0]))
 	helpers.FatalCheckError(err)
 
-	// config path is derived from app working directory
-	configDir := filepath.Join(appdir, "config")
-
-	// now that we have our appdir and configDir, lets read in our app config
-	// and marshall it to the Config struct
-	config := globals.Config{}
-	jsonContent, err := os.ReadFile(filepath.Join(configDir, "config.json"))
-	helpers.FatalCheckError(err)
-	err = json.Unmarshal(jsonContent, &config)
+	// the config file is the one given, or the first found in the search paths
+	var config globals.Config
+	configPath, err := configfile.Find(*optConfig, appdir)
+	if err == nil {
+		config, err = configfile.Load(configPath)
+	}
+	if *optCheckConfig {
+		checkConfig(configPath, err)
+	}
 	helpers.FatalCheckError(err)
+	// from here on the log takes its level and format from the config
+	helpers.FatalCheckError(logging.Configure(config.Logging))
+	if configPath != "" {
+		slog.Info("Using config file", "path", configPath)
+	} else {
+		slog.Info("No config file found, using the environment only")
+	}
+
+	// gin's own messages, such as the routes it registers, are debug output
+	gin.DebugPrintFunc = func(format string, values ...any) {
+		slog.Debug("gin: " + strings.TrimSpace(fmt.Sprintf(format, values...)))
+	}
+	r := gin.New()
+	r.Use(middleware.RequestLogger(), gin.CustomRecoveryWithWriter(io.Discard, middleware.LogPanic))
+	r.NoRoute(apierror.NoRoute)
+	// client addresses, used for lockout and rate limits, are only taken
+	// from forwarding headers set by a trusted proxy
+	helpers.FatalCheckError(r.SetTrustedProxies(config.Listen.TrustedProxies))
 
 	// create an app object that contains our routes and the configuration
 	UpdateReporter := new(controllers.UpdateReporter)
 	UpdateReporter.AppPath = appdir
-	UpdateReporter.ConfigPath = configDir
+	UpdateReporter.ConfigPath = configPath
 	UpdateReporter.ConfStruct = config
 
-	if _, err := os.Stat(UpdateReporter.ConfStruct.DbPath); errors.Is(err, os.ErrNotExist) {
-		_, err := createDB(UpdateReporter.ConfStruct.DbPath)
-		if err != nil {
-			helpers.FatalCheckError(err)
-		}
+	err = model.ConnectDatabase(UpdateReporter.ConfStruct)
+	helpers.FatalCheckError(err)
+	migrateDatabase(UpdateReporter.ConfStruct)
+	model.SetPasswordPolicy(UpdateReporter.ConfStruct.PasswordPolicy)
+	helpers.SetLockoutPolicy(UpdateReporter.ConfStruct.Lockout)
+	ratelimit.Configure(UpdateReporter.ConfStruct.RateLimit)
+	if UpdateReporter.ConfStruct.Ldap.Enabled {
+		ldapAuthenticator, err := ldapauth.New(UpdateReporter.ConfStruct.Ldap)
+		helpers.FatalCheckError(err)
+		helpers.SetLdapAuthenticator(ldapAuthenticator)
+		slog.Info("LDAP authentication enabled", "url", UpdateReporter.ConfStruct.Ldap.Url)
+	}
+	if UpdateReporter.ConfStruct.Oidc.Enabled {
+		oidcProvider, err := oidcauth.New(context.Background(), UpdateReporter.ConfStruct.Oidc)
+		helpers.FatalCheckError(err)
+		helpers.SetOidcProvider(oidcProvider)
+		slog.Info("Single sign-on enabled", "issuer", UpdateReporter.ConfStruct.Oidc.Issuer)
 	}
 
-	err = model.ConnectDatabase(UpdateReporter.ConfStruct.DbPath)
-	helpers.FatalCheckError(err)
+	// background jobs stop when quit is closed, and are waited for before
+	// the database is closed
+	quit := make(chan struct{})
+	var background sync.WaitGroup
+
+	// started even when disabled, so a reload can enable it
+	UpdateReporter.Retention = retention.New(UpdateReporter.ConfStruct.Retention)
+	background.Add(1)
+	go func() {
+		defer background.Done()
+		UpdateReporter.Retention.Start(quit)
+	}()
+
+	// clients are forgotten once their limits have refilled
+	background.Add(1)
+	go func() {
+		defer background.Done()
+		ratelimit.Cleanup(time.Minute, quit)
+	}()
 
 	// set up our static assets
 	// r.Static("/assets", "./assets")
 	// r.LoadHTMLGlob("templates/*.html")
 
 	// some defaults for using session support
-	r.Use(sessions.Sessions("session", cookie.NewStore(globals.Secret)))
+	sessionStore, err := sessionstore.New(UpdateReporter.ConfStruct.Session)
+	helpers.FatalCheckError(err)
+	if dbStore, ok := sessionStore.(*sessionstore.DatabaseStore); ok {
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			dbStore.Cleanup(time.Hour, quit)
+		}()
+	}
+	r.Use(sessions.Sessions(globals.SessionName, sessionStore))
 	// frontend
 	// fePublic := r.Group("/")
 	// routes.FePublicRoutes(fePublic, AllocatorD)
@@ -210,13 +275,30 @@ func main() {
 	// swagger doc
 	r.GET("/api/swagger/*any", ginSwagger.WrapHandler(swaggerfiles.Handler))
 
-	tcpPort := strconv.Itoa(UpdateReporter.ConfStruct.TcpPort)
-	tlsTcpPort := strconv.Itoa(UpdateReporter.ConfStruct.TLSTcpPort)
-	tlsPemFile := UpdateReporter.ConfStruct.TLSPemFile
-	tlsKeyFile := UpdateReporter.ConfStruct.TLSKeyFile
 	if UpdateReporter.ConfStruct.UseTLS {
-		r.RunTLS(":"+tlsTcpPort, tlsPemFile, tlsKeyFile)
-	} else {
-		r.Run(":" + tcpPort)
+		// served from a store, so a reload can replace the certificate
+		UpdateReporter.Certificates, err = tlscert.Load(UpdateReporter.ConfStruct)
+		helpers.FatalCheckError(err)
+		// renewed certificates are picked up without a reload
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			UpdateReporter.Certificates.Watch(quit)
+		}()
+	}
+	servers, err := openServers(UpdateReporter.ConfStruct, r.Handler(), UpdateReporter.Certificates)
+	helpers.FatalCheckError(err)
+	reloadOnHangup(UpdateReporter)
+
+	err = serve(servers, seconds(UpdateReporter.ConfStruct.Listen.ShutdownTimeoutSeconds, globals.DefaultShutdownTimeoutSeconds))
+
+	close(quit)
+	background.Wait()
+	if closeErr := model.DB.Close(); closeErr != nil {
+		slog.Error("Could not close the database", "error", closeErr)
+	}
+	if err != nil {
+		os.Exit(1)
 	}
+	slog.Info("Shut down cleanly")
 }
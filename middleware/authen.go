@@ -19,37 +19,143 @@ package middleware
 */
 
 import (
-	"encoding/base64"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"strings"
 
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
 
+	"github.com/greeneg/update-reporterd/apierror"
 	"github.com/greeneg/update-reporterd/globals"
 	"github.com/greeneg/update-reporterd/helpers"
 	"github.com/greeneg/update-reporterd/model"
+	"github.com/greeneg/update-reporterd/ratelimit"
+	"github.com/greeneg/update-reporterd/sessionstore"
 )
 
-func processAuthorizationHeader(authHeader string) (string, string) {
-	// split the header value at the space
-	encodedString := strings.Split(authHeader, " ")
+// the only routes a user with an expired password may use
+const (
+	passwordChangeRoute    = "/user/name/:name"
+	ownPasswordChangeRoute = "/me/password"
+)
 
-	// remove base64 encoding
-	decodedString, _ := base64.StdEncoding.DecodeString(encodedString[1])
+// checkPasswordExpiry Aborts the request if the user's password has expired,
+// unless the request is the user changing it
+func checkPasswordExpiry(c *gin.Context, user model.User) bool {
+	if !model.IsPasswordExpired(user) {
+		return true
+	}
+	if c.Request.Method == http.MethodPatch && strings.HasSuffix(c.FullPath(), passwordChangeRoute) &&
+		c.Param("name") == user.UserName {
+		return true
+	}
+	if c.Request.Method == http.MethodPut && strings.HasSuffix(c.FullPath(), ownPasswordChangeRoute) {
+		return true
+	}
 
-	// now lets return both the
-	authValues := strings.Split(string(decodedString), ":")
+	slog.WarnContext(c.Request.Context(), "Password has expired", "user", user.UserName)
+	apierror.RespondCode(c, http.StatusForbidden, apierror.CodePasswordExpired, "Password has expired and must be changed")
+	c.Abort()
+	return false
+}
 
-	return authValues[0], authValues[1]
+// checkTwoFactorEnrollment Aborts the request if the user's role requires
+// two-factor authentication and they have not enrolled yet, unless the request
+// is the user looking at their profile or enrolling
+func checkTwoFactorEnrollment(c *gin.Context, user model.User) bool {
+	required, err := helpers.CheckTwoFactorRequired(user)
+	if err != nil {
+		apierror.Error(c, err)
+		c.Abort()
+		return false
+	}
+	if !required {
+		return true
+	}
+	enrolled, err := helpers.CheckTwoFactorEnrolled(user)
+	if err != nil {
+		apierror.Error(c, err)
+		c.Abort()
+		return false
+	}
+	if enrolled {
+		return true
+	}
+	route := c.FullPath()
+	if strings.HasSuffix(route, "/me") || strings.Contains(route, "/me/totp") {
+		return true
+	}
+
+	slog.WarnContext(c.Request.Context(), "User must enrol in two-factor authentication", "user", user.UserName)
+	apierror.RespondCode(c, http.StatusForbidden, apierror.CodeTwoFactorEnrollmentRequired,
+		"Two-factor authentication must be set up before using this account")
+	c.Abort()
+	return false
+}
+
+// apiTokenAuthCheck Authenticates a request by a personal API token, limiting
+// it to the token's scopes. No session is started
+func apiTokenAuthCheck(c *gin.Context, token string) {
+	user, scopes, ok := helpers.AuthenticateApiToken(token, c.ClientIP())
+	if !ok {
+		slog.ErrorContext(c.Request.Context(), "API token authentication failed. Aborting")
+		apierror.Respond(c, http.StatusUnauthorized, "not authorized!")
+		c.Abort()
+		return
+	}
+	slog.DebugContext(c.Request.Context(), "Authenticated by API token")
+	if !helpers.ApiTokenScopesAllow(scopes, c.Request.Method) {
+		slog.WarnContext(c.Request.Context(), "API token does not allow the request method", "user", user.UserName, "method", c.Request.Method)
+		apierror.Respond(c, http.StatusForbidden, "API token scopes do not allow this request")
+		c.Abort()
+		return
+	}
+	if !checkPasswordExpiry(c, user) || !checkTwoFactorEnrollment(c, user) {
+		return
+	}
+
+	c.Set(globals.UserKey, user.UserName)
+	c.Set(globals.TokenScopesKey, scopes)
+	c.Next()
+}
+
+// bearerAuthCheck Authenticates a request by an access token from the single
+// sign-on issuer. No session is started, the user is only kept in the request.
+// Each request is not a login, so none is recorded
+func bearerAuthCheck(c *gin.Context, token string) {
+	user, ok := helpers.AuthenticateOidcAccessToken(c.Request.Context(), token, c.ClientIP())
+	if !ok {
+		slog.ErrorContext(c.Request.Context(), "Bearer token authentication failed. Aborting")
+		apierror.Respond(c, http.StatusUnauthorized, "not authorized!")
+		c.Abort()
+		return
+	}
+	slog.DebugContext(c.Request.Context(), "Authenticated by bearer token")
+	if !checkTwoFactorEnrollment(c, user) {
+		return
+	}
+
+	c.Set(globals.UserKey, user.UserName)
+	c.Next()
+}
+
+// machineIdentity Returns what a reporting machine is known by for rate
+// limiting: its address. Machine tokens are not verified yet, so keying on
+// one would let an agent dodge its limit by sending a new token each time
+func machineIdentity(c *gin.Context) string {
+	return "address:" + c.ClientIP()
 }
 
 func AuthCheck(c *gin.Context) {
 	var clientFingerprintHeader string = c.GetHeader("X-ASSIMILATOR-TYPE")
 	// check if this is a machine logging in for DB access
 	if clientFingerprintHeader == "MACHINE" {
+		// a runaway agent is throttled before its report is looked at
+		if !ratelimit.CheckMachine(c, machineIdentity(c)) {
+			return
+		}
 		// now grab the token from the headers
 		/*		authToken := c.GetHeader("X-Auth-Token")
 				if authToken != "" {
@@ -68,48 +174,109 @@ func AuthCheck(c *gin.Context) {
 		session := sessions.Default(c)
 		user := session.Get("user")
 		if user == nil {
-			log.Println("INFO: No session found. Attempting to check for authentication headers")
+			slog.DebugContext(c.Request.Context(), "No session found. Attempting to check for authentication headers")
 			baHeader := c.GetHeader("Authorization")
 			if baHeader == "" {
-				log.Println("ERROR: No authentication header found. Aborting")
-				c.IndentedJSON(http.StatusUnauthorized, gin.H{"error": "not authorized!"})
+				slog.ErrorContext(c.Request.Context(), "No authentication header found. Aborting")
+				apierror.Respond(c, http.StatusUnauthorized, "not authorized!")
 				c.Abort()
 				return
 			}
+			// every attempt counts against the client, failed or not
+			if !ratelimit.CheckClient(c) {
+				return
+			}
+			if strings.HasPrefix(baHeader, "Bearer "+helpers.ApiTokenPrefix) {
+				apiTokenAuthCheck(c, strings.TrimPrefix(baHeader, "Bearer "))
+				return
+			}
+			if strings.HasPrefix(baHeader, "Bearer ") {
+				bearerAuthCheck(c, strings.TrimPrefix(baHeader, "Bearer "))
+				return
+			}
 			// otherwise, lets process that header
-			username, password := processAuthorizationHeader(baHeader)
-			authStatus := helpers.CheckUserPass(username, password)
+			username, password, ok := c.Request.BasicAuth()
+			if !ok {
+				slog.ErrorContext(c.Request.Context(), "Malformed authentication header. Aborting")
+				apierror.Respond(c, http.StatusUnauthorized, "not authorized!")
+				c.Abort()
+				return
+			}
+			if !ratelimit.CheckUser(c, username) {
+				return
+			}
+			user, authStatus := helpers.AuthenticateUser(username, password, c.ClientIP())
 			if authStatus {
-				session.Set(globals.UserKey, username)
-				if err := session.Save(); err != nil {
-					c.IndentedJSON(http.StatusInternalServerError,
-						gin.H{"error": "failed to save user session"})
-					// session saving is not fatal, so allow them to proceed
+				// basic auth has no way to carry a second factor
+				enrolled, err := helpers.CheckTwoFactorEnrolled(user)
+				if err != nil {
+					apierror.Error(c, err)
+					c.Abort()
+					return
+				}
+				if enrolled {
+					slog.ErrorContext(c.Request.Context(), "User has two-factor authentication enabled. Aborting", "user", username)
+					apierror.RespondCode(c, http.StatusUnauthorized, apierror.CodeTwoFactorRequired,
+						"Two-factor authentication is enabled for this account; log in with /api/v1/login or use an API token")
+					c.Abort()
+					return
+				}
+				helpers.RecordLoginSuccess(user.UserName, c.ClientIP())
+
+				// session saving is not fatal, so allow them to proceed, but
+				// never under an Id that was handed out before the login
+				if err := sessionstore.Renew(session); err != nil {
+					slog.WarnContext(c.Request.Context(), "Failed to renew user session", "error", err)
+				} else {
+					session.Set(globals.UserKey, user.UserName)
+					if err := session.Save(); err != nil {
+						slog.WarnContext(c.Request.Context(), "Failed to save user session", "error", err)
+					}
+				}
+				slog.DebugContext(c.Request.Context(), "Authenticated")
+				if !checkPasswordExpiry(c, user) || !checkTwoFactorEnrollment(c, user) {
+					return
 				}
-				log.Println("INFO: Authenticated")
 			} else {
-				log.Println("ERROR: Authentication failed. Aborting")
-				c.IndentedJSON(http.StatusUnauthorized, gin.H{"error": "not authorized!"})
+				ratelimit.RecordFailure(username)
+				slog.ErrorContext(c.Request.Context(), "Authentication failed. Aborting")
+				apierror.Respond(c, http.StatusUnauthorized, "not authorized!")
 				c.Abort()
 				return
 			}
 		} else {
 			userString := fmt.Sprintf("%v", user)
-			log.Println("INFO: Session found: User: " + userString)
-			log.Println("INFO: Checking if user is locked or not...")
+			slog.DebugContext(c.Request.Context(), "Session found", "user", userString)
+			slog.DebugContext(c.Request.Context(), "Checking if user is locked or not")
 			user, err := model.GetUserByUserName(userString)
 			if err != nil {
-				log.Println("ERROR: " + string(err.Error()))
-				c.IndentedJSON(http.StatusUnauthorized, gin.H{"error": "unable to authenticate: " + err.Error()})
+				slog.ErrorContext(c.Request.Context(), "Cannot retrieve session user", "error", err)
+				apierror.Error(c, err)
+				c.Abort()
+				return
+			}
+			if user.UserName == "" {
+				// the user was deleted after the session began. A negative
+				// max age removes the session and expires the cookie
+				slog.WarnContext(c.Request.Context(), "Session user no longer exists", "user", userString)
+				session.Clear()
+				session.Options(sessions.Options{Path: "/", MaxAge: -1})
+				if err := session.Save(); err != nil {
+					slog.WarnContext(c.Request.Context(), "Failed to remove user session", "error", err)
+				}
+				apierror.Respond(c, http.StatusUnauthorized, "not authorized!")
 				c.Abort()
 				return
 			}
 			status := helpers.CheckIsNotLocked(user)
 			if status {
-				log.Println("INFO: Authenticated")
+				slog.DebugContext(c.Request.Context(), "Authenticated")
+				if !checkPasswordExpiry(c, user) || !checkTwoFactorEnrollment(c, user) {
+					return
+				}
 			} else {
-				log.Println("WARN: User '" + userString + "' is locked!")
-				c.IndentedJSON(http.StatusUnauthorized, gin.H{"error": "not authorized!"})
+				slog.WarnContext(c.Request.Context(), "User is locked", "user", userString)
+				apierror.Respond(c, http.StatusUnauthorized, "not authorized!")
 				c.Abort()
 				return
 			}
@@ -0,0 +1,215 @@
+package middleware
+
+/*
+
+  Copyright 2024, YggdrasilSoft, LLC.
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+
+	"github.com/greeneg/update-reporterd/globals"
+	"github.com/greeneg/update-reporterd/helpers"
+	"github.com/greeneg/update-reporterd/model"
+	"github.com/greeneg/update-reporterd/model/storetest"
+	"github.com/greeneg/update-reporterd/oidcauth"
+	"github.com/greeneg/update-reporterd/oidcauth/oidctest"
+	"github.com/greeneg/update-reporterd/sessionstore"
+)
+
+const (
+	testUserName = "authen-test"
+	testPassword = "Correct-Horse-42!"
+)
+
+// createTestUser Creates a local user in a role of their own
+func createTestUser(t *testing.T) {
+	if _, err := model.CreateRole(model.Role{RoleName: testUserName + "-role"}); err != nil {
+		t.Fatal(err)
+	}
+	role, err := model.GetRoleByName(testUserName + "-role")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = model.CreateUser(model.ProposedUser{UserName: testUserName, RoleId: role.Id, Password: testPassword})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// authRouter Returns a router whose /login starts a session for the test
+// user and whose /private needs authentication
+func authRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(sessions.Sessions("session", sessionstore.NewDatabaseStore([]byte("0123456789abcdef0123456789abcdef"))))
+	r.POST("/login", func(c *gin.Context) {
+		session := sessions.Default(c)
+		session.Set(globals.UserKey, testUserName)
+		session.Save()
+	})
+	r.GET("/private", AuthCheck, func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	return r
+}
+
+func request(r *gin.Engine, method string, path string, setup func(req *http.Request)) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	if setup != nil {
+		setup(req)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestSessionOfDeletedUser(t *testing.T) {
+	db := storetest.OpenDatabase(t)
+	createTestUser(t)
+	r := authRouter()
+
+	var session *http.Cookie
+	for _, cookie := range request(r, http.MethodPost, "/login", nil).Result().Cookies() {
+		if cookie.Name == "session" {
+			session = cookie
+		}
+	}
+	if session == nil {
+		t.Fatal("no session cookie was set")
+	}
+	withSession := func(req *http.Request) { req.AddCookie(session) }
+	if w := request(r, http.MethodGet, "/private", withSession); w.Code != http.StatusOK {
+		t.Fatalf("the session was answered %d before the user was deleted", w.Code)
+	}
+
+	// removed behind the API's back, so only the middleware can notice
+	if _, err := db.Exec("DELETE FROM Users WHERE UserName = ?", testUserName); err != nil {
+		t.Fatal(err)
+	}
+	if w := request(r, http.MethodGet, "/private", withSession); w.Code != http.StatusUnauthorized {
+		t.Errorf("the session of a deleted user was answered %d, not 401", w.Code)
+	}
+	sessions, err := model.GetSessionsByUserName(testUserName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 0 {
+		t.Errorf("%d sessions of the deleted user are still stored", len(sessions))
+	}
+}
+
+func TestBearerRequestsAreNotLogins(t *testing.T) {
+	storetest.OpenDatabase(t)
+	if _, err := model.CreateRole(model.Role{RoleName: "operators"}); err != nil {
+		t.Fatal(err)
+	}
+	issuer, err := oidctest.NewIssuer("update-reporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(issuer.Close)
+	provider, err := oidcauth.New(context.Background(), globals.OidcConfig{
+		Enabled:     true,
+		Issuer:      issuer.URL(),
+		ClientId:    "update-reporter",
+		RedirectUrl: "https://reporter.example.com/api/v1/oidc/callback",
+		Audience:    "update-reporter-api",
+		DefaultRole: "operators",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	helpers.SetOidcProvider(provider)
+	t.Cleanup(func() { helpers.SetOidcProvider(nil) })
+
+	token, err := issuer.Token(map[string]any{"sub": "f47ac10b", "preferred_username": "erin", "aud": "update-reporter-api"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := authRouter()
+	for i := 0; i < 3; i++ {
+		w := request(r, http.MethodGet, "/private", func(req *http.Request) { req.Header.Set("Authorization", "Bearer "+token) })
+		if w.Code != http.StatusOK {
+			t.Fatalf("bearer request %d was answered %d", i+1, w.Code)
+		}
+	}
+
+	events, err := model.GetLoginEvents("erin", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 0 {
+		t.Errorf("bearer requests recorded %d logins", len(events))
+	}
+}
+
+func TestMalformedBasicAuthentication(t *testing.T) {
+	storetest.OpenDatabase(t)
+	r := authRouter()
+
+	headers := map[string]string{
+		"no credentials":     "Basic",
+		"empty credentials":  "Basic ",
+		"not base64":         "Basic !!!",
+		"no colon":           "Basic " + base64.StdEncoding.EncodeToString([]byte(testUserName)),
+		"other scheme":       "Digest username=" + testUserName,
+		"bearer without one": "Bearer",
+	}
+	for name, header := range headers {
+		t.Run(name, func(t *testing.T) {
+			w := request(r, http.MethodGet, "/private", func(req *http.Request) {
+				req.RemoteAddr = "192.0.2.30:1234"
+				req.Header.Set("Authorization", header)
+			})
+			if w.Code != http.StatusUnauthorized {
+				t.Errorf("%q was answered %d, not 401", header, w.Code)
+			}
+		})
+	}
+}
+
+func TestBasicAuthenticationPasswordWithColon(t *testing.T) {
+	storetest.OpenDatabase(t)
+	if _, err := model.CreateRole(model.Role{RoleName: "colon-role"}); err != nil {
+		t.Fatal(err)
+	}
+	role, err := model.GetRoleByName("colon-role")
+	if err != nil {
+		t.Fatal(err)
+	}
+	password := "Correct:Horse:42!"
+	if _, err := model.CreateUser(model.ProposedUser{UserName: "colon", RoleId: role.Id, Password: password}); err != nil {
+		t.Fatal(err)
+	}
+	r := authRouter()
+
+	w := request(r, http.MethodGet, "/private", func(req *http.Request) {
+		req.RemoteAddr = "192.0.2.31:1234"
+		req.SetBasicAuth("colon", password)
+	})
+	if w.Code != http.StatusOK {
+		t.Errorf("a password containing colons was answered %d", w.Code)
+	}
+}
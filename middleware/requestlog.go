@@ -0,0 +1,121 @@
+package middleware
+
+/*
+
+  Copyright 2024, YggdrasilSoft, LLC.
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"runtime/debug"
+	"slices"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+
+	"github.com/greeneg/update-reporterd/apierror"
+	"github.com/greeneg/update-reporterd/globals"
+	"github.com/greeneg/update-reporterd/logging"
+)
+
+// requestIdPattern matches the request IDs accepted from clients and proxies,
+// anything else is replaced so it cannot forge log lines
+var requestIdPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// quietRoutes are polled by load balancers and monitoring, so their requests
+// are only logged at debug level unless they fail
+var quietRoutes = []string{
+	"/api/v1/health",
+	"/api/v1/health/live",
+	"/api/v1/health/ready",
+	"/api/v1/metrics",
+}
+
+func newRequestId() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// requestUser Returns the user the request was made as, if known
+func requestUser(c *gin.Context) string {
+	if user, exists := c.Get(globals.UserKey); exists {
+		return user.(string)
+	}
+	if _, exists := c.Get(sessions.DefaultKey); exists {
+		if user, ok := sessions.Default(c).Get(globals.UserKey).(string); ok {
+			return user
+		}
+	}
+	return ""
+}
+
+// RequestLogger Gives each request an ID, taken from its X-Request-ID header
+// when usable, returns it in the response and adds it to everything logged
+// while serving it. The request is logged once served
+func RequestLogger() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		id := c.GetHeader(logging.RequestIdHeader)
+		if !requestIdPattern.MatchString(id) {
+			id = newRequestId()
+		}
+		c.Header(logging.RequestIdHeader, id)
+		c.Request = c.Request.WithContext(logging.WithRequestId(c.Request.Context(), id))
+
+		c.Next()
+
+		status := c.Writer.Status()
+		route := c.FullPath()
+		attrs := []slog.Attr{
+			slog.String("method", c.Request.Method),
+			slog.String("route", route),
+			slog.Int("status", status),
+			slog.Float64("latencyMs", float64(time.Since(start).Microseconds())/1000),
+			slog.String("clientIp", c.ClientIP()),
+		}
+		if route == "" {
+			// unmatched, so the path is all there is to go by
+			attrs = append(attrs, slog.String("path", c.Request.URL.Path))
+		}
+		if user := requestUser(c); user != "" {
+			attrs = append(attrs, slog.String("user", user))
+		}
+
+		level := slog.LevelInfo
+		if status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		} else if slices.Contains(quietRoutes, route) {
+			level = slog.LevelDebug
+		}
+		slog.LogAttrs(c.Request.Context(), level, "Request served", attrs...)
+	}
+}
+
+// LogPanic Logs a panic raised while serving a request, with its stack, and
+// answers with 500, for gin.CustomRecovery
+func LogPanic(c *gin.Context, err any) {
+	slog.ErrorContext(c.Request.Context(), "Request panicked", "error", err, "stack", string(debug.Stack()))
+	if !c.Writer.Written() {
+		apierror.Internal(c)
+	}
+	c.Abort()
+}
@@ -0,0 +1,320 @@
+package migrations
+
+/*
+
+  Copyright 2024, YggdrasilSoft, LLC.
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/greeneg/update-reporterd/model"
+)
+
+// each dialect has its own scripts, in a directory named after it, numbered
+// alike so the schemas stay in step
+//
+//go:embed sqlite/*.sql postgres/*.sql
+var scripts embed.FS
+
+// Migration is one numbered schema change, loaded from NNNN_name.sql
+type Migration struct {
+	Version int
+	Name    string
+	Script  string
+}
+
+// Status is a migration along with whether it has been applied
+type Status struct {
+	Migration
+	Applied     bool
+	AppliedDate string
+}
+
+const timestampLayout = "2006-01-02 15:04:05"
+
+var createMigrationsTable = map[model.Dialect]string{
+	model.DialectSqlite: `CREATE TABLE IF NOT EXISTS schema_migrations (
+	Version                 INTEGER		PRIMARY KEY			NOT NULL,
+	Name                    STRING		NOT NULL,
+	AppliedDate             DATETIME	NOT NULL			DEFAULT (CURRENT_TIMESTAMP)
+)`,
+	model.DialectPostgres: `CREATE TABLE IF NOT EXISTS schema_migrations (
+	Version                 INTEGER		PRIMARY KEY,
+	Name                    TEXT		NOT NULL,
+	AppliedDate             TIMESTAMP(0)	NOT NULL			DEFAULT (CURRENT_TIMESTAMP AT TIME ZONE 'UTC')
+)`,
+}
+
+// postgresLockId is the advisory lock held while migrating a PostgreSQL
+// database, an arbitrary number unlikely to be used by anything else
+const postgresLockId = 7316452190
+
+// Load Returns all migrations embedded in the binary for a dialect, in order
+func Load(dialect model.Dialect) ([]Migration, error) {
+	if _, ok := createMigrationsTable[dialect]; !ok {
+		return nil, errors.New("no migrations for database driver '" + string(dialect) + "'")
+	}
+	files, err := fs.Glob(scripts, string(dialect)+"/*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	migrations := make([]Migration, 0, len(files))
+	for _, file := range files {
+		base := strings.TrimSuffix(path.Base(file), ".sql")
+		number, name, found := strings.Cut(base, "_")
+		if !found {
+			return nil, errors.New("migration '" + file + "' is not named NNNN_name.sql")
+		}
+		version, err := strconv.Atoi(number)
+		if err != nil || version <= 0 {
+			return nil, errors.New("migration '" + file + "' does not start with a version number")
+		}
+		script, err := scripts.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, Migration{Version: version, Name: name, Script: string(script)})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	for i := 1; i < len(migrations); i++ {
+		if migrations[i].Version == migrations[i-1].Version {
+			return nil, errors.New("duplicate migration version " + strconv.Itoa(migrations[i].Version))
+		}
+	}
+
+	return migrations, nil
+}
+
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+func appliedVersions(ctx context.Context, q querier) (map[int]string, error) {
+	rows, err := q.QueryContext(ctx, "SELECT Version, AppliedDate FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]string{}
+	for rows.Next() {
+		var version int
+		var appliedDate time.Time
+		if err := rows.Scan(&version, &appliedDate); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedDate.UTC().Format(timestampLayout)
+	}
+
+	return applied, rows.Err()
+}
+
+// GetStatus Returns every known migration and whether it has been applied
+func GetStatus(ctx context.Context, db *model.Database) ([]Status, error) {
+	migrations, err := Load(db.Dialect)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.ExecContext(ctx, createMigrationsTable[db.Dialect]); err != nil {
+		return nil, err
+	}
+	applied, err := appliedVersions(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]Status, 0, len(migrations))
+	for _, migration := range migrations {
+		appliedDate, ok := applied[migration.Version]
+		statuses = append(statuses, Status{Migration: migration, Applied: ok, AppliedDate: appliedDate})
+	}
+
+	return statuses, nil
+}
+
+// Pending Returns the migrations not yet applied to the database
+func Pending(ctx context.Context, db *model.Database) ([]Migration, error) {
+	statuses, err := GetStatus(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	pending := make([]Migration, 0)
+	for _, status := range statuses {
+		if !status.Applied {
+			pending = append(pending, status.Migration)
+		}
+	}
+
+	return pending, nil
+}
+
+// lock Starts the transaction migrations run in on conn, locking out other
+// processes until it ends so two cannot both decide the same migration is
+// pending
+func lock(ctx context.Context, conn *sql.Conn, dialect model.Dialect) error {
+	switch dialect {
+	case model.DialectPostgres:
+		if _, err := conn.ExecContext(ctx, "BEGIN"); err != nil {
+			return err
+		}
+		if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1)", postgresLockId); err != nil {
+			conn.ExecContext(context.Background(), "ROLLBACK")
+			return err
+		}
+	default:
+		// BEGIN IMMEDIATE takes the write lock now rather than at the first write
+		if _, err := conn.ExecContext(ctx, "BEGIN IMMEDIATE"); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Up Applies all pending migrations in one transaction, returning those that
+// were applied. The database is locked for the whole run, so concurrent
+// callers wait and then find nothing left to do. A dry run only reports what
+// would be applied
+func Up(ctx context.Context, db *model.Database, dryRun bool) ([]Migration, error) {
+	return apply(ctx, db, 0, dryRun)
+}
+
+// UpTo Applies the pending migrations up to and including version, as Up
+// does. It lets a database be brought to an older schema, such as to check
+// that later migrations upgrade the rows it holds
+func UpTo(ctx context.Context, db *model.Database, version int) ([]Migration, error) {
+	return apply(ctx, db, version, false)
+}
+
+// checkForeignKeys Returns an error if any row of a SQLite database refers to
+// a row that does not exist
+func checkForeignKeys(ctx context.Context, conn *sql.Conn) error {
+	rows, err := conn.QueryContext(ctx, "PRAGMA foreign_key_check")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		var table, parent string
+		var rowId sql.NullInt64
+		var key int
+		if err := rows.Scan(&table, &rowId, &parent, &key); err != nil {
+			return err
+		}
+		return errors.New("row " + strconv.FormatInt(rowId.Int64, 10) + " of " + table +
+			" refers to a missing row of " + parent)
+	}
+
+	return rows.Err()
+}
+
+// apply Applies the pending migrations up to target, or all of them if target
+// is 0
+func apply(ctx context.Context, db *model.Database, target int, dryRun bool) ([]Migration, error) {
+	migrations, err := Load(db.Dialect)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	// SQLite rebuilds a table to change its columns, and dropping the old
+	// table would fail on the rows referring to it. Foreign keys are off for
+	// the run, which cannot be changed inside the transaction, and checked as
+	// a whole before it commits
+	if db.Dialect == model.DialectSqlite {
+		if _, err := conn.ExecContext(ctx, "PRAGMA foreign_keys = OFF"); err != nil {
+			return nil, err
+		}
+		defer conn.ExecContext(context.Background(), "PRAGMA foreign_keys = ON")
+	}
+
+	if err := lock(ctx, conn, db.Dialect); err != nil {
+		return nil, err
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			conn.ExecContext(context.Background(), "ROLLBACK")
+		}
+	}()
+
+	if _, err := conn.ExecContext(ctx, createMigrationsTable[db.Dialect]); err != nil {
+		return nil, err
+	}
+	applied, err := appliedVersions(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	done := make([]Migration, 0)
+	for _, migration := range migrations {
+		if target > 0 && migration.Version > target {
+			break
+		}
+		if _, ok := applied[migration.Version]; ok {
+			continue
+		}
+		done = append(done, migration)
+		if dryRun {
+			continue
+		}
+
+		slog.Info("Applying migration", "version", migration.Version, "name", migration.Name)
+		if _, err := conn.ExecContext(ctx, migration.Script); err != nil {
+			return nil, errors.New("migration " + strconv.Itoa(migration.Version) + " " + migration.Name + " failed: " + err.Error())
+		}
+		_, err := conn.ExecContext(ctx, db.Dialect.Rebind("INSERT INTO schema_migrations (Version, Name, AppliedDate) VALUES (?, ?, ?)"),
+			migration.Version, migration.Name, time.Now().UTC().Format(timestampLayout))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if dryRun {
+		return done, nil
+	}
+	if db.Dialect == model.DialectSqlite {
+		if err := checkForeignKeys(ctx, conn); err != nil {
+			return nil, errors.New("migrations left broken references: " + err.Error())
+		}
+	}
+	if _, err := conn.ExecContext(ctx, "COMMIT"); err != nil {
+		return nil, err
+	}
+	committed = true
+
+	return done, nil
+}
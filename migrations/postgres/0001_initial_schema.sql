@@ -0,0 +1,83 @@
+-- The baseline schema in PostgreSQL types. Timestamps are kept in UTC, to the
+-- second, like the SQLite schema keeps them
+
+CREATE TABLE Architectures (
+	Id                      INTEGER		GENERATED BY DEFAULT AS IDENTITY	PRIMARY KEY,
+	ArchName                TEXT		UNIQUE				NOT NULL,
+	CreationDate            TIMESTAMP(0)	NOT NULL			DEFAULT (CURRENT_TIMESTAMP AT TIME ZONE 'UTC')
+);
+
+INSERT INTO Architectures (Id, ArchName) VALUES
+	(1, 'noarch'),
+	(2, 'aarch64'),
+	(3, 'x86'),
+	(4, 'x86_64');
+
+SELECT setval(pg_get_serial_sequence('architectures', 'id'), 4);
+
+CREATE TABLE OperatingSystems (
+	Id                      INTEGER		GENERATED BY DEFAULT AS IDENTITY	PRIMARY KEY,
+	OsIdName                TEXT		NOT NULL,
+	OsVersion               TEXT		NOT NULL,
+	CreationDate            TIMESTAMP(0)	NOT NULL			DEFAULT (CURRENT_TIMESTAMP AT TIME ZONE 'UTC')
+);
+
+CREATE TABLE OsFamilies (
+	Id                      INTEGER		GENERATED BY DEFAULT AS IDENTITY	PRIMARY KEY,
+	FamilyName              TEXT		UNIQUE				NOT NULL,
+	CreationDate            TIMESTAMP(0)	NOT NULL			DEFAULT (CURRENT_TIMESTAMP AT TIME ZONE 'UTC')
+);
+
+INSERT INTO OsFamilies (Id, FamilyName) VALUES
+	(1, 'linux'),
+	(2, 'darwin'),
+	(3, 'windows');
+
+SELECT setval(pg_get_serial_sequence('osfamilies', 'id'), 3);
+
+CREATE TABLE Roles (
+	Id                      INTEGER		GENERATED BY DEFAULT AS IDENTITY	PRIMARY KEY,
+	RoleName                TEXT		UNIQUE				NOT NULL,
+	Description             TEXT		NOT NULL,
+	CreationDate            TIMESTAMP(0)	NOT NULL			DEFAULT (CURRENT_TIMESTAMP AT TIME ZONE 'UTC')
+);
+
+INSERT INTO Roles (Id, RoleName, Description) VALUES
+	(1, 'SYSTEM', 'Built-in system role'),
+	(2, 'administrators', 'Accounts that have full administrative rights to the system');
+
+SELECT setval(pg_get_serial_sequence('roles', 'id'), 2);
+
+CREATE TABLE Systems (
+	Id                      INTEGER		GENERATED BY DEFAULT AS IDENTITY	PRIMARY KEY,
+	FQDN                    TEXT		UNIQUE					NOT NULL,
+	OsFamilyId              INTEGER		REFERENCES OsFamilies (Id)		NOT NULL,
+	OsId                    INTEGER		REFERENCES OperatingSystems (Id)	NOT NULL,
+	ArchId                  INTEGER		REFERENCES Architectures (Id)		NOT NULL,
+	CreationDate            TIMESTAMP(0)	NOT NULL				DEFAULT (CURRENT_TIMESTAMP AT TIME ZONE 'UTC')
+);
+
+CREATE TABLE UpdateRecords (
+	Id                      INTEGER		GENERATED BY DEFAULT AS IDENTITY	PRIMARY KEY,
+	SystemId                INTEGER		REFERENCES Systems (Id)			UNIQUE	NOT NULL,
+	UpdateCount             INTEGER		NOT NULL,
+	UpdateRecord            JSONB		NOT NULL,
+	CreationDate            TIMESTAMP(0)	NOT NULL				DEFAULT (CURRENT_TIMESTAMP AT TIME ZONE 'UTC'),
+	LastUpdateDate          TIMESTAMP(0)	NOT NULL				DEFAULT (CURRENT_TIMESTAMP AT TIME ZONE 'UTC')
+);
+
+CREATE TABLE Users (
+	Id                      INTEGER		GENERATED BY DEFAULT AS IDENTITY	PRIMARY KEY,
+	UserName                TEXT		NOT NULL			UNIQUE,
+	FullName                TEXT		NOT NULL,
+	Status                  TEXT		NOT NULL			DEFAULT 'enabled',
+	RoleId                  INTEGER		REFERENCES Roles (Id)		NOT NULL,
+	PasswordHash            TEXT		NOT NULL,
+	CreationDate            TIMESTAMP(0)	NOT NULL			DEFAULT (CURRENT_TIMESTAMP AT TIME ZONE 'UTC'),
+	LastPasswordChangedDate TIMESTAMP(0)	NOT NULL			DEFAULT (CURRENT_TIMESTAMP AT TIME ZONE 'UTC')
+);
+
+INSERT INTO Users (Id, UserName, FullName, Status, RoleId, PasswordHash)
+	VALUES (1, 'SYSTEM', 'Built-in System User', 'enabled', 1, '!');
+
+SELECT setval(pg_get_serial_sequence('users', 'id'), 1);
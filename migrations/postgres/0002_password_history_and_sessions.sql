@@ -0,0 +1,21 @@
+-- Password reuse history and server-side sessions
+
+CREATE TABLE PasswordHistory (
+	Id                      INTEGER		GENERATED BY DEFAULT AS IDENTITY		PRIMARY KEY,
+	UserId                  INTEGER		REFERENCES Users (Id) ON DELETE CASCADE	NOT NULL,
+	PasswordHash            TEXT		NOT NULL,
+	CreationDate            TIMESTAMP(0)	NOT NULL				DEFAULT (CURRENT_TIMESTAMP AT TIME ZONE 'UTC')
+);
+
+CREATE TABLE Sessions (
+	Id                      TEXT		PRIMARY KEY,
+	UserName                TEXT		NOT NULL,
+	Data                    TEXT		NOT NULL,
+	RemoteAddr              TEXT		NOT NULL,
+	UserAgent               TEXT		NOT NULL,
+	CreationDate            TIMESTAMP(0)	NOT NULL			DEFAULT (CURRENT_TIMESTAMP AT TIME ZONE 'UTC'),
+	LastSeenDate            TIMESTAMP(0)	NOT NULL			DEFAULT (CURRENT_TIMESTAMP AT TIME ZONE 'UTC'),
+	ExpiresDate             TIMESTAMP(0)	NOT NULL
+);
+
+CREATE INDEX SessionsUserName ON Sessions (UserName);
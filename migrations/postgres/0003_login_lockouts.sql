@@ -0,0 +1,22 @@
+-- Failed login tracking and account lockouts
+
+CREATE TABLE Lockouts (
+	Id                      INTEGER		GENERATED BY DEFAULT AS IDENTITY		PRIMARY KEY,
+	UserId                  INTEGER		REFERENCES Users (Id) ON DELETE CASCADE	UNIQUE	NOT NULL,
+	Reason                  TEXT		NOT NULL,
+	CreationDate            TIMESTAMP(0)	NOT NULL				DEFAULT (CURRENT_TIMESTAMP AT TIME ZONE 'UTC'),
+	UnlockDate              TIMESTAMP(0)
+);
+
+CREATE TABLE LoginEvents (
+	Id                      INTEGER		GENERATED BY DEFAULT AS IDENTITY	PRIMARY KEY,
+	UserName                TEXT		NOT NULL,
+	RemoteAddr              TEXT		NOT NULL,
+	Event                   TEXT		NOT NULL,
+	Reason                  TEXT		NOT NULL			DEFAULT '',
+	CreationDate            TIMESTAMP(0)	NOT NULL			DEFAULT (CURRENT_TIMESTAMP AT TIME ZONE 'UTC')
+);
+
+CREATE INDEX LoginEventsUserName ON LoginEvents (UserName, CreationDate);
+
+CREATE INDEX LoginEventsRemoteAddr ON LoginEvents (RemoteAddr, CreationDate);
@@ -0,0 +1,19 @@
+-- TOTP two-factor authentication
+
+ALTER TABLE Roles ADD COLUMN TwoFactorRequired BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE TABLE UserTotp (
+	UserId                  INTEGER		PRIMARY KEY REFERENCES Users (Id) ON DELETE CASCADE,
+	Secret                  TEXT		NOT NULL,
+	Confirmed               BOOLEAN		NOT NULL			DEFAULT FALSE,
+	LastUsedCounter         BIGINT		NOT NULL			DEFAULT 0,
+	CreationDate            TIMESTAMP(0)	NOT NULL			DEFAULT (CURRENT_TIMESTAMP AT TIME ZONE 'UTC'),
+	ConfirmedDate           TIMESTAMP(0)
+);
+
+CREATE TABLE RecoveryCodes (
+	Id                      INTEGER		GENERATED BY DEFAULT AS IDENTITY		PRIMARY KEY,
+	UserId                  INTEGER		REFERENCES Users (Id) ON DELETE CASCADE	NOT NULL,
+	CodeHash                TEXT		NOT NULL,
+	UsedDate                TIMESTAMP(0)
+);
@@ -0,0 +1,3 @@
+-- Users signing in through LDAP or single sign-on
+
+ALTER TABLE Users ADD COLUMN AuthSource TEXT NOT NULL DEFAULT 'local';
@@ -0,0 +1,15 @@
+-- Personal API tokens
+
+CREATE TABLE ApiTokens (
+	Id                      INTEGER		GENERATED BY DEFAULT AS IDENTITY		PRIMARY KEY,
+	UserId                  INTEGER		REFERENCES Users (Id) ON DELETE CASCADE	NOT NULL,
+	Name                    TEXT		NOT NULL,
+	TokenPrefix             TEXT		NOT NULL,
+	TokenHash               TEXT		NOT NULL				UNIQUE,
+	Scopes                  TEXT		NOT NULL,
+	CreationDate            TIMESTAMP(0)	NOT NULL				DEFAULT (CURRENT_TIMESTAMP AT TIME ZONE 'UTC'),
+	ExpiryDate              TIMESTAMP(0),
+	LastUsedDate            TIMESTAMP(0)
+);
+
+CREATE INDEX ApiTokensUserId ON ApiTokens (UserId);
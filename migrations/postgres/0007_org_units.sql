@@ -0,0 +1,10 @@
+-- Organizational units users belong to
+
+CREATE TABLE OrgUnits (
+	Id                      INTEGER		GENERATED BY DEFAULT AS IDENTITY	PRIMARY KEY,
+	OrgUnitName             TEXT		UNIQUE				NOT NULL,
+	Description             TEXT		NOT NULL,
+	CreationDate            TIMESTAMP(0)	NOT NULL			DEFAULT (CURRENT_TIMESTAMP AT TIME ZONE 'UTC')
+);
+
+ALTER TABLE Users ADD COLUMN OrgUnitId INTEGER REFERENCES OrgUnits (Id);
@@ -0,0 +1,2 @@
+-- Only SQLite stored operating system names and versions as numbers, the
+-- PostgreSQL columns have been TEXT from the start
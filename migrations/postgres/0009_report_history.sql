@@ -0,0 +1,33 @@
+-- History of update reports and their daily aggregates, pruned by the
+-- retention job
+
+CREATE TABLE UpdateReports (
+	Id                      INTEGER		GENERATED BY DEFAULT AS IDENTITY		PRIMARY KEY,
+	SystemId                INTEGER		REFERENCES Systems (Id) ON DELETE CASCADE	NOT NULL,
+	UpdateCount             INTEGER		NOT NULL,
+	UpdateRecord            JSONB		NOT NULL,
+	CreationDate            TIMESTAMP(0)	NOT NULL					DEFAULT (CURRENT_TIMESTAMP AT TIME ZONE 'UTC')
+);
+
+CREATE INDEX UpdateReportsSystemId ON UpdateReports (SystemId, CreationDate);
+
+CREATE INDEX UpdateReportsCreationDate ON UpdateReports (CreationDate);
+
+CREATE TABLE DailyUpdateCounts (
+	SystemId                INTEGER		REFERENCES Systems (Id) ON DELETE CASCADE	NOT NULL,
+	Day                     TEXT		NOT NULL,
+	Reports                 INTEGER		NOT NULL,
+	MaxUpdateCount          INTEGER		NOT NULL,
+	LastUpdateCount         INTEGER		NOT NULL,
+	PRIMARY KEY (SystemId, Day)
+);
+
+CREATE INDEX DailyUpdateCountsDay ON DailyUpdateCounts (Day);
+
+-- the latest report of each system starts its history
+
+INSERT INTO UpdateReports (SystemId, UpdateCount, UpdateRecord, CreationDate)
+	SELECT SystemId, UpdateCount, UpdateRecord, LastUpdateDate FROM UpdateRecords;
+
+INSERT INTO DailyUpdateCounts (SystemId, Day, Reports, MaxUpdateCount, LastUpdateCount)
+	SELECT SystemId, to_char(LastUpdateDate, 'YYYY-MM-DD'), 1, UpdateCount, UpdateCount FROM UpdateRecords;
@@ -0,0 +1,7 @@
+-- The issuer and subject single sign-on users are known by. The user name
+-- claim can be changed at the issuer, so it is not what accounts are matched on
+
+ALTER TABLE Users ADD COLUMN ExternalIssuer TEXT;
+ALTER TABLE Users ADD COLUMN ExternalSubject TEXT;
+
+CREATE UNIQUE INDEX IF NOT EXISTS UsersExternalIdentity ON Users (ExternalIssuer, ExternalSubject);
@@ -0,0 +1,74 @@
+-- Schema as created by the original createDB. Every statement is idempotent so
+-- databases created before migrations existed can adopt this as their baseline
+
+CREATE TABLE IF NOT EXISTS Architectures (
+	Id                      INTEGER		PRIMARY KEY AUTOINCREMENT	UNIQUE	NOT NULL,
+	ArchName                STRING		UNIQUE				NOT NULL,
+	CreationDate            DATETIME	NOT NULL			DEFAULT (CURRENT_TIMESTAMP)
+);
+
+INSERT OR IGNORE INTO Architectures (Id, ArchName) VALUES (1, 'noarch');
+INSERT OR IGNORE INTO Architectures (Id, ArchName) VALUES (2, 'aarch64');
+INSERT OR IGNORE INTO Architectures (Id, ArchName) VALUES (3, 'x86');
+INSERT OR IGNORE INTO Architectures (Id, ArchName) VALUES (4, 'x86_64');
+
+CREATE TABLE IF NOT EXISTS OperatingSystems (
+	Id                      INTEGER		PRIMARY KEY AUTOINCREMENT	UNIQUE	NOT NULL,
+	OsIdName                STRING		NOT NULL,
+	OsVersion               STRING		NOT NULL,
+	CreationDate            DATETIME	NOT NULL			DEFAULT (CURRENT_TIMESTAMP)
+);
+
+CREATE TABLE IF NOT EXISTS OsFamilies (
+	Id                      INTEGER		PRIMARY KEY AUTOINCREMENT	UNIQUE	NOT NULL,
+	FamilyName              STRING		UNIQUE				NOT NULL,
+	CreationDate            DATETIME	NOT NULL			DEFAULT (CURRENT_TIMESTAMP)
+);
+
+INSERT OR IGNORE INTO OsFamilies (Id, FamilyName) VALUES (1, 'linux');
+INSERT OR IGNORE INTO OsFamilies (Id, FamilyName) VALUES (2, 'darwin');
+INSERT OR IGNORE INTO OsFamilies (Id, FamilyName) VALUES (3, 'windows');
+
+CREATE TABLE IF NOT EXISTS Roles (
+	Id                      INTEGER		PRIMARY KEY AUTOINCREMENT	UNIQUE	NOT NULL,
+	RoleName                STRING		UNIQUE				NOT NULL,
+	Description             STRING		NOT NULL,
+	CreationDate            DATETIME	NOT NULL		 	DEFAULT (CURRENT_TIMESTAMP)
+);
+
+INSERT OR IGNORE INTO Roles (Id, RoleName, Description)
+	VALUES (1, 'SYSTEM', 'Built-in system role');
+INSERT OR IGNORE INTO Roles (Id, RoleName, Description)
+	VALUES (2, 'administrators', 'Accounts that have full administrative rights to the system');
+
+CREATE TABLE IF NOT EXISTS Systems (
+	Id                      INTEGER		PRIMARY KEY AUTOINCREMENT		UNIQUE	NOT NULL,
+	FQDN                    STRING		UNIQUE					NOT NULL,
+	OsFamilyId              INTEGER		REFERENCES OsFamilies (Id)		NOT NULL,
+	OsId                    INTEGER		REFERENCES OperatingSystems (Id)	NOT NULL,
+	ArchId                  INTEGER		REFERENCES Architectures (Id)		NOT NULL,
+	CreationDate            DATETIME	NOT NULL				DEFAULT (CURRENT_TIMESTAMP)
+);
+
+CREATE TABLE IF NOT EXISTS UpdateRecords (
+	Id                      INTEGER		PRIMARY KEY AUTOINCREMENT		UNIQUE	NOT NULL,
+	SystemId                INTEGER		REFERENCES Systems (Id)			UNIQUE	NOT NULL,
+	UpdateCount		INTEGER		NOT NULL,
+	UpdateRecord            JSON		NOT NULL,
+	CreationDate            INTEGER		NOT NULL				DEFAULT (CURRENT_TIMESTAMP),
+	LastUpdateDate          DATETIME	NOT NULL				DEFAULT (CURRENT_TIMESTAMP)
+);
+
+CREATE TABLE IF NOT EXISTS Users (
+	Id                      INTEGER 	PRIMARY KEY AUTOINCREMENT	UNIQUE	NOT NULL,
+	UserName                STRING		NOT NULL			UNIQUE,
+	FullName                STRING		NOT NULL,
+	Status                  STRING		NOT NULL			DEFAULT enabled,
+	RoleId                  INTEGER		REFERENCES Roles (Id)		NOT NULL,
+	PasswordHash            STRING		NOT NULL,
+	CreationDate            DATETIME	NOT NULL			DEFAULT (CURRENT_TIMESTAMP),
+	LastPasswordChangedDate DATETIME	NOT NULL			DEFAULT (CURRENT_TIMESTAMP)
+);
+
+INSERT OR IGNORE INTO Users (Id, UserName, FullName, Status, RoleId, PasswordHash)
+	VALUES (1, 'SYSTEM', 'Built-in System User', 'enabled', 1, '!');
@@ -0,0 +1,21 @@
+-- Password reuse history and server-side sessions
+
+CREATE TABLE IF NOT EXISTS PasswordHistory (
+	Id                      INTEGER		PRIMARY KEY AUTOINCREMENT		UNIQUE	NOT NULL,
+	UserId                  INTEGER		REFERENCES Users (Id) ON DELETE CASCADE	NOT NULL,
+	PasswordHash            STRING		NOT NULL,
+	CreationDate            DATETIME	NOT NULL				DEFAULT (CURRENT_TIMESTAMP)
+);
+
+CREATE TABLE IF NOT EXISTS Sessions (
+	Id                      STRING		PRIMARY KEY			UNIQUE	NOT NULL,
+	UserName                STRING		NOT NULL,
+	Data                    STRING		NOT NULL,
+	RemoteAddr              STRING		NOT NULL,
+	UserAgent               STRING		NOT NULL,
+	CreationDate            DATETIME	NOT NULL			DEFAULT (CURRENT_TIMESTAMP),
+	LastSeenDate            DATETIME	NOT NULL			DEFAULT (CURRENT_TIMESTAMP),
+	ExpiresDate             DATETIME	NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS SessionsUserName ON Sessions (UserName);
@@ -0,0 +1,22 @@
+-- Failed login tracking and account lockouts
+
+CREATE TABLE IF NOT EXISTS Lockouts (
+	Id                      INTEGER		PRIMARY KEY AUTOINCREMENT		UNIQUE	NOT NULL,
+	UserId                  INTEGER		REFERENCES Users (Id) ON DELETE CASCADE	UNIQUE	NOT NULL,
+	Reason                  STRING		NOT NULL,
+	CreationDate            DATETIME	NOT NULL				DEFAULT (CURRENT_TIMESTAMP),
+	UnlockDate              DATETIME
+);
+
+CREATE TABLE IF NOT EXISTS LoginEvents (
+	Id                      INTEGER		PRIMARY KEY AUTOINCREMENT	UNIQUE	NOT NULL,
+	UserName                STRING		NOT NULL,
+	RemoteAddr              STRING		NOT NULL,
+	Event                   STRING		NOT NULL,
+	Reason                  STRING		NOT NULL			DEFAULT '',
+	CreationDate            DATETIME	NOT NULL			DEFAULT (CURRENT_TIMESTAMP)
+);
+
+CREATE INDEX IF NOT EXISTS LoginEventsUserName ON LoginEvents (UserName, CreationDate);
+
+CREATE INDEX IF NOT EXISTS LoginEventsRemoteAddr ON LoginEvents (RemoteAddr, CreationDate);
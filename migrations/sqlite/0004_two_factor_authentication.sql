@@ -0,0 +1,19 @@
+-- TOTP two-factor authentication
+
+ALTER TABLE Roles ADD COLUMN TwoFactorRequired BOOLEAN NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS UserTotp (
+	UserId                  INTEGER		PRIMARY KEY REFERENCES Users (Id) ON DELETE CASCADE	NOT NULL,
+	Secret                  STRING		NOT NULL,
+	Confirmed               BOOLEAN		NOT NULL			DEFAULT 0,
+	LastUsedCounter         INTEGER		NOT NULL			DEFAULT 0,
+	CreationDate            DATETIME	NOT NULL			DEFAULT (CURRENT_TIMESTAMP),
+	ConfirmedDate           DATETIME
+);
+
+CREATE TABLE IF NOT EXISTS RecoveryCodes (
+	Id                      INTEGER		PRIMARY KEY AUTOINCREMENT		UNIQUE	NOT NULL,
+	UserId                  INTEGER		REFERENCES Users (Id) ON DELETE CASCADE	NOT NULL,
+	CodeHash                STRING		NOT NULL,
+	UsedDate                DATETIME
+);
@@ -0,0 +1,15 @@
+-- Personal API tokens
+
+CREATE TABLE IF NOT EXISTS ApiTokens (
+	Id                      INTEGER		PRIMARY KEY AUTOINCREMENT		UNIQUE	NOT NULL,
+	UserId                  INTEGER		REFERENCES Users (Id) ON DELETE CASCADE	NOT NULL,
+	Name                    STRING		NOT NULL,
+	TokenPrefix             STRING		NOT NULL,
+	TokenHash               STRING		NOT NULL				UNIQUE,
+	Scopes                  STRING		NOT NULL,
+	CreationDate            DATETIME	NOT NULL				DEFAULT (CURRENT_TIMESTAMP),
+	ExpiryDate              DATETIME,
+	LastUsedDate            DATETIME
+);
+
+CREATE INDEX IF NOT EXISTS ApiTokensUserId ON ApiTokens (UserId);
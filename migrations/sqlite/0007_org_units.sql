@@ -0,0 +1,11 @@
+-- Organizational units users belong to. model.User has carried OrgUnitId
+-- without a column to back it
+
+CREATE TABLE IF NOT EXISTS OrgUnits (
+	Id                      INTEGER		PRIMARY KEY AUTOINCREMENT	UNIQUE	NOT NULL,
+	OrgUnitName             STRING		UNIQUE				NOT NULL,
+	Description             STRING		NOT NULL,
+	CreationDate            DATETIME	NOT NULL			DEFAULT (CURRENT_TIMESTAMP)
+);
+
+ALTER TABLE Users ADD COLUMN OrgUnitId INTEGER REFERENCES OrgUnits (Id);
@@ -0,0 +1,17 @@
+-- STRING is not a SQLite type name, so those columns have numeric affinity
+-- and a version such as 15.10 was stored as the number 15.1. Rebuild the
+-- table with TEXT columns
+
+CREATE TABLE OperatingSystemsText (
+	Id                      INTEGER		PRIMARY KEY AUTOINCREMENT	UNIQUE	NOT NULL,
+	OsIdName                TEXT		NOT NULL,
+	OsVersion               TEXT		NOT NULL,
+	CreationDate            DATETIME	NOT NULL			DEFAULT (CURRENT_TIMESTAMP)
+);
+
+INSERT INTO OperatingSystemsText (Id, OsIdName, OsVersion, CreationDate)
+	SELECT Id, OsIdName, OsVersion, CreationDate FROM OperatingSystems;
+
+DROP TABLE OperatingSystems;
+
+ALTER TABLE OperatingSystemsText RENAME TO OperatingSystems;
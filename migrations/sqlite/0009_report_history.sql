@@ -0,0 +1,33 @@
+-- History of update reports and their daily aggregates, pruned by the
+-- retention job
+
+CREATE TABLE IF NOT EXISTS UpdateReports (
+	Id                      INTEGER		PRIMARY KEY AUTOINCREMENT			UNIQUE	NOT NULL,
+	SystemId                INTEGER		REFERENCES Systems (Id) ON DELETE CASCADE	NOT NULL,
+	UpdateCount             INTEGER		NOT NULL,
+	UpdateRecord            JSON		NOT NULL,
+	CreationDate            DATETIME	NOT NULL					DEFAULT (CURRENT_TIMESTAMP)
+);
+
+CREATE INDEX IF NOT EXISTS UpdateReportsSystemId ON UpdateReports (SystemId, CreationDate);
+
+CREATE INDEX IF NOT EXISTS UpdateReportsCreationDate ON UpdateReports (CreationDate);
+
+CREATE TABLE IF NOT EXISTS DailyUpdateCounts (
+	SystemId                INTEGER		REFERENCES Systems (Id) ON DELETE CASCADE	NOT NULL,
+	Day                     TEXT		NOT NULL,
+	Reports                 INTEGER		NOT NULL,
+	MaxUpdateCount          INTEGER		NOT NULL,
+	LastUpdateCount         INTEGER		NOT NULL,
+	PRIMARY KEY (SystemId, Day)
+);
+
+CREATE INDEX IF NOT EXISTS DailyUpdateCountsDay ON DailyUpdateCounts (Day);
+
+-- the latest report of each system starts its history
+
+INSERT INTO UpdateReports (SystemId, UpdateCount, UpdateRecord, CreationDate)
+	SELECT SystemId, UpdateCount, UpdateRecord, LastUpdateDate FROM UpdateRecords;
+
+INSERT INTO DailyUpdateCounts (SystemId, Day, Reports, MaxUpdateCount, LastUpdateCount)
+	SELECT SystemId, date(LastUpdateDate), 1, UpdateCount, UpdateCount FROM UpdateRecords;
@@ -18,6 +18,11 @@ package model
 
 */
 
+import (
+	"strconv"
+	"strings"
+)
+
 type InvalidStatusValue struct {
 	Err error
 }
@@ -33,3 +38,108 @@ type PasswordHashMismatch struct {
 func (p *PasswordHashMismatch) Error() string {
 	return "Password hashes do not match!"
 }
+
+type UnsupportedPasswordHash struct {
+	Err error
+}
+
+func (u *UnsupportedPasswordHash) Error() string {
+	return "Stored password hash is in an unsupported format!"
+}
+
+type PasswordPolicyViolation struct {
+	Reasons []string
+}
+
+func (p *PasswordPolicyViolation) Error() string {
+	return "Password does not meet the password policy: " + strings.Join(p.Reasons, "; ")
+}
+
+type ExternallyManagedAccount struct {
+	Err        error
+	AuthSource string
+}
+
+func (e *ExternallyManagedAccount) Error() string {
+	return "Account credentials are managed by '" + e.AuthSource + "' and cannot be changed here"
+}
+
+type InvalidUpdateRecord struct {
+	Err error
+}
+
+func (i *InvalidUpdateRecord) Error() string {
+	return "Update record is not a valid JSON document!"
+}
+
+// The errors below classify what went wrong, so the API can answer with the
+// matching status. Other errors are failures of the service itself
+
+// NotFound is returned when the object a request is about does not exist
+type NotFound struct {
+	Message string
+	Err     error
+}
+
+func (n *NotFound) Error() string {
+	return n.Message
+}
+
+func (n *NotFound) Unwrap() error {
+	return n.Err
+}
+
+// Conflict is returned when a change clashes with the current state, such
+// as a name that is already taken
+type Conflict struct {
+	Message string
+	Err     error
+}
+
+func (c *Conflict) Error() string {
+	return c.Message
+}
+
+func (c *Conflict) Unwrap() error {
+	return c.Err
+}
+
+// Validation is returned when a request carries values that cannot be used
+type Validation struct {
+	Message string
+	Err     error
+}
+
+func (v *Validation) Error() string {
+	return v.Message
+}
+
+func (v *Validation) Unwrap() error {
+	return v.Err
+}
+
+// Forbidden is returned when a change is not allowed whoever asks for it,
+// such as removing a protected user
+type Forbidden struct {
+	Message string
+	Err     error
+}
+
+func (f *Forbidden) Error() string {
+	return f.Message
+}
+
+func (f *Forbidden) Unwrap() error {
+	return f.Err
+}
+
+// RoleInUse is returned when a role that still has users is deleted without
+// naming a role to move them to
+type RoleInUse struct {
+	RoleId    int
+	UserNames []string
+}
+
+func (r *RoleInUse) Error() string {
+	return "Role Id " + strconv.Itoa(r.RoleId) + " still has users: " + strings.Join(r.UserNames, ", ")
+}
@@ -0,0 +1,190 @@
+package model
+
+/*
+
+  Copyright 2024, YggdrasilSoft, LLC.
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+import (
+	"database/sql"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// apiTokenScopes Returns the scopes of a token from their stored form
+func apiTokenScopes(scopes string) []string {
+	if scopes == "" {
+		return []string{}
+	}
+	return strings.Split(scopes, ",")
+}
+
+func scanApiToken(scanner interface{ Scan(...any) error }) (ApiToken, error) {
+	token := ApiToken{}
+	scopes := ""
+	expiryDate := sql.NullString{}
+	lastUsedDate := sql.NullString{}
+	err := scanner.Scan(
+		&token.Id,
+		&token.UserId,
+		&token.Name,
+		&token.TokenPrefix,
+		&scopes,
+		&token.CreationDate,
+		&expiryDate,
+		&lastUsedDate,
+	)
+	if err != nil {
+		return ApiToken{}, err
+	}
+
+	token.Scopes = apiTokenScopes(scopes)
+	token.CreationDate = ConvertDbTimestamp(token.CreationDate)
+	if expiryDate.Valid {
+		token.ExpiryDate = ConvertDbTimestamp(expiryDate.String)
+	}
+	if lastUsedDate.Valid {
+		token.LastUsedDate = ConvertDbTimestamp(lastUsedDate.String)
+	}
+
+	return token, nil
+}
+
+const apiTokenColumns = "t.Id, t.UserId, t.Name, t.TokenPrefix, t.Scopes, t.CreationDate, t.ExpiryDate, t.LastUsedDate"
+
+// CreateApiToken Stores a new token for a user, returning its Id
+func CreateApiToken(username string, name string, tokenPrefix string, tokenHash string, scopes []string, expiryDate time.Time) (int, error) {
+	slog.Debug("API token creation requested", "user", username)
+	expiry := sql.NullString{}
+	if !expiryDate.IsZero() {
+		expiry = sql.NullString{String: DbTimestamp(expiryDate), Valid: true}
+	}
+
+	id := 0
+	err := run(func(u *Unit) error {
+		return u.QueryRow(`INSERT INTO ApiTokens (UserId, Name, TokenPrefix, TokenHash, Scopes, ExpiryDate)
+			VALUES ((SELECT Id FROM Users WHERE UserName = ?), ?, ?, ?, ?, ?)
+			RETURNING Id`,
+			username, name, tokenPrefix, tokenHash, strings.Join(scopes, ","), expiry).Scan(&id)
+	})
+	if err != nil {
+		slog.Error("Cannot store API token", "user", username, "error", err)
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// GetApiTokenById Returns a token, or an empty one if there is no such token
+func GetApiTokenById(id int) (ApiToken, error) {
+	token := ApiToken{}
+	err := run(func(u *Unit) error {
+		var err error
+		token, err = scanApiToken(u.QueryRow("SELECT "+apiTokenColumns+" FROM ApiTokens t WHERE t.Id = ?", id))
+		return err
+	})
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ApiToken{}, nil
+		}
+		slog.Error("Cannot retrieve API token", "tokenId", id, "error", err)
+		return ApiToken{}, err
+	}
+
+	return token, nil
+}
+
+// GetApiTokenByHash Returns the unexpired token with the hash, or an empty
+// token if there is none
+func GetApiTokenByHash(tokenHash string) (ApiToken, error) {
+	token := ApiToken{}
+	err := run(func(u *Unit) error {
+		var err error
+		token, err = scanApiToken(u.QueryRow(`SELECT `+apiTokenColumns+` FROM ApiTokens t
+			WHERE t.TokenHash = ? AND (t.ExpiryDate IS NULL OR t.ExpiryDate > ?)`,
+			tokenHash, DbTimestamp(time.Now())))
+		return err
+	})
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ApiToken{}, nil
+		}
+		slog.Error("Cannot retrieve API token", "error", err)
+		return ApiToken{}, err
+	}
+
+	return token, nil
+}
+
+// GetApiTokensByUserName Returns all tokens of a user, including expired ones
+func GetApiTokensByUserName(username string) ([]ApiToken, error) {
+	tokens := make([]ApiToken, 0)
+	err := run(func(u *Unit) error {
+		return u.Each(`SELECT `+apiTokenColumns+`
+			FROM ApiTokens t JOIN Users u ON u.Id = t.UserId
+			WHERE u.UserName = ? ORDER BY t.Id`, []any{username}, func(rows *sql.Rows) error {
+			token, err := scanApiToken(rows)
+			if err != nil {
+				return err
+			}
+			tokens = append(tokens, token)
+			return nil
+		})
+	})
+	if err != nil {
+		slog.Error("Cannot marshal the API token objects", "error", err)
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+// TouchApiToken Records that a token was used, at most once a minute
+func TouchApiToken(id int) error {
+	now := time.Now()
+	err := run(func(u *Unit) error {
+		_, err := u.Exec(`UPDATE ApiTokens SET LastUsedDate = ?
+			WHERE Id = ? AND (LastUsedDate IS NULL OR LastUsedDate < ?)`,
+			DbTimestamp(now), id, DbTimestamp(now.Add(-time.Minute)))
+		return err
+	})
+	if err != nil {
+		slog.Error("Cannot record use of API token", "tokenId", id, "error", err)
+	}
+	return err
+}
+
+// DeleteApiToken Revokes one of a user's tokens, returning false if they have no such token
+func DeleteApiToken(username string, id int) (bool, error) {
+	slog.Debug("API token revocation requested", "tokenId", id, "user", username)
+	var numberOfRows int64
+	err := run(func(u *Unit) error {
+		result, err := u.Exec(`DELETE FROM ApiTokens
+			WHERE Id = ? AND UserId = (SELECT Id FROM Users WHERE UserName = ?)`, id, username)
+		if err != nil {
+			return err
+		}
+		numberOfRows, err = result.RowsAffected()
+		return err
+	})
+	if err != nil {
+		slog.Error("Cannot revoke API token", "tokenId", id, "error", err)
+		return false, err
+	}
+
+	return numberOfRows > 0, nil
+}
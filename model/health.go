@@ -0,0 +1,84 @@
+package model
+
+/*
+
+  Copyright 2024, YggdrasilSoft, LLC.
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"time"
+)
+
+// IngestionStatus describes how current the reports of the systems are
+type IngestionStatus struct {
+	// zero if no system has reported yet
+	LastReport   time.Time
+	Systems      int
+	StaleSystems int
+}
+
+func Probe(ctx context.Context) error {
+	return store.Probe(ctx)
+}
+
+func (s *SqlStore) Probe(ctx context.Context) error {
+	if err := s.db.PingContext(ctx); err != nil {
+		slog.Error("Cannot reach the database", "error", err)
+		return err
+	}
+
+	var one int
+	err := s.db.Run(ctx, func(u *Unit) error {
+		return u.QueryRow("SELECT 1").Scan(&one)
+	})
+	if err != nil {
+		slog.Error("Cannot query the database", "error", err)
+		return err
+	}
+
+	return nil
+}
+
+func GetIngestionStatus(ctx context.Context, staleBefore time.Time) (IngestionStatus, error) {
+	return store.GetIngestionStatus(ctx, staleBefore)
+}
+
+func (s *SqlStore) GetIngestionStatus(ctx context.Context, staleBefore time.Time) (IngestionStatus, error) {
+	status := IngestionStatus{}
+	err := s.db.Run(ctx, func(u *Unit) error {
+		var lastReport sql.NullString
+		if err := u.QueryRow("SELECT MAX(LastUpdateDate) FROM UpdateRecords").Scan(&lastReport); err != nil {
+			return err
+		}
+		if lastReport.Valid {
+			status.LastReport, _ = time.Parse(timestampLayout, ConvertDbTimestamp(lastReport.String))
+		}
+
+		// systems that never reported count from when they were added
+		return u.QueryRow(`SELECT COUNT(*), COUNT(CASE WHEN COALESCE(r.LastUpdateDate, s.CreationDate) < ? THEN 1 END)
+			FROM Systems s LEFT JOIN UpdateRecords r ON r.SystemId = s.Id`,
+			DbTimestamp(staleBefore)).Scan(&status.Systems, &status.StaleSystems)
+	})
+	if err != nil {
+		slog.Error("Cannot read the ingestion status", "error", err)
+		return IngestionStatus{}, err
+	}
+
+	return status, nil
+}
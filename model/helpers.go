@@ -20,9 +20,23 @@ package model
 
 import "time"
 
-func ConvertSqliteTimestamp(t string) string {
-	sqlTimestampFormat := "2006-01-02T15:04:05Z"
-	timeFormat := "2006-01-02 15:04:05"
-	createTime, _ := time.Parse(sqlTimestampFormat, t)
-	return createTime.Format(timeFormat)
+const timestampLayout = "2006-01-02 15:04:05"
+
+// dayLayout is how days are stored, in UTC
+const dayLayout = "2006-01-02"
+
+// ConvertDbTimestamp Formats a timestamp read from the database. Drivers hand
+// back DATETIME and TIMESTAMP columns as RFC 3339 times, columns without a
+// date type come back as they were stored
+func ConvertDbTimestamp(t string) string {
+	createTime, err := time.Parse(time.RFC3339Nano, t)
+	if err != nil {
+		createTime, _ = time.Parse(timestampLayout, t)
+	}
+	return createTime.UTC().Format(timestampLayout)
+}
+
+// DbTimestamp Returns a time in the UTC format timestamps are stored in
+func DbTimestamp(t time.Time) string {
+	return t.UTC().Format(timestampLayout)
 }
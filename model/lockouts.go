@@ -0,0 +1,181 @@
+package model
+
+/*
+
+  Copyright 2024, YggdrasilSoft, LLC.
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+import (
+	"database/sql"
+	"log/slog"
+	"time"
+)
+
+// login event kinds recorded in LoginEvents
+const (
+	LoginEventSuccess  = "success"
+	LoginEventFailure  = "failure"
+	LoginEventLocked   = "locked"
+	LoginEventUnlocked = "unlocked"
+)
+
+func RecordLoginEvent(username string, remoteAddr string, event string, reason string) (bool, error) {
+	err := run(func(u *Unit) error {
+		return recordLoginEvent(u, username, remoteAddr, event, reason)
+	})
+	if err != nil {
+		slog.Error("Cannot record login event", "user", username, "error", err)
+		return false, err
+	}
+
+	return true, nil
+}
+
+// GetLoginEvents Returns the most recent login events of a user, newest first
+func GetLoginEvents(username string, limit int) ([]LoginEvent, error) {
+	slog.Debug("Login history requested", "user", username)
+	events := make([]LoginEvent, 0)
+	err := run(func(u *Unit) error {
+		return u.Each(`SELECT Id, UserName, RemoteAddr, Event, Reason, CreationDate FROM LoginEvents
+			WHERE UserName = ? ORDER BY Id DESC LIMIT ?`, []any{username, limit}, func(rows *sql.Rows) error {
+			event := LoginEvent{}
+			err := rows.Scan(&event.Id, &event.UserName, &event.RemoteAddr, &event.Event, &event.Reason, &event.CreationDate)
+			if err != nil {
+				return err
+			}
+			event.CreationDate = ConvertDbTimestamp(event.CreationDate)
+			events = append(events, event)
+			return nil
+		})
+	})
+	if err != nil {
+		slog.Error("Cannot retrieve login events", "user", username, "error", err)
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// CountLoginFailures Returns the failed logins of a user since the given time,
+// not counting those before their last successful login or unlock
+func CountLoginFailures(username string, since time.Time) (int, error) {
+	count := 0
+	err := run(func(u *Unit) error {
+		return u.QueryRow(`SELECT COUNT(*) FROM LoginEvents
+			WHERE UserName = ? AND Event = ? AND CreationDate > ?
+			AND Id > COALESCE((SELECT MAX(Id) FROM LoginEvents WHERE UserName = ? AND Event IN (?, ?)), 0)`,
+			username, LoginEventFailure, DbTimestamp(since),
+			username, LoginEventSuccess, LoginEventUnlocked,
+		).Scan(&count)
+	})
+	if err != nil {
+		slog.Error("Cannot count login failures", "user", username, "error", err)
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// CountLoginFailuresFromAddr Returns the failed logins from an address since the given time
+func CountLoginFailuresFromAddr(remoteAddr string, since time.Time) (int, error) {
+	count := 0
+	err := run(func(u *Unit) error {
+		return u.QueryRow("SELECT COUNT(*) FROM LoginEvents WHERE RemoteAddr = ? AND Event = ? AND CreationDate > ?",
+			remoteAddr, LoginEventFailure, DbTimestamp(since),
+		).Scan(&count)
+	})
+	if err != nil {
+		slog.Error("Cannot count login failures", "clientIp", remoteAddr, "error", err)
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// GetLockout Returns why and until when a user is locked, or an empty lockout if they are not
+func GetLockout(username string) (Lockout, error) {
+	lockout := Lockout{}
+	unlockDate := sql.NullString{}
+	err := run(func(u *Unit) error {
+		return u.QueryRow(`SELECT u.UserName, l.Reason, l.CreationDate, l.UnlockDate
+			FROM Lockouts l JOIN Users u ON u.Id = l.UserId
+			WHERE u.UserName = ?`, username).Scan(
+			&lockout.UserName,
+			&lockout.Reason,
+			&lockout.LockedDate,
+			&unlockDate,
+		)
+	})
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return Lockout{}, nil
+		}
+		slog.Error("Cannot retrieve lockout", "user", username, "error", err)
+		return Lockout{}, err
+	}
+
+	lockout.LockedDate = ConvertDbTimestamp(lockout.LockedDate)
+	if unlockDate.Valid {
+		lockout.UnlockDate = ConvertDbTimestamp(unlockDate.String)
+	}
+
+	return lockout, nil
+}
+
+// LockUser Locks a user through SetUserStatus, to be unlocked automatically
+// at unlockDate unless it is the zero time
+func LockUser(username string, reason string, unlockDate time.Time) (bool, error) {
+	_, err := SetUserStatus(username, UserStatus{Status: "locked", Reason: reason})
+	if err != nil {
+		return false, err
+	}
+
+	if !unlockDate.IsZero() {
+		err = run(func(u *Unit) error {
+			_, err := u.Exec("UPDATE Lockouts SET UnlockDate = ? WHERE UserId = (SELECT Id FROM Users WHERE UserName = ?)",
+				DbTimestamp(unlockDate), username)
+			return err
+		})
+		if err != nil {
+			slog.Error("Cannot set unlock date", "user", username, "error", err)
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// setLockout Records the reason for a lock, replacing any earlier one
+func setLockout(u *Unit, username string, reason string) error {
+	_, err := u.Exec(`INSERT INTO Lockouts (UserId, Reason)
+		SELECT Id, CAST(? AS TEXT) FROM Users WHERE UserName = ?
+		ON CONFLICT (UserId) DO UPDATE SET
+			Reason = excluded.Reason,
+			CreationDate = ?,
+			UnlockDate = NULL`, reason, username, DbTimestamp(time.Now()))
+	return err
+}
+
+func recordLoginEvent(u *Unit, username string, remoteAddr string, event string, reason string) error {
+	_, err := u.Exec("INSERT INTO LoginEvents (UserName, RemoteAddr, Event, Reason) VALUES (?, ?, ?, ?)",
+		username, remoteAddr, event, reason)
+	return err
+}
+
+func clearLockout(u *Unit, username string) error {
+	_, err := u.Exec("DELETE FROM Lockouts WHERE UserId = (SELECT Id FROM Users WHERE UserName = ?)", username)
+	return err
+}
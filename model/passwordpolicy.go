@@ -0,0 +1,169 @@
+package model
+
+/*
+
+  Copyright 2024, YggdrasilSoft, LLC.
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+import (
+	"database/sql"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/greeneg/update-reporterd/globals"
+)
+
+var (
+	passwordPolicyMu sync.RWMutex
+	passwordPolicy   = globals.PasswordPolicyConfig{
+		MinLength: globals.DefaultPasswordMinLength,
+	}
+)
+
+// SetPasswordPolicy Sets the policy enforced when passwords are created or changed
+func SetPasswordPolicy(p globals.PasswordPolicyConfig) {
+	if p.MinLength <= 0 {
+		p.MinLength = globals.DefaultPasswordMinLength
+	}
+	passwordPolicyMu.Lock()
+	defer passwordPolicyMu.Unlock()
+	passwordPolicy = p
+}
+
+func currentPasswordPolicy() globals.PasswordPolicyConfig {
+	passwordPolicyMu.RLock()
+	defer passwordPolicyMu.RUnlock()
+	return passwordPolicy
+}
+
+// ValidatePassword Returns a PasswordPolicyViolation listing every rule the password breaks
+func ValidatePassword(password string) error {
+	policy := currentPasswordPolicy()
+	reasons := make([]string, 0)
+
+	if len([]rune(password)) < policy.MinLength {
+		reasons = append(reasons, "must be at least "+strconv.Itoa(policy.MinLength)+" characters long")
+	}
+
+	var hasUpper, hasLower, hasDigit, hasSymbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			hasSymbol = true
+		}
+	}
+	if policy.RequireUpper && !hasUpper {
+		reasons = append(reasons, "must contain an upper case letter")
+	}
+	if policy.RequireLower && !hasLower {
+		reasons = append(reasons, "must contain a lower case letter")
+	}
+	if policy.RequireDigit && !hasDigit {
+		reasons = append(reasons, "must contain a digit")
+	}
+	if policy.RequireSymbol && !hasSymbol {
+		reasons = append(reasons, "must contain a symbol")
+	}
+
+	if len(reasons) > 0 {
+		return &PasswordPolicyViolation{Reasons: reasons}
+	}
+	return nil
+}
+
+// IsPasswordExpired Returns whether the user's password is older than the policy allows
+func IsPasswordExpired(u User) bool {
+	policy := currentPasswordPolicy()
+	if policy.MaxAgeDays <= 0 || u.LastPasswordChangedDate == "" {
+		return false
+	}
+	// the directory enforces its own policy for external accounts
+	if u.AuthSource != "" && u.AuthSource != AuthSourceLocal {
+		return false
+	}
+
+	changed, err := time.Parse("2006-01-02 15:04:05", u.LastPasswordChangedDate)
+	if err != nil {
+		slog.Error("Cannot parse password change date", "user", u.UserName, "error", err)
+		return false
+	}
+
+	maxAge := time.Duration(policy.MaxAgeDays) * 24 * time.Hour
+	return time.Since(changed) > maxAge
+}
+
+// checkPasswordReuse Returns a PasswordPolicyViolation if the password matches
+// the current hash or one of the user's remembered previous hashes
+func checkPasswordReuse(username string, currentHash string, password string) error {
+	policy := currentPasswordPolicy()
+	if policy.HistoryCount <= 0 {
+		return nil
+	}
+
+	hashes, err := store.GetPasswordHistory(username, policy.HistoryCount)
+	if err != nil {
+		return err
+	}
+	hashes = append([]string{currentHash}, hashes...)
+
+	for _, hash := range hashes {
+		match, _, err := VerifyPassword(password, hash)
+		if err != nil {
+			// unreadable hashes cannot be compared, so skip them
+			continue
+		}
+		if match {
+			return &PasswordPolicyViolation{Reasons: []string{
+				"must not be the current password or one of the last " + strconv.Itoa(policy.HistoryCount),
+			}}
+		}
+	}
+
+	return nil
+}
+
+func (s *SqlStore) GetPasswordHistory(username string, count int) ([]string, error) {
+	hashes := make([]string, 0)
+	err := s.run(func(u *Unit) error {
+		return u.Each(`SELECT h.PasswordHash FROM PasswordHistory h
+			JOIN Users u ON u.Id = h.UserId
+			WHERE u.UserName = ?
+			ORDER BY h.Id DESC
+			LIMIT ?`, []any{username, count}, func(rows *sql.Rows) error {
+			hash := ""
+			if err := rows.Scan(&hash); err != nil {
+				return err
+			}
+			hashes = append(hashes, hash)
+			return nil
+		})
+	})
+	if err != nil {
+		slog.Error("Could not retrieve password history", "error", err)
+		return nil, err
+	}
+
+	return hashes, nil
+}
@@ -0,0 +1,151 @@
+package model
+
+/*
+
+  Copyright 2024, YggdrasilSoft, LLC.
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+import (
+	"crypto/rand"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// argon2Params holds the tunables encoded into every stored argon2id hash
+type argon2Params struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// defaultArgon2Params are the parameters used for all newly hashed passwords.
+// Stored hashes using different parameters are flagged for rehashing.
+var defaultArgon2Params = argon2Params{
+	Memory:      64 * 1024,
+	Iterations:  3,
+	Parallelism: 2,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+const argon2idPrefix = "$argon2id$"
+
+// legacy hashes are unsalted sha512 digests, hex encoded
+const legacySha512HexLength = sha512.Size * 2
+
+// HashPassword Returns the encoded argon2id hash of a password with a random salt
+func HashPassword(password string) (string, error) {
+	p := defaultArgon2Params
+
+	salt := make([]byte, p.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2idPrefix,
+		argon2.Version,
+		p.Memory, p.Iterations, p.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// VerifyPassword Returns whether a password matches a stored hash, and whether
+// the stored hash should be replaced with one using the current scheme
+func VerifyPassword(password string, storedHash string) (bool, bool, error) {
+	if strings.HasPrefix(storedHash, argon2idPrefix) {
+		return verifyArgon2idPassword(password, storedHash)
+	}
+
+	if isLegacyPasswordHash(storedHash) {
+		sha := sha512.Sum512([]byte(password))
+		legacyHash := hex.EncodeToString(sha[:])
+		match := subtle.ConstantTimeCompare([]byte(legacyHash), []byte(strings.ToLower(storedHash))) == 1
+		// legacy hashes always get upgraded once we know the password
+		return match, match, nil
+	}
+
+	return false, false, &UnsupportedPasswordHash{Err: errors.New("unrecognized password hash format")}
+}
+
+func isLegacyPasswordHash(storedHash string) bool {
+	if len(storedHash) != legacySha512HexLength {
+		return false
+	}
+	_, err := hex.DecodeString(storedHash)
+	return err == nil
+}
+
+func decodeArgon2idHash(encodedHash string) (argon2Params, []byte, []byte, error) {
+	// format: $argon2id$v=19$m=65536,t=3,p=2$<salt>$<key>
+	fields := strings.Split(encodedHash, "$")
+	if len(fields) != 6 {
+		return argon2Params{}, nil, nil, &UnsupportedPasswordHash{Err: errors.New("malformed argon2id hash")}
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(fields[2], "v=%d", &version); err != nil {
+		return argon2Params{}, nil, nil, &UnsupportedPasswordHash{Err: err}
+	}
+	if version != argon2.Version {
+		return argon2Params{}, nil, nil, &UnsupportedPasswordHash{Err: errors.New("unsupported argon2 version")}
+	}
+
+	p := argon2Params{}
+	if _, err := fmt.Sscanf(fields[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Iterations, &p.Parallelism); err != nil {
+		return argon2Params{}, nil, nil, &UnsupportedPasswordHash{Err: err}
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(fields[4])
+	if err != nil {
+		return argon2Params{}, nil, nil, &UnsupportedPasswordHash{Err: err}
+	}
+	p.SaltLength = uint32(len(salt))
+
+	key, err := base64.RawStdEncoding.DecodeString(fields[5])
+	if err != nil {
+		return argon2Params{}, nil, nil, &UnsupportedPasswordHash{Err: err}
+	}
+	p.KeyLength = uint32(len(key))
+
+	return p, salt, key, nil
+}
+
+func verifyArgon2idPassword(password string, encodedHash string) (bool, bool, error) {
+	p, salt, key, err := decodeArgon2idHash(encodedHash)
+	if err != nil {
+		return false, false, err
+	}
+
+	otherKey := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+	if subtle.ConstantTimeCompare(key, otherKey) != 1 {
+		return false, false, nil
+	}
+
+	return true, p != defaultArgon2Params, nil
+}
@@ -0,0 +1,205 @@
+package model
+
+/*
+
+  Copyright 2024, YggdrasilSoft, LLC.
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"time"
+)
+
+// sqliteAutoVacuumIncremental is the auto_vacuum mode that lets
+// incremental_vacuum hand free pages back to the filesystem
+const sqliteAutoVacuumIncremental = 2
+
+func PruneUpdateReports(before time.Time, limit int) (int64, error) {
+	return store.PruneUpdateReports(before, limit)
+}
+
+func (s *SqlStore) PruneUpdateReports(before time.Time, limit int) (int64, error) {
+	deleted, err := s.prune(`DELETE FROM UpdateReports WHERE Id IN
+		(SELECT Id FROM UpdateReports WHERE CreationDate < ? ORDER BY Id LIMIT ?)`,
+		DbTimestamp(before), limit)
+	if err != nil {
+		slog.Error("Cannot prune update reports", "error", err)
+		return 0, err
+	}
+
+	return deleted, nil
+}
+
+func PruneDailyUpdateCounts(before time.Time, limit int) (int64, error) {
+	return store.PruneDailyUpdateCounts(before, limit)
+}
+
+func (s *SqlStore) PruneDailyUpdateCounts(before time.Time, limit int) (int64, error) {
+	deleted, err := s.prune(`DELETE FROM DailyUpdateCounts WHERE (SystemId, Day) IN
+		(SELECT SystemId, Day FROM DailyUpdateCounts WHERE Day < ? ORDER BY Day LIMIT ?)`,
+		before.UTC().Format(dayLayout), limit)
+	if err != nil {
+		slog.Error("Cannot prune daily update counts", "error", err)
+		return 0, err
+	}
+
+	return deleted, nil
+}
+
+func (s *SqlStore) prune(query string, args ...any) (int64, error) {
+	var numberOfRows int64
+	err := s.run(func(u *Unit) error {
+		result, err := u.Exec(query, args...)
+		if err != nil {
+			return err
+		}
+		numberOfRows, err = result.RowsAffected()
+		return err
+	})
+
+	return numberOfRows, err
+}
+
+func PruneSilentSystems(before time.Time, limit int) (int64, error) {
+	return store.PruneSilentSystems(before, limit)
+}
+
+func (s *SqlStore) PruneSilentSystems(before time.Time, limit int) (int64, error) {
+	var numberOfRows int64
+	err := s.transaction(func(u *Unit) error {
+		// systems that never reported count from when they were added
+		ids := make([]int, 0)
+		err := u.Each(`SELECT s.Id FROM Systems s LEFT JOIN UpdateRecords r ON r.SystemId = s.Id
+			WHERE COALESCE(r.LastUpdateDate, s.CreationDate) < ? ORDER BY s.Id LIMIT ?`,
+			[]any{DbTimestamp(before), limit}, func(rows *sql.Rows) error {
+				var id int
+				if err := rows.Scan(&id); err != nil {
+					return err
+				}
+				ids = append(ids, id)
+				return nil
+			})
+		if err != nil {
+			return err
+		}
+
+		for _, id := range ids {
+			// the history goes with the system, UpdateRecords does not cascade
+			if _, err := u.Exec("DELETE FROM UpdateRecords WHERE SystemId = ?", id); err != nil {
+				return err
+			}
+			if _, err := u.Exec("DELETE FROM Systems WHERE Id = ?", id); err != nil {
+				return err
+			}
+		}
+		numberOfRows = int64(len(ids))
+		return nil
+	})
+	if err != nil {
+		slog.Error("Cannot prune decommissioned systems", "error", err)
+		return 0, err
+	}
+
+	return numberOfRows, nil
+}
+
+func ReclaimSpace() (int64, error) {
+	return store.ReclaimSpace()
+}
+
+func (s *SqlStore) ReclaimSpace() (int64, error) {
+	// PostgreSQL's autovacuum makes the space of deleted rows reusable
+	if s.db.Dialect != DialectSqlite {
+		return 0, nil
+	}
+
+	var mode, before, after int64
+	err := s.run(func(u *Unit) error {
+		if err := u.QueryRow("PRAGMA auto_vacuum").Scan(&mode); err != nil {
+			return err
+		}
+		return u.QueryRow("PRAGMA freelist_count").Scan(&before)
+	})
+	if err != nil {
+		slog.Error("Cannot read the database vacuum state", "error", err)
+		return 0, err
+	}
+
+	if mode != sqliteAutoVacuumIncremental {
+		// switching needs a full vacuum, which locks out writers for as long
+		// as it takes, so it is left to an administrator
+		slog.Warn("Database is not in incremental vacuum mode, run 'setuptool vacuum' to free the space of pruned rows",
+			"freePages", before)
+		return 0, nil
+	}
+
+	err = s.run(func(u *Unit) error {
+		// each step of the pragma frees one page, so its rows are read to the end
+		err := u.Each("PRAGMA incremental_vacuum", nil, func(rows *sql.Rows) error {
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		return u.QueryRow("PRAGMA freelist_count").Scan(&after)
+	})
+	if err != nil {
+		slog.Error("Cannot vacuum the database", "error", err)
+		return 0, err
+	}
+
+	return before - after, nil
+}
+
+// EnableIncrementalVacuum Switches a SQLite database to incremental vacuum
+// mode, which takes a full vacuum rewriting the whole file. Writers wait for
+// it, so it is run by an administrator rather than the daemon. Returns false
+// if there was nothing to switch
+func EnableIncrementalVacuum(ctx context.Context, db *Database) (bool, error) {
+	if db.Dialect != DialectSqlite {
+		return false, nil
+	}
+
+	// the mode and the vacuum must run on the same connection
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	var mode int64
+	if err := conn.QueryRowContext(ctx, "PRAGMA auto_vacuum").Scan(&mode); err != nil {
+		slog.Error("Cannot read the database vacuum mode", "error", err)
+		return false, err
+	}
+	if mode == sqliteAutoVacuumIncremental {
+		return false, nil
+	}
+
+	slog.Info("Switching the database to incremental vacuum, running a full vacuum")
+	if _, err := conn.ExecContext(ctx, "PRAGMA auto_vacuum = INCREMENTAL"); err != nil {
+		slog.Error("Cannot set the database vacuum mode", "error", err)
+		return false, err
+	}
+	if _, err := conn.ExecContext(ctx, "VACUUM"); err != nil {
+		slog.Error("Cannot vacuum the database", "error", err)
+		return false, err
+	}
+
+	return true, nil
+}
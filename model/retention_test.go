@@ -0,0 +1,98 @@
+package model_test
+
+/*
+
+  Copyright 2024, YggdrasilSoft, LLC.
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/greeneg/update-reporterd/migrations"
+	"github.com/greeneg/update-reporterd/model"
+	"github.com/greeneg/update-reporterd/model/storetest"
+)
+
+func vacuumMode(t *testing.T, db *model.Database) int {
+	t.Helper()
+	var mode int
+	if err := db.QueryRow("PRAGMA auto_vacuum").Scan(&mode); err != nil {
+		t.Fatal(err)
+	}
+	return mode
+}
+
+func TestNewDatabaseVacuumsIncrementally(t *testing.T) {
+	db := storetest.OpenDatabase(t)
+	if mode := vacuumMode(t, db); mode != 2 {
+		t.Errorf("a new database has vacuum mode %d, expected incremental", mode)
+	}
+}
+
+func TestOnlyAnAdministratorSwitchesTheVacuumMode(t *testing.T) {
+	// a file made before the mode was set when creating it
+	path := filepath.Join(t.TempDir(), "legacy.db")
+	legacy, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := legacy.Exec("CREATE TABLE Legacy (Id INTEGER PRIMARY KEY)"); err != nil {
+		t.Fatal(err)
+	}
+	legacy.Close()
+
+	db, err := model.OpenDatabase(model.DialectSqlite, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := migrations.Up(context.Background(), db, false); err != nil {
+		t.Fatal(err)
+	}
+	model.SetStore(model.NewSqlStore(db))
+
+	if _, err := model.ReclaimSpace(); err != nil {
+		t.Fatal(err)
+	}
+	if mode := vacuumMode(t, db); mode != 0 {
+		t.Errorf("reclaiming space switched the vacuum mode to %d", mode)
+	}
+
+	switched, err := model.EnableIncrementalVacuum(context.Background(), db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !switched {
+		t.Error("a database without auto vacuum was not switched")
+	}
+	if mode := vacuumMode(t, db); mode != 2 {
+		t.Errorf("the switched database has vacuum mode %d, expected incremental", mode)
+	}
+
+	switched, err = model.EnableIncrementalVacuum(context.Background(), db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if switched {
+		t.Error("an incremental database was switched again")
+	}
+	if _, err := model.ReclaimSpace(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -20,147 +20,341 @@ package model
 
 import (
 	"database/sql"
-	"log"
+	"errors"
+	"log/slog"
 	"strconv"
+	"strings"
 )
 
-func CreateRole(r Role) (bool, error) {
-	log.Println("INFO: User creation requested: " + r.RoleName)
-	t, err := DB.Begin()
+// Ids of the built-in roles created along with the database
+const (
+	SystemRoleId         = 1
+	AdministratorsRoleId = 2
+)
+
+const roleColumns = "Id, RoleName, Description, TwoFactorRequired, CreationDate"
+
+func scanRole(scanner interface{ Scan(...any) error }) (Role, error) {
+	role := Role{}
+	err := scanner.Scan(
+		&role.Id,
+		&role.RoleName,
+		&role.Description,
+		&role.TwoFactorRequired,
+		&role.CreationDate,
+	)
 	if err != nil {
-		log.Println("ERROR: Could not start DB transaction!" + string(err.Error()))
-		return false, err
+		return Role{}, err
 	}
 
-	q, err := t.Prepare("INSERT INTO Roles (RoleName, Description) VALUES (?, ?)")
+	role.CreationDate = ConvertDbTimestamp(role.CreationDate)
+
+	return role, nil
+}
+
+func CreateRole(r Role) (bool, error) {
+	slog.Debug("Role creation requested", "role", r.RoleName)
+	if strings.TrimSpace(r.RoleName) == "" {
+		return false, &Validation{Message: "Role name is required"}
+	}
+	existing, err := store.GetRoleByName(r.RoleName)
 	if err != nil {
-		log.Println("ERROR: Could not prepare the DB query!" + string(err.Error()))
 		return false, err
 	}
-
-	_, err = q.Exec(r.RoleName, r.Description)
+	if existing.Id != 0 {
+		return false, &Conflict{Message: "Role '" + r.RoleName + "' already exists"}
+	}
+	_, err = store.CreateRole(r)
 	if err != nil {
-		log.Println("ERROR: Cannot create user '" + r.RoleName + "': " + string(err.Error()))
 		return false, err
 	}
 
-	t.Commit()
-
-	log.Println("INFO: User '" + r.RoleName + "' created")
+	slog.Info("Role created", "role", r.RoleName)
 	return true, nil
 }
 
-func DeleteRole(roleId int) (bool, error) {
-	log.Println("INFO: Role deletion requested: " + strconv.Itoa(roleId))
-	t, err := DB.Begin()
+func (s *SqlStore) CreateRole(r Role) (bool, error) {
+	err := s.run(func(u *Unit) error {
+		_, err := u.Exec("INSERT INTO Roles (RoleName, Description, TwoFactorRequired) VALUES (?, ?, ?)",
+			r.RoleName, r.Description, r.TwoFactorRequired)
+		return err
+	})
 	if err != nil {
-		log.Println("ERROR: Could not start DB transaction!" + string(err.Error()))
+		slog.Error("Cannot create role", "role", r.RoleName, "error", err)
 		return false, err
 	}
 
-	q, err := DB.Prepare("DELETE FROM Roles WHERE Id IS ?")
+	return true, nil
+}
+
+// isBuiltInRole Returns whether a role is one of those created along with
+// the database, which the service relies on
+func isBuiltInRole(roleId int) bool {
+	return roleId == SystemRoleId || roleId == AdministratorsRoleId
+}
+
+// noSuchRole Returns the error for a role Id that is not known
+func noSuchRole(roleId int) error {
+	return &NotFound{Message: "No such role Id " + strconv.Itoa(roleId)}
+}
+
+// DeleteRole Deletes a role. Its users are moved to the role reassignTo, or
+// if that is 0 the role must have no users left
+func DeleteRole(roleId int, reassignTo int) (bool, error) {
+	slog.Debug("Role deletion requested", "roleId", roleId, "reassignTo", reassignTo)
+	if isBuiltInRole(roleId) {
+		return false, &Forbidden{Message: "Built-in roles cannot be removed"}
+	}
+	role, err := store.GetRoleById(roleId)
 	if err != nil {
-		log.Println("ERROR: Could not prepare the DB query!" + string(err.Error()))
 		return false, err
 	}
+	if role.Id == 0 {
+		return false, noSuchRole(roleId)
+	}
+
+	// whether the role still has users is checked by the store in the same
+	// transaction as the delete, so none can be assigned in between
+	if reassignTo != 0 {
+		if reassignTo == roleId {
+			return false, &Validation{Message: "Users cannot be reassigned to the role being removed"}
+		}
+		err = checkRoleExists(reassignTo)
+		if err != nil {
+			return false, err
+		}
+	}
 
-	_, err = q.Exec(roleId)
+	numberOfRows, err := store.DeleteRole(roleId, reassignTo)
 	if err != nil {
-		log.Println("ERROR: Cannot delete user '" + strconv.Itoa(roleId) + "': " + string(err.Error()))
 		return false, err
 	}
+	if numberOfRows == 0 {
+		return false, noSuchRole(roleId)
+	}
 
-	t.Commit()
-
-	log.Println("INFO: Role with Id '" + strconv.Itoa(roleId) + "' has been deleted")
+	slog.Info("Role has been deleted", "roleId", roleId, "role", role.RoleName, "reassignTo", reassignTo)
 	return true, nil
 }
 
-func GetRoles() ([]Role, error) {
-	log.Println("INFO: List of role object requested")
-	rows, err := DB.Query("SELECT * FROM Roles")
+func (s *SqlStore) DeleteRole(roleId int, reassignTo int) (int64, error) {
+	var numberOfRows int64
+	err := s.transaction(func(u *Unit) error {
+		// SQLite transactions hold the write lock from the start. On
+		// PostgreSQL, locking the role makes a concurrent assignment to it
+		// wait until this transaction is done
+		if s.db.Dialect == DialectPostgres {
+			var id int
+			err := u.QueryRow("SELECT Id FROM Roles WHERE Id = ? FOR UPDATE", roleId).Scan(&id)
+			if err == sql.ErrNoRows {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		if reassignTo != 0 {
+			_, err := u.Exec("UPDATE Users SET RoleId = ? WHERE RoleId = ?", reassignTo, roleId)
+			if err != nil {
+				return err
+			}
+		} else {
+			userNames := make([]string, 0)
+			err := u.Each("SELECT UserName FROM Users WHERE RoleId = ? ORDER BY UserName", []any{roleId}, func(rows *sql.Rows) error {
+				var userName string
+				if err := rows.Scan(&userName); err != nil {
+					return err
+				}
+				userNames = append(userNames, userName)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			if len(userNames) > 0 {
+				return &RoleInUse{RoleId: roleId, UserNames: userNames}
+			}
+		}
+		result, err := u.Exec("DELETE FROM Roles WHERE Id = ?", roleId)
+		if err != nil {
+			return err
+		}
+		numberOfRows, err = result.RowsAffected()
+		return err
+	})
+	var inUse *RoleInUse
+	if errors.As(err, &inUse) {
+		return 0, err
+	}
 	if err != nil {
-		log.Println("ERROR: Could not run the DB query!" + string(err.Error()))
-		return nil, err
+		slog.Error("Cannot delete role", "roleId", roleId, "error", err)
+		return 0, err
 	}
 
-	roles := make([]Role, 0)
-	for rows.Next() {
-		role := Role{}
-		err = rows.Scan(
-			&role.Id,
-			&role.RoleName,
-			&role.Description,
-			&role.CreationDate,
-		)
+	return numberOfRows, nil
+}
+
+// UpdateRole Changes the name, description or two-factor requirement of a
+// role, returning the stored role. Built-in roles keep their names
+func UpdateRole(roleId int, update RoleUpdate) (Role, error) {
+	slog.Debug("Role update requested", "roleId", roleId)
+	role, err := store.GetRoleById(roleId)
+	if err != nil {
+		return Role{}, err
+	}
+	if role.Id == 0 {
+		return Role{}, noSuchRole(roleId)
+	}
+
+	if update.RoleName != nil && *update.RoleName != role.RoleName {
+		if isBuiltInRole(roleId) {
+			return Role{}, &Forbidden{Message: "Built-in roles cannot be renamed"}
+		}
+		if strings.TrimSpace(*update.RoleName) == "" {
+			return Role{}, &Validation{Message: "Role name is required"}
+		}
+		existing, err := store.GetRoleByName(*update.RoleName)
 		if err != nil {
-			log.Println("ERROR: Cannot marshal the user objects!" + string(err.Error()))
-			return nil, err
+			return Role{}, err
+		}
+		if existing.Id != 0 {
+			return Role{}, &Conflict{Message: "Role '" + *update.RoleName + "' already exists"}
 		}
+		role.RoleName = *update.RoleName
+	}
+	if update.Description != nil {
+		role.Description = *update.Description
+	}
+	if update.TwoFactorRequired != nil {
+		role.TwoFactorRequired = *update.TwoFactorRequired
+	}
+
+	numberOfRows, err := store.UpdateRole(role)
+	if err != nil {
+		return Role{}, err
+	}
+	// the role may have been removed since it was read
+	if numberOfRows == 0 {
+		return Role{}, noSuchRole(roleId)
+	}
 
-		role.CreationDate = ConvertSqliteTimestamp(role.CreationDate)
+	slog.Info("Role updated", "roleId", roleId, "role", role.RoleName)
+	return store.GetRoleById(roleId)
+}
 
-		roles = append(roles, role)
+func (s *SqlStore) UpdateRole(role Role) (int64, error) {
+	var numberOfRows int64
+	err := s.run(func(u *Unit) error {
+		result, err := u.Exec("UPDATE Roles SET RoleName = ?, Description = ?, TwoFactorRequired = ? WHERE Id = ?",
+			role.RoleName, role.Description, role.TwoFactorRequired, role.Id)
+		if err != nil {
+			return err
+		}
+		numberOfRows, err = result.RowsAffected()
+		return err
+	})
+	if err != nil {
+		slog.Error("Cannot update role", "roleId", role.Id, "error", err)
+		return 0, err
 	}
 
-	log.Println("INFO: List of all users retrieved")
-	return roles, nil
+	return numberOfRows, nil
 }
 
-func GetRoleById(id int) (Role, error) {
-	log.Println("INFO: Role by Id requested: " + strconv.Itoa(id))
-	rec, err := DB.Prepare("SELECT * FROM Roles WHERE Id = ?")
+func GetRoles() ([]Role, error) {
+	slog.Debug("List of role object requested")
+	return store.GetRoles()
+}
+
+func (s *SqlStore) GetRoles() ([]Role, error) {
+	roles := make([]Role, 0)
+	err := s.run(func(u *Unit) error {
+		return u.Each("SELECT "+roleColumns+" FROM Roles", nil, func(rows *sql.Rows) error {
+			role, err := scanRole(rows)
+			if err != nil {
+				return err
+			}
+			roles = append(roles, role)
+			return nil
+		})
+	})
 	if err != nil {
-		log.Println("ERROR: Could not prepare the DB query!" + string(err.Error()))
-		return Role{}, err
+		slog.Error("Cannot marshal the role objects", "error", err)
+		return nil, err
 	}
 
+	slog.Debug("List of all roles retrieved")
+	return roles, nil
+}
+
+func GetRoleById(id int) (Role, error) {
+	slog.Debug("Role by Id requested", "roleId", id)
+	return store.GetRoleById(id)
+}
+
+func (s *SqlStore) GetRoleById(id int) (Role, error) {
 	role := Role{}
-	err = rec.QueryRow(id).Scan(
-		&role.Id,
-		&role.RoleName,
-		&role.Description,
-		&role.CreationDate,
-	)
+	err := s.run(func(u *Unit) error {
+		var err error
+		role, err = scanRole(u.QueryRow("SELECT "+roleColumns+" FROM Roles WHERE Id = ?", id))
+		return err
+	})
 	if err != nil {
 		if err == sql.ErrNoRows {
-			log.Println("ERROR: No such user found in DB: " + string(err.Error()))
+			slog.Error("No such role found in DB", "error", err)
 			return Role{}, nil
 		}
-		log.Println("ERROR: Cannot retrieve user from DB: " + string(err.Error()))
+		slog.Error("Cannot retrieve role from DB", "error", err)
 		return Role{}, err
 	}
 
-	role.CreationDate = ConvertSqliteTimestamp(role.CreationDate)
-
 	return role, nil
 }
 
 func GetRoleByName(roleName string) (Role, error) {
-	log.Println("INFO: Role by Id requested: " + roleName)
-	rec, err := DB.Prepare("SELECT * FROM Roles WHERE RoleName = ?")
-	if err != nil {
-		log.Println("ERROR: Could not prepare the DB query!" + string(err.Error()))
-		return Role{}, err
-	}
+	slog.Debug("Role by name requested", "role", roleName)
+	return store.GetRoleByName(roleName)
+}
 
+func (s *SqlStore) GetRoleByName(roleName string) (Role, error) {
 	role := Role{}
-	err = rec.QueryRow(roleName).Scan(
-		&role.Id,
-		&role.RoleName,
-		&role.Description,
-		&role.CreationDate,
-	)
+	err := s.run(func(u *Unit) error {
+		var err error
+		role, err = scanRole(u.QueryRow("SELECT "+roleColumns+" FROM Roles WHERE RoleName = ?", roleName))
+		return err
+	})
 	if err != nil {
 		if err == sql.ErrNoRows {
-			log.Println("ERROR: No such user found in DB: " + string(err.Error()))
+			slog.Error("No such role found in DB", "error", err)
 			return Role{}, nil
 		}
-		log.Println("ERROR: Cannot retrieve user from DB: " + string(err.Error()))
+		slog.Error("Cannot retrieve role from DB", "error", err)
 		return Role{}, err
 	}
 
-	role.CreationDate = ConvertSqliteTimestamp(role.CreationDate)
-
 	return role, nil
 }
+
+func SetRoleTwoFactorRequired(roleId int, j RoleTwoFactorRequired) (bool, error) {
+	slog.Debug("Set two-factor requirement", "roleId", roleId)
+	return store.SetRoleTwoFactorRequired(roleId, j.TwoFactorRequired)
+}
+
+func (s *SqlStore) SetRoleTwoFactorRequired(roleId int, required bool) (bool, error) {
+	var numberOfRows int64
+	err := s.run(func(u *Unit) error {
+		result, err := u.Exec("UPDATE Roles SET TwoFactorRequired = ? WHERE Id = ?", required, roleId)
+		if err != nil {
+			return err
+		}
+		numberOfRows, err = result.RowsAffected()
+		return err
+	})
+	if err != nil {
+		slog.Error("Could not execute query", "roleId", roleId, "error", err)
+		return false, err
+	}
+
+	return numberOfRows > 0, nil
+}
@@ -0,0 +1,173 @@
+package model
+
+/*
+
+  Copyright 2024, YggdrasilSoft, LLC.
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+import (
+	"database/sql"
+	"log/slog"
+	"time"
+)
+
+const sessionColumns = "Id, UserName, Data, RemoteAddr, UserAgent, CreationDate, LastSeenDate, ExpiresDate"
+
+func scanSession(scanner interface{ Scan(...any) error }) (Session, error) {
+	session := Session{}
+	err := scanner.Scan(
+		&session.Id,
+		&session.UserName,
+		&session.Data,
+		&session.RemoteAddr,
+		&session.UserAgent,
+		&session.CreationDate,
+		&session.LastSeenDate,
+		&session.ExpiresDate,
+	)
+	if err != nil {
+		return Session{}, err
+	}
+
+	session.CreationDate = ConvertDbTimestamp(session.CreationDate)
+	session.LastSeenDate = ConvertDbTimestamp(session.LastSeenDate)
+	session.ExpiresDate = ConvertDbTimestamp(session.ExpiresDate)
+
+	return session, nil
+}
+
+func SaveSession(s Session) (bool, error) {
+	err := run(func(u *Unit) error {
+		_, err := u.Exec(`INSERT INTO Sessions (Id, UserName, Data, RemoteAddr, UserAgent, LastSeenDate, ExpiresDate)
+			VALUES (?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT (Id) DO UPDATE SET
+				UserName = excluded.UserName,
+				Data = excluded.Data,
+				LastSeenDate = excluded.LastSeenDate,
+				ExpiresDate = excluded.ExpiresDate`,
+			s.Id, s.UserName, s.Data, s.RemoteAddr, s.UserAgent, s.LastSeenDate, s.ExpiresDate)
+		return err
+	})
+	if err != nil {
+		slog.Error("Cannot save session", "error", err)
+		return false, err
+	}
+
+	return true, nil
+}
+
+// GetSessionById Returns an unexpired session, or an empty session if none was found
+func GetSessionById(id string) (Session, error) {
+	session := Session{}
+	err := run(func(u *Unit) error {
+		var err error
+		session, err = scanSession(u.QueryRow("SELECT "+sessionColumns+" FROM Sessions WHERE Id = ? AND ExpiresDate > ?",
+			id, DbTimestamp(time.Now())))
+		return err
+	})
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return Session{}, nil
+		}
+		slog.Error("Cannot retrieve session from DB", "error", err)
+		return Session{}, err
+	}
+
+	return session, nil
+}
+
+func TouchSession(id string) (bool, error) {
+	err := run(func(u *Unit) error {
+		_, err := u.Exec("UPDATE Sessions SET LastSeenDate = ? WHERE Id = ?", DbTimestamp(time.Now()), id)
+		return err
+	})
+	if err != nil {
+		slog.Error("Cannot update session last seen date", "error", err)
+		return false, err
+	}
+
+	return true, nil
+}
+
+func GetSessionsByUserName(username string) ([]Session, error) {
+	slog.Debug("List of sessions requested", "user", username)
+	sessions := make([]Session, 0)
+	err := run(func(u *Unit) error {
+		return u.Each("SELECT "+sessionColumns+" FROM Sessions WHERE UserName = ? AND ExpiresDate > ?",
+			[]any{username, DbTimestamp(time.Now())}, func(rows *sql.Rows) error {
+				session, err := scanSession(rows)
+				if err != nil {
+					return err
+				}
+				sessions = append(sessions, session)
+				return nil
+			})
+	})
+	if err != nil {
+		slog.Error("Cannot marshal the session objects", "error", err)
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+// deleteSessions Runs a DELETE on Sessions, returning how many were deleted
+func deleteSessions(query string, args ...any) (int64, error) {
+	var numberOfRows int64
+	err := run(func(u *Unit) error {
+		result, err := u.Exec(query, args...)
+		if err != nil {
+			return err
+		}
+		numberOfRows, err = result.RowsAffected()
+		return err
+	})
+
+	return numberOfRows, err
+}
+
+func DeleteSessionById(id string) (bool, error) {
+	numberOfRows, err := deleteSessions("DELETE FROM Sessions WHERE Id = ?", id)
+	if err != nil {
+		slog.Error("Cannot delete session", "error", err)
+		return false, err
+	}
+
+	return numberOfRows > 0, nil
+}
+
+// DeleteSessionsByUserName Revokes every server-side session belonging to a user
+func DeleteSessionsByUserName(username string) (int64, error) {
+	slog.Info("Revoking all sessions", "user", username)
+	numberOfRows, err := deleteSessions("DELETE FROM Sessions WHERE UserName = ?", username)
+	if err != nil {
+		slog.Error("Cannot revoke sessions", "user", username, "error", err)
+		return 0, err
+	}
+
+	slog.Info("Revoked sessions", "count", int(numberOfRows), "user", username)
+	return numberOfRows, nil
+}
+
+func DeleteExpiredSessions() (int64, error) {
+	numberOfRows, err := deleteSessions("DELETE FROM Sessions WHERE ExpiresDate <= ?", DbTimestamp(time.Now()))
+	if err != nil {
+		slog.Error("Cannot prune expired sessions", "error", err)
+		return 0, err
+	}
+
+	return numberOfRows, nil
+}
@@ -0,0 +1,176 @@
+package model
+
+/*
+
+  Copyright 2024, YggdrasilSoft, LLC.
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+import (
+	"context"
+	"time"
+)
+
+// UserStore keeps user accounts and their passwords
+type UserStore interface {
+	GetUserById(id int) (User, error)
+	GetUserByUserName(username string) (User, error)
+	GetUsers() ([]User, error)
+	GetUsersByRoleId(roleId int) ([]User, error)
+	CreateUser(user User) (bool, error)
+	DeleteUser(username string) (bool, error)
+	GetUserStatus(username string) (string, error)
+	// SetUserStatus also records or clears the lockout reason of a locked user
+	SetUserStatus(username string, status string, reason string) (int64, error)
+	SetUserRoleId(username string, roleId int) (int64, error)
+	// UpdateUser stores the full name, role and organizational unit of a user
+	UpdateUser(user User) (int64, error)
+	OrgUnitExists(orgUnitId int) (bool, error)
+	SaveExternalUser(username string, fullName string, roleId int, authSource string) (bool, error)
+	// SaveOidcUser creates or refreshes the single sign-on user known by the
+	// issuer and subject, returning an empty User if a new user's name is taken
+	SaveOidcUser(issuer string, subject string, username string, fullName string, roleId int) (User, error)
+	GetPasswordHash(username string) (string, error)
+	// SetPasswordHash replaces a hash without it counting as a password change
+	SetPasswordHash(username string, passwordHash string) (bool, error)
+	// ChangePasswordHash stores a new password, keeping up to historyCount
+	// previous hashes
+	ChangePasswordHash(username string, passwordHash string, historyCount int) (bool, error)
+	GetPasswordHistory(username string, count int) ([]string, error)
+}
+
+// RoleStore keeps the roles users are assigned
+type RoleStore interface {
+	GetRoles() ([]Role, error)
+	GetRoleById(id int) (Role, error)
+	GetRoleByName(roleName string) (Role, error)
+	CreateRole(role Role) (bool, error)
+	// DeleteRole moves the users of the role to reassignTo and deletes the
+	// role in one transaction. With reassignTo 0, a role that has users is
+	// kept and a RoleInUse error names them
+	DeleteRole(roleId int, reassignTo int) (int64, error)
+	UpdateRole(role Role) (int64, error)
+	SetRoleTwoFactorRequired(roleId int, required bool) (bool, error)
+}
+
+// SystemStore keeps the systems that report updates
+type SystemStore interface {
+	GetOperatingSystems() ([]OperatingSystem, error)
+	CreateOperatingSystem(os OperatingSystem) (int, error)
+	GetSystems() ([]System, error)
+	GetSystemById(id int) (System, error)
+	GetSystemByFqdn(fqdn string) (System, error)
+	CreateSystem(system System) (int, error)
+	DeleteSystem(id int) (bool, error)
+}
+
+// UpdateRecordStore keeps the latest update report of each system, along
+// with the history of its reports
+type UpdateRecordStore interface {
+	GetUpdateRecords() ([]UpdateRecord, error)
+	GetUpdateRecordBySystemId(systemId int) (UpdateRecord, error)
+	// SaveUpdateRecord replaces the system's record if it has one, and adds
+	// the report to its history and daily counts
+	SaveUpdateRecord(record UpdateRecord) (bool, error)
+	DeleteUpdateRecord(systemId int) (bool, error)
+	GetUpdateReports(systemId int) ([]UpdateReport, error)
+	GetDailyUpdateCounts(systemId int) ([]DailyUpdateCount, error)
+}
+
+// RetentionStore removes history past its retention period. Each prune
+// deletes at most limit rows and returns how many it deleted, so callers
+// repeat it until fewer than limit come back
+type RetentionStore interface {
+	PruneUpdateReports(before time.Time, limit int) (int64, error)
+	// PruneDailyUpdateCounts deletes the counts of days before the day of before
+	PruneDailyUpdateCounts(before time.Time, limit int) (int64, error)
+	// PruneSilentSystems deletes systems that have not reported since
+	// before, along with their records and history
+	PruneSilentSystems(before time.Time, limit int) (int64, error)
+	// ReclaimSpace Returns the space freed by pruning to the filesystem, as
+	// far as the database supports it, and how many pages were freed
+	ReclaimSpace() (int64, error)
+}
+
+// HealthStore answers the readiness checks, within the deadline of ctx
+type HealthStore interface {
+	// Probe pings the database and runs a trivial query on it
+	Probe(ctx context.Context) error
+	// GetIngestionStatus counts the systems that have not reported since
+	// staleBefore, along with when the last report arrived
+	GetIngestionStatus(ctx context.Context, staleBefore time.Time) (IngestionStatus, error)
+}
+
+// Store is where the model keeps its data. Lookups of a single row that does
+// not exist return an empty value and no error
+type Store interface {
+	UserStore
+	RoleStore
+	SystemStore
+	UpdateRecordStore
+	RetentionStore
+	HealthStore
+}
+
+// SqlStore is a Store in a SQLite or PostgreSQL database
+type SqlStore struct {
+	db *Database
+}
+
+func NewSqlStore(db *Database) *SqlStore {
+	return &SqlStore{db: db}
+}
+
+// NewSqliteStore Opens a store in the SQLite database at path
+func NewSqliteStore(path string) (*SqlStore, error) {
+	db, err := OpenDatabase(DialectSqlite, path)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewSqlStore(db), nil
+}
+
+// NewPostgresStore Opens a store in the PostgreSQL database at url
+func NewPostgresStore(url string) (*SqlStore, error) {
+	db, err := OpenDatabase(DialectPostgres, url)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewSqlStore(db), nil
+}
+
+// Database Returns the database the store is kept in
+func (s *SqlStore) Database() *Database {
+	return s.db
+}
+
+// run Runs fn as a unit of work on the store's database
+func (s *SqlStore) run(fn func(u *Unit) error) error {
+	return s.db.Run(context.Background(), fn)
+}
+
+// transaction Runs fn as a unit of work in a transaction on the store's database
+func (s *SqlStore) transaction(fn func(u *Unit) error) error {
+	return s.db.Transaction(context.Background(), fn)
+}
+
+var store Store
+
+// SetStore Sets the store the model functions use
+func SetStore(s Store) {
+	store = s
+}
@@ -0,0 +1,122 @@
+package model_test
+
+/*
+
+  Copyright 2024, YggdrasilSoft, LLC.
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"github.com/greeneg/update-reporterd/migrations"
+	"github.com/greeneg/update-reporterd/model"
+	"github.com/greeneg/update-reporterd/model/storetest"
+)
+
+// postgresUrlVariable names the environment variable holding the URL of a
+// PostgreSQL database to run the suite against. Each test works in a scratch
+// schema that is dropped afterwards, so it can be a database in use
+const postgresUrlVariable = "UPDATE_REPORTER_TEST_DATABASE_URL"
+
+var schemas atomic.Int32
+
+// backends Returns a function opening an empty database for each backend
+// available to the tests
+func backends() map[string]func(t *testing.T) *model.Database {
+	return map[string]func(t *testing.T) *model.Database{
+		"sqlite":   openSqlite,
+		"postgres": openPostgres,
+	}
+}
+
+func openSqlite(t *testing.T) *model.Database {
+	db, err := model.OpenDatabase(model.DialectSqlite, filepath.Join(t.TempDir(), "store.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
+
+func openPostgres(t *testing.T) *model.Database {
+	databaseUrl := os.Getenv(postgresUrlVariable)
+	if databaseUrl == "" {
+		t.Skip("no PostgreSQL database, set " + postgresUrlVariable + " to run against one")
+	}
+
+	admin, err := model.OpenDatabase(model.DialectPostgres, databaseUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { admin.Close() })
+
+	schema := "storetest_" + strconv.Itoa(os.Getpid()) + "_" + strconv.Itoa(int(schemas.Add(1)))
+	if _, err := admin.Exec("CREATE SCHEMA " + schema); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { admin.Exec("DROP SCHEMA " + schema + " CASCADE") })
+
+	u, err := url.Parse(databaseUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := u.Query()
+	query.Set("search_path", schema)
+	u.RawQuery = query.Encode()
+
+	db, err := model.OpenDatabase(model.DialectPostgres, u.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// closed before the schema is dropped, cleanups running last first
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
+
+func TestStoreConformance(t *testing.T) {
+	for name, open := range backends() {
+		t.Run(name, func(t *testing.T) {
+			db := open(t)
+			if _, err := migrations.Up(context.Background(), db, false); err != nil {
+				t.Fatal(err)
+			}
+
+			for _, result := range storetest.Run(model.NewSqlStore(db)) {
+				if result.Err != nil {
+					t.Errorf("%s: %v", result.Name, result.Err)
+				}
+			}
+		})
+	}
+}
+
+func TestUpgradePopulatedDatabase(t *testing.T) {
+	for name, open := range backends() {
+		t.Run(name, func(t *testing.T) {
+			if err := storetest.CheckUpgrade(open(t)); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
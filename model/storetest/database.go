@@ -0,0 +1,47 @@
+package storetest
+
+/*
+
+  Copyright 2024, YggdrasilSoft, LLC.
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/greeneg/update-reporterd/migrations"
+	"github.com/greeneg/update-reporterd/model"
+)
+
+// OpenDatabase Makes a freshly migrated SQLite database the model's database
+// and store for the rest of the test, for tests of the packages built on the model
+func OpenDatabase(t testing.TB) *model.Database {
+	t.Helper()
+	db, err := model.OpenDatabase(model.DialectSqlite, filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if _, err := migrations.Up(context.Background(), db, false); err != nil {
+		t.Fatal(err)
+	}
+
+	model.DB = db
+	model.SetStore(model.NewSqlStore(db))
+
+	return db
+}
@@ -0,0 +1,889 @@
+// Package storetest is the conformance suite every model.Store has to pass,
+// run against each backend by the tests of package model. It also opens the
+// migrated databases the tests of other packages run against
+package storetest
+
+/*
+
+  Copyright 2024, YggdrasilSoft, LLC.
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/greeneg/update-reporterd/model"
+)
+
+const (
+	testRoleName     = "storetest"
+	reassignRoleName = "storetest-reassign"
+	testUserName     = "storetest-user"
+	externalUserName = "storetest-external"
+	oidcUserName     = "storetest-oidc"
+	oidcIssuer       = "https://issuer.example.com"
+	testFqdn         = "storetest.example.com"
+	silentFqdn       = "storetest-silent.example.com"
+)
+
+// Result is the outcome of one check
+type Result struct {
+	Name string
+	Err  error
+}
+
+// fixture carries the rows one check creates to the checks after it
+type fixture struct {
+	role     model.Role
+	osId     int
+	systemId int
+	recordId int
+}
+
+type check struct {
+	name string
+	run  func(s model.Store, f *fixture) error
+}
+
+// checks run in order, each relying on what the ones before it created
+var checks = []check{
+	{"missing rows are empty, not errors", checkMissingRows},
+	{"roles are created and found", checkCreateRole},
+	{"duplicate role names are refused", checkDuplicateRole},
+	{"role two-factor requirement is stored", checkRoleTwoFactor},
+	{"users are created and found", checkCreateUser},
+	{"user status is set along with lockouts", checkUserStatus},
+	{"user role is changed", checkUserRole},
+	{"user profile is updated", checkUpdateUser},
+	{"roles are updated and deleted with their users moved", checkRoleReassignment},
+	{"password changes keep history", checkPasswords},
+	{"external users are synchronized", checkExternalUsers},
+	{"single sign-on users are matched on issuer and subject", checkOidcUsers},
+	{"systems are created and found", checkSystems},
+	{"update records are saved and replaced", checkUpdateRecords},
+	{"update history is kept and pruned", checkUpdateHistory},
+	{"health probes answer", checkHealthProbes},
+	{"rows are deleted", checkDeletes},
+	{"silent systems are pruned with their history", checkSilentSystems},
+}
+
+// Run Runs the suite against a store in a freshly migrated database, stopping
+// at the first failing check
+func Run(s model.Store) []Result {
+	f := &fixture{}
+	results := make([]Result, 0, len(checks))
+	for _, c := range checks {
+		err := c.run(s, f)
+		results = append(results, Result{Name: c.name, Err: err})
+		if err != nil {
+			break
+		}
+	}
+
+	return results
+}
+
+func expect(ok bool, format string, args ...any) error {
+	if ok {
+		return nil
+	}
+	return fmt.Errorf(format, args...)
+}
+
+// validTimestamp reports whether a timestamp is in the model's format and
+// close enough to now to have been set by the database
+func validTimestamp(t string) bool {
+	parsed, err := time.Parse("2006-01-02 15:04:05", t)
+	if err != nil {
+		return false
+	}
+	return time.Since(parsed).Abs() < time.Hour
+}
+
+func checkMissingRows(s model.Store, f *fixture) error {
+	role, err := s.GetRoleByName("no-such-role")
+	if err != nil {
+		return err
+	}
+	if err := expect(role.Id == 0, "found role %d for a missing name", role.Id); err != nil {
+		return err
+	}
+	role, err = s.GetRoleById(-1)
+	if err != nil {
+		return err
+	}
+	if err := expect(role.Id == 0, "found role %d for a missing Id", role.Id); err != nil {
+		return err
+	}
+	user, err := s.GetUserByUserName("no-such-user")
+	if err != nil {
+		return err
+	}
+	if err := expect(user.Id == 0, "found user %d for a missing name", user.Id); err != nil {
+		return err
+	}
+	user, err = s.GetUserById(-1)
+	if err != nil {
+		return err
+	}
+	if err := expect(user.Id == 0, "found user %d for a missing Id", user.Id); err != nil {
+		return err
+	}
+	system, err := s.GetSystemByFqdn("no-such-system.example.com")
+	if err != nil {
+		return err
+	}
+	if err := expect(system.Id == 0, "found system %d for a missing FQDN", system.Id); err != nil {
+		return err
+	}
+	system, err = s.GetSystemById(-1)
+	if err != nil {
+		return err
+	}
+	if err := expect(system.Id == 0, "found system %d for a missing Id", system.Id); err != nil {
+		return err
+	}
+	record, err := s.GetUpdateRecordBySystemId(-1)
+	if err != nil {
+		return err
+	}
+	return expect(record.Id == 0, "found update record %d for a missing system", record.Id)
+}
+
+func checkCreateRole(s model.Store, f *fixture) error {
+	ok, err := s.CreateRole(model.Role{RoleName: testRoleName, Description: "Conformance test role"})
+	if err != nil {
+		return err
+	}
+	if err := expect(ok, "role was not created"); err != nil {
+		return err
+	}
+
+	role, err := s.GetRoleByName(testRoleName)
+	if err != nil {
+		return err
+	}
+	if err := expect(role.Id != 0 && role.RoleName == testRoleName && role.Description == "Conformance test role" &&
+		!role.TwoFactorRequired, "role read back as %+v", role); err != nil {
+		return err
+	}
+	if err := expect(validTimestamp(role.CreationDate), "role creation date is '%s'", role.CreationDate); err != nil {
+		return err
+	}
+	f.role = role
+
+	byId, err := s.GetRoleById(role.Id)
+	if err != nil {
+		return err
+	}
+	if err := expect(reflect.DeepEqual(byId, role), "role by Id is %+v, by name %+v", byId, role); err != nil {
+		return err
+	}
+
+	roles, err := s.GetRoles()
+	if err != nil {
+		return err
+	}
+	found := map[string]bool{}
+	for _, r := range roles {
+		found[r.RoleName] = true
+	}
+	return expect(found[testRoleName] && found["administrators"] && found["SYSTEM"],
+		"role list is missing roles: %+v", roles)
+}
+
+func checkDuplicateRole(s model.Store, f *fixture) error {
+	_, err := s.CreateRole(model.Role{RoleName: testRoleName, Description: "Duplicate"})
+	return expect(err != nil, "a second role named '%s' was created", testRoleName)
+}
+
+func checkRoleTwoFactor(s model.Store, f *fixture) error {
+	for _, required := range []bool{true, false} {
+		ok, err := s.SetRoleTwoFactorRequired(f.role.Id, required)
+		if err != nil {
+			return err
+		}
+		if err := expect(ok, "role was not updated"); err != nil {
+			return err
+		}
+		role, err := s.GetRoleById(f.role.Id)
+		if err != nil {
+			return err
+		}
+		if err := expect(role.TwoFactorRequired == required, "two-factor requirement read back as %v", role.TwoFactorRequired); err != nil {
+			return err
+		}
+	}
+
+	ok, err := s.SetRoleTwoFactorRequired(-1, true)
+	if err != nil {
+		return err
+	}
+	return expect(!ok, "a missing role was updated")
+}
+
+func checkCreateUser(s model.Store, f *fixture) error {
+	ok, err := s.CreateUser(model.User{
+		UserName:     testUserName,
+		FullName:     "Conformance Test User",
+		RoleId:       f.role.Id,
+		PasswordHash: "hash-1",
+	})
+	if err != nil {
+		return err
+	}
+	if err := expect(ok, "user was not created"); err != nil {
+		return err
+	}
+
+	user, err := s.GetUserByUserName(testUserName)
+	if err != nil {
+		return err
+	}
+	if err := expect(user.Id != 0 && user.FullName == "Conformance Test User" && user.RoleId == f.role.Id &&
+		user.Status == "enabled" && user.AuthSource == model.AuthSourceLocal && user.OrgUnitId == 0 &&
+		user.PasswordHash == "hash-1", "user read back as %+v", user); err != nil {
+		return err
+	}
+	if err := expect(validTimestamp(user.CreationDate) && validTimestamp(user.LastPasswordChangedDate),
+		"user dates are '%s' and '%s'", user.CreationDate, user.LastPasswordChangedDate); err != nil {
+		return err
+	}
+
+	byId, err := s.GetUserById(user.Id)
+	if err != nil {
+		return err
+	}
+	if err := expect(reflect.DeepEqual(byId, user), "user by Id is %+v, by name %+v", byId, user); err != nil {
+		return err
+	}
+
+	users, err := s.GetUsers()
+	if err != nil {
+		return err
+	}
+	found := false
+	for _, u := range users {
+		found = found || u.UserName == testUserName
+	}
+	if err := expect(found, "user list is missing '%s'", testUserName); err != nil {
+		return err
+	}
+
+	users, err = s.GetUsersByRoleId(f.role.Id)
+	if err != nil {
+		return err
+	}
+	return expect(len(users) == 1 && users[0].UserName == testUserName, "users of the role are %+v", users)
+}
+
+func checkUserStatus(s model.Store, f *fixture) error {
+	for _, status := range []string{"locked", "enabled"} {
+		rows, err := s.SetUserStatus(testUserName, status, "conformance test")
+		if err != nil {
+			return err
+		}
+		if err := expect(rows == 1, "setting status '%s' changed %d rows", status, rows); err != nil {
+			return err
+		}
+		stored, err := s.GetUserStatus(testUserName)
+		if err != nil {
+			return err
+		}
+		if err := expect(stored == status, "status read back as '%s', not '%s'", stored, status); err != nil {
+			return err
+		}
+	}
+
+	rows, err := s.SetUserStatus("no-such-user", "locked", "")
+	if err != nil {
+		return err
+	}
+	return expect(rows == 0, "locking a missing user changed %d rows", rows)
+}
+
+func checkUserRole(s model.Store, f *fixture) error {
+	for _, roleId := range []int{model.AdministratorsRoleId, f.role.Id} {
+		rows, err := s.SetUserRoleId(testUserName, roleId)
+		if err != nil {
+			return err
+		}
+		if err := expect(rows == 1, "setting role %d changed %d rows", roleId, rows); err != nil {
+			return err
+		}
+		user, err := s.GetUserByUserName(testUserName)
+		if err != nil {
+			return err
+		}
+		if err := expect(user.RoleId == roleId, "role read back as %d, not %d", user.RoleId, roleId); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func checkUpdateUser(s model.Store, f *fixture) error {
+	user, err := s.GetUserByUserName(testUserName)
+	if err != nil {
+		return err
+	}
+	original := user
+	user.FullName = "Updated Store Check User"
+	user.RoleId = model.AdministratorsRoleId
+	rows, err := s.UpdateUser(user)
+	if err != nil {
+		return err
+	}
+	if err := expect(rows == 1, "updating the user changed %d rows", rows); err != nil {
+		return err
+	}
+	updated, err := s.GetUserById(user.Id)
+	if err != nil {
+		return err
+	}
+	if err := expect(updated.FullName == user.FullName && updated.RoleId == user.RoleId && updated.OrgUnitId == 0,
+		"user read back as %+v", updated); err != nil {
+		return err
+	}
+	// later checks expect the profile the user was created with
+	_, err = s.UpdateUser(original)
+	if err != nil {
+		return err
+	}
+
+	rows, err = s.UpdateUser(model.User{Id: -1, FullName: "Nobody", RoleId: f.role.Id})
+	if err != nil {
+		return err
+	}
+	if err := expect(rows == 0, "updating a missing user changed %d rows", rows); err != nil {
+		return err
+	}
+	exists, err := s.OrgUnitExists(-1)
+	if err != nil {
+		return err
+	}
+
+	return expect(!exists, "a missing organizational unit was found")
+}
+
+func checkRoleReassignment(s model.Store, f *fixture) error {
+	if _, err := s.CreateRole(model.Role{RoleName: reassignRoleName}); err != nil {
+		return err
+	}
+	role, err := s.GetRoleByName(reassignRoleName)
+	if err != nil {
+		return err
+	}
+	role.Description = "Updated by the store check"
+	role.TwoFactorRequired = true
+	rows, err := s.UpdateRole(role)
+	if err != nil {
+		return err
+	}
+	if err := expect(rows == 1, "updating the role changed %d rows", rows); err != nil {
+		return err
+	}
+	updated, err := s.GetRoleById(role.Id)
+	if err != nil {
+		return err
+	}
+	if err := expect(updated.Description == role.Description && updated.TwoFactorRequired,
+		"role read back as %+v", updated); err != nil {
+		return err
+	}
+
+	if _, err := s.SetUserRoleId(testUserName, role.Id); err != nil {
+		return err
+	}
+	_, err = s.DeleteRole(role.Id, 0)
+	var inUse *model.RoleInUse
+	if err := expect(errors.As(err, &inUse) && reflect.DeepEqual(inUse.UserNames, []string{testUserName}),
+		"deleting a role with users failed with %v", err); err != nil {
+		return err
+	}
+	kept, err := s.GetRoleById(role.Id)
+	if err != nil {
+		return err
+	}
+	if err := expect(kept.Id == role.Id, "role with users was deleted"); err != nil {
+		return err
+	}
+
+	rows, err = s.DeleteRole(role.Id, f.role.Id)
+	if err != nil {
+		return err
+	}
+	if err := expect(rows == 1, "deleting the role removed %d rows", rows); err != nil {
+		return err
+	}
+	user, err := s.GetUserByUserName(testUserName)
+	if err != nil {
+		return err
+	}
+	if err := expect(user.RoleId == f.role.Id, "user was moved to role %d, not %d", user.RoleId, f.role.Id); err != nil {
+		return err
+	}
+	deleted, err := s.GetRoleById(role.Id)
+	if err != nil {
+		return err
+	}
+
+	return expect(deleted.Id == 0, "deleted role is still found")
+}
+
+func checkPasswords(s model.Store, f *fixture) error {
+	for _, hash := range []string{"hash-2", "hash-3", "hash-4"} {
+		ok, err := s.ChangePasswordHash(testUserName, hash, 2)
+		if err != nil {
+			return err
+		}
+		if err := expect(ok, "password was not changed to '%s'", hash); err != nil {
+			return err
+		}
+	}
+
+	hash, err := s.GetPasswordHash(testUserName)
+	if err != nil {
+		return err
+	}
+	if err := expect(hash == "hash-4", "password hash read back as '%s'", hash); err != nil {
+		return err
+	}
+	history, err := s.GetPasswordHistory(testUserName, 5)
+	if err != nil {
+		return err
+	}
+	if err := expect(reflect.DeepEqual(history, []string{"hash-3", "hash-2"}), "password history is %v", history); err != nil {
+		return err
+	}
+
+	// rehashing is not a password change, so history stays as it is
+	ok, err := s.SetPasswordHash(testUserName, "hash-5")
+	if err != nil {
+		return err
+	}
+	if err := expect(ok, "password hash was not replaced"); err != nil {
+		return err
+	}
+	hash, err = s.GetPasswordHash(testUserName)
+	if err != nil {
+		return err
+	}
+	if err := expect(hash == "hash-5", "password hash read back as '%s'", hash); err != nil {
+		return err
+	}
+	history, err = s.GetPasswordHistory(testUserName, 5)
+	if err != nil {
+		return err
+	}
+	return expect(reflect.DeepEqual(history, []string{"hash-3", "hash-2"}), "password history changed to %v", history)
+}
+
+func checkExternalUsers(s model.Store, f *fixture) error {
+	ok, err := s.SaveExternalUser(externalUserName, "External User", f.role.Id, model.AuthSourceLdap)
+	if err != nil {
+		return err
+	}
+	if err := expect(ok, "external user was not created"); err != nil {
+		return err
+	}
+	ok, err = s.SaveExternalUser(externalUserName, "Renamed User", model.AdministratorsRoleId, model.AuthSourceLdap)
+	if err != nil {
+		return err
+	}
+	if err := expect(ok, "external user was not updated"); err != nil {
+		return err
+	}
+	user, err := s.GetUserByUserName(externalUserName)
+	if err != nil {
+		return err
+	}
+	if err := expect(user.FullName == "Renamed User" && user.RoleId == model.AdministratorsRoleId &&
+		user.AuthSource == model.AuthSourceLdap, "external user read back as %+v", user); err != nil {
+		return err
+	}
+
+	// a local account of the same name must not be taken over
+	ok, err = s.SaveExternalUser(testUserName, "Impostor", model.AdministratorsRoleId, model.AuthSourceOidc)
+	if err != nil {
+		return err
+	}
+	if err := expect(!ok, "local user was overwritten by an external one"); err != nil {
+		return err
+	}
+	user, err = s.GetUserByUserName(testUserName)
+	if err != nil {
+		return err
+	}
+	return expect(user.FullName == "Conformance Test User" && user.AuthSource == model.AuthSourceLocal,
+		"local user read back as %+v", user)
+}
+
+func checkOidcUsers(s model.Store, f *fixture) error {
+	created, err := s.SaveOidcUser(oidcIssuer, "subject-1", oidcUserName, "Single Sign-On User", f.role.Id)
+	if err != nil {
+		return err
+	}
+	if err := expect(created.UserName == oidcUserName && created.AuthSource == model.AuthSourceOidc,
+		"single sign-on user was created as %+v", created); err != nil {
+		return err
+	}
+
+	// a new name claim is a display change, not another account
+	renamed, err := s.SaveOidcUser(oidcIssuer, "subject-1", oidcUserName+"-renamed", "Renamed User", model.AdministratorsRoleId)
+	if err != nil {
+		return err
+	}
+	if err := expect(renamed.Id == created.Id && renamed.UserName == oidcUserName && renamed.FullName == "Renamed User" &&
+		renamed.RoleId == model.AdministratorsRoleId, "single sign-on user read back as %+v", renamed); err != nil {
+		return err
+	}
+
+	// neither another subject nor another issuer takes over a name
+	for _, identity := range [][2]string{{oidcIssuer, "subject-2"}, {"https://elsewhere.example.com", "subject-1"}} {
+		taken, err := s.SaveOidcUser(identity[0], identity[1], oidcUserName, "Impostor", model.AdministratorsRoleId)
+		if err != nil {
+			return err
+		}
+		if err := expect(taken.Id == 0, "%v took over a single sign-on user as %+v", identity, taken); err != nil {
+			return err
+		}
+	}
+	taken, err := s.SaveOidcUser(oidcIssuer, "subject-3", testUserName, "Impostor", model.AdministratorsRoleId)
+	if err != nil {
+		return err
+	}
+	if err := expect(taken.Id == 0, "a single sign-on user took over a local one as %+v", taken); err != nil {
+		return err
+	}
+
+	_, err = s.DeleteUser(oidcUserName)
+	return err
+}
+
+func checkSystems(s model.Store, f *fixture) error {
+	osId, err := s.CreateOperatingSystem(model.OperatingSystem{OsIdName: "storetest", OsVersion: "1.0"})
+	if err != nil {
+		return err
+	}
+	if err := expect(osId != 0, "operating system was created without an Id"); err != nil {
+		return err
+	}
+	f.osId = osId
+	operatingSystems, err := s.GetOperatingSystems()
+	if err != nil {
+		return err
+	}
+	found := false
+	for _, os := range operatingSystems {
+		found = found || (os.Id == osId && os.OsIdName == "storetest" && os.OsVersion == "1.0")
+	}
+	if err := expect(found, "operating system list is missing %d: %+v", osId, operatingSystems); err != nil {
+		return err
+	}
+
+	systemId, err := s.CreateSystem(model.System{Fqdn: testFqdn, OsFamilyId: 1, OsId: osId, ArchId: 4})
+	if err != nil {
+		return err
+	}
+	if err := expect(systemId != 0, "system was created without an Id"); err != nil {
+		return err
+	}
+	f.systemId = systemId
+
+	system, err := s.GetSystemByFqdn(testFqdn)
+	if err != nil {
+		return err
+	}
+	if err := expect(system.Id == systemId && system.OsFamilyId == 1 && system.OsId == osId && system.ArchId == 4 &&
+		validTimestamp(system.CreationDate), "system read back as %+v", system); err != nil {
+		return err
+	}
+	byId, err := s.GetSystemById(systemId)
+	if err != nil {
+		return err
+	}
+	if err := expect(reflect.DeepEqual(byId, system), "system by Id is %+v, by FQDN %+v", byId, system); err != nil {
+		return err
+	}
+	systems, err := s.GetSystems()
+	if err != nil {
+		return err
+	}
+	if err := expect(len(systems) == 1 && systems[0].Id == systemId, "system list is %+v", systems); err != nil {
+		return err
+	}
+
+	_, err = s.CreateSystem(model.System{Fqdn: testFqdn, OsFamilyId: 1, OsId: osId, ArchId: 4})
+	return expect(err != nil, "a second system named '%s' was created", testFqdn)
+}
+
+// sameJson reports whether two documents hold the same values, as servers
+// may store JSON in a normalized form
+func sameJson(a []byte, b []byte) bool {
+	var av, bv any
+	if json.Unmarshal(a, &av) != nil || json.Unmarshal(b, &bv) != nil {
+		return false
+	}
+	return reflect.DeepEqual(av, bv)
+}
+
+func checkUpdateRecords(s model.Store, f *fixture) error {
+	documents := []string{
+		`{"packages": [{"name": "bash", "version": "5.2.15"}], "security": true}`,
+		`{"packages": [{"name": "bash", "version": "5.2.21"}, {"name": "curl", "version": "8.5.0"}], "security": false}`,
+	}
+	for i, document := range documents {
+		ok, err := s.SaveUpdateRecord(model.UpdateRecord{
+			SystemId:     f.systemId,
+			UpdateCount:  i + 1,
+			UpdateRecord: json.RawMessage(document),
+		})
+		if err != nil {
+			return err
+		}
+		if err := expect(ok, "update record was not saved"); err != nil {
+			return err
+		}
+
+		record, err := s.GetUpdateRecordBySystemId(f.systemId)
+		if err != nil {
+			return err
+		}
+		if err := expect(record.Id != 0 && record.SystemId == f.systemId && record.UpdateCount == i+1 &&
+			sameJson(record.UpdateRecord, []byte(document)), "update record read back as %+v", record); err != nil {
+			return err
+		}
+		if err := expect(validTimestamp(record.CreationDate) && validTimestamp(record.LastUpdateDate),
+			"update record dates are '%s' and '%s'", record.CreationDate, record.LastUpdateDate); err != nil {
+			return err
+		}
+		if f.recordId == 0 {
+			f.recordId = record.Id
+		}
+		if err := expect(record.Id == f.recordId, "saving again created record %d instead of replacing %d",
+			record.Id, f.recordId); err != nil {
+			return err
+		}
+	}
+
+	records, err := s.GetUpdateRecords()
+	if err != nil {
+		return err
+	}
+	return expect(len(records) == 1 && records[0].Id == f.recordId, "update record list is %+v", records)
+}
+
+func checkUpdateHistory(s model.Store, f *fixture) error {
+	reports, err := s.GetUpdateReports(f.systemId)
+	if err != nil {
+		return err
+	}
+	if err := expect(len(reports) == 2 && reports[0].UpdateCount == 1 && reports[1].UpdateCount == 2 &&
+		validTimestamp(reports[1].CreationDate), "update reports are %+v", reports); err != nil {
+		return err
+	}
+	counts, err := s.GetDailyUpdateCounts(f.systemId)
+	if err != nil {
+		return err
+	}
+	today := time.Now().UTC().Format("2006-01-02")
+	if err := expect(len(counts) == 1 && counts[0].Day == today && counts[0].Reports == 2 &&
+		counts[0].MaxUpdateCount == 2 && counts[0].LastUpdateCount == 2, "daily update counts are %+v", counts); err != nil {
+		return err
+	}
+
+	deleted, err := s.PruneUpdateReports(time.Now().Add(-time.Hour), 1)
+	if err != nil {
+		return err
+	}
+	if err := expect(deleted == 0, "pruned %d recent update reports", deleted); err != nil {
+		return err
+	}
+	// one at a time, as the retention job does in batches
+	for _, want := range []int64{1, 1, 0} {
+		deleted, err = s.PruneUpdateReports(time.Now().Add(time.Hour), 1)
+		if err != nil {
+			return err
+		}
+		if err := expect(deleted == want, "pruned %d update reports in a batch of 1, expected %d", deleted, want); err != nil {
+			return err
+		}
+	}
+	record, err := s.GetUpdateRecordBySystemId(f.systemId)
+	if err != nil {
+		return err
+	}
+	if err := expect(record.Id == f.recordId, "pruning the history removed the latest update record"); err != nil {
+		return err
+	}
+
+	deleted, err = s.PruneDailyUpdateCounts(time.Now(), 10)
+	if err != nil {
+		return err
+	}
+	if err := expect(deleted == 0, "pruned %d daily update counts of today", deleted); err != nil {
+		return err
+	}
+	deleted, err = s.PruneDailyUpdateCounts(time.Now().AddDate(0, 0, 1), 10)
+	if err != nil {
+		return err
+	}
+	if err := expect(deleted == 1, "pruned %d daily update counts of earlier days, expected 1", deleted); err != nil {
+		return err
+	}
+
+	deleted, err = s.PruneSilentSystems(time.Now().Add(-time.Hour), 10)
+	if err != nil {
+		return err
+	}
+	if err := expect(deleted == 0, "pruned %d systems that reported recently", deleted); err != nil {
+		return err
+	}
+	_, err = s.ReclaimSpace()
+	return err
+}
+
+func checkHealthProbes(s model.Store, f *fixture) error {
+	if err := s.Probe(context.Background()); err != nil {
+		return err
+	}
+
+	status, err := s.GetIngestionStatus(context.Background(), time.Now().Add(-time.Hour))
+	if err != nil {
+		return err
+	}
+	if err := expect(status.Systems >= 1 && status.StaleSystems == 0, "ingestion status before any system is stale is %+v", status); err != nil {
+		return err
+	}
+	if err := expect(time.Since(status.LastReport) < time.Hour, "last report arrived at %v", status.LastReport); err != nil {
+		return err
+	}
+	status, err = s.GetIngestionStatus(context.Background(), time.Now().Add(time.Hour))
+	if err != nil {
+		return err
+	}
+
+	return expect(status.StaleSystems == status.Systems, "ingestion status once every system is stale is %+v", status)
+}
+
+func checkDeletes(s model.Store, f *fixture) error {
+	ok, err := s.DeleteUpdateRecord(f.systemId)
+	if err != nil {
+		return err
+	}
+	if err := expect(ok, "update record was not deleted"); err != nil {
+		return err
+	}
+	ok, err = s.DeleteUpdateRecord(f.systemId)
+	if err != nil {
+		return err
+	}
+	if err := expect(!ok, "update record was deleted twice"); err != nil {
+		return err
+	}
+	ok, err = s.DeleteSystem(f.systemId)
+	if err != nil {
+		return err
+	}
+	if err := expect(ok, "system was not deleted"); err != nil {
+		return err
+	}
+	system, err := s.GetSystemById(f.systemId)
+	if err != nil {
+		return err
+	}
+	if err := expect(system.Id == 0, "deleted system is still found"); err != nil {
+		return err
+	}
+
+	for _, username := range []string{testUserName, externalUserName} {
+		if _, err := s.DeleteUser(username); err != nil {
+			return err
+		}
+		user, err := s.GetUserByUserName(username)
+		if err != nil {
+			return err
+		}
+		if err := expect(user.Id == 0, "deleted user '%s' is still found", username); err != nil {
+			return err
+		}
+	}
+
+	if _, err := s.DeleteRole(f.role.Id, 0); err != nil {
+		return err
+	}
+	role, err := s.GetRoleById(f.role.Id)
+	if err != nil {
+		return err
+	}
+	return expect(role.Id == 0, "deleted role is still found")
+}
+
+func checkSilentSystems(s model.Store, f *fixture) error {
+	systemId, err := s.CreateSystem(model.System{Fqdn: silentFqdn, OsFamilyId: 1, OsId: f.osId, ArchId: 4})
+	if err != nil {
+		return err
+	}
+	ok, err := s.SaveUpdateRecord(model.UpdateRecord{
+		SystemId:     systemId,
+		UpdateCount:  3,
+		UpdateRecord: json.RawMessage(`{"packages": []}`),
+	})
+	if err != nil {
+		return err
+	}
+	if err := expect(ok, "update record was not saved"); err != nil {
+		return err
+	}
+
+	deleted, err := s.PruneSilentSystems(time.Now().Add(time.Hour), 10)
+	if err != nil {
+		return err
+	}
+	if err := expect(deleted == 1, "pruned %d silent systems, expected 1", deleted); err != nil {
+		return err
+	}
+	system, err := s.GetSystemById(systemId)
+	if err != nil {
+		return err
+	}
+	if err := expect(system.Id == 0, "pruned system is still found"); err != nil {
+		return err
+	}
+	record, err := s.GetUpdateRecordBySystemId(systemId)
+	if err != nil {
+		return err
+	}
+	if err := expect(record.Id == 0, "pruned system still has an update record"); err != nil {
+		return err
+	}
+	reports, err := s.GetUpdateReports(systemId)
+	if err != nil {
+		return err
+	}
+	counts, err := s.GetDailyUpdateCounts(systemId)
+	if err != nil {
+		return err
+	}
+	return expect(len(reports) == 0 && len(counts) == 0, "pruned system still has %d reports and %d daily counts",
+		len(reports), len(counts))
+}
@@ -0,0 +1,91 @@
+package storetest
+
+/*
+
+  Copyright 2024, YggdrasilSoft, LLC.
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+import (
+	"context"
+
+	"github.com/greeneg/update-reporterd/migrations"
+	"github.com/greeneg/update-reporterd/model"
+)
+
+// populatedVersion is the schema the upgrade check fills with rows, the last
+// one before operating systems were rebuilt with text columns
+const populatedVersion = 7
+
+const upgradeFqdn = "storetest-upgrade.example.com"
+
+// CheckUpgrade Migrates an empty database to an older schema, stores a system
+// that reported updates, and checks that the remaining migrations apply and
+// keep its rows
+func CheckUpgrade(db *model.Database) error {
+	ctx := context.Background()
+	if _, err := migrations.UpTo(ctx, db, populatedVersion); err != nil {
+		return err
+	}
+
+	exec := func(query string, args ...any) error {
+		_, err := db.ExecContext(ctx, db.Dialect.Rebind(query), args...)
+		return err
+	}
+	err := exec("INSERT INTO OperatingSystems (OsIdName, OsVersion) VALUES (?, ?)", "sles", "15.1")
+	if err != nil {
+		return err
+	}
+	err = exec(`INSERT INTO Systems (FQDN, OsFamilyId, OsId, ArchId)
+		SELECT ?, 1, Id, 4 FROM OperatingSystems WHERE OsIdName = ?`, upgradeFqdn, "sles")
+	if err != nil {
+		return err
+	}
+	err = exec(`INSERT INTO UpdateRecords (SystemId, UpdateCount, UpdateRecord)
+		SELECT Id, 2, ? FROM Systems WHERE FQDN = ?`, `{"packages": []}`, upgradeFqdn)
+	if err != nil {
+		return err
+	}
+
+	if _, err := migrations.Up(ctx, db, false); err != nil {
+		return err
+	}
+
+	s := model.NewSqlStore(db)
+	system, err := s.GetSystemByFqdn(upgradeFqdn)
+	if err != nil {
+		return err
+	}
+	if err := expect(system.Id != 0, "system is missing after the upgrade"); err != nil {
+		return err
+	}
+	operatingSystems, err := s.GetOperatingSystems()
+	if err != nil {
+		return err
+	}
+	found := false
+	for _, os := range operatingSystems {
+		found = found || (os.Id == system.OsId && os.OsIdName == "sles" && os.OsVersion == "15.1")
+	}
+	if err := expect(found, "operating system of the system read back as %+v", operatingSystems); err != nil {
+		return err
+	}
+	reports, err := s.GetUpdateReports(system.Id)
+	if err != nil {
+		return err
+	}
+
+	return expect(len(reports) == 1 && reports[0].UpdateCount == 2, "update history read back as %+v", reports)
+}
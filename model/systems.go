@@ -0,0 +1,212 @@
+package model
+
+/*
+
+  Copyright 2024, YggdrasilSoft, LLC.
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+import (
+	"database/sql"
+	"log/slog"
+)
+
+const systemColumns = "Id, FQDN, OsFamilyId, OsId, ArchId, CreationDate"
+
+func scanSystem(scanner interface{ Scan(...any) error }) (System, error) {
+	system := System{}
+	err := scanner.Scan(
+		&system.Id,
+		&system.Fqdn,
+		&system.OsFamilyId,
+		&system.OsId,
+		&system.ArchId,
+		&system.CreationDate,
+	)
+	if err != nil {
+		return System{}, err
+	}
+
+	system.CreationDate = ConvertDbTimestamp(system.CreationDate)
+
+	return system, nil
+}
+
+func GetOperatingSystems() ([]OperatingSystem, error) {
+	slog.Debug("List of operating systems requested")
+	return store.GetOperatingSystems()
+}
+
+func (s *SqlStore) GetOperatingSystems() ([]OperatingSystem, error) {
+	operatingSystems := make([]OperatingSystem, 0)
+	err := s.run(func(u *Unit) error {
+		return u.Each("SELECT Id, OsIdName, OsVersion, CreationDate FROM OperatingSystems", nil, func(rows *sql.Rows) error {
+			os := OperatingSystem{}
+			err := rows.Scan(
+				&os.Id,
+				&os.OsIdName,
+				&os.OsVersion,
+				&os.CreationDate,
+			)
+			if err != nil {
+				return err
+			}
+
+			os.CreationDate = ConvertDbTimestamp(os.CreationDate)
+
+			operatingSystems = append(operatingSystems, os)
+			return nil
+		})
+	})
+	if err != nil {
+		slog.Error("Cannot marshal the operating system objects", "error", err)
+		return nil, err
+	}
+
+	return operatingSystems, nil
+}
+
+func CreateOperatingSystem(os OperatingSystem) (int, error) {
+	slog.Debug("Operating system creation requested", "os", os.OsIdName, "osVersion", os.OsVersion)
+	return store.CreateOperatingSystem(os)
+}
+
+func (s *SqlStore) CreateOperatingSystem(os OperatingSystem) (int, error) {
+	id := 0
+	err := s.run(func(u *Unit) error {
+		return u.QueryRow("INSERT INTO OperatingSystems (OsIdName, OsVersion) VALUES (?, ?) RETURNING Id",
+			os.OsIdName, os.OsVersion).Scan(&id)
+	})
+	if err != nil {
+		slog.Error("Cannot create operating system", "os", os.OsIdName, "error", err)
+		return 0, err
+	}
+
+	return id, nil
+}
+
+func GetSystems() ([]System, error) {
+	slog.Debug("List of system objects requested")
+	return store.GetSystems()
+}
+
+func (s *SqlStore) GetSystems() ([]System, error) {
+	systems := make([]System, 0)
+	err := s.run(func(u *Unit) error {
+		return u.Each("SELECT "+systemColumns+" FROM Systems", nil, func(rows *sql.Rows) error {
+			system, err := scanSystem(rows)
+			if err != nil {
+				return err
+			}
+			systems = append(systems, system)
+			return nil
+		})
+	})
+	if err != nil {
+		slog.Error("Cannot marshal the system objects", "error", err)
+		return nil, err
+	}
+
+	return systems, nil
+}
+
+func GetSystemById(id int) (System, error) {
+	slog.Debug("System by Id requested", "systemId", id)
+	return store.GetSystemById(id)
+}
+
+func (s *SqlStore) GetSystemById(id int) (System, error) {
+	system := System{}
+	err := s.run(func(u *Unit) error {
+		var err error
+		system, err = scanSystem(u.QueryRow("SELECT "+systemColumns+" FROM Systems WHERE Id = ?", id))
+		return err
+	})
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return System{}, nil
+		}
+		slog.Error("Cannot retrieve system from DB", "error", err)
+		return System{}, err
+	}
+
+	return system, nil
+}
+
+func GetSystemByFqdn(fqdn string) (System, error) {
+	slog.Debug("System by FQDN requested", "fqdn", fqdn)
+	return store.GetSystemByFqdn(fqdn)
+}
+
+func (s *SqlStore) GetSystemByFqdn(fqdn string) (System, error) {
+	system := System{}
+	err := s.run(func(u *Unit) error {
+		var err error
+		system, err = scanSystem(u.QueryRow("SELECT "+systemColumns+" FROM Systems WHERE FQDN = ?", fqdn))
+		return err
+	})
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return System{}, nil
+		}
+		slog.Error("Cannot retrieve system from DB", "error", err)
+		return System{}, err
+	}
+
+	return system, nil
+}
+
+func CreateSystem(system System) (int, error) {
+	slog.Debug("System creation requested", "fqdn", system.Fqdn)
+	return store.CreateSystem(system)
+}
+
+func (s *SqlStore) CreateSystem(system System) (int, error) {
+	id := 0
+	err := s.run(func(u *Unit) error {
+		return u.QueryRow(`INSERT INTO Systems (FQDN, OsFamilyId, OsId, ArchId)
+			VALUES (?, ?, ?, ?) RETURNING Id`,
+			system.Fqdn, system.OsFamilyId, system.OsId, system.ArchId).Scan(&id)
+	})
+	if err != nil {
+		slog.Error("Cannot create system", "fqdn", system.Fqdn, "error", err)
+		return 0, err
+	}
+
+	return id, nil
+}
+
+func DeleteSystem(id int) (bool, error) {
+	slog.Debug("System deletion requested", "systemId", id)
+	return store.DeleteSystem(id)
+}
+
+func (s *SqlStore) DeleteSystem(id int) (bool, error) {
+	var numberOfRows int64
+	err := s.run(func(u *Unit) error {
+		result, err := u.Exec("DELETE FROM Systems WHERE Id = ?", id)
+		if err != nil {
+			return err
+		}
+		numberOfRows, err = result.RowsAffected()
+		return err
+	})
+	if err != nil {
+		slog.Error("Cannot delete system", "systemId", id, "error", err)
+		return false, err
+	}
+
+	return numberOfRows > 0, nil
+}
@@ -0,0 +1,233 @@
+package model
+
+/*
+
+  Copyright 2024, YggdrasilSoft, LLC.
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"log/slog"
+	"time"
+)
+
+// GetUserTotp Returns a user's TOTP enrolment, or an empty one if they have none
+func GetUserTotp(username string) (UserTotp, error) {
+	userTotp := UserTotp{}
+	confirmedDate := sql.NullString{}
+	err := run(func(u *Unit) error {
+		return u.QueryRow(`SELECT t.UserId, t.Secret, t.Confirmed, t.LastUsedCounter, t.CreationDate, t.ConfirmedDate
+			FROM UserTotp t JOIN Users u ON u.Id = t.UserId
+			WHERE u.UserName = ?`, username).Scan(
+			&userTotp.UserId,
+			&userTotp.Secret,
+			&userTotp.Confirmed,
+			&userTotp.LastUsedCounter,
+			&userTotp.CreationDate,
+			&confirmedDate,
+		)
+	})
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return UserTotp{}, nil
+		}
+		slog.Error("Cannot retrieve TOTP enrolment", "user", username, "error", err)
+		return UserTotp{}, err
+	}
+
+	userTotp.CreationDate = ConvertDbTimestamp(userTotp.CreationDate)
+	if confirmedDate.Valid {
+		userTotp.ConfirmedDate = ConvertDbTimestamp(confirmedDate.String)
+	}
+
+	return userTotp, nil
+}
+
+// SaveUserTotpSecret Starts a TOTP enrolment, replacing any unconfirmed one
+func SaveUserTotpSecret(username string, secret string) (bool, error) {
+	slog.Info("TOTP enrolment started", "user", username)
+	err := run(func(u *Unit) error {
+		_, err := u.Exec(`INSERT INTO UserTotp (UserId, Secret)
+			SELECT Id, CAST(? AS TEXT) FROM Users WHERE UserName = ?
+			ON CONFLICT (UserId) DO UPDATE SET
+				Secret = excluded.Secret,
+				LastUsedCounter = 0,
+				CreationDate = ?
+			WHERE UserTotp.Confirmed = FALSE`, secret, username, DbTimestamp(time.Now()))
+		return err
+	})
+	if err != nil {
+		slog.Error("Cannot store TOTP secret", "user", username, "error", err)
+		return false, err
+	}
+
+	return true, nil
+}
+
+// ConfirmUserTotp Completes a TOTP enrolment and stores the hashes of the
+// user's recovery codes
+func ConfirmUserTotp(username string, counter int64, recoveryCodeHashes []string) (bool, error) {
+	err := transaction(func(u *Unit) error {
+		_, err := u.Exec(`UPDATE UserTotp SET Confirmed = TRUE, ConfirmedDate = ?, LastUsedCounter = ?
+			WHERE UserId = (SELECT Id FROM Users WHERE UserName = ?)`,
+			DbTimestamp(time.Now()), counter, username)
+		if err != nil {
+			slog.Error("Cannot confirm TOTP enrolment", "user", username, "error", err)
+			return err
+		}
+
+		return replaceRecoveryCodes(u, username, recoveryCodeHashes)
+	})
+	if err != nil {
+		return false, err
+	}
+
+	slog.Info("TOTP enrolment confirmed", "user", username)
+	return true, nil
+}
+
+// UseTotpCounter Records a TOTP time step as used, returning false if it or a
+// later step was already used, so each code only works once
+func UseTotpCounter(username string, counter int64) (bool, error) {
+	var numberOfRows int64
+	err := run(func(u *Unit) error {
+		result, err := u.Exec(`UPDATE UserTotp SET LastUsedCounter = ?
+			WHERE UserId = (SELECT Id FROM Users WHERE UserName = ?) AND LastUsedCounter < ?`,
+			counter, username, counter)
+		if err != nil {
+			return err
+		}
+		numberOfRows, err = result.RowsAffected()
+		return err
+	})
+	if err != nil {
+		slog.Error("Cannot record TOTP use", "user", username, "error", err)
+		return false, err
+	}
+
+	return numberOfRows > 0, nil
+}
+
+// DeleteUserTotp Removes a user's TOTP enrolment along with their recovery codes
+func DeleteUserTotp(username string) (bool, error) {
+	slog.Debug("TOTP enrolment removal requested", "user", username)
+	var numberOfRows int64
+	err := transaction(func(u *Unit) error {
+		_, err := u.Exec("DELETE FROM RecoveryCodes WHERE UserId = (SELECT Id FROM Users WHERE UserName = ?)", username)
+		if err != nil {
+			return err
+		}
+		result, err := u.Exec("DELETE FROM UserTotp WHERE UserId = (SELECT Id FROM Users WHERE UserName = ?)", username)
+		if err != nil {
+			return err
+		}
+		numberOfRows, err = result.RowsAffected()
+		return err
+	})
+	if err != nil {
+		slog.Error("Cannot remove TOTP enrolment", "user", username, "error", err)
+		return false, err
+	}
+
+	return numberOfRows > 0, nil
+}
+
+// ReplaceRecoveryCodes Replaces all of a user's recovery codes
+func ReplaceRecoveryCodes(username string, recoveryCodeHashes []string) (bool, error) {
+	err := transaction(func(u *Unit) error {
+		return replaceRecoveryCodes(u, username, recoveryCodeHashes)
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func replaceRecoveryCodes(u *Unit, username string, recoveryCodeHashes []string) error {
+	_, err := u.Exec("DELETE FROM RecoveryCodes WHERE UserId = (SELECT Id FROM Users WHERE UserName = ?)", username)
+	if err != nil {
+		slog.Error("Cannot remove recovery codes", "user", username, "error", err)
+		return err
+	}
+
+	for _, hash := range recoveryCodeHashes {
+		_, err = u.Exec("INSERT INTO RecoveryCodes (UserId, CodeHash) SELECT Id, CAST(? AS TEXT) FROM Users WHERE UserName = ?",
+			hash, username)
+		if err != nil {
+			slog.Error("Cannot store recovery code", "user", username, "error", err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// UseRecoveryCode Marks a recovery code as used, returning false if it does
+// not exist or was already used. The hash is compared with each unused code
+// in constant time rather than looked up, so timing does not reveal how much
+// of it matched
+func UseRecoveryCode(username string, codeHash string) (bool, error) {
+	var numberOfRows int64
+	err := transaction(func(u *Unit) error {
+		matchId := 0
+		err := u.Each(`SELECT r.Id, r.CodeHash FROM RecoveryCodes r JOIN Users u ON u.Id = r.UserId
+			WHERE u.UserName = ? AND r.UsedDate IS NULL`, []any{username}, func(rows *sql.Rows) error {
+			var id int
+			var storedHash string
+			if err := rows.Scan(&id, &storedHash); err != nil {
+				return err
+			}
+			if subtle.ConstantTimeCompare([]byte(storedHash), []byte(codeHash)) == 1 {
+				matchId = id
+			}
+			return nil
+		})
+		if err != nil || matchId == 0 {
+			return err
+		}
+
+		result, err := u.Exec("UPDATE RecoveryCodes SET UsedDate = ? WHERE Id = ? AND UsedDate IS NULL",
+			DbTimestamp(time.Now()), matchId)
+		if err != nil {
+			return err
+		}
+		numberOfRows, err = result.RowsAffected()
+		return err
+	})
+	if err != nil {
+		slog.Error("Cannot use recovery code", "user", username, "error", err)
+		return false, err
+	}
+
+	return numberOfRows > 0, nil
+}
+
+// CountUnusedRecoveryCodes Returns how many recovery codes a user has left
+func CountUnusedRecoveryCodes(username string) (int, error) {
+	count := 0
+	err := run(func(u *Unit) error {
+		return u.QueryRow(`SELECT COUNT(*) FROM RecoveryCodes
+			WHERE UserId = (SELECT Id FROM Users WHERE UserName = ?) AND UsedDate IS NULL`, username).Scan(&count)
+	})
+	if err != nil {
+		slog.Error("Cannot count recovery codes", "user", username, "error", err)
+		return 0, err
+	}
+
+	return count, nil
+}
@@ -18,16 +18,138 @@ package model
 
 */
 
+import "encoding/json"
+
+type ApiToken struct {
+	Id           int      `json:"Id"`
+	UserId       int      `json:"userId"`
+	Name         string   `json:"name"`
+	TokenPrefix  string   `json:"tokenPrefix"`
+	Scopes       []string `json:"scopes"`
+	CreationDate string   `json:"creationDate"`
+	ExpiryDate   string   `json:"expiryDate"`
+	LastUsedDate string   `json:"lastUsedDate"`
+}
+
+type ApiTokensList struct {
+	Data []ApiToken `json:"data"`
+}
+
+// BackupManifest describes a database backup, written next to it
+type BackupManifest struct {
+	FormatVersion int `json:"formatVersion"`
+	// name of the backup file, in the same directory as the manifest
+	File          string `json:"file"`
+	Compressed    bool   `json:"compressed"`
+	Sha256        string `json:"sha256"`
+	SchemaVersion int    `json:"schemaVersion"`
+	// rows in each table at the time of the backup
+	RowCounts    map[string]int64 `json:"rowCounts"`
+	CreationDate string           `json:"creationDate"`
+}
+
+// CertificateStatus describes a certificate served over TLS
+type CertificateStatus struct {
+	PemFile  string   `json:"pemFile"`
+	Subject  string   `json:"subject"`
+	DnsNames []string `json:"dnsNames"`
+	NotAfter string   `json:"notAfter"`
+	// negative once the certificate has expired
+	DaysUntilExpiry int    `json:"daysUntilExpiry"`
+	Status          string `json:"status" enum:"OK,EXPIRING,EXPIRED"`
+}
+
+// ConfigReload lists the config keys a reload changed
+type ConfigReload struct {
+	Message    string `json:"message"`
+	ConfigPath string `json:"configPath"`
+	// keys whose new values are in effect
+	Applied []string `json:"applied"`
+	// keys whose new values wait for a restart, the running values are kept
+	RestartRequired     []string `json:"restartRequired"`
+	CertificateReloaded bool     `json:"certificateReloaded"`
+}
+
+type CreatedBackup struct {
+	Message  string         `json:"message"`
+	Path     string         `json:"path"`
+	Manifest BackupManifest `json:"manifest"`
+}
+
+// CreatedApiToken holds a new token along with its secret, which is only ever shown once
+type CreatedApiToken struct {
+	ApiToken
+	Token string `json:"token"`
+}
+
+type Credentials struct {
+	UserName string `json:"userName"`
+	Password string `json:"password"`
+	// a TOTP code or recovery code, for users enrolled in two-factor authentication
+	TotpCode string `json:"totpCode"`
+}
+
+// DailyUpdateCount sums up the reports of one system on one day, kept longer
+// than the reports themselves
+type DailyUpdateCount struct {
+	SystemId int `json:"systemId"`
+	// UTC day, as YYYY-MM-DD
+	Day             string `json:"day"`
+	Reports         int    `json:"reports"`
+	MaxUpdateCount  int    `json:"maxUpdateCount"`
+	LastUpdateCount int    `json:"lastUpdateCount"`
+}
+
+// FailureMsg is the body of every error response
 type FailureMsg struct {
+	// what went wrong, e.g. NOT_FOUND or VALIDATION_FAILED
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// finds the request in the log
+	RequestId string `json:"requestId,omitempty"`
+	// the message again, for clients written against the former error body
 	Error string `json:"error"`
 }
 
 type HealthCheck struct {
-	Db           string `json:"db"`
-	DiskSpace    string `json:"diskSpace"`
-	DiskWritable string `json:"diskWritable"`
-	Health       string `json:"health"`
-	Status       int    `json:"status"`
+	// the checks that do not apply, e.g. to liveness, are left out
+	Db           string `json:"db,omitempty"`
+	DiskSpace    string `json:"diskSpace,omitempty"`
+	DiskWritable string `json:"diskWritable,omitempty"`
+	IngestionLag string `json:"ingestionLag,omitempty"`
+	StaleAgents  string `json:"staleAgents,omitempty"`
+	// only present when TLS is used
+	Certificates []CertificateStatus `json:"certificates,omitempty"`
+	Health       string              `json:"health" enum:"OK,WARNING,UNHEALTHY"`
+	Status       int                 `json:"status"`
+}
+
+type Lockout struct {
+	UserName   string `json:"userName"`
+	Reason     string `json:"reason"`
+	LockedDate string `json:"lockedDate"`
+	// empty when the lock is only lifted by an administrator
+	UnlockDate string `json:"unlockDate"`
+}
+
+type LoginEvent struct {
+	Id           int    `json:"Id"`
+	UserName     string `json:"userName"`
+	RemoteAddr   string `json:"remoteAddr"`
+	Event        string `json:"event" enum:"success,failure,locked,unlocked"`
+	Reason       string `json:"reason"`
+	CreationDate string `json:"creationDate"`
+}
+
+type LoginEventsList struct {
+	Data []LoginEvent `json:"data"`
+}
+
+type OperatingSystem struct {
+	Id           int    `json:"Id"`
+	OsIdName     string `json:"osIdName"`
+	OsVersion    string `json:"osVersion"`
+	CreationDate string `json:"creationDate"`
 }
 
 type PasswordChange struct {
@@ -35,21 +157,79 @@ type PasswordChange struct {
 	NewPassword string `json:"newPassword"`
 }
 
+type ProposedApiToken struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	// days until the token expires, 0 for a token that does not expire
+	ExpiresInDays int `json:"expiresInDays"`
+}
+
 type ProposedUser struct {
 	Id        int    `json:"Id"`
 	UserName  string `json:"userName"`
 	FullName  string `json:"fullName"`
-	Status    string `json:"status" enum:"enabled,disabled"`
+	Status    string `json:"status" enum:"enabled,locked"`
 	OrgUnitId int    `json:"orgUnitId"`
 	RoleId    int    `json:"roleId"`
 	Password  string `json:"password"`
 }
 
+// UserUpdate holds the profile fields of a user to change, fields left out
+// keep their value. An orgUnitId of 0 removes the user from their unit
+type UserUpdate struct {
+	FullName  *string `json:"fullName"`
+	RoleId    *int    `json:"roleId"`
+	OrgUnitId *int    `json:"orgUnitId"`
+}
+
+// RetentionRun describes one run of the retention job
+type RetentionRun struct {
+	StartDate         string `json:"startDate"`
+	FinishDate        string `json:"finishDate"`
+	ReportsDeleted    int64  `json:"reportsDeleted"`
+	AggregatesDeleted int64  `json:"aggregatesDeleted"`
+	SystemsDeleted    int64  `json:"systemsDeleted"`
+	// database pages returned to the filesystem by the vacuum
+	PagesFreed int64 `json:"pagesFreed"`
+	// empty unless the run failed part way
+	Error string `json:"error"`
+}
+
+type RetentionStatus struct {
+	Enabled                  bool   `json:"enabled"`
+	ReportDays               int    `json:"reportDays"`
+	AggregateMonths          int    `json:"aggregateMonths"`
+	DecommissionedSystemDays int    `json:"decommissionedSystemDays"`
+	IntervalMinutes          int    `json:"intervalMinutes"`
+	Running                  bool   `json:"running"`
+	NextRunDate              string `json:"nextRunDate"`
+	// absent until the job has run once
+	LastRun *RetentionRun `json:"lastRun,omitempty"`
+}
+
 type Role struct {
-	Id           int    `json:"Id"`
-	RoleName     string `json:"roleName"`
-	Description  string `json:"description"`
-	CreationDate string `json:"creationDate"`
+	Id                int    `json:"Id"`
+	RoleName          string `json:"roleName"`
+	Description       string `json:"description"`
+	TwoFactorRequired bool   `json:"twoFactorRequired"`
+	CreationDate      string `json:"creationDate"`
+}
+
+type RoleTwoFactorRequired struct {
+	TwoFactorRequired bool `json:"twoFactorRequired"`
+}
+
+// ProfileUpdate holds the fields users may change in their own profile
+type ProfileUpdate struct {
+	FullName *string `json:"fullName"`
+}
+
+// RoleUpdate holds the fields of a role to change, fields left out keep their
+// value
+type RoleUpdate struct {
+	RoleName          *string `json:"roleName"`
+	Description       *string `json:"description"`
+	TwoFactorRequired *bool   `json:"twoFactorRequired"`
 }
 
 type RolesList struct {
@@ -66,6 +246,57 @@ type User struct {
 	PasswordHash            string `json:"passwordHash"`
 	CreationDate            string `json:"creationDate"`
 	LastPasswordChangedDate string `json:"lastPasswordChangedDate"`
+	AuthSource              string `json:"authSource"`
+}
+
+type System struct {
+	Id           int    `json:"Id"`
+	Fqdn         string `json:"fqdn"`
+	OsFamilyId   int    `json:"osFamilyId"`
+	OsId         int    `json:"osId"`
+	ArchId       int    `json:"archId"`
+	CreationDate string `json:"creationDate"`
+}
+
+type TotpCode struct {
+	Code string `json:"code"`
+}
+
+type TotpEnrollment struct {
+	Secret     string `json:"secret"`
+	OtpauthUri string `json:"otpauthUri"`
+	// PNG image of the otpauth URI as a QR code, base64 encoded
+	QrCode string `json:"qrCode"`
+}
+
+type TotpRecoveryCodes struct {
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+type TotpStatus struct {
+	Enabled                bool   `json:"enabled"`
+	Required               bool   `json:"required"`
+	ConfirmedDate          string `json:"confirmedDate"`
+	RecoveryCodesRemaining int    `json:"recoveryCodesRemaining"`
+}
+
+type UpdateRecord struct {
+	Id          int `json:"Id"`
+	SystemId    int `json:"systemId"`
+	UpdateCount int `json:"updateCount"`
+	// the updates as reported by the system
+	UpdateRecord   json.RawMessage `json:"updateRecord" swaggertype:"object"`
+	CreationDate   string          `json:"creationDate"`
+	LastUpdateDate string          `json:"lastUpdateDate"`
+}
+
+// UpdateReport is one report a system sent, kept for the retention period
+type UpdateReport struct {
+	Id           int             `json:"Id"`
+	SystemId     int             `json:"systemId"`
+	UpdateCount  int             `json:"updateCount"`
+	UpdateRecord json.RawMessage `json:"updateRecord" swaggertype:"object"`
+	CreationDate string          `json:"creationDate"`
 }
 
 type UserRoleId struct {
@@ -77,17 +308,43 @@ type UserRoleIdMsg struct {
 	UserRoleId int    `json:"roleId"`
 }
 
+type UserTotp struct {
+	UserId          int
+	Secret          string
+	Confirmed       bool
+	LastUsedCounter int64
+	CreationDate    string
+	ConfirmedDate   string
+}
+
 type UsersList struct {
 	Data []User `json:"data"`
 }
 
 type UserStatus struct {
-	Status string `json:"status" enum:"enabled,disabled"`
+	Status string `json:"status" enum:"enabled,locked"`
+	Reason string `json:"reason"`
 }
 
 type UserStatusMsg struct {
-	Message    string `json:"message"`
-	UserStatus string `json:"userStatus" enum:"enabled,disabled"`
+	Message    string   `json:"message"`
+	UserStatus string   `json:"userStatus" enum:"enabled,disabled"`
+	Lockout    *Lockout `json:"lockout,omitempty"`
+}
+
+type Session struct {
+	Id           string `json:"Id"`
+	UserName     string `json:"userName"`
+	Data         string `json:"-"`
+	RemoteAddr   string `json:"remoteAddr"`
+	UserAgent    string `json:"userAgent"`
+	CreationDate string `json:"creationDate"`
+	LastSeenDate string `json:"lastSeenDate"`
+	ExpiresDate  string `json:"expiresDate"`
+}
+
+type SessionsList struct {
+	Data []Session `json:"data"`
 }
 
 type SuccessMsg struct {
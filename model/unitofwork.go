@@ -0,0 +1,191 @@
+package model
+
+/*
+
+  Copyright 2024, YggdrasilSoft, LLC.
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+)
+
+// Unit is one unit of work on a Database. Its queries share a context that is
+// cancelled once the query timeout passes, run as cached prepared statements
+// and, when the unit is a transaction, run inside it
+type Unit struct {
+	ctx context.Context
+	db  *Database
+	tx  *sql.Tx
+}
+
+// Row is the result of QueryRow, holding the error if the query could not be
+// prepared until it is scanned
+type Row struct {
+	row *sql.Row
+	err error
+}
+
+func (r *Row) Scan(dest ...any) error {
+	if r.err != nil {
+		return r.err
+	}
+	return r.row.Scan(dest...)
+}
+
+// Run Runs fn as a unit of work outside of any transaction, for reads and
+// single statements
+func (d *Database) Run(ctx context.Context, fn func(u *Unit) error) error {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	return fn(&Unit{ctx: ctx, db: d})
+}
+
+// Transaction Runs fn as a unit of work in a transaction, committed if fn
+// returns nil and rolled back if it returns an error or panics
+func (d *Database) Transaction(ctx context.Context, fn func(u *Unit) error) error {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := d.BeginTx(ctx, nil)
+	if err != nil {
+		slog.Error("Could not start DB transaction", "error", err)
+		return err
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			tx.Rollback()
+		}
+	}()
+
+	if err := fn(&Unit{ctx: ctx, db: d, tx: tx}); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		slog.Error("Could not commit DB transaction", "error", err)
+		return err
+	}
+	committed = true
+
+	return nil
+}
+
+func (d *Database) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if d.QueryTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, d.QueryTimeout)
+}
+
+// prepared Returns the cached statement for a query, preparing it on first
+// use. Statements on the pool are safe for concurrent use and are prepared
+// again on each connection as needed
+func (d *Database) prepared(ctx context.Context, query string) (*sql.Stmt, error) {
+	d.mu.Lock()
+	stmt, ok := d.stmts[query]
+	d.mu.Unlock()
+	if ok {
+		return stmt, nil
+	}
+
+	stmt, err := d.DB.PrepareContext(ctx, d.Dialect.Rebind(query))
+	if err != nil {
+		slog.Error("Could not prepare the DB query", "error", err)
+		return nil, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	// another caller may have prepared it meanwhile, keep theirs
+	if cached, ok := d.stmts[query]; ok {
+		stmt.Close()
+		return cached, nil
+	}
+	if d.stmts == nil {
+		d.stmts = map[string]*sql.Stmt{}
+	}
+	d.stmts[query] = stmt
+
+	return stmt, nil
+}
+
+// Close Closes the cached statements and then the pool
+func (d *Database) Close() error {
+	d.mu.Lock()
+	for query, stmt := range d.stmts {
+		stmt.Close()
+		delete(d.stmts, query)
+	}
+	d.mu.Unlock()
+
+	return d.DB.Close()
+}
+
+func (u *Unit) stmt(query string) (*sql.Stmt, error) {
+	stmt, err := u.db.prepared(u.ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	if u.tx != nil {
+		// closed along with the transaction
+		return u.tx.StmtContext(u.ctx, stmt), nil
+	}
+
+	return stmt, nil
+}
+
+func (u *Unit) Exec(query string, args ...any) (sql.Result, error) {
+	stmt, err := u.stmt(query)
+	if err != nil {
+		return nil, err
+	}
+
+	return stmt.ExecContext(u.ctx, args...)
+}
+
+func (u *Unit) QueryRow(query string, args ...any) *Row {
+	stmt, err := u.stmt(query)
+	if err != nil {
+		return &Row{err: err}
+	}
+
+	return &Row{row: stmt.QueryRowContext(u.ctx, args...)}
+}
+
+// Each Runs a query and calls fn for each row, closing the rows afterwards
+func (u *Unit) Each(query string, args []any, fn func(rows *sql.Rows) error) error {
+	stmt, err := u.stmt(query)
+	if err != nil {
+		return err
+	}
+
+	rows, err := stmt.QueryContext(u.ctx, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		if err := fn(rows); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
@@ -19,19 +19,152 @@ package model
 */
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
+	_ "github.com/jackc/pgx/v5/stdlib"
 	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/greeneg/update-reporterd/globals"
 )
 
-var DB *sql.DB
+// Dialect is the flavour of SQL a database server speaks
+type Dialect string
+
+const (
+	DialectSqlite   Dialect = globals.DatabaseSqlite
+	DialectPostgres Dialect = globals.DatabasePostgres
+)
+
+// Rebind Rewrites the ? placeholders queries are written with into the
+// placeholders of the dialect
+func (d Dialect) Rebind(query string) string {
+	if d != DialectPostgres {
+		return query
+	}
+
+	var rebound strings.Builder
+	n := 0
+	quoted := false
+	for _, r := range query {
+		if r == '\'' {
+			quoted = !quoted
+		}
+		if r == '?' && !quoted {
+			n++
+			rebound.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		rebound.WriteRune(r)
+	}
+
+	return rebound.String()
+}
+
+// Database is a connection pool along with the dialect of its server. Queries
+// are written with ? placeholders and run through Run or Transaction, which
+// rebind them and cache their prepared statements
+type Database struct {
+	*sql.DB
+	Dialect Dialect
+	// QueryTimeout bounds each unit of work, unbounded when zero
+	QueryTimeout time.Duration
 
-func ConnectDatabase(dbPath string) error {
-	db, err := sql.Open("sqlite3", "file:"+dbPath+"?_foreign_keys=on")
+	mu    sync.Mutex
+	stmts map[string]*sql.Stmt
+}
+
+var DB *Database
+
+// run Runs fn as a unit of work on DB
+func run(fn func(u *Unit) error) error {
+	return DB.Run(context.Background(), fn)
+}
+
+// transaction Runs fn as a unit of work in a transaction on DB
+func transaction(fn func(u *Unit) error) error {
+	return DB.Transaction(context.Background(), fn)
+}
+
+// OpenDatabase Opens a database of the given dialect. The source is a file
+// path for SQLite and a connection URL for PostgreSQL
+func OpenDatabase(dialect Dialect, source string) (*Database, error) {
+	var db *sql.DB
+	var err error
+	switch dialect {
+	case DialectSqlite:
+		// transactions take the write lock when they begin, as two that each
+		// read before writing would otherwise wait on one another until the
+		// busy timeout and fail
+		db, err = sql.Open("sqlite3", "file:"+source+"?_foreign_keys=on&_busy_timeout=5000&_txlock=immediate")
+	case DialectPostgres:
+		db, err = sql.Open("pgx", source)
+	default:
+		return nil, errors.New("unsupported database driver '" + string(dialect) + "'")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	database := &Database{
+		DB:           db,
+		Dialect:      dialect,
+		QueryTimeout: globals.DefaultDatabaseQueryTimeoutSeconds * time.Second,
+	}
+	if dialect == DialectSqlite {
+		if err := startIncrementalVacuum(database); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+
+	return database, nil
+}
+
+// startIncrementalVacuum Puts a new, empty SQLite file in incremental vacuum
+// mode, which is free before any table is written. Older files are switched
+// by setuptool vacuum
+func startIncrementalVacuum(db *Database) error {
+	var pages int64
+	if err := db.QueryRow("PRAGMA page_count").Scan(&pages); err != nil {
+		return err
+	}
+	if pages > 0 {
+		return nil
+	}
+
+	_, err := EnableIncrementalVacuum(context.Background(), db)
+	return err
+}
+
+// DatabaseSource Returns the dialect and source of the configured database
+func DatabaseSource(config globals.Config) (Dialect, string) {
+	switch config.Database.Driver {
+	case "", globals.DatabaseSqlite:
+		return DialectSqlite, config.DbPath
+	default:
+		return Dialect(config.Database.Driver), config.Database.Url
+	}
+}
+
+// ConnectDatabase Opens the configured database and makes it the store the
+// model is kept in
+func ConnectDatabase(config globals.Config) error {
+	db, err := OpenDatabase(DatabaseSource(config))
 	if err != nil {
 		return err
 	}
 
+	if config.Database.QueryTimeoutSeconds > 0 {
+		db.QueryTimeout = time.Duration(config.Database.QueryTimeoutSeconds) * time.Second
+	}
+
 	DB = db
+	SetStore(NewSqlStore(db))
 	return nil
 }
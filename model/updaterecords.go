@@ -0,0 +1,222 @@
+package model
+
+/*
+
+  Copyright 2024, YggdrasilSoft, LLC.
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log/slog"
+	"time"
+)
+
+const updateRecordColumns = "Id, SystemId, UpdateCount, UpdateRecord, CreationDate, LastUpdateDate"
+
+func scanUpdateRecord(scanner interface{ Scan(...any) error }) (UpdateRecord, error) {
+	record := UpdateRecord{}
+	document := ""
+	err := scanner.Scan(
+		&record.Id,
+		&record.SystemId,
+		&record.UpdateCount,
+		&document,
+		&record.CreationDate,
+		&record.LastUpdateDate,
+	)
+	if err != nil {
+		return UpdateRecord{}, err
+	}
+
+	record.UpdateRecord = json.RawMessage(document)
+	record.CreationDate = ConvertDbTimestamp(record.CreationDate)
+	record.LastUpdateDate = ConvertDbTimestamp(record.LastUpdateDate)
+
+	return record, nil
+}
+
+func GetUpdateRecords() ([]UpdateRecord, error) {
+	slog.Debug("List of update records requested")
+	return store.GetUpdateRecords()
+}
+
+func (s *SqlStore) GetUpdateRecords() ([]UpdateRecord, error) {
+	records := make([]UpdateRecord, 0)
+	err := s.run(func(u *Unit) error {
+		return u.Each("SELECT "+updateRecordColumns+" FROM UpdateRecords", nil, func(rows *sql.Rows) error {
+			record, err := scanUpdateRecord(rows)
+			if err != nil {
+				return err
+			}
+			records = append(records, record)
+			return nil
+		})
+	})
+	if err != nil {
+		slog.Error("Cannot marshal the update records", "error", err)
+		return nil, err
+	}
+
+	return records, nil
+}
+
+func GetUpdateRecordBySystemId(systemId int) (UpdateRecord, error) {
+	slog.Debug("Update record requested", "systemId", systemId)
+	return store.GetUpdateRecordBySystemId(systemId)
+}
+
+func (s *SqlStore) GetUpdateRecordBySystemId(systemId int) (UpdateRecord, error) {
+	record := UpdateRecord{}
+	err := s.run(func(u *Unit) error {
+		var err error
+		record, err = scanUpdateRecord(u.QueryRow("SELECT "+updateRecordColumns+" FROM UpdateRecords WHERE SystemId = ?", systemId))
+		return err
+	})
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return UpdateRecord{}, nil
+		}
+		slog.Error("Cannot retrieve update record from DB", "error", err)
+		return UpdateRecord{}, err
+	}
+
+	return record, nil
+}
+
+func SaveUpdateRecord(record UpdateRecord) (bool, error) {
+	slog.Debug("Saving update record", "systemId", record.SystemId)
+	if !json.Valid(record.UpdateRecord) {
+		return false, &InvalidUpdateRecord{}
+	}
+	return store.SaveUpdateRecord(record)
+}
+
+func (s *SqlStore) SaveUpdateRecord(record UpdateRecord) (bool, error) {
+	now := time.Now().UTC()
+	err := s.transaction(func(u *Unit) error {
+		_, err := u.Exec(`INSERT INTO UpdateRecords (SystemId, UpdateCount, UpdateRecord, LastUpdateDate)
+			VALUES (?, ?, ?, ?)
+			ON CONFLICT (SystemId) DO UPDATE SET
+				UpdateCount = excluded.UpdateCount,
+				UpdateRecord = excluded.UpdateRecord,
+				LastUpdateDate = excluded.LastUpdateDate`,
+			record.SystemId, record.UpdateCount, string(record.UpdateRecord), DbTimestamp(now))
+		if err != nil {
+			return err
+		}
+
+		_, err = u.Exec("INSERT INTO UpdateReports (SystemId, UpdateCount, UpdateRecord, CreationDate) VALUES (?, ?, ?, ?)",
+			record.SystemId, record.UpdateCount, string(record.UpdateRecord), DbTimestamp(now))
+		if err != nil {
+			return err
+		}
+
+		_, err = u.Exec(`INSERT INTO DailyUpdateCounts (SystemId, Day, Reports, MaxUpdateCount, LastUpdateCount)
+			VALUES (?, ?, 1, ?, ?)
+			ON CONFLICT (SystemId, Day) DO UPDATE SET
+				Reports = DailyUpdateCounts.Reports + 1,
+				MaxUpdateCount = CASE WHEN excluded.MaxUpdateCount > DailyUpdateCounts.MaxUpdateCount
+					THEN excluded.MaxUpdateCount ELSE DailyUpdateCounts.MaxUpdateCount END,
+				LastUpdateCount = excluded.LastUpdateCount`,
+			record.SystemId, now.Format(dayLayout), record.UpdateCount, record.UpdateCount)
+		return err
+	})
+	if err != nil {
+		slog.Error("Cannot store update record", "systemId", record.SystemId, "error", err)
+		return false, err
+	}
+
+	return true, nil
+}
+
+func DeleteUpdateRecord(systemId int) (bool, error) {
+	slog.Debug("Update record deletion requested", "systemId", systemId)
+	return store.DeleteUpdateRecord(systemId)
+}
+
+func (s *SqlStore) DeleteUpdateRecord(systemId int) (bool, error) {
+	var numberOfRows int64
+	err := s.run(func(u *Unit) error {
+		result, err := u.Exec("DELETE FROM UpdateRecords WHERE SystemId = ?", systemId)
+		if err != nil {
+			return err
+		}
+		numberOfRows, err = result.RowsAffected()
+		return err
+	})
+	if err != nil {
+		slog.Error("Cannot delete update record", "systemId", systemId, "error", err)
+		return false, err
+	}
+
+	return numberOfRows > 0, nil
+}
+
+func GetUpdateReports(systemId int) ([]UpdateReport, error) {
+	slog.Debug("Update report history requested", "systemId", systemId)
+	return store.GetUpdateReports(systemId)
+}
+
+func (s *SqlStore) GetUpdateReports(systemId int) ([]UpdateReport, error) {
+	reports := make([]UpdateReport, 0)
+	err := s.run(func(u *Unit) error {
+		return u.Each(`SELECT Id, SystemId, UpdateCount, UpdateRecord, CreationDate FROM UpdateReports
+			WHERE SystemId = ? ORDER BY CreationDate, Id`, []any{systemId}, func(rows *sql.Rows) error {
+			report := UpdateReport{}
+			document := ""
+			if err := rows.Scan(&report.Id, &report.SystemId, &report.UpdateCount, &document, &report.CreationDate); err != nil {
+				return err
+			}
+			report.UpdateRecord = json.RawMessage(document)
+			report.CreationDate = ConvertDbTimestamp(report.CreationDate)
+			reports = append(reports, report)
+			return nil
+		})
+	})
+	if err != nil {
+		slog.Error("Cannot retrieve update reports", "systemId", systemId, "error", err)
+		return nil, err
+	}
+
+	return reports, nil
+}
+
+func GetDailyUpdateCounts(systemId int) ([]DailyUpdateCount, error) {
+	slog.Debug("Daily update counts requested", "systemId", systemId)
+	return store.GetDailyUpdateCounts(systemId)
+}
+
+func (s *SqlStore) GetDailyUpdateCounts(systemId int) ([]DailyUpdateCount, error) {
+	counts := make([]DailyUpdateCount, 0)
+	err := s.run(func(u *Unit) error {
+		return u.Each(`SELECT SystemId, Day, Reports, MaxUpdateCount, LastUpdateCount FROM DailyUpdateCounts
+			WHERE SystemId = ? ORDER BY Day`, []any{systemId}, func(rows *sql.Rows) error {
+			count := DailyUpdateCount{}
+			if err := rows.Scan(&count.SystemId, &count.Day, &count.Reports, &count.MaxUpdateCount, &count.LastUpdateCount); err != nil {
+				return err
+			}
+			counts = append(counts, count)
+			return nil
+		})
+	})
+	if err != nil {
+		slog.Error("Cannot retrieve daily update counts", "systemId", systemId, "error", err)
+		return nil, err
+	}
+
+	return counts, nil
+}
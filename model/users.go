@@ -19,25 +19,54 @@ package model
 */
 
 import (
-	"crypto/sha512"
 	"database/sql"
-	"encoding/hex"
 	"errors"
-	"log"
+	"log/slog"
 	"strconv"
+	"strings"
 	"time"
 )
 
-func getStoredPasswordHash(username string) (string, error) {
-	q, err := DB.Prepare("SELECT PasswordHash FROM Users WHERE UserName = ?")
+// userColumns are the Users columns in the order they are scanned into a User
+const userColumns = "Id, UserName, FullName, Status, RoleId, PasswordHash, CreationDate, " +
+	"LastPasswordChangedDate, AuthSource, COALESCE(OrgUnitId, 0)"
+
+func scanUser(scanner interface{ Scan(...any) error }) (User, error) {
+	user := User{}
+	err := scanner.Scan(
+		&user.Id,
+		&user.UserName,
+		&user.FullName,
+		&user.Status,
+		&user.RoleId,
+		&user.PasswordHash,
+		&user.CreationDate,
+		&user.LastPasswordChangedDate,
+		&user.AuthSource,
+		&user.OrgUnitId,
+	)
 	if err != nil {
-		return "", err
+		return User{}, err
 	}
 
+	user.CreationDate = ConvertDbTimestamp(user.CreationDate)
+	user.LastPasswordChangedDate = ConvertDbTimestamp(user.LastPasswordChangedDate)
+
+	return user, nil
+}
+
+// where a user's credentials are checked, recorded in Users.AuthSource
+const (
+	AuthSourceLocal = "local"
+	AuthSourceLdap  = "ldap"
+	AuthSourceOidc  = "oidc"
+)
+
+func (s *SqlStore) GetPasswordHash(username string) (string, error) {
 	passwordHash := ""
-	err = q.QueryRow(username).Scan(
-		&passwordHash,
-	)
+	err := s.run(func(u *Unit) error {
+		return u.QueryRow("SELECT PasswordHash FROM Users WHERE UserName = ?", username).Scan(&passwordHash)
+	})
 	if err != nil {
 		return "", err
 	}
@@ -45,347 +74,696 @@ func getStoredPasswordHash(username string) (string, error) {
 	return passwordHash, nil
 }
 
-func storeNewPassword(hashedPassword string, username string) (bool, error) {
-	t, err := DB.Begin()
-	if err != nil {
-		return false, err
-	}
+func (s *SqlStore) ChangePasswordHash(username string, hashedPassword string, historyCount int) (bool, error) {
+	err := s.transaction(func(u *Unit) error {
+		// remember the outgoing hash so it cannot be reused
+		if historyCount > 0 {
+			_, err := u.Exec(`INSERT INTO PasswordHistory (UserId, PasswordHash)
+				SELECT Id, PasswordHash FROM Users WHERE UserName = ?`, username)
+			if err != nil {
+				return err
+			}
+
+			_, err = u.Exec(`DELETE FROM PasswordHistory
+				WHERE UserId = (SELECT Id FROM Users WHERE UserName = ?)
+				AND Id NOT IN (
+					SELECT h.Id FROM PasswordHistory h JOIN Users u ON u.Id = h.UserId
+					WHERE u.UserName = ? ORDER BY h.Id DESC LIMIT ?
+				)`, username, username, historyCount)
+			if err != nil {
+				return err
+			}
+		}
 
-	// now we need to create a new transaction to SET the password hash into the DB
-	q, err := DB.Prepare("UPDATE Users SET PasswordHash = ?, LastPasswordChangedDate = ? WHERE UserName = ?")
+		// get time stamp
+		tStamp := DbTimestamp(time.Now()) // force into SQL DateTime format
+
+		_, err := u.Exec("UPDATE Users SET PasswordHash = ?, LastPasswordChangedDate = ? WHERE UserName = ?",
+			hashedPassword, tStamp, username)
+		return err
+	})
 	if err != nil {
 		return false, err
 	}
 
-	// get time stamp
-	tStamp := time.Now().Format("2006-01-02 15:04:05") // force into SQL DateTime format
+	return true, nil
+}
 
-	_, err = q.Exec(hashedPassword, tStamp, username)
+// UpdatePasswordHash Replaces a user's stored password hash without treating
+// it as a password change, used to upgrade hashes to the current scheme
+func UpdatePasswordHash(username string, hashedPassword string) (bool, error) {
+	return store.SetPasswordHash(username, hashedPassword)
+}
+
+func (s *SqlStore) SetPasswordHash(username string, hashedPassword string) (bool, error) {
+	err := s.run(func(u *Unit) error {
+		_, err := u.Exec("UPDATE Users SET PasswordHash = ? WHERE UserName = ?", hashedPassword, username)
+		return err
+	})
 	if err != nil {
 		return false, err
 	}
 
-	t.Commit()
-
 	return true, nil
 }
 
 func ChangeAccountPassword(username string, oldPassword string, newPassword string) (bool, error) {
-	log.Println("INFO: Password change requested")
-	hashedOldPassword := sha512.Sum512([]byte(oldPassword))
-	encodedHashedOldPassword := hex.EncodeToString(hashedOldPassword[:])
+	slog.Debug("Password change requested")
+	user, err := GetUserByUserName(username)
+	if err != nil {
+		return false, err
+	}
+	if user.UserName == "" {
+		return false, noSuchUser(username)
+	}
+	if user.AuthSource != "" && user.AuthSource != AuthSourceLocal {
+		slog.Error("Password is managed externally", "user", username, "authSource", user.AuthSource)
+		return false, &ExternallyManagedAccount{AuthSource: user.AuthSource}
+	}
 
-	storedHash, err := getStoredPasswordHash(username)
+	storedHash, err := store.GetPasswordHash(username)
 	if err != nil {
-		log.Println("ERROR: Cannot retrieve stored password hash from DB: " + string(err.Error()))
+		slog.Error("Cannot retrieve stored password hash from DB", "error", err)
 		return false, err
 	}
-	log.Println("INFO: Retrieved stored hash for comparison")
+	slog.Debug("Retrieved stored hash for comparison")
 
-	// now get password hash from the db
-	if storedHash != encodedHashedOldPassword {
-		log.Println("ERROR: Hashed value of old password does not match stored hashed value")
+	// now verify the old password against the stored hash
+	match, _, err := VerifyPassword(oldPassword, storedHash)
+	if err != nil {
+		slog.Error("Cannot verify old password", "error", err)
+		return false, err
+	}
+	if !match {
+		slog.Error("Hashed value of old password does not match stored hashed value")
 		p := new(PasswordHashMismatch)
 		return false, p
 	}
 
-	// matches, so hash new password
-	hashedNewPassword := sha512.Sum512([]byte(newPassword))
-	encodedHashedNewPassword := hex.EncodeToString(hashedNewPassword[:])
-	_, err = storeNewPassword(encodedHashedNewPassword, username)
+	// matches, so make sure the new password is acceptable
+	err = ValidatePassword(newPassword)
+	if err != nil {
+		slog.Error("New password rejected", "error", err)
+		return false, err
+	}
+	err = checkPasswordReuse(username, storedHash, newPassword)
 	if err != nil {
-		log.Println("ERROR: Cannot store updated password hash in DB: " + string(err.Error()))
+		slog.Error("New password rejected", "error", err)
 		return false, err
 	}
-	log.Println("INFO: Stored updated hash")
+
+	// and hash it
+	hashedNewPassword, err := HashPassword(newPassword)
+	if err != nil {
+		slog.Error("Cannot hash new password", "error", err)
+		return false, err
+	}
+	_, err = store.ChangePasswordHash(username, hashedNewPassword, currentPasswordPolicy().HistoryCount)
+	if err != nil {
+		slog.Error("Cannot store updated password hash in DB", "error", err)
+		return false, err
+	}
+	slog.Debug("Stored updated hash")
 
 	return true, nil
 }
 
 func GetUserById(id int) (User, error) {
-	log.Println("INFO: User by Id requested: " + strconv.Itoa(id))
-	rec, err := DB.Prepare("SELECT * FROM Users WHERE Id = ?")
-	if err != nil {
-		log.Println("ERROR: Could not prepare the DB query!" + string(err.Error()))
-		return User{}, err
-	}
+	slog.Debug("User by Id requested", "userId", id)
+	return store.GetUserById(id)
+}
 
+func (s *SqlStore) GetUserById(id int) (User, error) {
 	user := User{}
-	err = rec.QueryRow(id).Scan(
-		&user.Id,
-		&user.UserName,
-		&user.FullName,
-		&user.Status,
-		&user.RoleId,
-		&user.PasswordHash,
-		&user.CreationDate,
-		&user.LastPasswordChangedDate,
-	)
+	err := s.run(func(u *Unit) error {
+		var err error
+		user, err = scanUser(u.QueryRow("SELECT "+userColumns+" FROM Users WHERE Id = ?", id))
+		return err
+	})
 	if err != nil {
 		if err == sql.ErrNoRows {
-			log.Println("ERROR: No such user found in DB: " + string(err.Error()))
+			slog.Error("No such user found in DB", "error", err)
 			return User{}, nil
 		}
-		log.Println("ERROR: Cannot retrieve user from DB: " + string(err.Error()))
+		slog.Error("Cannot retrieve user from DB", "error", err)
 		return User{}, err
 	}
 
-	user.CreationDate = ConvertSqliteTimestamp(user.CreationDate)
-	user.LastPasswordChangedDate = ConvertSqliteTimestamp(user.LastPasswordChangedDate)
-
 	return user, nil
 }
 
 func GetUserByUserName(username string) (User, error) {
-	log.Println("INFO: User by username requested: " + username)
-	rec, err := DB.Prepare("SELECT * FROM Users WHERE UserName = ?")
-	if err != nil {
-		log.Println("ERROR: Could not prepare the DB query!" + string(err.Error()))
-		return User{}, err
-	}
+	slog.Debug("User by username requested", "user", username)
+	return store.GetUserByUserName(username)
+}
 
+func (s *SqlStore) GetUserByUserName(username string) (User, error) {
 	user := User{}
-	err = rec.QueryRow(username).Scan(
-		&user.Id,
-		&user.UserName,
-		&user.FullName,
-		&user.Status,
-		&user.RoleId,
-		&user.PasswordHash,
-		&user.CreationDate,
-		&user.LastPasswordChangedDate,
-	)
+	err := s.run(func(u *Unit) error {
+		var err error
+		user, err = scanUser(u.QueryRow("SELECT "+userColumns+" FROM Users WHERE UserName = ?", username))
+		return err
+	})
 	if err != nil {
 		if err == sql.ErrNoRows {
-			log.Println("ERROR: No such user found in DB: " + string(err.Error()))
+			slog.Error("No such user found in DB", "error", err)
 			return User{}, nil
 		}
-		log.Println("ERROR: Cannot retrieve user from DB: " + string(err.Error()))
+		slog.Error("Cannot retrieve user from DB", "error", err)
 		return User{}, err
 	}
 
-	user.CreationDate = ConvertSqliteTimestamp(user.CreationDate)
-	user.LastPasswordChangedDate = ConvertSqliteTimestamp(user.LastPasswordChangedDate)
-
 	return user, nil
 }
 
-func CreateUser(p ProposedUser) (bool, error) {
-	log.Println("INFO: User creation requested: " + p.UserName)
-	t, err := DB.Begin()
+// noSuchUser Returns the error for a user name that is not known
+func noSuchUser(username string) error {
+	return &NotFound{Message: "No such user '" + username + "'"}
+}
+
+// checkRoleExists Returns a Validation error if no role has the given Id
+func checkRoleExists(roleId int) error {
+	role, err := store.GetRoleById(roleId)
 	if err != nil {
-		log.Println("ERROR: Could not start DB transaction!" + string(err.Error()))
-		return false, err
+		return err
+	}
+	if role.Id == 0 {
+		return &Validation{Message: "No such role Id " + strconv.Itoa(roleId)}
 	}
+	return nil
+}
 
-	q, err := t.Prepare("INSERT INTO Users (UserName, PasswordHash) VALUES (?, ?)")
+// checkOrgUnitExists Returns a Validation error if no organizational unit has
+// the given Id. Id 0 stands for no unit
+func checkOrgUnitExists(orgUnitId int) error {
+	if orgUnitId == 0 {
+		return nil
+	}
+	exists, err := store.OrgUnitExists(orgUnitId)
 	if err != nil {
-		log.Println("ERROR: Could not prepare the DB query!" + string(err.Error()))
-		return false, err
+		return err
+	}
+	if !exists {
+		return &Validation{Message: "No such organizational unit Id " + strconv.Itoa(orgUnitId)}
 	}
+	return nil
+}
 
-	// take password and hash it
-	hash := sha512.Sum512([]byte(p.Password))
-	passwdHash := hex.EncodeToString(hash[:])
+// CreateUser Creates a local user from all the fields of the proposal,
+// returning the stored user
+func CreateUser(p ProposedUser) (User, error) {
+	slog.Debug("User creation requested", "user", p.UserName)
+	if strings.TrimSpace(p.UserName) == "" {
+		return User{}, &Validation{Message: "User name is required"}
+	}
+	if p.Status == "" {
+		p.Status = "enabled"
+	}
+	if p.Status != "enabled" && p.Status != "locked" {
+		return User{}, &InvalidStatusValue{Err: errors.New("invalid value: " + p.Status)}
+	}
+	existing, err := store.GetUserByUserName(p.UserName)
+	if err != nil {
+		return User{}, err
+	}
+	if existing.UserName != "" {
+		return User{}, &Conflict{Message: "User '" + p.UserName + "' already exists"}
+	}
+	err = checkRoleExists(p.RoleId)
+	if err != nil {
+		return User{}, err
+	}
+	err = checkOrgUnitExists(p.OrgUnitId)
+	if err != nil {
+		return User{}, err
+	}
+	err = ValidatePassword(p.Password)
+	if err != nil {
+		slog.Error("Password rejected", "user", p.UserName, "error", err)
+		return User{}, err
+	}
 
-	_, err = q.Exec(p.UserName, passwdHash)
+	// take password and hash it
+	passwdHash, err := HashPassword(p.Password)
 	if err != nil {
-		log.Println("ERROR: Cannot create user '" + p.UserName + "': " + string(err.Error()))
-		return false, err
+		slog.Error("Cannot hash password", "user", p.UserName, "error", err)
+		return User{}, err
 	}
 
-	t.Commit()
+	_, err = store.CreateUser(User{
+		UserName:     p.UserName,
+		FullName:     p.FullName,
+		Status:       "enabled",
+		OrgUnitId:    p.OrgUnitId,
+		RoleId:       p.RoleId,
+		PasswordHash: passwdHash,
+	})
+	if err != nil {
+		return User{}, err
+	}
+	// locking goes through SetUserStatus, so the lockout reason is recorded
+	if p.Status == "locked" {
+		_, err = store.SetUserStatus(p.UserName, p.Status, "Created locked")
+		if err != nil {
+			return User{}, err
+		}
+	}
 
-	log.Println("INFO: User '" + p.UserName + "' created")
-	return true, nil
+	slog.Info("User created", "user", p.UserName)
+	return store.GetUserByUserName(p.UserName)
 }
 
-func DeleteUser(username string) (bool, error) {
-	log.Println("INFO: User deletion requested: " + username)
-	t, err := DB.Begin()
+func (s *SqlStore) CreateUser(user User) (bool, error) {
+	if user.Status == "" {
+		user.Status = "enabled"
+	}
+	if user.AuthSource == "" {
+		user.AuthSource = AuthSourceLocal
+	}
+	orgUnitId := sql.NullInt64{Int64: int64(user.OrgUnitId), Valid: user.OrgUnitId != 0}
+
+	err := s.run(func(u *Unit) error {
+		_, err := u.Exec(`INSERT INTO Users (UserName, FullName, Status, OrgUnitId, RoleId, PasswordHash, AuthSource)
+			VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			user.UserName, user.FullName, user.Status, orgUnitId, user.RoleId, user.PasswordHash, user.AuthSource)
+		return err
+	})
 	if err != nil {
-		log.Println("ERROR: Could not start DB transaction!" + string(err.Error()))
+		slog.Error("Cannot create user", "user", user.UserName, "error", err)
 		return false, err
 	}
 
-	q, err := DB.Prepare("DELETE FROM Users WHERE UserName IS ?")
+	return true, nil
+}
+
+func DeleteUser(username string) (bool, error) {
+	slog.Debug("User deletion requested", "user", username)
+	user, err := store.GetUserByUserName(username)
 	if err != nil {
-		log.Println("ERROR: Could not prepare the DB query!" + string(err.Error()))
 		return false, err
 	}
+	if user.UserName == "" {
+		return false, noSuchUser(username)
+	}
+	return store.DeleteUser(username)
+}
 
-	_, err = q.Exec(username)
+func (s *SqlStore) DeleteUser(username string) (bool, error) {
+	err := s.transaction(func(u *Unit) error {
+		// sessions are kept by name without a foreign key, so a later account
+		// of the same name would take them over. Tokens and two-factor rows
+		// would cascade, but are removed here too rather than relying on it
+		queries := []string{
+			"DELETE FROM Sessions WHERE UserName = ?",
+			"DELETE FROM ApiTokens WHERE UserId = (SELECT Id FROM Users WHERE UserName = ?)",
+			"DELETE FROM RecoveryCodes WHERE UserId = (SELECT Id FROM Users WHERE UserName = ?)",
+			"DELETE FROM UserTotp WHERE UserId = (SELECT Id FROM Users WHERE UserName = ?)",
+			"DELETE FROM Users WHERE UserName = ?",
+		}
+		for _, query := range queries {
+			if _, err := u.Exec(query, username); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 	if err != nil {
-		log.Println("ERROR: Cannot delete user '" + username + "': " + string(err.Error()))
+		slog.Error("Cannot delete user", "user", username, "error", err)
 		return false, err
 	}
 
-	t.Commit()
-
-	log.Println("INFO: User '" + username + "' has been deleted")
+	slog.Info("User has been deleted", "user", username)
 	return true, nil
 }
 
 func GetUsers() ([]User, error) {
-	log.Println("INFO: List of user object requested")
-	rows, err := DB.Query("SELECT * FROM Users")
+	slog.Debug("List of user objects requested")
+	return store.GetUsers()
+}
+
+func (s *SqlStore) GetUsers() ([]User, error) {
+	users := make([]User, 0)
+	err := s.run(func(u *Unit) error {
+		return u.Each("SELECT "+userColumns+" FROM Users", nil, func(rows *sql.Rows) error {
+			user, err := scanUser(rows)
+			if err != nil {
+				return err
+			}
+			users = append(users, user)
+			return nil
+		})
+	})
 	if err != nil {
-		log.Println("ERROR: Could not run the DB query!" + string(err.Error()))
+		slog.Error("Cannot marshal the user objects", "error", err)
 		return nil, err
 	}
 
-	users := make([]User, 0)
-	for rows.Next() {
-		user := User{}
-		err = rows.Scan(
-			&user.Id,
-			&user.UserName,
-			&user.FullName,
-			&user.Status,
-			&user.RoleId,
-			&user.PasswordHash,
-			&user.CreationDate,
-			&user.LastPasswordChangedDate,
-		)
-		if err != nil {
-			log.Println("ERROR: Cannot marshal the user objects!" + string(err.Error()))
-			return nil, err
-		}
+	slog.Debug("List of all users retrieved")
+	return users, nil
+}
 
-		user.CreationDate = ConvertSqliteTimestamp(user.CreationDate)
-		user.LastPasswordChangedDate = ConvertSqliteTimestamp(user.LastPasswordChangedDate)
+func GetUsersByRoleId(roleId int) ([]User, error) {
+	slog.Debug("Users by role Id requested")
+	return store.GetUsersByRoleId(roleId)
+}
 
-		users = append(users, user)
+func (s *SqlStore) GetUsersByRoleId(roleId int) ([]User, error) {
+	users := make([]User, 0)
+	err := s.run(func(u *Unit) error {
+		return u.Each("SELECT "+userColumns+" FROM Users WHERE RoleId = ?", []any{roleId}, func(rows *sql.Rows) error {
+			user, err := scanUser(rows)
+			if err != nil {
+				return err
+			}
+			users = append(users, user)
+			return nil
+		})
+	})
+	if err != nil {
+		slog.Error("Could not retrieve users by role Id", "error", err)
+		return nil, err
 	}
 
-	log.Println("INFO: List of all users retrieved")
+	slog.Debug("List of selected users retrieved")
 	return users, nil
 }
 
-func GetUsersByRoleId(roleId int) ([]User, error) {
-	log.Println("INFO: List user objects based on role Id")
-	rows, err := DB.Query("SELECT * FROM Users WHERE RoleId IS ?", roleId)
+func GetUserStatus(username string) (string, error) {
+	slog.Debug("User status requested", "user", username)
+	status, err := store.GetUserStatus(username)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", noSuchUser(username)
+	}
+	return status, err
+}
+
+func (s *SqlStore) GetUserStatus(username string) (string, error) {
+	status := ""
+	err := s.run(func(u *Unit) error {
+		return u.QueryRow("SELECT Status FROM Users WHERE UserName = ?", username).Scan(&status)
+	})
 	if err != nil {
-		log.Println("ERROR: Could not prepare DB query! " + string(err.Error()))
-		return []User{}, err
+		slog.Error("Could not query user status", "user", username, "error", err)
+		return "", err
 	}
 
-	users := make([]User, 0)
-	for rows.Next() {
-		user := User{}
-		err := rows.Scan(
-			&user.Id,
-			&user.UserName,
-			&user.FullName,
-			&user.Status,
-			&user.RoleId,
-			&user.PasswordHash,
-			&user.CreationDate,
-			&user.LastPasswordChangedDate,
-		)
+	slog.Debug("User status", "user", username, "status", status)
+	return status, nil
+}
+
+func SetUserStatus(username string, j UserStatus) (bool, error) {
+	slog.Debug("Set user status requested", "user", username, "status", j.Status)
+	// ensure the UserStatus.Status value is either 'enabled' or 'locked'
+	if j.Status != "enabled" && j.Status != "locked" {
+		return false, &InvalidStatusValue{Err: errors.New("invalid value: " + j.Status)}
+	}
+
+	numberOfRows, err := store.SetUserStatus(username, j.Status, j.Reason)
+	if err != nil {
+		return false, err
+	}
+	slog.Debug("Rows affected", "rows", int(numberOfRows))
+	if numberOfRows == 0 {
+		return false, noSuchUser(username)
+	}
+
+	// a locked user must not keep using sessions opened before the lock
+	if j.Status == "locked" {
+		_, err = DeleteSessionsByUserName(username)
 		if err != nil {
-			return nil, err
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+func (s *SqlStore) SetUserStatus(username string, status string, reason string) (int64, error) {
+	var numberOfRows int64
+	err := s.transaction(func(u *Unit) error {
+		var current string
+		err := u.QueryRow("SELECT Status FROM Users WHERE UserName = ?", username).Scan(&current)
+		if err != nil && err != sql.ErrNoRows {
+			return err
 		}
 
-		user.CreationDate = ConvertSqliteTimestamp(user.CreationDate)
-		user.LastPasswordChangedDate = ConvertSqliteTimestamp(user.LastPasswordChangedDate)
+		result, err := u.Exec("UPDATE Users SET Status = ? WHERE UserName = ?", status, username)
+		if err != nil {
+			slog.Error("Could not execute query", "user", username, "error", err)
+			return err
+		}
+		numberOfRows, err = result.RowsAffected()
+		if err != nil {
+			return err
+		}
 
-		users = append(users, user)
+		// keep the reason for the lock so admins can see it
+		if status == "locked" {
+			if reason == "" {
+				reason = "Locked by an administrator"
+			}
+			err = setLockout(u, username, reason)
+		} else {
+			err = clearLockout(u, username)
+			// an unlock ends the failed logins before it, as a successful
+			// login does, so they no longer count towards the next lock
+			if err == nil && current == "locked" {
+				if reason == "" {
+					reason = "Unlocked by an administrator"
+				}
+				err = recordLoginEvent(u, username, "", LoginEventUnlocked, reason)
+			}
+		}
+		if err != nil {
+			slog.Error("Could not update lockout", "user", username, "error", err)
+		}
+		return err
+	})
+	if err != nil {
+		return 0, err
 	}
 
-	log.Println("INFO: List of selected users retrieved")
-	return users, nil
+	return numberOfRows, nil
 }
 
-func GetUserStatus(username string) (string, error) {
-	log.Println("INFO: User status requested for user '" + username + "'")
-	t, err := DB.Begin()
+func SetUserRoleId(username string, j UserRoleId) (bool, error) {
+	slog.Debug("Set user's role Id", "user", username)
+	err := checkRoleExists(j.RoleId)
 	if err != nil {
-		log.Println("ERROR: Could not start DB transaction: " + string(err.Error()))
-		return "", err
+		return false, err
 	}
-
-	q, err := DB.Prepare("SELECT Status FROM Users WHERE UserName IS ?")
+	numberOfRows, err := store.SetUserRoleId(username, j.RoleId)
 	if err != nil {
-		log.Println("ERROR: Could not prepare DB query! " + string(err.Error()))
-		return "", err
+		return false, err
 	}
 
-	status := ""
-	err = q.QueryRow(username).Scan(
-		&status,
-	)
-	if err != nil {
-		log.Println("ERROR: Could not query status for user '" + username + "': " + string(err.Error()))
-		return "", err
+	slog.Debug("Rows affected", "rows", int(numberOfRows))
+	if numberOfRows == 0 {
+		return false, noSuchUser(username)
 	}
+	return true, nil
+}
 
-	t.Commit()
+func (s *SqlStore) SetUserRoleId(username string, roleId int) (int64, error) {
+	var numberOfRows int64
+	err := s.run(func(u *Unit) error {
+		result, err := u.Exec("UPDATE Users SET RoleId = ? WHERE UserName = ?", roleId, username)
+		if err != nil {
+			return err
+		}
+		numberOfRows, err = result.RowsAffected()
+		return err
+	})
+	if err != nil {
+		slog.Error("Could not execute query", "user", username, "error", err)
+		return 0, err
+	}
 
-	log.Println("INFO: User '" + username + "' status: " + status)
-	return status, nil
+	return numberOfRows, nil
 }
 
-func SetUserStatus(username string, j UserStatus) (bool, error) {
-	log.Println("INFO: Set user status for user '" + username + "'")
-	t, err := DB.Begin()
+// UpdateUser Changes the profile of the user with the given Id, returning the
+// stored user. The full name and role of users from an external identity
+// provider are managed there and cannot be changed
+func UpdateUser(id int, update UserUpdate) (User, error) {
+	slog.Debug("User update requested", "userId", id)
+	user, err := store.GetUserById(id)
 	if err != nil {
-		log.Println("ERROR: Could not start DB transaction: " + string(err.Error()))
-		return false, err
+		return User{}, err
+	}
+	if user.UserName == "" {
+		return User{}, &NotFound{Message: "No user with Id " + strconv.Itoa(id)}
 	}
 
-	q, err := DB.Prepare("UPDATE Users SET Status = ? WHERE UserName = ?")
+	external := user.AuthSource != AuthSourceLocal
+	if update.FullName != nil && *update.FullName != user.FullName {
+		if external {
+			return User{}, &Forbidden{Message: "The full name of '" + user.UserName + "' is managed by '" + user.AuthSource + "'"}
+		}
+		user.FullName = *update.FullName
+	}
+	if update.RoleId != nil && *update.RoleId != user.RoleId {
+		if external {
+			return User{}, &Forbidden{Message: "The role of '" + user.UserName + "' is managed by '" + user.AuthSource + "'"}
+		}
+		err = checkRoleExists(*update.RoleId)
+		if err != nil {
+			return User{}, err
+		}
+		user.RoleId = *update.RoleId
+	}
+	if update.OrgUnitId != nil {
+		err = checkOrgUnitExists(*update.OrgUnitId)
+		if err != nil {
+			return User{}, err
+		}
+		user.OrgUnitId = *update.OrgUnitId
+	}
+
+	numberOfRows, err := store.UpdateUser(user)
 	if err != nil {
-		log.Println("ERROR: Could not prepare DB query! " + string(err.Error()))
-		return false, err
+		return User{}, err
 	}
-	// ensure the UserStatus.Status value is either 'enabled' or 'locked'
-	log.Println("INFO: user to set status of: " + username)
-	log.Println("INFO: requested state to set user to: " + j.Status)
-	if j.Status != "enabled" && j.Status != "locked" {
-		return false, &InvalidStatusValue{Err: errors.New("invalid value: " + j.Status)}
+	// the user may have been removed since it was read
+	if numberOfRows == 0 {
+		return User{}, noSuchUser(user.UserName)
 	}
 
-	result, err := q.Exec(j.Status, username)
+	slog.Info("User updated", "user", user.UserName)
+	return store.GetUserById(id)
+}
+
+func (s *SqlStore) UpdateUser(user User) (int64, error) {
+	orgUnitId := sql.NullInt64{Int64: int64(user.OrgUnitId), Valid: user.OrgUnitId != 0}
+
+	var numberOfRows int64
+	err := s.run(func(u *Unit) error {
+		result, err := u.Exec("UPDATE Users SET FullName = ?, RoleId = ?, OrgUnitId = ? WHERE Id = ?",
+			user.FullName, user.RoleId, orgUnitId, user.Id)
+		if err != nil {
+			return err
+		}
+		numberOfRows, err = result.RowsAffected()
+		return err
+	})
 	if err != nil {
-		log.Println("ERROR: Could not execute query for user '" + username + "': " + string(err.Error()))
-		return false, err
+		slog.Error("Cannot update user", "user", user.UserName, "error", err)
+		return 0, err
 	}
-	numberOfRows, err := result.RowsAffected()
+
+	return numberOfRows, nil
+}
+
+func (s *SqlStore) OrgUnitExists(orgUnitId int) (bool, error) {
+	var count int
+	err := s.run(func(u *Unit) error {
+		return u.QueryRow("SELECT COUNT(*) FROM OrgUnits WHERE Id = ?", orgUnitId).Scan(&count)
+	})
 	if err != nil {
+		slog.Error("Cannot look up organizational unit", "orgUnitId", orgUnitId, "error", err)
 		return false, err
 	}
 
-	t.Commit()
+	return count > 0, nil
+}
 
-	log.Println("INFO: SQL result: Rows: " + strconv.Itoa(int(numberOfRows)))
-	return true, nil
+// SaveExternalUser Creates or refreshes the local record of a user who signs
+// in through an external identity provider. Local accounts of the same name
+// are left untouched
+func SaveExternalUser(username string, fullName string, roleId int, authSource string) (bool, error) {
+	slog.Debug("Synchronizing external user", "authSource", authSource, "user", username)
+	return store.SaveExternalUser(username, fullName, roleId, authSource)
 }
 
-func SetUserRoleId(username string, j UserRoleId) (bool, error) {
-	log.Println("INFO: Set user's role Id for user '" + username + "'")
-	t, err := DB.Begin()
+func (s *SqlStore) SaveExternalUser(username string, fullName string, roleId int, authSource string) (bool, error) {
+	var numberOfRows int64
+	err := s.run(func(u *Unit) error {
+		result, err := u.Exec(`INSERT INTO Users (UserName, FullName, RoleId, PasswordHash, AuthSource)
+			VALUES (?, ?, ?, '!', ?)
+			ON CONFLICT (UserName) DO UPDATE SET
+				FullName = excluded.FullName,
+				RoleId = excluded.RoleId
+			WHERE Users.AuthSource = excluded.AuthSource`, username, fullName, roleId, authSource)
+		if err != nil {
+			return err
+		}
+		numberOfRows, err = result.RowsAffected()
+		return err
+	})
 	if err != nil {
-		log.Println("ERROR: Could not start DB transaction: " + string(err.Error()))
+		slog.Error("Cannot store external user", "authSource", authSource, "user", username, "error", err)
 		return false, err
 	}
 
-	q, err := DB.Prepare("UPDATE Users SET RoleId = ? WHERE UserName = ?")
-	if err != nil {
-		log.Println("ERROR: Could not prepare DB query! " + string(err.Error()))
-		return false, err
+	return numberOfRows > 0, nil
+}
+
+// SaveOidcUser Creates or refreshes the local record of a single sign-on user.
+// They are matched on the issuer and subject, which the issuer never reuses.
+// The user name claim only names a new user and is not trusted afterwards
+func SaveOidcUser(issuer string, subject string, username string, fullName string, roleId int) (User, error) {
+	slog.Debug("Synchronizing single sign-on user", "issuer", issuer, "subject", subject, "user", username)
+	if issuer == "" || subject == "" {
+		return User{}, &Validation{Message: "Single sign-on users need an issuer and a subject"}
 	}
-	result, err := q.Exec(j.RoleId, username)
-	if err != nil {
-		log.Println("ERROR: Could not execute query for user '" + username + "': " + string(err.Error()))
-		return false, err
+	return store.SaveOidcUser(issuer, subject, username, fullName, roleId)
+}
+
+func (s *SqlStore) SaveOidcUser(issuer string, subject string, username string, fullName string, roleId int) (User, error) {
+	user := User{}
+	// bearer tokens bring the same claims on every request, so an unchanged
+	// user is answered without writing
+	err := s.run(func(u *Unit) error {
+		var err error
+		user, err = scanUser(u.QueryRow("SELECT "+userColumns+" FROM Users WHERE ExternalIssuer = ? AND ExternalSubject = ?",
+			issuer, subject))
+		return err
+	})
+	if err == nil && user.FullName == fullName && user.RoleId == roleId {
+		return user, nil
+	}
+	if err != nil && err != sql.ErrNoRows {
+		slog.Error("Cannot look up single sign-on user", "issuer", issuer, "subject", subject, "error", err)
+		return User{}, err
 	}
-	numberOfRows, err := result.RowsAffected()
+
+	user = User{}
+	err = s.transaction(func(u *Unit) error {
+		found, err := scanUser(u.QueryRow("SELECT "+userColumns+" FROM Users WHERE ExternalIssuer = ? AND ExternalSubject = ?",
+			issuer, subject))
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		if err == nil {
+			_, err = u.Exec("UPDATE Users SET FullName = ?, RoleId = ? WHERE Id = ?", fullName, roleId, found.Id)
+			if err != nil {
+				return err
+			}
+			found.FullName, found.RoleId = fullName, roleId
+			user = found
+			return nil
+		}
+
+		// a name already in use, even by an earlier single sign-on account,
+		// is not handed to whoever claims it
+		result, err := u.Exec(`INSERT INTO Users (UserName, FullName, RoleId, PasswordHash, AuthSource, ExternalIssuer, ExternalSubject)
+			VALUES (?, ?, ?, '!', ?, ?, ?)
+			ON CONFLICT (UserName) DO NOTHING`, username, fullName, roleId, AuthSourceOidc, issuer, subject)
+		if err != nil {
+			return err
+		}
+		inserted, err := result.RowsAffected()
+		if err != nil || inserted == 0 {
+			return err
+		}
+		user, err = scanUser(u.QueryRow("SELECT "+userColumns+" FROM Users WHERE ExternalIssuer = ? AND ExternalSubject = ?",
+			issuer, subject))
+		return err
+	})
 	if err != nil {
-		return false, err
+		slog.Error("Cannot store single sign-on user", "issuer", issuer, "subject", subject, "error", err)
+		return User{}, err
 	}
 
-	t.Commit()
-	log.Println("INFO: SQL result: Rows: " + strconv.Itoa(int(numberOfRows)))
-	return true, nil
+	return user, nil
 }
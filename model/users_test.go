@@ -0,0 +1,93 @@
+package model_test
+
+/*
+
+  Copyright 2024, YggdrasilSoft, LLC.
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+import (
+	"testing"
+	"time"
+
+	"github.com/greeneg/update-reporterd/model"
+	"github.com/greeneg/update-reporterd/model/storetest"
+)
+
+const (
+	reusedUserName = "reused-name"
+	reusedPassword = "Correct-Horse-42!"
+)
+
+func TestDeletedUserLeavesNothingToItsNamesake(t *testing.T) {
+	storetest.OpenDatabase(t)
+	if _, err := model.CreateRole(model.Role{RoleName: "reused-role"}); err != nil {
+		t.Fatal(err)
+	}
+	role, err := model.GetRoleByName("reused-role")
+	if err != nil {
+		t.Fatal(err)
+	}
+	proposed := model.ProposedUser{UserName: reusedUserName, RoleId: role.Id, Password: reusedPassword}
+	if _, err := model.CreateUser(proposed); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	_, err = model.SaveSession(model.Session{
+		Id:           "reused-session",
+		UserName:     reusedUserName,
+		LastSeenDate: model.DbTimestamp(now),
+		ExpiresDate:  model.DbTimestamp(now.Add(time.Hour)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := model.CreateApiToken(reusedUserName, "ci", "urt_abc", "hash", []string{"read"}, now.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := model.SaveUserTotpSecret(reusedUserName, "JBSWY3DPEHPK3PXP"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := model.DeleteUser(reusedUserName); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := model.CreateUser(proposed); err != nil {
+		t.Fatal(err)
+	}
+
+	sessions, err := model.GetSessionsByUserName(reusedUserName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 0 {
+		t.Errorf("the new account took over %d sessions", len(sessions))
+	}
+	tokens, err := model.GetApiTokensByUserName(reusedUserName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) != 0 {
+		t.Errorf("the new account took over %d API tokens", len(tokens))
+	}
+	userTotp, err := model.GetUserTotp(reusedUserName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if userTotp.Secret != "" {
+		t.Error("the new account took over a TOTP secret")
+	}
+}
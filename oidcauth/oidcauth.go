@@ -0,0 +1,267 @@
+package oidcauth
+
+/*
+
+  Copyright 2024, YggdrasilSoft, LLC.
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+
+	"github.com/greeneg/update-reporterd/globals"
+)
+
+// ErrNonceMismatch is returned when an ID token was not issued for the login in progress
+var ErrNonceMismatch = errors.New("id token nonce does not match the login")
+
+// ErrNoAccessTokens is returned for bearer tokens when no API audience is configured
+var ErrNoAccessTokens = errors.New("bearer access tokens are not accepted without an oidc audience")
+
+// Identity is a user as asserted by the issuer. The issuer and subject
+// identify them, the user name is only what they like to be called
+type Identity struct {
+	Issuer   string
+	Subject  string
+	UserName string
+	FullName string
+	Roles    []string
+}
+
+// LoginState holds the values binding an authorization code login to the
+// browser that started it
+type LoginState struct {
+	State    string
+	Nonce    string
+	Verifier string
+}
+
+// NewLoginState Returns fresh random values for a login
+func NewLoginState() (LoginState, error) {
+	state, err := randomValue()
+	if err != nil {
+		return LoginState{}, err
+	}
+	nonce, err := randomValue()
+	if err != nil {
+		return LoginState{}, err
+	}
+
+	return LoginState{
+		State:    state,
+		Nonce:    nonce,
+		Verifier: oauth2.GenerateVerifier(),
+	}, nil
+}
+
+func randomValue() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+// Provider signs users in through an OpenID Connect issuer and verifies the
+// access tokens it issues
+type Provider struct {
+	config              globals.OidcConfig
+	oauth2Config        oauth2.Config
+	idTokenVerifier     *oidc.IDTokenVerifier
+	accessTokenVerifier *oidc.IDTokenVerifier
+}
+
+// New Returns a Provider for the config, fetching the issuer's discovery
+// document. Fills in defaults
+func New(ctx context.Context, config globals.OidcConfig) (*Provider, error) {
+	if config.Issuer == "" {
+		return nil, errors.New("oidc issuer is not set")
+	}
+	if config.ClientId == "" {
+		return nil, errors.New("oidc clientId is not set")
+	}
+	if config.RedirectUrl == "" {
+		return nil, errors.New("oidc redirectUrl is not set")
+	}
+
+	if len(config.Scopes) == 0 {
+		config.Scopes = []string{oidc.ScopeOpenID, "profile", "email"}
+	}
+	if config.PostLoginRedirect == "" {
+		config.PostLoginRedirect = globals.DefaultOidcPostLoginRedirect
+	}
+	if config.UserNameClaim == "" {
+		config.UserNameClaim = globals.DefaultOidcUserNameClaim
+	}
+	if config.FullNameClaim == "" {
+		config.FullNameClaim = globals.DefaultOidcFullNameClaim
+	}
+	if config.RolesClaim == "" {
+		config.RolesClaim = globals.DefaultOidcRolesClaim
+	}
+	// an ID token is issued to the client, so accepting the client as the
+	// audience of access tokens would let a leaked ID token be used as one
+	if config.Audience != "" && config.Audience == config.ClientId {
+		return nil, errors.New("oidc audience must differ from the clientId")
+	}
+
+	provider, err := oidc.NewProvider(ctx, config.Issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Provider{
+		config: config,
+		oauth2Config: oauth2.Config{
+			ClientID:     config.ClientId,
+			ClientSecret: config.ClientSecret,
+			RedirectURL:  config.RedirectUrl,
+			Endpoint:     provider.Endpoint(),
+			Scopes:       config.Scopes,
+		},
+		idTokenVerifier: provider.Verifier(&oidc.Config{ClientID: config.ClientId}),
+	}
+	if config.Audience != "" {
+		p.accessTokenVerifier = provider.Verifier(&oidc.Config{ClientID: config.Audience})
+	}
+
+	return p, nil
+}
+
+// PostLoginRedirect Returns where to send the browser after logging in
+func (p *Provider) PostLoginRedirect() string {
+	return p.config.PostLoginRedirect
+}
+
+// AuthCodeURL Returns the issuer URL that starts an authorization code login
+// bound to the login state, using PKCE
+func (p *Provider) AuthCodeURL(login LoginState) string {
+	return p.oauth2Config.AuthCodeURL(login.State, oidc.Nonce(login.Nonce), oauth2.S256ChallengeOption(login.Verifier))
+}
+
+// Exchange Redeems an authorization code and returns the identity from the
+// verified ID token
+func (p *Provider) Exchange(ctx context.Context, code string, verifier string, nonce string) (Identity, error) {
+	token, err := p.oauth2Config.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return Identity{}, err
+	}
+
+	rawIdToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return Identity{}, errors.New("token response has no id_token")
+	}
+	idToken, err := p.idTokenVerifier.Verify(ctx, rawIdToken)
+	if err != nil {
+		return Identity{}, err
+	}
+	if idToken.Nonce != nonce {
+		return Identity{}, ErrNonceMismatch
+	}
+
+	return p.identity(idToken)
+}
+
+// VerifyAccessToken Returns the identity in a JWT access token issued for our
+// API audience. Tokens also issued to the client are refused, as ID tokens are
+func (p *Provider) VerifyAccessToken(ctx context.Context, rawToken string) (Identity, error) {
+	if p.accessTokenVerifier == nil {
+		return Identity{}, ErrNoAccessTokens
+	}
+	token, err := p.accessTokenVerifier.Verify(ctx, rawToken)
+	if err != nil {
+		return Identity{}, err
+	}
+	if slices.Contains(token.Audience, p.config.ClientId) {
+		return Identity{}, errors.New("token is issued to the client, not for the API")
+	}
+
+	return p.identity(token)
+}
+
+func (p *Provider) identity(token *oidc.IDToken) (Identity, error) {
+	claims := map[string]interface{}{}
+	if err := token.Claims(&claims); err != nil {
+		return Identity{}, err
+	}
+
+	identity := Identity{Issuer: token.Issuer, Subject: token.Subject}
+	identity.UserName, _ = lookupClaim(claims, p.config.UserNameClaim).(string)
+	if identity.UserName == "" {
+		return Identity{}, fmt.Errorf("token has no '%s' claim", p.config.UserNameClaim)
+	}
+	identity.FullName, _ = lookupClaim(claims, p.config.FullNameClaim).(string)
+	if identity.FullName == "" {
+		identity.FullName = identity.UserName
+	}
+
+	switch roles := lookupClaim(claims, p.config.RolesClaim).(type) {
+	case string:
+		identity.Roles = []string{roles}
+	case []interface{}:
+		for _, role := range roles {
+			if s, ok := role.(string); ok {
+				identity.Roles = append(identity.Roles, s)
+			}
+		}
+	}
+
+	return identity, nil
+}
+
+// lookupClaim Returns a claim by name. Dots reach into nested claims, as in
+// Keycloak's realm_access.roles
+func lookupClaim(claims map[string]interface{}, name string) interface{} {
+	if value, ok := claims[name]; ok {
+		return value
+	}
+
+	var value interface{} = claims
+	for _, part := range strings.Split(name, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = object[part]
+	}
+
+	return value
+}
+
+// RoleName Returns the name of the role the identity's claim values map to,
+// or false if none do and there is no default role
+func (p *Provider) RoleName(identity Identity) (string, bool) {
+	for _, mapping := range p.config.ClaimRoles {
+		for _, role := range identity.Roles {
+			if role == mapping.Value {
+				return mapping.RoleName, true
+			}
+		}
+	}
+
+	if p.config.DefaultRole != "" {
+		return p.config.DefaultRole, true
+	}
+	return "", false
+}
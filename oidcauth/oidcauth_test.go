@@ -0,0 +1,212 @@
+package oidcauth_test
+
+/*
+
+  Copyright 2024, YggdrasilSoft, LLC.
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/greeneg/update-reporterd/globals"
+	"github.com/greeneg/update-reporterd/oidcauth"
+	"github.com/greeneg/update-reporterd/oidcauth/oidctest"
+)
+
+const (
+	clientId    = "update-reporter"
+	apiAudience = "update-reporter-api"
+)
+
+func newIssuer(t *testing.T) *oidctest.Issuer {
+	issuer, err := oidctest.NewIssuer(clientId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(issuer.Close)
+	return issuer
+}
+
+func newConfig(issuer *oidctest.Issuer) globals.OidcConfig {
+	return globals.OidcConfig{
+		Enabled:     true,
+		Issuer:      issuer.URL(),
+		ClientId:    clientId,
+		RedirectUrl: "https://reporter.example.com/api/v1/oidc/callback",
+		RolesClaim:  "realm_access.roles",
+		ClaimRoles:  []globals.OidcClaimRole{{Value: "reporter-admin", RoleName: "administrators"}},
+		Audience:    apiAudience,
+	}
+}
+
+func newProvider(t *testing.T, issuer *oidctest.Issuer) *oidcauth.Provider {
+	p, err := oidcauth.New(context.Background(), newConfig(issuer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// tokenCases Returns claims for a valid token for the audience and for ones
+// each verifier must refuse
+func tokenCases(audience string) []struct {
+	name   string
+	claims map[string]any
+	valid  bool
+} {
+	valid := func(extra map[string]any) map[string]any {
+		claims := map[string]any{
+			"sub":                "f47ac10b",
+			"preferred_username": "erin",
+			"name":               "Erin Example",
+			"realm_access":       map[string]any{"roles": []string{"reporter-admin"}},
+			"aud":                audience,
+		}
+		for name, value := range extra {
+			claims[name] = value
+		}
+		return claims
+	}
+	hourAgo := time.Now().Add(-time.Hour).Unix()
+
+	return []struct {
+		name   string
+		claims map[string]any
+		valid  bool
+	}{
+		{"valid token", valid(nil), true},
+		{"wrong audience", valid(map[string]any{"aud": "another-client"}), false},
+		{"expired token", valid(map[string]any{"iat": hourAgo - 60, "exp": hourAgo}), false},
+		{"wrong issuer", valid(map[string]any{"iss": "https://elsewhere.example.com"}), false},
+	}
+}
+
+func checkIdentity(t *testing.T, p *oidcauth.Provider, identity oidcauth.Identity) {
+	if identity.Subject != "f47ac10b" || identity.UserName != "erin" || identity.FullName != "Erin Example" {
+		t.Errorf("identity read back as %+v", identity)
+	}
+	if role, ok := p.RoleName(identity); !ok || role != "administrators" {
+		t.Errorf("identity maps to the role '%s', not administrators", role)
+	}
+}
+
+func TestVerifyAccessToken(t *testing.T) {
+	issuer := newIssuer(t)
+	p := newProvider(t, issuer)
+
+	cases := append(tokenCases(apiAudience), []struct {
+		name   string
+		claims map[string]any
+		valid  bool
+	}{
+		{"ID token of the client", map[string]any{"sub": "f47ac10b", "preferred_username": "erin", "aud": clientId}, false},
+		{"token for the client and the API", map[string]any{"sub": "f47ac10b", "preferred_username": "erin", "aud": []string{apiAudience, clientId}}, false},
+	}...)
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			token, err := issuer.Token(tc.claims)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			identity, err := p.VerifyAccessToken(context.Background(), token)
+			if !tc.valid {
+				if err == nil {
+					t.Errorf("token was accepted as %+v", identity)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkIdentity(t, p, identity)
+		})
+	}
+}
+
+func TestVerifyAccessTokenOfAnotherIssuer(t *testing.T) {
+	p := newProvider(t, newIssuer(t))
+	// same claims and key Id, signed with a different key
+	token, err := newIssuer(t).Token(map[string]any{"sub": "f47ac10b", "preferred_username": "erin", "aud": apiAudience})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := p.VerifyAccessToken(context.Background(), token); err == nil {
+		t.Error("a token signed by another key was accepted")
+	}
+}
+
+func TestExchange(t *testing.T) {
+	issuer := newIssuer(t)
+	p := newProvider(t, issuer)
+	login, err := oidcauth.NewLoginState()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range tokenCases(clientId) {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.claims["nonce"] = login.Nonce
+			issuer.SetIdTokenClaims(tc.claims)
+
+			identity, err := p.Exchange(context.Background(), "code", login.Verifier, login.Nonce)
+			if !tc.valid {
+				if err == nil {
+					t.Errorf("ID token was accepted as %+v", identity)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkIdentity(t, p, identity)
+		})
+	}
+
+	t.Run("nonce of another login", func(t *testing.T) {
+		issuer.SetIdTokenClaims(map[string]any{"sub": "f47ac10b", "preferred_username": "erin", "nonce": "replayed"})
+		_, err := p.Exchange(context.Background(), "code", login.Verifier, login.Nonce)
+		if !errors.Is(err, oidcauth.ErrNonceMismatch) {
+			t.Errorf("failed with '%v', not '%v'", err, oidcauth.ErrNonceMismatch)
+		}
+	})
+}
+
+func TestAccessTokenAudience(t *testing.T) {
+	issuer := newIssuer(t)
+	config := newConfig(issuer)
+	config.Audience = clientId
+	if _, err := oidcauth.New(context.Background(), config); err == nil {
+		t.Error("the client Id was accepted as the API audience")
+	}
+
+	config.Audience = ""
+	p, err := oidcauth.New(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := issuer.Token(map[string]any{"sub": "f47ac10b", "preferred_username": "erin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.VerifyAccessToken(context.Background(), token); !errors.Is(err, oidcauth.ErrNoAccessTokens) {
+		t.Errorf("without an API audience, a bearer token failed with '%v', not '%v'", err, oidcauth.ErrNoAccessTokens)
+	}
+}
@@ -0,0 +1,155 @@
+package ratelimit
+
+/*
+
+  Copyright 2024, YggdrasilSoft, LLC.
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+import (
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/greeneg/update-reporterd/apierror"
+	"github.com/greeneg/update-reporterd/globals"
+)
+
+// names of the limits, as reported in the metrics
+const (
+	LimitAuth         = "auth"
+	LimitAuthFailures = "authFailures"
+	LimitIngestion    = "ingestion"
+)
+
+var (
+	clients  = NewLimiter(globals.RateLimit{}, globals.DefaultRateLimitAuthPerMinute, globals.DefaultRateLimitAuthBurst)
+	users    = NewLimiter(globals.RateLimit{}, globals.DefaultRateLimitAuthFailuresPerMinute, globals.DefaultRateLimitAuthFailuresBurst)
+	machines = NewLimiter(globals.RateLimit{}, globals.DefaultRateLimitIngestionPerMinute, globals.DefaultRateLimitIngestionBurst)
+
+	// requests refused by each limit since the start
+	refused = map[string]*atomic.Uint64{
+		LimitAuth:         {},
+		LimitAuthFailures: {},
+		LimitIngestion:    {},
+	}
+)
+
+// Configure Sets the limits, keeping the state of the clients seen so far
+func Configure(config globals.RateLimitConfig) {
+	off := globals.RateLimit{PerMinute: -1}
+	if config.Disabled {
+		config.Auth, config.AuthFailures, config.Ingestion = off, off, off
+	}
+	clients.SetLimit(config.Auth, globals.DefaultRateLimitAuthPerMinute, globals.DefaultRateLimitAuthBurst)
+	users.SetLimit(config.AuthFailures, globals.DefaultRateLimitAuthFailuresPerMinute, globals.DefaultRateLimitAuthFailuresBurst)
+	machines.SetLimit(config.Ingestion, globals.DefaultRateLimitIngestionPerMinute, globals.DefaultRateLimitIngestionBurst)
+}
+
+// Cleanup Forgets the clients whose buckets have refilled every interval,
+// until quit is closed
+func Cleanup(interval time.Duration, quit <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-quit:
+			return
+		case now := <-ticker.C:
+			clients.Forget(now)
+			users.Forget(now)
+			machines.Forget(now)
+		}
+	}
+}
+
+// Refused Returns the number of requests each limit has refused
+func Refused() map[string]uint64 {
+	counts := make(map[string]uint64, len(refused))
+	for limit, count := range refused {
+		counts[limit] = count.Load()
+	}
+
+	return counts
+}
+
+// CheckClient Counts an authentication attempt from the client's address,
+// answering 429 and returning false once it has made too many
+func CheckClient(c *gin.Context) bool {
+	ok, retryAfter, first := clients.Allow(c.ClientIP(), time.Now())
+	if !ok {
+		refuse(c, LimitAuth, retryAfter, first, "clientIp", c.ClientIP())
+	}
+
+	return ok
+}
+
+// CheckUser Answers 429 and returns false if a user name has had too many
+// failed authentications. Only failures, recorded by RecordFailure, count
+func CheckUser(c *gin.Context, username string) bool {
+	ok, retryAfter, first := users.Check(userKey(username), time.Now())
+	if !ok {
+		refuse(c, LimitAuthFailures, retryAfter, first, "user", username)
+	}
+
+	return ok
+}
+
+// RecordFailure Counts a failed authentication for a user name
+func RecordFailure(username string) {
+	users.Take(userKey(username), time.Now())
+}
+
+// CheckMachine Counts a report submission from a machine, answering 429 and
+// returning false once it has made too many
+func CheckMachine(c *gin.Context, machine string) bool {
+	ok, retryAfter, first := machines.Allow(machine, time.Now())
+	if !ok {
+		refuse(c, LimitIngestion, retryAfter, first, "machine", machine)
+	}
+
+	return ok
+}
+
+// userKey Folds the case of a user name, so it cannot dodge its limit by
+// changing case
+func userKey(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
+
+func refuse(c *gin.Context, limit string, retryAfter time.Duration, first bool, key string, value string) {
+	refused[limit].Add(1)
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	// a flood is logged when it starts, not for every request
+	if first {
+		slog.WarnContext(c.Request.Context(), "Rate limit reached, refusing requests", "limit", limit, key, value,
+			"retryAfterSeconds", seconds)
+	} else {
+		slog.DebugContext(c.Request.Context(), "Rate limit reached", "limit", limit, key, value)
+	}
+
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	apierror.Respond(c, http.StatusTooManyRequests, "Too many requests, try again in "+strconv.Itoa(seconds)+" seconds")
+	c.Abort()
+}
@@ -0,0 +1,166 @@
+// Package ratelimit throttles authentication attempts and report submissions
+// with token buckets, so a client guessing passwords or a runaway agent
+// cannot flood the service
+package ratelimit
+
+/*
+
+  Copyright 2024, YggdrasilSoft, LLC.
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/greeneg/update-reporterd/globals"
+)
+
+// Limiter holds one token bucket per key. Buckets start full, and keys that
+// have not been seen for a while are forgotten by Cleanup
+type Limiter struct {
+	mu       sync.Mutex
+	disabled bool
+	// tokens added per second
+	rate    float64
+	burst   float64
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	// set once a request is refused, so only the first refusal is logged
+	limited bool
+}
+
+// NewLimiter Returns a limiter for the given limit, with the defaults for the
+// values it leaves unset
+func NewLimiter(limit globals.RateLimit, defaultPerMinute int, defaultBurst int) *Limiter {
+	l := &Limiter{buckets: make(map[string]*bucket)}
+	l.SetLimit(limit, defaultPerMinute, defaultBurst)
+
+	return l
+}
+
+// SetLimit Replaces the limit. Buckets keep their tokens, up to the new burst
+func (l *Limiter) SetLimit(limit globals.RateLimit, defaultPerMinute int, defaultBurst int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.disabled = limit.PerMinute < 0
+	if limit.PerMinute == 0 {
+		limit.PerMinute = defaultPerMinute
+	}
+	if limit.Burst <= 0 {
+		limit.Burst = defaultBurst
+	}
+	l.rate = float64(limit.PerMinute) / 60
+	l.burst = float64(limit.Burst)
+}
+
+// refill Returns the bucket of a key with the tokens added since it was last
+// used, the caller holds l.mu
+func (l *Limiter) refill(key string, now time.Time) *bucket {
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+		return b
+	}
+	if now.After(b.last) {
+		b.tokens += now.Sub(b.last).Seconds() * l.rate
+		b.last = now
+	}
+	// the burst may have been lowered since the bucket was last used
+	b.tokens = math.Min(l.burst, b.tokens)
+
+	return b
+}
+
+// wait Returns how long a bucket takes to hold a whole token again, the
+// caller holds l.mu
+func (l *Limiter) wait(b *bucket) time.Duration {
+	return time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+}
+
+// Allow Takes a token for a key if one is left. Otherwise it returns how long
+// to wait for the next one, and whether this is the first refusal since the
+// key was last allowed
+func (l *Limiter) Allow(key string, now time.Time) (ok bool, retryAfter time.Duration, first bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.disabled {
+		return true, 0, false
+	}
+
+	b := l.refill(key, now)
+	if b.tokens >= 1 {
+		b.tokens--
+		b.limited = false
+		return true, 0, false
+	}
+	first = !b.limited
+	b.limited = true
+
+	return false, l.wait(b), first
+}
+
+// Check Works like Allow, but leaves the token in the bucket. It is used with
+// Take for limits that only count some requests, such as failed logins
+func (l *Limiter) Check(key string, now time.Time) (ok bool, retryAfter time.Duration, first bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.disabled {
+		return true, 0, false
+	}
+
+	b := l.refill(key, now)
+	if b.tokens >= 1 {
+		b.limited = false
+		return true, 0, false
+	}
+	first = !b.limited
+	b.limited = true
+
+	return false, l.wait(b), first
+}
+
+// Take Takes a token for a key, if there is one
+func (l *Limiter) Take(key string, now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.disabled {
+		return
+	}
+
+	b := l.refill(key, now)
+	b.tokens = math.Max(0, b.tokens-1)
+}
+
+// Forget Drops the buckets that have refilled, returning how many are left
+func (l *Limiter) Forget(now time.Time) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for key := range l.buckets {
+		if l.refill(key, now).tokens >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+
+	return len(l.buckets)
+}
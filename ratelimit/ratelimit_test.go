@@ -0,0 +1,206 @@
+package ratelimit_test
+
+/*
+
+  Copyright 2024, YggdrasilSoft, LLC.
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/greeneg/update-reporterd/globals"
+	"github.com/greeneg/update-reporterd/ratelimit"
+)
+
+func TestBucketStartsFullAndRefills(t *testing.T) {
+	l := ratelimit.NewLimiter(globals.RateLimit{PerMinute: 60, Burst: 3}, 1, 1)
+	now := time.Now()
+
+	for i := 0; i < 3; i++ {
+		if ok, _, _ := l.Allow("client", now); !ok {
+			t.Fatalf("request %d of a full bucket was refused", i+1)
+		}
+	}
+	ok, retryAfter, first := l.Allow("client", now)
+	if ok {
+		t.Fatal("a request past the burst was allowed")
+	}
+	if retryAfter != time.Second {
+		t.Errorf("retry after %v, expected the second one token takes", retryAfter)
+	}
+	if !first {
+		t.Error("the first refusal was not reported as such")
+	}
+	if _, _, first := l.Allow("client", now); first {
+		t.Error("a second refusal was reported as the first")
+	}
+	if ok, _, _ := l.Allow("other", now); !ok {
+		t.Error("another key shares the bucket")
+	}
+
+	if ok, _, _ := l.Allow("client", now.Add(500*time.Millisecond)); ok {
+		t.Error("half a token allowed a request")
+	}
+	if ok, _, _ := l.Allow("client", now.Add(time.Second)); !ok {
+		t.Error("a refilled token was refused")
+	}
+}
+
+func TestCheckOnlyCountsTakenTokens(t *testing.T) {
+	l := ratelimit.NewLimiter(globals.RateLimit{PerMinute: 1, Burst: 2}, 1, 1)
+	now := time.Now()
+
+	for i := 0; i < 5; i++ {
+		if ok, _, _ := l.Check("alice", now); !ok {
+			t.Fatal("checking took a token")
+		}
+	}
+	l.Take("alice", now)
+	l.Take("alice", now)
+	if ok, retryAfter, _ := l.Check("alice", now); ok || retryAfter != time.Minute {
+		t.Errorf("check after the burst was taken returned %v, retry after %v", ok, retryAfter)
+	}
+}
+
+func TestDefaultsAndDisabledLimits(t *testing.T) {
+	now := time.Now()
+
+	l := ratelimit.NewLimiter(globals.RateLimit{}, 60, 2)
+	l.Allow("client", now)
+	l.Allow("client", now)
+	if ok, retryAfter, _ := l.Allow("client", now); ok || retryAfter != time.Second {
+		t.Errorf("the default limit returned %v, retry after %v", ok, retryAfter)
+	}
+
+	l.SetLimit(globals.RateLimit{PerMinute: -1}, 60, 2)
+	for i := 0; i < 10; i++ {
+		if ok, _, _ := l.Allow("client", now); !ok {
+			t.Fatal("a disabled limit refused a request")
+		}
+	}
+}
+
+func TestSetLimitKeepsTokensUpToTheBurst(t *testing.T) {
+	l := ratelimit.NewLimiter(globals.RateLimit{PerMinute: 60, Burst: 5}, 1, 1)
+	now := time.Now()
+	l.Allow("client", now)
+
+	l.SetLimit(globals.RateLimit{PerMinute: 60, Burst: 2}, 1, 1)
+	l.Allow("client", now)
+	l.Allow("client", now)
+	if ok, _, _ := l.Allow("client", now); ok {
+		t.Error("the bucket kept more tokens than the new burst")
+	}
+}
+
+func TestForgetDropsRefilledBuckets(t *testing.T) {
+	l := ratelimit.NewLimiter(globals.RateLimit{PerMinute: 60, Burst: 2}, 1, 1)
+	now := time.Now()
+	l.Allow("busy", now)
+	l.Allow("busy", now)
+	l.Allow("idle", now)
+
+	if left := l.Forget(now.Add(time.Second)); left != 1 {
+		t.Errorf("%d buckets left, expected the one still refilling", left)
+	}
+	if left := l.Forget(now.Add(2 * time.Second)); left != 0 {
+		t.Errorf("%d buckets left after all refilled", left)
+	}
+}
+
+// limitedRouter Returns a router whose /login counts against the client
+// limit, and whose /failure records a failed login for the user in the query
+func limitedRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/login", func(c *gin.Context) {
+		if !ratelimit.CheckClient(c) || !ratelimit.CheckUser(c, c.Query("user")) {
+			return
+		}
+		c.Status(http.StatusOK)
+	})
+	r.POST("/failure", func(c *gin.Context) {
+		ratelimit.RecordFailure(c.Query("user"))
+		c.Status(http.StatusUnauthorized)
+	})
+
+	return r
+}
+
+func post(r *gin.Engine, path string, remoteAddr string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, nil)
+	req.RemoteAddr = remoteAddr
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestRefusalsCarryRetryAfter(t *testing.T) {
+	ratelimit.Configure(globals.RateLimitConfig{
+		Auth:         globals.RateLimit{PerMinute: 1, Burst: 1},
+		AuthFailures: globals.RateLimit{PerMinute: 2, Burst: 1},
+	})
+	t.Cleanup(func() { ratelimit.Configure(globals.RateLimitConfig{}) })
+	r := limitedRouter()
+	before := ratelimit.Refused()
+
+	if w := post(r, "/login?user=carol", "192.0.2.10:1234"); w.Code != http.StatusOK {
+		t.Fatalf("the first login was answered %d", w.Code)
+	}
+	w := post(r, "/login?user=carol", "192.0.2.10:1234")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("a login past the client limit was answered %d", w.Code)
+	}
+	if retryAfter := w.Header().Get("Retry-After"); retryAfter != "60" {
+		t.Errorf("Retry-After is %q, expected the 60 seconds a token takes", retryAfter)
+	}
+
+	// failures count against the user name from any address, whatever its case
+	post(r, "/failure?user=Dave", "192.0.2.11:1234")
+	w = post(r, "/login?user=%20dave", "192.0.2.12:1234")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("a login past the failure limit was answered %d", w.Code)
+	}
+	if retryAfter := w.Header().Get("Retry-After"); retryAfter != "30" {
+		t.Errorf("Retry-After is %q, expected the 30 seconds a token takes", retryAfter)
+	}
+
+	after := ratelimit.Refused()
+	if after[ratelimit.LimitAuth]-before[ratelimit.LimitAuth] != 1 ||
+		after[ratelimit.LimitAuthFailures]-before[ratelimit.LimitAuthFailures] != 1 {
+		t.Errorf("refusals counted %v, before %v", after, before)
+	}
+}
+
+func TestDisabledRateLimits(t *testing.T) {
+	ratelimit.Configure(globals.RateLimitConfig{
+		Disabled: true,
+		Auth:     globals.RateLimit{PerMinute: 1, Burst: 1},
+	})
+	t.Cleanup(func() { ratelimit.Configure(globals.RateLimitConfig{}) })
+	r := limitedRouter()
+
+	for i := 0; i < 5; i++ {
+		if w := post(r, "/login?user=erin", "192.0.2.20:1234"); w.Code != http.StatusOK {
+			t.Fatalf("login %d was answered %d with rate limits disabled", i+1, w.Code)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package retention
+
+/*
+
+  Copyright 2024, YggdrasilSoft, LLC.
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/greeneg/update-reporterd/globals"
+	"github.com/greeneg/update-reporterd/model"
+)
+
+// Pruner deletes update history past its retention period, a batch at a time
+// so writers are not held off for long, and then returns the freed space to
+// the filesystem
+type Pruner struct {
+	// signalled when the config changes, so Start picks up a new interval
+	reconfigured chan struct{}
+
+	mu          sync.Mutex
+	config      globals.RetentionConfig
+	running     bool
+	nextRun     time.Time
+	lastRunDate time.Time
+	lastRun     *model.RetentionRun
+}
+
+// New Returns a pruner for the given retention config
+func New(config globals.RetentionConfig) *Pruner {
+	return &Pruner{
+		config:       withDefaults(config),
+		reconfigured: make(chan struct{}, 1),
+	}
+}
+
+// withDefaults Fills in defaults for the values the config leaves unset
+func withDefaults(config globals.RetentionConfig) globals.RetentionConfig {
+	if config.ReportDays == 0 {
+		config.ReportDays = globals.DefaultRetentionReportDays
+	}
+	if config.AggregateMonths == 0 {
+		config.AggregateMonths = globals.DefaultRetentionAggregateMonths
+	}
+	if config.IntervalMinutes <= 0 {
+		config.IntervalMinutes = globals.DefaultRetentionIntervalMinutes
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = globals.DefaultRetentionBatchSize
+	}
+
+	return config
+}
+
+// Reconfigure Replaces the retention config. The next run is moved to one
+// new interval after the last run, and the run after that follows the new
+// settings
+func (p *Pruner) Reconfigure(config globals.RetentionConfig) {
+	p.mu.Lock()
+	p.config = withDefaults(config)
+	last := p.lastRunDate
+	if last.IsZero() {
+		last = time.Now()
+	}
+	p.nextRun = last.Add(p.interval())
+	p.mu.Unlock()
+
+	select {
+	case p.reconfigured <- struct{}{}:
+	default:
+	}
+}
+
+// interval Returns the time between runs, the caller holds p.mu
+func (p *Pruner) interval() time.Duration {
+	return time.Duration(p.config.IntervalMinutes) * time.Minute
+}
+
+// Start Prunes once straight away and then every interval until quit is
+// closed, skipping runs while retention is disabled. A run in progress stops
+// between batches once quit is closed
+func (p *Pruner) Start(quit <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-quit:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-quit:
+			return
+		case <-p.reconfigured:
+			p.mu.Lock()
+			timer.Reset(time.Until(p.nextRun))
+			p.mu.Unlock()
+			continue
+		case <-timer.C:
+		}
+
+		p.mu.Lock()
+		disabled := p.config.Disabled
+		p.mu.Unlock()
+		if !disabled {
+			p.Run(ctx)
+		}
+
+		p.mu.Lock()
+		p.nextRun = time.Now().Add(p.interval())
+		timer.Reset(p.interval())
+		p.mu.Unlock()
+	}
+}
+
+// Run Prunes everything past its retention period once and returns what was
+// done. It is skipped if another run is still going
+func (p *Pruner) Run(ctx context.Context) model.RetentionRun {
+	p.mu.Lock()
+	if p.running {
+		p.mu.Unlock()
+		slog.Warn("Retention run skipped, the previous run has not finished")
+		return model.RetentionRun{}
+	}
+	p.running = true
+	config := p.config
+	p.mu.Unlock()
+
+	start := time.Now()
+	run := model.RetentionRun{StartDate: model.DbTimestamp(start)}
+	err := prune(ctx, config, start, &run)
+	if err != nil {
+		run.Error = string(err.Error())
+		slog.Error("Retention run failed", "error", err)
+	}
+	run.FinishDate = model.DbTimestamp(time.Now())
+
+	if run.ReportsDeleted > 0 || run.AggregatesDeleted > 0 || run.SystemsDeleted > 0 {
+		slog.Info("Pruned update history", "reports", run.ReportsDeleted, "dailyCounts", run.AggregatesDeleted,
+			"systems", run.SystemsDeleted, "pagesFreed", run.PagesFreed)
+	}
+
+	p.mu.Lock()
+	p.running = false
+	p.lastRun = &run
+	p.lastRunDate = time.Now()
+	p.mu.Unlock()
+
+	return run
+}
+
+func prune(ctx context.Context, config globals.RetentionConfig, now time.Time, run *model.RetentionRun) error {
+	var err error
+	if config.ReportDays > 0 {
+		run.ReportsDeleted, err = batches(ctx, config.BatchSize, now.AddDate(0, 0, -config.ReportDays), model.PruneUpdateReports)
+		if err != nil {
+			return err
+		}
+	}
+	if config.AggregateMonths > 0 {
+		run.AggregatesDeleted, err = batches(ctx, config.BatchSize, now.AddDate(0, -config.AggregateMonths, 0), model.PruneDailyUpdateCounts)
+		if err != nil {
+			return err
+		}
+	}
+	if config.DecommissionedSystemDays > 0 {
+		run.SystemsDeleted, err = batches(ctx, config.BatchSize, now.AddDate(0, 0, -config.DecommissionedSystemDays), model.PruneSilentSystems)
+		if err != nil {
+			return err
+		}
+	}
+
+	if run.ReportsDeleted == 0 && run.AggregatesDeleted == 0 && run.SystemsDeleted == 0 {
+		return nil
+	}
+	run.PagesFreed, err = model.ReclaimSpace()
+
+	return err
+}
+
+// batches Calls prune until it deletes less than a full batch, returning the
+// number of rows deleted in all
+func batches(ctx context.Context, batchSize int, before time.Time, prune func(time.Time, int) (int64, error)) (int64, error) {
+	var total int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return total, err
+		}
+		deleted, err := prune(before, batchSize)
+		total += deleted
+		if err != nil {
+			return total, err
+		}
+		if deleted < int64(batchSize) {
+			return total, nil
+		}
+	}
+}
+
+// Status Returns the retention settings in effect and the last run
+func (p *Pruner) Status() model.RetentionStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	status := model.RetentionStatus{
+		Enabled:                  !p.config.Disabled,
+		ReportDays:               p.config.ReportDays,
+		AggregateMonths:          p.config.AggregateMonths,
+		DecommissionedSystemDays: p.config.DecommissionedSystemDays,
+		IntervalMinutes:          p.config.IntervalMinutes,
+		Running:                  p.running,
+	}
+	if !p.nextRun.IsZero() && !p.config.Disabled {
+		status.NextRunDate = model.DbTimestamp(p.nextRun)
+	}
+	if p.lastRun != nil {
+		lastRun := *p.lastRun
+		status.LastRun = &lastRun
+	}
+
+	return status
+}
@@ -25,12 +25,31 @@ import (
 )
 
 func PrivateRoutes(g *gin.RouterGroup, u *controllers.UpdateReporter) {
+	// current user
+	g.GET("/me", u.GetMe)                                  // get the logged in user
+	g.PATCH("/me", u.UpdateMe)                             // change the logged in user's full name
+	g.PUT("/me/password", u.ChangeMyPassword)              // change the logged in user's password
+	g.GET("/me/logins", u.GetMyLogins)                     // login history
+	g.GET("/me/sessions", u.GetMySessions)                 // list active sessions
+	g.DELETE("/me/sessions/:sessionId", u.DeleteMySession) // revoke an active session
+	// two-factor authentication of the logged in user
+	g.GET("/me/totp", u.GetMyTotp)                                // get two-factor status
+	g.POST("/me/totp", u.EnrollMyTotp)                            // start TOTP enrolment
+	g.POST("/me/totp/confirm", u.ConfirmMyTotp)                   // confirm TOTP enrolment
+	g.POST("/me/totp/recoveryCodes", u.RegenerateMyRecoveryCodes) // replace recovery codes
+	g.DELETE("/me/totp", u.DeleteMyTotp)                          // disable two-factor authentication
+	// API tokens of the logged in user
+	g.GET("/me/tokens", u.GetMyApiTokens)               // list API tokens
+	g.POST("/me/tokens", u.CreateMyApiToken)            // create an API token
+	g.DELETE("/me/tokens/:tokenId", u.DeleteMyApiToken) // revoke an API token
 	// Roles
-	g.GET("/roles", u.GetRoles)                    // get all roles
-	g.GET("/role/id/:roleId", u.GetRoleById)       // get role by Id
-	g.GET("/role/name/:roleName", u.GetRoleByName) // get role by name
-	g.POST("/role", u.CreateRole)                  // create new role
-	g.DELETE("/role/:roleId", u.DeleteRole)        // delete a role by Id
+	g.GET("/roles", u.GetRoles)                                            // get all roles
+	g.GET("/role/id/:roleId", u.GetRoleById)                               // get role by Id
+	g.GET("/role/name/:roleName", u.GetRoleByName)                         // get role by name
+	g.POST("/role", u.CreateRole)                                          // create new role
+	g.PATCH("/role/:roleId", u.UpdateRole)                                 // change a role's name or description
+	g.DELETE("/role/:roleId", u.DeleteRole)                                // delete a role by Id, reassigning its users
+	g.PATCH("/role/:roleId/twoFactorRequired", u.SetRoleTwoFactorRequired) // require two-factor authentication for a role
 	// user related routes
 	g.GET("/users", u.GetUsers)                          // get all users
 	g.GET("/users/roleId/:roleId", u.GetUsersByRoleId)   // get all users by role Id
@@ -38,13 +57,33 @@ func PrivateRoutes(g *gin.RouterGroup, u *controllers.UpdateReporter) {
 	g.GET("/user/name/:name/status", u.GetUserStatus)    // get whether a user is locked or not
 	g.GET("/user/id/:id", u.GetUserById)                 // get a user by Id
 	g.POST("/user", u.CreateUser)                        // create new user
+	g.PUT("/user/id/:id", u.ReplaceUser)                 // replace a user's profile
+	g.PATCH("/user/id/:id", u.UpdateUser)                // update a user's profile
 	g.PATCH("/user/name/:name", u.ChangeAccountPassword) // update a user password
 	g.PATCH("/user/name/:name/status", u.SetUserStatus)  // lock a user
 	g.PATCH("/user/name/:name/roleId", u.SetUserRoleId)  // set a user's role Id
 	g.DELETE("/user/name/:name", u.DeleteUser)           // trash a user
+	g.DELETE("/user/name/:name/totp", u.DeleteUserTotp)  // reset a user's two-factor authentication
+	// session related routes
+	g.GET("/user/name/:name/sessions", u.GetUserSessions)                 // list a user's active sessions
+	g.DELETE("/user/name/:name/sessions", u.DeleteUserSessions)           // revoke all of a user's sessions
+	g.DELETE("/user/name/:name/sessions/:sessionId", u.DeleteUserSession) // revoke one of a user's sessions
+	// administration
+	g.POST("/admin/backup", u.CreateBackup)            // back up the database
+	g.GET("/admin/retention", u.GetRetentionStatus)    // retention settings and last pruning run
+	g.POST("/admin/config/reload", u.PostConfigReload) // reload the configuration, as on SIGHUP
 }
 
 func PublicRoutes(g *gin.RouterGroup, u *controllers.UpdateReporter) {
 	// service related routes
-	g.GET("/health", u.GetHealth) // service health API
+	g.GET("/health", u.GetHealth)          // service health API, same as readiness
+	g.GET("/health/live", u.GetLiveness)   // the process is up
+	g.GET("/health/ready", u.GetReadiness) // the service can serve requests
+	g.GET("/metrics", u.GetMetrics)        // service metrics, in the Prometheus text format
+	// session related routes
+	g.POST("/login", u.Login)   // start a session
+	g.POST("/logout", u.Logout) // end a session
+	// single sign-on
+	g.GET("/oidc/login", u.OidcLogin)       // redirect to the issuer
+	g.GET("/oidc/callback", u.OidcCallback) // complete a login at the issuer
 }
@@ -0,0 +1,190 @@
+package main
+
+/*
+
+  Copyright 2024, YggdrasilSoft, LLC
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/greeneg/update-reporterd/globals"
+	"github.com/greeneg/update-reporterd/listener"
+	"github.com/greeneg/update-reporterd/tlscert"
+)
+
+// server is an HTTP server along with the socket it serves on
+type server struct {
+	*http.Server
+	name     string
+	listener net.Listener
+	tls      bool
+}
+
+func seconds(value int, fallback int) time.Duration {
+	if value <= 0 {
+		value = fallback
+	}
+	return time.Duration(value) * time.Second
+}
+
+func newServer(listen globals.ListenConfig, handler http.Handler) *http.Server {
+	return &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: seconds(listen.ReadHeaderTimeoutSeconds, globals.DefaultReadHeaderTimeoutSeconds),
+		ReadTimeout:       seconds(listen.ReadTimeoutSeconds, globals.DefaultReadTimeoutSeconds),
+		WriteTimeout:      seconds(listen.WriteTimeoutSeconds, globals.DefaultWriteTimeoutSeconds),
+		IdleTimeout:       seconds(listen.IdleTimeoutSeconds, globals.DefaultIdleTimeoutSeconds),
+	}
+}
+
+// redirectToHttps Returns a handler sending every request to the same URL
+// over HTTPS on the given port. 308 keeps the method and body of API calls
+func redirectToHttps(httpsPort int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		host := req.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if httpsPort != 0 && httpsPort != 443 {
+			host = net.JoinHostPort(host, strconv.Itoa(httpsPort))
+		} else if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+		http.Redirect(w, req, "https://"+host+req.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
+
+// openServers Opens the sockets the config asks for, so that an address that
+// cannot be bound stops the daemon before anything is served
+func openServers(config globals.Config, handler http.Handler, certificates *tlscert.Store) ([]*server, error) {
+	socketMode, err := listener.ParseMode(config.Listen.SocketMode)
+	if err != nil {
+		return nil, err
+	}
+
+	httpAddress := config.Listen.HttpAddress
+	if httpAddress == "" {
+		httpAddress = ":" + strconv.Itoa(config.TcpPort)
+	}
+	httpsAddress := config.Listen.HttpsAddress
+	if httpsAddress == "" {
+		httpsAddress = ":" + strconv.Itoa(config.TLSTcpPort)
+	}
+
+	var httpHandler http.Handler
+	switch {
+	case !config.UseTLS, config.Listen.HttpMode == globals.HttpModeServe:
+		httpHandler = handler
+	case config.Listen.HttpMode == globals.HttpModeRedirect:
+		httpsPort := listener.Port(httpsAddress)
+		if httpsPort == 0 {
+			httpsPort = config.TLSTcpPort
+		}
+		httpHandler = redirectToHttps(httpsPort)
+	}
+
+	servers := make([]*server, 0, 2)
+	closeAll := func() {
+		for _, s := range servers {
+			s.listener.Close()
+		}
+	}
+	if config.UseTLS {
+		l, err := listener.Listen(httpsAddress, socketMode)
+		if err != nil {
+			return nil, errors.New("cannot listen for HTTPS on " + httpsAddress + ": " + string(err.Error()))
+		}
+		s := newServer(config.Listen, handler)
+		s.TLSConfig = certificates.Config()
+		servers = append(servers, &server{Server: s, name: "HTTPS on " + httpsAddress, listener: l, tls: true})
+	}
+	if httpHandler != nil {
+		l, err := listener.Listen(httpAddress, socketMode)
+		if err != nil {
+			closeAll()
+			return nil, errors.New("cannot listen for HTTP on " + httpAddress + ": " + string(err.Error()))
+		}
+		name := "HTTP on " + httpAddress
+		if config.UseTLS && config.Listen.HttpMode == globals.HttpModeRedirect {
+			name = "HTTP redirects to HTTPS on " + httpAddress
+		}
+		servers = append(servers, &server{Server: newServer(config.Listen, httpHandler), name: name, listener: l})
+	}
+
+	return servers, nil
+}
+
+// serve Serves until SIGINT or SIGTERM, or until a server fails, then stops
+// accepting connections and waits up to the shutdown timeout for requests in
+// flight. A second signal exits straight away
+func serve(servers []*server, shutdownTimeout time.Duration) error {
+	failed := make(chan error, len(servers))
+	for _, s := range servers {
+		slog.Info("Serving", "server", s.name)
+		go func(s *server) {
+			var err error
+			if s.tls {
+				err = s.ServeTLS(s.listener, "", "")
+			} else {
+				err = s.Serve(s.listener)
+			}
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				failed <- errors.New("serving " + s.name + " failed: " + string(err.Error()))
+			}
+		}(s)
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	var err error
+	select {
+	case sig := <-stop:
+		slog.Info("Received signal, shutting down", "signal", sig)
+	case err = <-failed:
+		slog.Error("Server failed, shutting down", "error", err)
+	}
+	// restore the default handling, so another signal ends the process
+	signal.Stop(stop)
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	var wg sync.WaitGroup
+	for _, s := range servers {
+		wg.Add(1)
+		go func(s *server) {
+			defer wg.Done()
+			if shutdownErr := s.Shutdown(ctx); shutdownErr != nil {
+				slog.Warn("Requests did not finish in time, closing their connections", "server", s.name)
+				s.Close()
+			}
+		}(s)
+	}
+	wg.Wait()
+
+	return err
+}
@@ -0,0 +1,223 @@
+package sessionstore
+
+/*
+
+  Copyright 2024, YggdrasilSoft, LLC.
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+import (
+	"encoding/base32"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gorilla/securecookie"
+	gsessions "github.com/gorilla/sessions"
+
+	"github.com/greeneg/update-reporterd/globals"
+	"github.com/greeneg/update-reporterd/model"
+)
+
+// how stale a session's last seen date may get before a request refreshes it
+const touchInterval = time.Minute
+
+// DatabaseStore keeps session values server-side in the Sessions table, so
+// the cookie only carries a signed session Id that can be revoked
+type DatabaseStore struct {
+	Codecs  []securecookie.Codec
+	options *gsessions.Options
+}
+
+func NewDatabaseStore(keyPairs ...[]byte) *DatabaseStore {
+	s := &DatabaseStore{
+		Codecs: securecookie.CodecsFromPairs(keyPairs...),
+		options: &gsessions.Options{
+			Path:   "/",
+			MaxAge: globals.DefaultSessionMaxAge,
+		},
+	}
+	s.MaxAge(s.options.MaxAge)
+
+	return s
+}
+
+func (s *DatabaseStore) Options(options sessions.Options) {
+	s.options = options.ToGorillaOptions()
+	s.MaxAge(s.options.MaxAge)
+}
+
+func (s *DatabaseStore) MaxAge(age int) {
+	s.options.MaxAge = age
+	for _, codec := range s.Codecs {
+		if sc, ok := codec.(*securecookie.SecureCookie); ok {
+			sc.MaxAge(age)
+		}
+	}
+}
+
+func (s *DatabaseStore) Get(r *http.Request, name string) (*gsessions.Session, error) {
+	return gsessions.GetRegistry(r).Get(s, name)
+}
+
+func (s *DatabaseStore) New(r *http.Request, name string) (*gsessions.Session, error) {
+	session := gsessions.NewSession(s, name)
+	opts := *s.options
+	session.Options = &opts
+	session.IsNew = true
+
+	c, errCookie := r.Cookie(name)
+	if errCookie != nil {
+		return session, nil
+	}
+
+	err := securecookie.DecodeMulti(name, c.Value, &session.ID, s.Codecs...)
+	if err != nil {
+		return session, err
+	}
+
+	found, err := s.load(session)
+	if err != nil {
+		return session, err
+	}
+	if !found {
+		// expired or revoked, so start over with a fresh session
+		session.ID = ""
+		return session, nil
+	}
+	session.IsNew = false
+
+	return session, nil
+}
+
+func (s *DatabaseStore) Save(r *http.Request, w http.ResponseWriter, session *gsessions.Session) error {
+	// delete if max-age is <= 0
+	if session.Options.MaxAge <= 0 {
+		if session.ID != "" {
+			if _, err := model.DeleteSessionById(session.ID); err != nil {
+				return err
+			}
+		}
+		http.SetCookie(w, gsessions.NewCookie(session.Name(), "", session.Options))
+		return nil
+	}
+
+	if session.ID == "" {
+		session.ID = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(
+			securecookie.GenerateRandomKey(32))
+	}
+
+	data, err := securecookie.EncodeMulti(session.Name(), session.Values, s.Codecs...)
+	if err != nil {
+		return err
+	}
+
+	username := ""
+	if user, ok := session.Values[globals.UserKey]; ok && user != nil {
+		username = fmt.Sprintf("%v", user)
+	}
+
+	now := time.Now()
+	expires := now.Add(time.Duration(session.Options.MaxAge) * time.Second)
+	_, err = model.SaveSession(model.Session{
+		Id:           session.ID,
+		UserName:     username,
+		Data:         data,
+		RemoteAddr:   r.RemoteAddr,
+		UserAgent:    r.UserAgent(),
+		LastSeenDate: model.DbTimestamp(now),
+		ExpiresDate:  model.DbTimestamp(expires),
+	})
+	if err != nil {
+		return err
+	}
+
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID, s.Codecs...)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, gsessions.NewCookie(session.Name(), encoded, session.Options))
+
+	return nil
+}
+
+// Renew Gives a session a new Id when it is next saved, deleting the record
+// kept under the old one, so an Id planted before a login is worthless after
+// it. Sessions kept in cookies have no Id and are left alone
+func Renew(session sessions.Session) error {
+	s, ok := session.(interface{ Session() *gsessions.Session })
+	if !ok {
+		return nil
+	}
+	current := s.Session()
+	if current == nil || current.ID == "" {
+		return nil
+	}
+
+	if _, err := model.DeleteSessionById(current.ID); err != nil {
+		return err
+	}
+	current.ID = ""
+	current.IsNew = true
+
+	return nil
+}
+
+// load Fills in the session values from the DB, returning false if the
+// session no longer exists
+func (s *DatabaseStore) load(session *gsessions.Session) (bool, error) {
+	record, err := model.GetSessionById(session.ID)
+	if err != nil {
+		return false, err
+	}
+	if record.Id == "" {
+		return false, nil
+	}
+
+	err = securecookie.DecodeMulti(session.Name(), record.Data, &session.Values, s.Codecs...)
+	if err != nil {
+		return false, err
+	}
+
+	lastSeen, err := time.Parse("2006-01-02 15:04:05", record.LastSeenDate)
+	if err == nil && time.Since(lastSeen) > touchInterval {
+		model.TouchSession(session.ID)
+	}
+
+	return true, nil
+}
+
+// Cleanup Periodically removes expired sessions until quit is closed
+func (s *DatabaseStore) Cleanup(interval time.Duration, quit <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-quit:
+			return
+		case <-ticker.C:
+			count, err := model.DeleteExpiredSessions()
+			if err != nil {
+				continue
+			}
+			if count > 0 {
+				slog.Info("Pruned expired sessions", "count", int(count))
+			}
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package sessionstore
+
+/*
+
+  Copyright 2024, YggdrasilSoft, LLC.
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+
+	"github.com/greeneg/update-reporterd/globals"
+	"github.com/greeneg/update-reporterd/model"
+	"github.com/greeneg/update-reporterd/model/storetest"
+)
+
+// sessionRouter Returns a router whose /visit starts an anonymous session,
+// /login logs in as the login handler does and /whoami names the session user
+func sessionRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(sessions.Sessions("session", NewDatabaseStore([]byte("0123456789abcdef0123456789abcdef"))))
+	r.GET("/visit", func(c *gin.Context) {
+		session := sessions.Default(c)
+		session.Set("visited", true)
+		session.Save()
+	})
+	r.POST("/login", func(c *gin.Context) {
+		session := sessions.Default(c)
+		session.Clear()
+		if err := Renew(session); err != nil {
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		session.Set(globals.UserKey, "alice")
+		session.Save()
+	})
+	r.GET("/whoami", func(c *gin.Context) {
+		user, _ := sessions.Default(c).Get(globals.UserKey).(string)
+		c.String(http.StatusOK, user)
+	})
+
+	return r
+}
+
+func request(r *gin.Engine, method string, path string, cookie *http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func sessionCookie(t *testing.T, w *httptest.ResponseRecorder) *http.Cookie {
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == "session" {
+			return cookie
+		}
+	}
+	t.Fatal("no session cookie was set")
+	return nil
+}
+
+func TestLoginRenewsSessionId(t *testing.T) {
+	storetest.OpenDatabase(t)
+	r := sessionRouter()
+
+	planted := sessionCookie(t, request(r, http.MethodGet, "/visit", nil))
+	before, err := model.GetSessionsByUserName("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(before) != 1 {
+		t.Fatalf("%d anonymous sessions were stored, not 1", len(before))
+	}
+
+	w := request(r, http.MethodPost, "/login", planted)
+	if w.Code != http.StatusOK {
+		t.Fatalf("login answered %d", w.Code)
+	}
+	renewed := sessionCookie(t, w)
+
+	old, err := model.GetSessionById(before[0].Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if old.Id != "" {
+		t.Error("the session from before the login is still stored")
+	}
+	after, err := model.GetSessionsByUserName("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(after) != 1 || after[0].Id == before[0].Id {
+		t.Errorf("login kept the session Id planted before it: %+v", after)
+	}
+
+	if user := request(r, http.MethodGet, "/whoami", planted).Body.String(); user != "" {
+		t.Errorf("the planted cookie is logged in as '%s'", user)
+	}
+	if user := request(r, http.MethodGet, "/whoami", renewed).Body.String(); user != "alice" {
+		t.Errorf("the renewed cookie is logged in as '%s', not alice", user)
+	}
+}
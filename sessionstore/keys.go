@@ -0,0 +1,115 @@
+package sessionstore
+
+/*
+
+  Copyright 2024, YggdrasilSoft, LLC.
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/greeneg/update-reporterd/globals"
+)
+
+// MinSecretLength is the shortest session secret we accept, in bytes
+const MinSecretLength = 32
+
+// LoadSecrets Returns the configured session secrets, current secret first.
+// Secrets from the secret file come before those listed in the config.
+func LoadSecrets(config globals.SessionConfig) ([][]byte, error) {
+	secrets := make([][]byte, 0)
+
+	if config.SecretFile != "" {
+		fileSecrets, err := readSecretFile(config.SecretFile)
+		if err != nil {
+			return nil, err
+		}
+		secrets = append(secrets, fileSecrets...)
+	}
+	for _, secret := range config.Secrets {
+		secrets = append(secrets, []byte(secret))
+	}
+
+	for i, secret := range secrets {
+		if len(secret) < MinSecretLength {
+			return nil, errors.New("session secret " + strconv.Itoa(i+1) + " is shorter than " +
+				strconv.Itoa(MinSecretLength) + " bytes")
+		}
+	}
+
+	if len(secrets) == 0 {
+		slog.Warn("No session secret configured. Generating a random one; sessions will not survive a restart")
+		secret := make([]byte, 64)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+		secrets = append(secrets, secret)
+	}
+
+	return secrets, nil
+}
+
+// readSecretFile Returns one secret per non-empty line, ignoring '#' comments
+func readSecretFile(path string) ([][]byte, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	secrets := make([][]byte, 0)
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		secrets = append(secrets, []byte(line))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(secrets) == 0 {
+		return nil, errors.New("session secret file '" + path + "' contains no secrets")
+	}
+
+	return secrets, nil
+}
+
+// KeyPairs Derives an authentication and an encryption key from each secret,
+// in the pair layout expected by securecookie
+func KeyPairs(secrets [][]byte) [][]byte {
+	keyPairs := make([][]byte, 0, len(secrets)*2)
+	for _, secret := range secrets {
+		hashKey := hmac.New(sha512.New, secret)
+		hashKey.Write([]byte("update-reporterd session authentication"))
+		blockKey := hmac.New(sha256.New, secret)
+		blockKey.Write([]byte("update-reporterd session encryption"))
+
+		keyPairs = append(keyPairs, hashKey.Sum(nil), blockKey.Sum(nil))
+	}
+
+	return keyPairs
+}
@@ -0,0 +1,99 @@
+package sessionstore
+
+/*
+
+  Copyright 2024, YggdrasilSoft, LLC.
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-contrib/sessions/cookie"
+
+	"github.com/greeneg/update-reporterd/globals"
+)
+
+// New Returns the session store selected in the configuration, keyed with
+// the configured secrets and with the configured cookie options applied
+func New(config globals.SessionConfig) (sessions.Store, error) {
+	secrets, err := LoadSecrets(config)
+	if err != nil {
+		return nil, err
+	}
+	keyPairs := KeyPairs(secrets)
+
+	options, err := Options(config)
+	if err != nil {
+		return nil, err
+	}
+
+	var store sessions.Store
+	switch config.Store {
+	case "", globals.SessionStoreCookie:
+		store = cookie.NewStore(keyPairs...)
+	case globals.SessionStoreDatabase:
+		store = NewDatabaseStore(keyPairs...)
+	default:
+		return nil, errors.New("unknown session store '" + config.Store + "'")
+	}
+	store.Options(options)
+
+	// keep the signed timestamp check in step with the cookie lifetime
+	if s, ok := store.(interface{ MaxAge(int) }); ok {
+		s.MaxAge(options.MaxAge)
+	}
+
+	return store, nil
+}
+
+// Options Returns the cookie options described by the configuration
+func Options(config globals.SessionConfig) (sessions.Options, error) {
+	options := sessions.Options{
+		Path:     "/",
+		MaxAge:   globals.DefaultSessionMaxAge,
+		Secure:   config.Secure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+
+	if config.MaxAge > 0 {
+		options.MaxAge = config.MaxAge
+	}
+	if config.HttpOnly != nil {
+		options.HttpOnly = *config.HttpOnly
+	}
+
+	switch strings.ToLower(config.SameSite) {
+	case "", "lax":
+		options.SameSite = http.SameSiteLaxMode
+	case "strict":
+		options.SameSite = http.SameSiteStrictMode
+	case "none":
+		options.SameSite = http.SameSiteNoneMode
+	case "default":
+		options.SameSite = http.SameSiteDefaultMode
+	default:
+		return options, errors.New("unknown sameSite mode '" + config.SameSite + "'")
+	}
+	if options.SameSite == http.SameSiteNoneMode && !options.Secure {
+		return options, errors.New("sameSite mode 'none' requires secure cookies")
+	}
+
+	return options, nil
+}
@@ -0,0 +1,323 @@
+// Package tlscert holds the certificates the daemon serves TLS with, so they
+// can be replaced without restarting the listener, and reports their expiry
+package tlscert
+
+/*
+
+  Copyright 2024, YggdrasilSoft, LLC.
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"log/slog"
+	"math"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/greeneg/update-reporterd/globals"
+	"github.com/greeneg/update-reporterd/model"
+)
+
+// certificate statuses, by days left before expiry
+const (
+	StatusOk       = "OK"
+	StatusExpiring = "EXPIRING"
+	StatusExpired  = "EXPIRED"
+)
+
+// Store holds the current certificates. New connections get whichever
+// certificates were loaded last, connections already open keep theirs
+type Store struct {
+	mu          sync.RWMutex
+	files       []globals.TLSCertificate
+	certs       []*tls.Certificate
+	stamps      []string
+	interval    time.Duration
+	warningDays int
+	// stamps of files that failed to load, so a broken renewal is reported once
+	failedStamps []string
+	lastWarning  time.Time
+}
+
+// Files Returns the certificate files a config serves, the default one first
+func Files(config globals.Config) []globals.TLSCertificate {
+	files := []globals.TLSCertificate{{PemFile: config.TLSPemFile, KeyFile: config.TLSKeyFile}}
+
+	return append(files, config.TLS.Certificates...)
+}
+
+// Load Returns a store holding the certificates of the given config
+func Load(config globals.Config) (*Store, error) {
+	s := &Store{}
+	if err := s.Reload(config); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Reload Replaces the certificates with those of the given config, read
+// again even if their paths are unchanged. The current certificates are kept
+// if any of the new ones cannot be loaded
+func (s *Store) Reload(config globals.Config) error {
+	files := Files(config)
+	// stamped before reading, so a file replaced meanwhile is read again
+	stamps := stampFiles(files)
+	certs, err := loadFiles(files)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.files = files
+	s.certs = certs
+	s.stamps = stamps
+	s.failedStamps = nil
+	s.lastWarning = time.Time{}
+	s.interval = seconds(config.TLS.WatchIntervalSeconds, globals.DefaultTLSWatchIntervalSeconds)
+	s.warningDays = config.TLS.ExpiryWarningDays
+	if s.warningDays <= 0 {
+		s.warningDays = globals.DefaultTLSExpiryWarningDays
+	}
+	s.mu.Unlock()
+
+	s.logExpiry(time.Now())
+	return nil
+}
+
+func seconds(value int, fallback int) time.Duration {
+	if value <= 0 {
+		value = fallback
+	}
+	return time.Duration(value) * time.Second
+}
+
+func loadFiles(files []globals.TLSCertificate) ([]*tls.Certificate, error) {
+	certs := make([]*tls.Certificate, 0, len(files))
+	for _, file := range files {
+		cert, err := tls.LoadX509KeyPair(file.PemFile, file.KeyFile)
+		if err != nil {
+			return nil, errors.New("cannot load TLS certificate '" + file.PemFile + "' and key '" + file.KeyFile + "': " + string(err.Error()))
+		}
+		if cert.Leaf == nil {
+			cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+			if err != nil {
+				return nil, errors.New("cannot parse TLS certificate '" + file.PemFile + "': " + string(err.Error()))
+			}
+		}
+		certs = append(certs, &cert)
+	}
+
+	return certs, nil
+}
+
+// stampFiles Returns the size and modification time of each file, which
+// change when a certificate is renewed. Files that cannot be read get an
+// empty stamp
+func stampFiles(files []globals.TLSCertificate) []string {
+	stamps := make([]string, 0, 2*len(files))
+	for _, file := range files {
+		for _, path := range []string{file.PemFile, file.KeyFile} {
+			info, err := os.Stat(path)
+			if err != nil {
+				stamps = append(stamps, "")
+				continue
+			}
+			stamps = append(stamps, strconv.FormatInt(info.Size(), 10)+"@"+info.ModTime().UTC().Format(time.RFC3339Nano))
+		}
+	}
+
+	return stamps
+}
+
+// GetCertificate Returns the first certificate valid for the name the client
+// asks for, or the default certificate, for tls.Config
+func (s *Store) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if hello.ServerName != "" {
+		for _, cert := range s.certs {
+			if hello.SupportsCertificate(cert) == nil {
+				return cert, nil
+			}
+		}
+	}
+
+	return s.certs[0], nil
+}
+
+// Config Returns a TLS config serving the store's current certificates
+func (s *Store) Config() *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: s.GetCertificate,
+	}
+}
+
+// Watch Checks the certificate files for changes until quit is closed, and
+// loads them again when they change. A renewal that cannot be loaded yet,
+// e.g. because only the certificate has been replaced so far, keeps the
+// current certificates and is tried again on the next check
+func (s *Store) Watch(quit <-chan struct{}) {
+	for {
+		s.mu.RLock()
+		interval := s.interval
+		s.mu.RUnlock()
+
+		timer := time.NewTimer(interval)
+		select {
+		case <-quit:
+			timer.Stop()
+			return
+		case <-timer.C:
+			s.check(time.Now())
+		}
+	}
+}
+
+func (s *Store) check(now time.Time) {
+	s.mu.RLock()
+	files := s.files
+	current := s.stamps
+	failed := s.failedStamps
+	s.mu.RUnlock()
+
+	stamps := stampFiles(files)
+	if slices.Equal(stamps, current) || slices.Equal(stamps, failed) {
+		s.logExpiry(now)
+		return
+	}
+
+	certs, err := loadFiles(files)
+	s.mu.Lock()
+	if err != nil || !slices.Equal(s.stamps, current) {
+		if err != nil {
+			s.failedStamps = stamps
+		}
+		s.mu.Unlock()
+		if err != nil {
+			slog.Warn("Changed TLS certificate files cannot be loaded, keeping the current certificates", "error", err)
+		}
+		return
+	}
+	s.certs = certs
+	s.stamps = stamps
+	s.failedStamps = nil
+	s.lastWarning = time.Time{}
+	s.mu.Unlock()
+
+	for i, cert := range certs {
+		if slices.Equal(stamps[2*i:2*i+2], current[2*i:2*i+2]) {
+			continue
+		}
+		slog.Info("Loaded renewed TLS certificate", "pemFile", files[i].PemFile, "subject", cert.Leaf.Subject.String(),
+			"notAfter", cert.Leaf.NotAfter.UTC().Format(time.RFC3339))
+	}
+	s.logExpiry(now)
+}
+
+// logExpiry Warns about certificates that expire soon, at most once a day
+func (s *Store) logExpiry(now time.Time) {
+	s.mu.Lock()
+	if now.Sub(s.lastWarning) < 24*time.Hour {
+		s.mu.Unlock()
+		return
+	}
+	s.mu.Unlock()
+
+	warned := false
+	for _, status := range s.Status(now) {
+		switch status.Status {
+		case StatusExpired:
+			slog.Error("TLS certificate has expired", "pemFile", status.PemFile, "subject", status.Subject,
+				"notAfter", status.NotAfter)
+		case StatusExpiring:
+			slog.Warn("TLS certificate expires soon", "pemFile", status.PemFile, "subject", status.Subject,
+				"notAfter", status.NotAfter, "daysUntilExpiry", status.DaysUntilExpiry)
+		default:
+			continue
+		}
+		warned = true
+	}
+
+	if warned {
+		s.mu.Lock()
+		s.lastWarning = now
+		s.mu.Unlock()
+	}
+}
+
+// Status Returns the expiry of each certificate, the default one first
+func (s *Store) Status(now time.Time) []model.CertificateStatus {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	statuses := make([]model.CertificateStatus, 0, len(s.certs))
+	for i, cert := range s.certs {
+		leaf := cert.Leaf
+		days := int(math.Floor(leaf.NotAfter.Sub(now).Hours() / 24))
+		status := StatusOk
+		if now.After(leaf.NotAfter) {
+			status = StatusExpired
+		} else if days < s.warningDays {
+			status = StatusExpiring
+		}
+		dnsNames := leaf.DNSNames
+		if dnsNames == nil {
+			dnsNames = []string{}
+		}
+		statuses = append(statuses, model.CertificateStatus{
+			PemFile:         s.files[i].PemFile,
+			Subject:         leaf.Subject.String(),
+			DnsNames:        dnsNames,
+			NotAfter:        leaf.NotAfter.UTC().Format(time.RFC3339),
+			DaysUntilExpiry: days,
+			Status:          status,
+		})
+	}
+
+	return statuses
+}
+
+// Worst Returns the most severe of the statuses, StatusOk if there are none
+func Worst(statuses []model.CertificateStatus) string {
+	worst := StatusOk
+	for _, status := range statuses {
+		if status.Status == StatusExpired {
+			return StatusExpired
+		}
+		if status.Status == StatusExpiring {
+			worst = StatusExpiring
+		}
+	}
+
+	return worst
+}
+
+// WarningDays Returns the days before expiry from which a certificate is
+// reported as expiring
+func (s *Store) WarningDays() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.warningDays
+}
@@ -0,0 +1,223 @@
+package tlscert
+
+/*
+
+  Copyright 2024, YggdrasilSoft, LLC.
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/greeneg/update-reporterd/globals"
+)
+
+// writeCertificate Writes a self-signed certificate for name and its key to
+// dir, returning their paths. Files written later get a later modification
+// time, as a renewal would
+func writeCertificate(t *testing.T, dir string, name string, notAfter time.Time) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pemFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	writeFile(t, pemFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	writeFile(t, keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}))
+
+	return pemFile, keyFile
+}
+
+func writeFile(t *testing.T, path string, content []byte) {
+	t.Helper()
+	modified := time.Now()
+	if info, err := os.Stat(path); err == nil && !info.ModTime().Before(modified) {
+		modified = info.ModTime().Add(time.Second)
+	}
+	if err := os.WriteFile(path, content, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modified, modified); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// served Returns the common name of the certificate served for serverName
+func served(t *testing.T, s *Store, serverName string) string {
+	t.Helper()
+	cert, err := s.GetCertificate(&tls.ClientHelloInfo{
+		ServerName:        serverName,
+		SupportedVersions: []uint16{tls.VersionTLS13},
+		SignatureSchemes:  []tls.SignatureScheme{tls.ECDSAWithP256AndSHA256},
+		SupportedCurves:   []tls.CurveID{tls.CurveP256},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert.Leaf.Subject.CommonName
+}
+
+func TestServesTheCertificateForTheName(t *testing.T) {
+	pemFile, keyFile := writeCertificate(t, t.TempDir(), "default.example.com", time.Now().AddDate(1, 0, 0))
+	otherPem, otherKey := writeCertificate(t, t.TempDir(), "api.example.com", time.Now().AddDate(1, 0, 0))
+	s, err := Load(globals.Config{
+		TLSPemFile: pemFile,
+		TLSKeyFile: keyFile,
+		TLS:        globals.TLSConfig{Certificates: []globals.TLSCertificate{{PemFile: otherPem, KeyFile: otherKey}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if name := served(t, s, "api.example.com"); name != "api.example.com" {
+		t.Errorf("served %s for api.example.com", name)
+	}
+	if name := served(t, s, "unknown.example.com"); name != "default.example.com" {
+		t.Errorf("served %s for an unknown name, expected the default", name)
+	}
+	if name := served(t, s, ""); name != "default.example.com" {
+		t.Errorf("served %s without a name, expected the default", name)
+	}
+}
+
+func TestReloadKeepsTheCertificatesOnFailure(t *testing.T) {
+	dir := t.TempDir()
+	pemFile, keyFile := writeCertificate(t, dir, "first.example.com", time.Now().AddDate(1, 0, 0))
+	config := globals.Config{TLSPemFile: pemFile, TLSKeyFile: keyFile}
+	s, err := Load(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Reload(globals.Config{TLSPemFile: filepath.Join(dir, "missing.pem"), TLSKeyFile: keyFile}); err == nil {
+		t.Error("a missing certificate was loaded")
+	}
+	if name := served(t, s, ""); name != "first.example.com" {
+		t.Errorf("served %s after a failed reload", name)
+	}
+
+	// the same paths are read again
+	writeCertificate(t, dir, "second.example.com", time.Now().AddDate(1, 0, 0))
+	if err := s.Reload(config); err != nil {
+		t.Fatal(err)
+	}
+	if name := served(t, s, ""); name != "second.example.com" {
+		t.Errorf("served %s after a reload", name)
+	}
+}
+
+func TestWatchLoadsARenewalOnceComplete(t *testing.T) {
+	dir := t.TempDir()
+	pemFile, keyFile := writeCertificate(t, dir, "old.example.com", time.Now().AddDate(1, 0, 0))
+	s, err := Load(globals.Config{TLSPemFile: pemFile, TLSKeyFile: keyFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.check(time.Now())
+	if name := served(t, s, ""); name != "old.example.com" {
+		t.Fatalf("served %s with unchanged files", name)
+	}
+
+	// a renewal caught between writing the certificate and its key
+	renewed := t.TempDir()
+	newPem, newKey := writeCertificate(t, renewed, "new.example.com", time.Now().AddDate(1, 0, 0))
+	content, err := os.ReadFile(newPem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, pemFile, content)
+	s.check(time.Now())
+	if name := served(t, s, ""); name != "old.example.com" {
+		t.Fatalf("served %s from a certificate without its key", name)
+	}
+
+	content, err = os.ReadFile(newKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, keyFile, content)
+	s.check(time.Now())
+	if name := served(t, s, ""); name != "new.example.com" {
+		t.Errorf("served %s after the renewal was complete", name)
+	}
+}
+
+func TestExpiryStatus(t *testing.T) {
+	now := time.Now()
+	cases := []struct {
+		notAfter time.Time
+		status   string
+	}{
+		{now.AddDate(0, 0, 60), StatusOk},
+		{now.AddDate(0, 0, 10), StatusExpiring},
+		{now.Add(2 * time.Hour), StatusExpiring},
+	}
+	for _, tc := range cases {
+		pemFile, keyFile := writeCertificate(t, t.TempDir(), "status.example.com", tc.notAfter)
+		s, err := Load(globals.Config{TLSPemFile: pemFile, TLSKeyFile: keyFile})
+		if err != nil {
+			t.Fatal(err)
+		}
+		statuses := s.Status(now)
+		if len(statuses) != 1 || statuses[0].Status != tc.status {
+			t.Errorf("a certificate expiring %s has status %+v, expected %s", tc.notAfter, statuses, tc.status)
+		}
+		if statuses[0].PemFile != pemFile || statuses[0].DnsNames[0] != "status.example.com" {
+			t.Errorf("unexpected status %+v", statuses[0])
+		}
+		if expired := s.Status(tc.notAfter.Add(time.Minute)); expired[0].Status != StatusExpired {
+			t.Errorf("a certificate past its expiry has status %s", expired[0].Status)
+		}
+	}
+
+	if worst := Worst(nil); worst != StatusOk {
+		t.Errorf("no certificates are %s", worst)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+/*
+
+  Copyright 2024, YggdrasilSoft, LLC.
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+
+	"github.com/greeneg/update-reporterd/backup"
+)
+
+// backupDatabase Writes a backup of the database file and its manifest to dir
+func backupDatabase(dir string, compress bool) error {
+	if dir == "" {
+		dir = backup.DefaultDirectory(dbFile)
+	}
+
+	manifestPath, manifest, err := backup.Create(context.Background(), DB, dir, compress)
+	if err != nil {
+		return err
+	}
+
+	slog.Info("Backed up the database", "schemaVersion", manifest.SchemaVersion, "file", manifest.File)
+	println(manifestPath)
+	return nil
+}
+
+// restoreDatabase Replaces the database file with the backup the manifest describes
+func restoreDatabase(manifestPath string) error {
+	if dbFile == "" {
+		return backup.ErrNotSqlite
+	}
+	if manifestPath == "" {
+		return errors.New("restore needs the path of a backup manifest")
+	}
+
+	// the pool must not hold the file being replaced
+	DB.Close()
+	manifest, err := backup.Restore(context.Background(), manifestPath, dbFile)
+	if err != nil {
+		return err
+	}
+
+	slog.Info("Restored the database", "schemaVersion", manifest.SchemaVersion, "file", manifest.File,
+		"taken", manifest.CreationDate)
+	return nil
+}
@@ -1,10 +1,11 @@
 package main
 
 import (
-	"crypto/sha512"
+	"context"
 	"database/sql"
-	"encoding/hex"
-	"time"
+	"log/slog"
+
+	"github.com/greeneg/update-reporterd/model"
 )
 
 type Role struct {
@@ -23,156 +24,93 @@ type User struct {
 	CreationDate string
 }
 
-func convertSqliteTimestamp(t string) string {
-	sqlTimestampFormat := "2006-01-02T15:04:05Z"
-	timeFormat := "2006-01-02 15:04:05"
-	createTime, _ := time.Parse(sqlTimestampFormat, t)
-	return createTime.Format(timeFormat)
-}
-
-func getRoleStatus(role string) (bool, error) {
-	t, err := DB.Begin()
-	if err != nil {
-		errPrintln("Could not start DB transaction: " + string(err.Error()))
-		return false, err
-	}
-
-	q, err := DB.Prepare("SELECT * FROM Roles WHERE RoleName IS ?")
+// exists Returns whether a query finds a row
+func exists(query string, args ...any) (bool, error) {
+	found := 0
+	err := DB.Run(context.Background(), func(u *model.Unit) error {
+		return u.QueryRow(query, args...).Scan(&found)
+	})
 	if err != nil {
-		errPrintln("Could not prepare DB query! " + string(err.Error()))
-		return false, err
-	}
-
-	rr := Role{}
-	err = q.QueryRow(role).Scan(
-		&rr.Id,
-		&rr.RoleName,
-		&rr.Description,
-		&rr.CreationDate,
-	)
-	if err != nil {
-		if err != sql.ErrNoRows {
-			errPrintln("Encountered error when querying database: " + string(err.Error()))
-			return false, err
+		if err == sql.ErrNoRows {
+			return false, nil
 		}
-		return false, nil
+		slog.Error("Encountered error when querying database", "error", err)
+		return false, err
 	}
 
-	t.Commit()
-
 	return true, nil
 }
 
-func createRole(roleName string, roleDescription string) (bool, error) {
-	t, err := DB.Begin()
-	if err != nil {
-		errPrintln("Could not start DB transaction: " + string(err.Error()))
-		return false, err
-	}
-
-	q, err := t.Prepare("INSERT INTO Roles (RoleName, Description) VALUES (?, ?)")
-	if err != nil {
-		errPrintln("Could not prepare the DB query: " + string(err.Error()))
-		return false, err
-	}
+func getRoleStatus(role string) (bool, error) {
+	return exists("SELECT 1 FROM Roles WHERE RoleName = ?", role)
+}
 
-	_, err = q.Exec(roleName, roleDescription)
+func createRole(roleName string, roleDescription string) (bool, error) {
+	err := DB.Run(context.Background(), func(u *model.Unit) error {
+		_, err := u.Exec("INSERT INTO Roles (RoleName, Description) VALUES (?, ?)", roleName, roleDescription)
+		return err
+	})
 	if err != nil {
-		errPrintln("Cannot create role '" + roleName + "': " + string(err.Error()))
+		slog.Error("Cannot create role", "role", roleName, "error", err)
 		return false, err
 	}
 
-	t.Commit()
-
 	return true, nil
 }
 
 func getRoleByName(roleName string) (Role, error) {
-	rec, err := DB.Prepare("SELECT * FROM Roles WHERE RoleName = ?")
-	if err != nil {
-		errPrintln("Could not prepare the DB query!" + string(err.Error()))
-		return Role{}, err
-	}
-
 	role := Role{}
-	err = rec.QueryRow(roleName).Scan(
-		&role.Id,
-		&role.RoleName,
-		&role.Description,
-		&role.CreationDate,
-	)
+	err := DB.Run(context.Background(), func(u *model.Unit) error {
+		return u.QueryRow("SELECT Id, RoleName, Description, CreationDate FROM Roles WHERE RoleName = ?", roleName).Scan(
+			&role.Id,
+			&role.RoleName,
+			&role.Description,
+			&role.CreationDate,
+		)
+	})
 	if err != nil {
 		if err == sql.ErrNoRows {
-			errPrintln("No such role found in DB: " + string(err.Error()))
+			slog.Error("No such role found in DB", "error", err)
 			return Role{}, nil
 		}
-		errPrintln("Cannot retrieve role from DB: " + string(err.Error()))
+		slog.Error("Cannot retrieve role from DB", "error", err)
 		return Role{}, err
 	}
 
-	role.CreationDate = convertSqliteTimestamp(role.CreationDate)
-	rec.Close()
+	role.CreationDate = model.ConvertDbTimestamp(role.CreationDate)
 
 	return role, nil
 }
 
 func getAccountStatus(account string) (bool, error) {
-	t, err := DB.Begin()
-	if err != nil {
-		errPrintln("Could not start DB transaction: " + string(err.Error()))
-		return false, err
-	}
-
-	q, err := DB.Prepare("SELECT * FROM Users WHERE UserName IS ?")
-	if err != nil {
-		errPrintln("Could not prepare DB query! " + string(err.Error()))
-		return false, err
-	}
-
-	err = q.QueryRow(account).Scan()
-	if err != nil {
-		if err != sql.ErrNoRows {
-			errPrintln("Encountered error when querying database: " + string(err.Error()))
-			return false, err
-		}
-		return false, err
-	}
-
-	t.Commit()
-
-	return true, nil
+	return exists("SELECT 1 FROM Users WHERE UserName = ?", account)
 }
 
 func createAccount(accountName string, accountFullName string, roleId int, passwd string) (User, error) {
-	t, err := DB.Begin()
-	if err != nil {
-		errPrintln("Could not start DB transaction!" + string(err.Error()))
+	// hold the password to the same policy the daemon does before hashing it
+	if err := model.ValidatePassword(passwd); err != nil {
+		slog.Error("The account password does not meet the password policy", "error", err)
 		return User{}, err
 	}
-
-	q, err := t.Prepare("INSERT INTO Users (UserName, FullName, RoleId, PasswordHash) VALUES (?, ?, ?, ?)")
+	passwdHash, err := model.HashPassword(passwd)
 	if err != nil {
-		errPrintln("Could not prepare the DB query!" + string(err.Error()))
+		slog.Error("Could not hash the account password", "error", err)
 		return User{}, err
 	}
 
-	// take password and hash it
-	hash := sha512.Sum512([]byte(passwd))
-	passwdHash := hex.EncodeToString(hash[:])
-
-	// get the org Id
-
-	_, err = q.Exec(accountName, accountFullName, roleId, passwdHash)
+	err = DB.Run(context.Background(), func(u *model.Unit) error {
+		_, err := u.Exec("INSERT INTO Users (UserName, FullName, RoleId, PasswordHash) VALUES (?, ?, ?, ?)",
+			accountName, accountFullName, roleId, passwdHash)
+		return err
+	})
 	if err != nil {
-		errPrintln("Cannot create user '" + accountName + "': " + string(err.Error()))
+		slog.Error("Cannot create user", "user", accountName, "error", err)
 		return User{}, err
 	}
 
-	t.Commit()
-
 	user, err := getAccountByName(accountName)
 	if err != nil {
-		errPrintln("Could not retrieve user account: " + string(err.Error()))
+		slog.Error("Could not retrieve user account", "error", err)
 		return User{}, err
 	}
 
@@ -180,31 +118,27 @@ func createAccount(accountName string, accountFullName string, roleId int, passw
 }
 
 func getAccountByName(accountName string) (User, error) {
-	rec, err := DB.Prepare("SELECT Id,UserName,FullName,Status,RoleId,CreationDate FROM Users WHERE UserName = ?")
-	if err != nil {
-		errPrintln("Could not prepare the DB query: " + string(err.Error()))
-		return User{}, err
-	}
-
 	user := User{}
-	err = rec.QueryRow(accountName).Scan(
-		&user.Id,
-		&user.UserName,
-		&user.FullName,
-		&user.Status,
-		&user.RoleId,
-		&user.CreationDate,
-	)
+	err := DB.Run(context.Background(), func(u *model.Unit) error {
+		return u.QueryRow("SELECT Id,UserName,FullName,Status,RoleId,CreationDate FROM Users WHERE UserName = ?", accountName).Scan(
+			&user.Id,
+			&user.UserName,
+			&user.FullName,
+			&user.Status,
+			&user.RoleId,
+			&user.CreationDate,
+		)
+	})
 	if err != nil {
 		if err == sql.ErrNoRows {
-			errPrintln("No such role found in DB: " + string(err.Error()))
+			slog.Error("No such role found in DB", "error", err)
 			return User{}, nil
 		}
-		errPrintln("Cannot retrieve role from DB: " + string(err.Error()))
+		slog.Error("Cannot retrieve role from DB", "error", err)
 		return User{}, err
 	}
 
-	user.CreationDate = convertSqliteTimestamp(user.CreationDate)
+	user.CreationDate = model.ConvertDbTimestamp(user.CreationDate)
 
 	return user, nil
 }
@@ -19,15 +19,14 @@ package main
 */
 
 import (
-	"database/sql"
-
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/greeneg/update-reporterd/model"
 )
 
-var DB *sql.DB
+var DB *model.Database
 
-func ConnectDatabase(dbPath string) error {
-	db, err := sql.Open("sqlite3", "file:"+dbPath+"?_foreign_keys=on")
+// ConnectDatabase Opens a SQLite database file, or a PostgreSQL database by URL
+func ConnectDatabase(dialect model.Dialect, source string) error {
+	db, err := model.OpenDatabase(dialect, source)
 	if err != nil {
 		return err
 	}
@@ -3,9 +3,21 @@ module github.com/greeneg/update-reporterd/tools/setuptool
 go 1.23.0
 
 require (
-	github.com/mattn/go-sqlite3 v1.14.22
+	github.com/greeneg/update-reporterd v0.0.0-00010101000000-000000000000
 	github.com/pborman/getopt/v2 v2.1.0
 	golang.org/x/term v0.23.0
 )
 
-require golang.org/x/sys v0.23.0 // indirect
+require (
+	github.com/jackc/pgpassfile v1.0.0 // indirect
+	github.com/jackc/pgservicefile v0.0.0-20221227161230-091c0ba34f0a // indirect
+	github.com/jackc/pgx/v5 v5.6.0 // indirect
+	github.com/jackc/puddle/v2 v2.2.1 // indirect
+	github.com/mattn/go-sqlite3 v1.14.22 // indirect
+	golang.org/x/crypto v0.25.0 // indirect
+	golang.org/x/sync v0.7.0 // indirect
+	golang.org/x/sys v0.23.0 // indirect
+	golang.org/x/text v0.16.0 // indirect
+)
+
+replace github.com/greeneg/update-reporterd => ../..
@@ -1,20 +1,20 @@
 package main
 
 import (
-	"runtime"
-	"strconv"
-)
+	"log/slog"
+	"os"
 
-func classedPrintln(class string, msg string) {
-	println(class + msg)
-}
+	"github.com/greeneg/update-reporterd/logging"
+)
 
-func errPrintln(msg string) {
-	_, filename, line, _ := runtime.Caller(1)
-	classedPrintln("ERROR", ": FILE: "+filename+", LINE: "+strconv.Itoa(line)+", "+msg)
-}
+// setupLogging Logs at the level and in the format set in the environment,
+// as the daemon does with its logging config
+func setupLogging() error {
+	handler, err := logging.NewHandler(os.Stderr, logging.ConfigFromEnv())
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(slog.New(handler))
 
-func infoPrintln(msg string) {
-	_, filename, line, _ := runtime.Caller(1)
-	classedPrintln("INFO", ": FILE: "+filename+", LINE: "+strconv.Itoa(line)+", "+msg)
+	return nil
 }
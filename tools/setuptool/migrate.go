@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+
+	"github.com/greeneg/update-reporterd/migrations"
+)
+
+// showMigrationStatus Prints every known migration and whether it is applied
+func showMigrationStatus() error {
+	statuses, err := migrations.GetStatus(context.Background(), DB)
+	if err != nil {
+		return err
+	}
+
+	for _, status := range statuses {
+		state := "pending"
+		if status.Applied {
+			state = "applied " + status.AppliedDate
+		}
+		println(padVersion(status.Version) + "  " + status.Name + "  " + state)
+	}
+	return nil
+}
+
+// migrateDatabase Applies pending migrations, or only lists them on a dry run
+func migrateDatabase(dryRun bool) error {
+	applied, err := migrations.Up(context.Background(), DB, dryRun)
+	if err != nil {
+		return err
+	}
+
+	if len(applied) == 0 {
+		slog.Info("Database schema is up to date")
+		return nil
+	}
+	for _, migration := range applied {
+		if dryRun {
+			println("would apply " + padVersion(migration.Version) + "  " + migration.Name)
+		} else {
+			slog.Info("Applied migration", "version", migration.Version, "name", migration.Name)
+		}
+	}
+	return nil
+}
+
+func padVersion(version int) string {
+	v := strconv.Itoa(version)
+	for len(v) < 4 {
+		v = "0" + v
+	}
+	return v
+}
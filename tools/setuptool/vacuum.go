@@ -0,0 +1,42 @@
+package main
+
+/*
+
+  Copyright 2024, YggdrasilSoft, LLC.
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/greeneg/update-reporterd/model"
+)
+
+// vacuumDatabase Switches a SQLite database to incremental vacuum, so the
+// daemon can return the space of pruned rows to the filesystem
+func vacuumDatabase() error {
+	switched, err := model.EnableIncrementalVacuum(context.Background(), DB)
+	if err != nil {
+		return err
+	}
+
+	if switched {
+		slog.Info("Database switched to incremental vacuum")
+	} else {
+		slog.Info("Database needs no switch to incremental vacuum")
+	}
+	return nil
+}
@@ -1,11 +1,37 @@
 package main
 
 import (
-	"log"
+	"log/slog"
+	"os"
+	"strings"
 )
 
+// environment variables setting the log level and format, the same ones that
+// set them for the daemon and setuptool
+const (
+	logLevelVariable  = "UPDATE_REPORTER_LOGGING_LEVEL"
+	logFormatVariable = "UPDATE_REPORTER_LOGGING_FORMAT"
+)
+
+// setupLogging Logs to stderr at the level and in the format set in the
+// environment, keeping stdout for the report
+func setupLogging() {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(os.Getenv(logLevelVariable))); err != nil {
+		level = slog.LevelInfo
+	}
+	options := &slog.HandlerOptions{Level: level}
+
+	var handler slog.Handler = slog.NewTextHandler(os.Stderr, options)
+	if strings.EqualFold(os.Getenv(logFormatVariable), "json") {
+		handler = slog.NewJSONHandler(os.Stderr, options)
+	}
+	slog.SetDefault(slog.New(handler))
+}
+
 func fatalCheckError(err error) {
 	if err != nil {
-		log.Fatal(err)
+		slog.Error("Cannot produce the update report", "error", err)
+		os.Exit(1)
 	}
 }
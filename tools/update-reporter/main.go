@@ -24,7 +24,6 @@ import (
 	"encoding/xml"
 	"errors"
 	"fmt"
-	"log"
 	"os"
 	"os/exec"
 	"strings"
@@ -306,54 +305,39 @@ func getHostArch() (string, error) {
 
 func main() {
 	output := ""
+	setupLogging()
 	ors, osVariant, err := DetectOs()
-	if err != nil {
-		os.Exit(1)
-	}
+	fatalCheckError(err)
 
 	if osVariant == "suse" {
 		output, err = getZypperLuOutput()
-		if err != nil {
-			log.Fatal(err)
-		}
+		fatalCheckError(err)
 	} else if osVariant == "debian" {
 		output, err = getAptListOutput()
-		if err != nil {
-			log.Fatal(err)
-		}
+		fatalCheckError(err)
 	}
 
 	us, err := processUpdates(output, osVariant)
-	if err != nil {
-		log.Fatal(err)
-	}
+	fatalCheckError(err)
 
 	us.OsId = ors.Id
 	us.OsVersion = ors.Version
 
 	fqdn, err := fqdn.FqdnHostname()
-	if err != nil {
-		log.Fatal(err)
-	}
+	fatalCheckError(err)
 	us.FQDN = fqdn
 
 	osFamily, err := getOsFamily()
-	if err != nil {
-		log.Fatal(err)
-	}
+	fatalCheckError(err)
 	us.OsFamily = osFamily
 
 	hostArch, err := getHostArch()
-	if err != nil {
-		log.Fatal(err)
-	}
+	fatalCheckError(err)
 	us.HostArch = hostArch
 
 	// convert the UpdateStruct to JSON text
 	j, err := json.Marshal(us)
-	if err != nil {
-		log.Fatal(err)
-	}
+	fatalCheckError(err)
 
 	fmt.Fprint(os.Stdout, string(j))
 }